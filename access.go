@@ -0,0 +1,175 @@
+package gitbase
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-borges/util"
+	errors "gopkg.in/src-d/go-errors.v1"
+)
+
+var (
+	// ErrParseRepositoryACL is returned when the repository access of the
+	// users can't be read.
+	ErrParseRepositoryACL = errors.NewKind("error parsing repository access: %s")
+	// ErrInvalidRepositoryPattern is returned when a repository pattern is
+	// not valid.
+	ErrInvalidRepositoryPattern = errors.NewKind("invalid repository pattern %q of user %s")
+)
+
+// RepositoryAccess holds the repositories a user can access. Patterns use
+// the syntax of path.Match, so * doesn't match /. When Allow is empty all the
+// repositories not denied can be accessed. Deny takes precedence over Allow.
+type RepositoryAccess struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// Allowed returns whether the repository with the given ID can be accessed.
+func (a *RepositoryAccess) Allowed(id string) bool {
+	if a == nil {
+		return true
+	}
+
+	for _, p := range a.Deny {
+		if ok, _ := path.Match(p, id); ok {
+			return false
+		}
+	}
+
+	if len(a.Allow) == 0 {
+		return true
+	}
+
+	for _, p := range a.Allow {
+		if ok, _ := path.Match(p, id); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RepositoryACL holds the repository access of each user. Users without an
+// entry can access all the repositories.
+type RepositoryACL map[string]*RepositoryAccess
+
+// Access returns the repository access of the given user, or nil if the user
+// can access all the repositories.
+func (acl RepositoryACL) Access(user string) *RepositoryAccess {
+	a, ok := acl[user]
+	if !ok || (len(a.Allow) == 0 && len(a.Deny) == 0) {
+		return nil
+	}
+
+	return a
+}
+
+// NewRepositoryACLFile reads the repository access of the users from the
+// "repositories" key of the users in a JSON user file, the same file used by
+// auth.NewNativeFile.
+func NewRepositoryACLFile(file string) (RepositoryACL, error) {
+	var users []struct {
+		Name         string            `json:"name"`
+		Repositories *RepositoryAccess `json:"repositories"`
+	}
+
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, ErrParseRepositoryACL.New(err)
+	}
+
+	if err := json.Unmarshal(raw, &users); err != nil {
+		return nil, ErrParseRepositoryACL.New(err)
+	}
+
+	acl := make(RepositoryACL)
+	for _, u := range users {
+		if u.Repositories == nil {
+			continue
+		}
+
+		patterns := append(append([]string(nil), u.Repositories.Allow...), u.Repositories.Deny...)
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, ErrInvalidRepositoryPattern.New(p, u.Name)
+			}
+		}
+
+		acl[u.Name] = u.Repositories
+	}
+
+	return acl, nil
+}
+
+// FilterLibrary returns a library with only the repositories of the given
+// library whose ID is allowed by the given function.
+func FilterLibrary(lib borges.Library, allowed func(id string) bool) borges.Library {
+	return &filteredLibrary{Library: lib, allowed: allowed}
+}
+
+// filteredLibrary is a library without some of its repositories.
+type filteredLibrary struct {
+	borges.Library
+	allowed func(id string) bool
+}
+
+// Get implements the borges.Library interface.
+func (l *filteredLibrary) Get(id borges.RepositoryID, mode borges.Mode) (borges.Repository, error) {
+	if !l.allowed(id.String()) {
+		return nil, borges.ErrRepositoryNotExists.New(id)
+	}
+
+	return l.Library.Get(id, mode)
+}
+
+// Has implements the borges.Library interface.
+func (l *filteredLibrary) Has(id borges.RepositoryID) (bool, borges.LibraryID, borges.LocationID, error) {
+	if !l.allowed(id.String()) {
+		return false, "", "", nil
+	}
+
+	return l.Library.Has(id)
+}
+
+// Repositories implements the borges.Library interface.
+func (l *filteredLibrary) Repositories(mode borges.Mode) (borges.RepositoryIterator, error) {
+	iter, err := l.Library.Repositories(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &filteredRepositoryIter{iter: iter, allowed: l.allowed}, nil
+}
+
+type filteredRepositoryIter struct {
+	iter    borges.RepositoryIterator
+	allowed func(id string) bool
+}
+
+func (i *filteredRepositoryIter) Next() (borges.Repository, error) {
+	for {
+		r, err := i.iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if i.allowed(r.ID().String()) {
+			return r, nil
+		}
+
+		if err := r.Close(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (i *filteredRepositoryIter) ForEach(cb func(borges.Repository) error) error {
+	return util.ForEachRepositoryIterator(i, cb)
+}
+
+func (i *filteredRepositoryIter) Close() {
+	i.iter.Close()
+}
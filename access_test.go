@@ -0,0 +1,169 @@
+package gitbase
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fixtures "github.com/src-d/go-git-fixtures"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryAccessAllowed(t *testing.T) {
+	testCases := []struct {
+		name    string
+		access  *RepositoryAccess
+		allowed []string
+		denied  []string
+	}{
+		{
+			"nil",
+			nil,
+			[]string{"foo", "foo/bar"},
+			nil,
+		},
+		{
+			"allow",
+			&RepositoryAccess{Allow: []string{"public/*", "docs"}},
+			[]string{"public/foo", "docs"},
+			[]string{"public/foo/bar", "secret/foo", "docs2"},
+		},
+		{
+			"deny",
+			&RepositoryAccess{Deny: []string{"secret/*"}},
+			[]string{"public/foo", "secret"},
+			[]string{"secret/foo"},
+		},
+		{
+			"deny has precedence",
+			&RepositoryAccess{Allow: []string{"*/*"}, Deny: []string{"secret/*"}},
+			[]string{"public/foo"},
+			[]string{"secret/foo", "foo"},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+			for _, id := range tt.allowed {
+				require.True(tt.access.Allowed(id), id)
+			}
+
+			for _, id := range tt.denied {
+				require.False(tt.access.Allowed(id), id)
+			}
+		})
+	}
+}
+
+func TestNewRepositoryACLFile(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	file := filepath.Join(tmpDir, "users.json")
+	require.NoError(ioutil.WriteFile(file, []byte(`[
+		{"name": "root", "password": "", "permissions": ["read", "write"]},
+		{"name": "empty", "password": "", "repositories": {}},
+		{"name": "user", "password": "", "repositories": {
+			"allow": ["public/*"],
+			"deny": ["public/secret"]
+		}}
+	]`), 0644))
+
+	acl, err := NewRepositoryACLFile(file)
+	require.NoError(err)
+
+	require.Nil(acl.Access("root"))
+	require.Nil(acl.Access("empty"))
+	require.Nil(acl.Access("unknown"))
+	require.Equal(&RepositoryAccess{
+		Allow: []string{"public/*"},
+		Deny:  []string{"public/secret"},
+	}, acl.Access("user"))
+
+	require.NoError(ioutil.WriteFile(file, []byte(`[
+		{"name": "user", "password": "", "repositories": {"deny": ["[a"]}}
+	]`), 0644))
+
+	_, err = NewRepositoryACLFile(file)
+	require.True(ErrInvalidRepositoryPattern.Is(err))
+
+	_, err = NewRepositoryACLFile(filepath.Join(tmpDir, "missing.json"))
+	require.True(ErrParseRepositoryACL.Is(err))
+}
+
+func TestRepositoryPoolWithAccess(t *testing.T) {
+	require := require.New(t)
+
+	defer func() {
+		require.NoError(fixtures.Clean())
+	}()
+
+	lib, pool, err := newMultiPool()
+	require.NoError(err)
+
+	path := fixtures.ByTag("worktree").One().Worktree().Root()
+	require.NoError(lib.AddPlain("public/repo", path, nil))
+	require.NoError(lib.AddPlain("secret/repo", path, nil))
+
+	require.Equal(pool, pool.WithAccess(nil))
+
+	view := pool.WithAccess(&RepositoryAccess{Deny: []string{"secret/*"}})
+
+	iter, err := view.RepoIter()
+	require.NoError(err)
+
+	repo, err := iter.Next()
+	require.NoError(err)
+	require.Equal("public/repo", repo.ID())
+
+	_, err = iter.Next()
+	require.Equal(io.EOF, err)
+
+	_, err = view.GetRepo("public/repo")
+	require.NoError(err)
+
+	_, err = view.GetRepo("secret/repo")
+	require.True(ErrPoolRepoNotFound.Is(err))
+
+	// the checksum is the one of all the repositories
+	checksum, err := pool.Checksum()
+	require.NoError(err)
+	viewChecksum, err := view.Checksum()
+	require.NoError(err)
+	require.Equal(checksum, viewChecksum)
+
+	// sessions of users with restricted access only see their repositories
+	acl := RepositoryACL{"user": &RepositoryAccess{Allow: []string{"secret/*"}}}
+	partitions := func(user string) []string {
+		session := NewSession(pool,
+			WithRepositoryACL(acl),
+			WithBaseSession(sql.NewSession("localhost", "127.0.0.1", user, 1)),
+		)
+		ctx := sql.NewContext(context.Background(), sql.WithSession(session))
+
+		iter, err := newRepositoryPartitionIter(ctx)
+		require.NoError(err)
+		defer iter.Close()
+
+		var ids []string
+		for {
+			p, err := iter.Next()
+			if err == io.EOF {
+				return ids
+			}
+			require.NoError(err)
+			ids = append(ids, string(p.Key()))
+		}
+	}
+
+	require.ElementsMatch([]string{"public/repo", "secret/repo"}, partitions("root"))
+	require.Equal([]string{"secret/repo"}, partitions("user"))
+}
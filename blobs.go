@@ -25,9 +25,20 @@ var (
 	blobsMaxSize     = getIntEnv(blobsMaxSizeKey, 5) * mib
 )
 
+// SetBlobsMaxSize sets the maximum size in MiB of the blob contents returned
+// by the blobs table. It must be called before running any query.
+func SetBlobsMaxSize(size int) {
+	blobsMaxSize = size * mib
+}
+
+// SetBlobsAllowBinary sets whether the contents of binary blobs are returned
+// by the blobs table. It must be called before running any query.
+func SetBlobsAllowBinary(allow bool) {
+	blobsAllowBinary = allow
+}
+
 type blobsTable struct {
 	checksumable
-	partitioned
 	filters    []sql.Expression
 	projection []string
 	index      sql.IndexLookup
@@ -91,6 +102,16 @@ func (r *blobsTable) IndexLookup() sql.IndexLookup { return r.index }
 func (r *blobsTable) Filters() []sql.Expression    { return r.filters }
 func (r *blobsTable) Projection() []string         { return r.projection }
 
+// Partitions implements the sql.Table interface.
+func (r *blobsTable) Partitions(ctx *sql.Context) (sql.PartitionIter, error) {
+	return splitPartitions(ctx, r.index)
+}
+
+// PartitionCount implements the sql.PartitionCounter interface.
+func (r *blobsTable) PartitionCount(ctx *sql.Context) (int64, error) {
+	return splitPartitionCount(ctx, r.index)
+}
+
 func (r *blobsTable) PartitionRows(
 	ctx *sql.Context,
 	p sql.Partition,
@@ -136,6 +157,7 @@ func (r *blobsTable) PartitionRows(
 				hashes:        stringsToHashes(hashes),
 				repo:          repo,
 				readContent:   shouldReadContent(r.projection),
+				part:          partitionPart(p),
 				skipGitErrors: shouldSkipErrors(ctx),
 			}, nil
 		},
@@ -146,7 +168,7 @@ func (r *blobsTable) PartitionRows(
 		return nil, errorWithRepo(repo, err)
 	}
 
-	return sql.NewSpanIter(span, newRepoRowIter(repo, iter)), nil
+	return sql.NewSpanIter(span, newRepoRowIter(ctx, repo, iter)), nil
 }
 
 func (blobsTable) HandledFilters(filters []sql.Expression) []sql.Expression {
@@ -176,6 +198,8 @@ type blobRowIter struct {
 	hashes        []plumbing.Hash
 	pos           int
 	readContent   bool
+	part          repositoryPart
+	partRead      bool
 	skipGitErrors bool
 }
 
@@ -186,6 +210,10 @@ func (i *blobRowIter) init() error {
 }
 
 func (i *blobRowIter) Next() (sql.Row, error) {
+	if i.part.split() {
+		return i.nextInPart()
+	}
+
 	if len(i.hashes) > 0 {
 		return i.nextByHash()
 	}
@@ -213,6 +241,25 @@ func (i *blobRowIter) nextByHash() (sql.Row, error) {
 	}
 }
 
+// nextInPart returns the next blob of the part of the repository read by
+// the iterator, looking up by hash the objects of the part.
+func (i *blobRowIter) nextInPart() (sql.Row, error) {
+	if !i.partRead {
+		hashes, err := partObjectHashes(i.repo, i.part, i.hashes)
+		if err != nil {
+			if i.skipGitErrors {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+
+		i.hashes = hashes
+		i.partRead = true
+	}
+
+	return i.nextByHash()
+}
+
 func (i *blobRowIter) next() (sql.Row, error) {
 	for {
 		if i.iter == nil {
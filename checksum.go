@@ -16,10 +16,21 @@ type checksumable struct {
 }
 
 func (c *checksumable) Checksum() (string, error) {
+	checksums, err := c.repositoryChecksums()
+	if err != nil {
+		return "", err
+	}
+
+	return checksums.sum()
+}
+
+// repositoryChecksums returns the checksum of each repository of the pool,
+// computed from its packfiles and references.
+func (c *checksumable) repositoryChecksums() (checksums, error) {
 	hash := sha1.New()
 	iter, err := c.pool.RepoIter()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer iter.Close()
 
@@ -32,25 +43,25 @@ func (c *checksumable) Checksum() (string, error) {
 			break
 		}
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		bytes, err := readChecksum(repo)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		if _, err = hash.Write(bytes); err != nil {
-			return "", err
+			return nil, err
 		}
 
 		bytes, err = readRefs(repo)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		if _, err = hash.Write(bytes); err != nil {
-			return "", err
+			return nil, err
 		}
 
 		c := checksum{
@@ -61,16 +72,77 @@ func (c *checksumable) Checksum() (string, error) {
 		checksums = append(checksums, c)
 	}
 
-	sort.Stable(checksums)
-	hash.Reset()
+	return checksums, nil
+}
+
+// Checksum returns the checksum of the repositories in the pool. It's cached
+// until the library of the pool is replaced or InvalidateChecksum is called,
+// so it doesn't reflect changes in the contents of the repositories made in
+// the meantime. Views of the pool created with WithAccess return the checksum
+// of all the repositories, which is the one of the indexes.
+func (p *RepositoryPool) Checksum() (string, error) {
+	p = p.root()
+	p.mut.RLock()
+	checksum, generation := p.checksum, p.generation
+	p.mut.RUnlock()
+
+	if checksum != "" {
+		return checksum, nil
+	}
+
+	checksum, err := (&checksumable{p}).Checksum()
+	if err != nil {
+		return "", err
+	}
+
+	p.mut.Lock()
+	if p.generation == generation {
+		p.checksum = checksum
+	}
+	p.mut.Unlock()
+
+	return checksum, nil
+}
 
+// RepositoryChecksums returns the checksum of each repository of the pool by
+// repository ID, along with the checksum of all of them, which is the one
+// returned by Checksum. Unlike Checksum, they are always computed, and the
+// cached checksum of the pool is replaced with the new one.
+func (p *RepositoryPool) RepositoryChecksums() (map[string]string, string, error) {
+	generation := p.Generation()
+
+	checksums, err := (&checksumable{p}).repositoryChecksums()
+	if err != nil {
+		return nil, "", err
+	}
+
+	repos := make(map[string]string, len(checksums))
 	for _, c := range checksums {
-		if _, err = hash.Write(c.hash); err != nil {
-			return "", err
+		repos[c.name] = base64.StdEncoding.EncodeToString(c.hash)
+	}
+
+	checksum, err := checksums.sum()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if p.base == nil {
+		p.mut.Lock()
+		if p.generation == generation {
+			p.checksum = checksum
 		}
+		p.mut.Unlock()
 	}
 
-	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+	return repos, checksum, nil
+}
+
+// InvalidateChecksum discards the cached checksum of the pool.
+func (p *RepositoryPool) InvalidateChecksum() {
+	p = p.root()
+	p.mut.Lock()
+	p.checksum = ""
+	p.mut.Unlock()
 }
 
 func readChecksum(r *Repository) ([]byte, error) {
@@ -144,6 +216,20 @@ func (b checksums) Less(i, j int) bool {
 	return strings.Compare(b[i].name, b[j].name) < 0
 }
 
+// sum returns the checksum of all the repositories, which doesn't depend on
+// the order they were read.
+func (b checksums) sum() (string, error) {
+	sort.Stable(b)
+	hash := sha1.New()
+	for _, c := range b {
+		if _, err := hash.Write(c.hash); err != nil {
+			return "", err
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
 func readRefs(repo *Repository) ([]byte, error) {
 	buf := bytes.NewBuffer(nil)
 
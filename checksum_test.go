@@ -101,3 +101,33 @@ func TestChecksumStable(t *testing.T) {
 		require.Equal(checksumMulti, checksum)
 	}
 }
+
+func TestRepositoryChecksums(t *testing.T) {
+	require := require.New(t)
+
+	defer func() {
+		require.NoError(fixtures.Clean())
+	}()
+
+	lib, pool, err := newMultiPool()
+	require.NoError(err)
+
+	for i, f := range fixtures.ByTag("worktree") {
+		path := f.Worktree().Root()
+		require.NoError(lib.AddPlain(fmt.Sprintf("repo_%d", i), path, nil))
+	}
+
+	repos, checksum, err := pool.RepositoryChecksums()
+	require.NoError(err)
+	require.Equal(checksumMulti, checksum)
+	require.Contains(repos, "repo_0")
+
+	cached, err := pool.Checksum()
+	require.NoError(err)
+	require.Equal(checksumMulti, cached)
+
+	// the checksum of a repository doesn't depend on the other ones
+	single, _, err := pool.WithRepositories("repo_0").RepositoryChecksums()
+	require.NoError(err)
+	require.Equal(map[string]string{"repo_0": repos["repo_0"]}, single)
+}
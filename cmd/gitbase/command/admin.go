@@ -0,0 +1,622 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/src-d/gitbase"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-borges/libraries"
+	"github.com/src-d/go-borges/plain"
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/src-d/go-mysql-server/server"
+	"github.com/src-d/go-mysql-server/sql"
+	"vitess.io/vitess/go/mysql"
+	"vitess.io/vitess/go/sqltypes"
+	"vitess.io/vitess/go/vt/proto/query"
+)
+
+// Names of the gitbase administrative procedures.
+const (
+	addDirectoryProcedure     = "gitbase_add_directory"
+	removeRepositoryProcedure = "gitbase_remove_repository"
+	fetchProcedure            = "gitbase_fetch"
+
+	// explainAnalyzeStatement is the procedure of EXPLAIN ANALYZE, which
+	// has the query to profile as its only argument.
+	explainAnalyzeStatement = "explain analyze"
+
+	// reindexStatement is the procedure of REINDEX, which has the ID of
+	// the index to update as its only argument, or none to update all.
+	reindexStatement = "reindex"
+
+	// createViewStatement is the procedure of CREATE MATERIALIZED VIEW,
+	// which has the name of the view and its query as arguments.
+	createViewStatement = "create materialized view"
+	// refreshViewStatement is the procedure of REFRESH MATERIALIZED VIEW,
+	// which has the name of the view to refresh as its only argument, or
+	// none to refresh all.
+	refreshViewStatement = "refresh materialized view"
+	// dropViewStatement is the procedure of DROP MATERIALIZED VIEW, which
+	// has the name of the view as its only argument.
+	dropViewStatement = "drop materialized view"
+)
+
+var (
+	callRegexp          = regexp.MustCompile(`(?is)^\s*call\s+(\w+)\s*\((.*)\)\s*;?\s*$`)
+	showLibrariesRegexp = regexp.MustCompile(`(?is)^\s*show\s+gitbase\s+libraries\s*;?\s*$`)
+	explainRegexp       = regexp.MustCompile(`(?is)^\s*explain\s+analyze\s+(.*?)\s*;?\s*$`)
+	reindexRegexp       = regexp.MustCompile(`(?is)^\s*reindex(?:\s+(\w+))?\s*;?\s*$`)
+	createViewRegexp    = regexp.MustCompile(`(?is)^\s*create\s+materialized\s+view\s+(\w+)\s+as\s+(.*?)\s*;?\s*$`)
+	refreshViewRegexp   = regexp.MustCompile(`(?is)^\s*refresh\s+materialized\s+view(?:\s+(\w+))?\s*;?\s*$`)
+	dropViewRegexp      = regexp.MustCompile(`(?is)^\s*drop\s+materialized\s+view\s+(\w+)\s*;?\s*$`)
+	stringLiteralRegexp = regexp.MustCompile(`(?s)^\s*(?:'((?:[^'\\]|\\.|'')*)'|"((?:[^"\\]|\\.|"")*)")\s*(?:(,)|$)`)
+)
+
+// adminSchema is the schema of the results of the administrative procedures,
+// with the repositories added, removed or fetched.
+var adminSchema = sql.Schema{
+	{Name: "repository_id", Type: sql.Text},
+}
+
+// librariesSchema is the schema of the result of SHOW GITBASE LIBRARIES.
+var librariesSchema = sql.Schema{
+	{Name: "path", Type: sql.Text},
+	{Name: "format", Type: sql.Text},
+	{Name: "bucket", Type: sql.Int64, Nullable: true},
+	{Name: "rooted", Type: sql.Boolean, Nullable: true},
+	{Name: "bare", Type: sql.Text, Nullable: true},
+	{Name: "repositories", Type: sql.Int64, Nullable: true},
+}
+
+// adminStatement is a gitbase administrative statement, which is not
+// understood by the SQL engine.
+type adminStatement struct {
+	// procedure is the name of the called procedure, the name of the
+	// statement for EXPLAIN ANALYZE, REINDEX and the materialized view
+	// statements, or empty for SHOW GITBASE LIBRARIES.
+	procedure string
+	args      []string
+}
+
+// procedureArgs are the minimum and maximum number of arguments of each
+// procedure.
+var procedureArgs = map[string][2]int{
+	addDirectoryProcedure:     {1, 1},
+	removeRepositoryProcedure: {1, 1},
+	fetchProcedure:            {1, 3},
+}
+
+// parseAdminStatement returns the administrative statement in the query, if
+// the query is one.
+func parseAdminStatement(q string) (*adminStatement, bool, error) {
+	if showLibrariesRegexp.MatchString(q) {
+		return &adminStatement{}, true, nil
+	}
+
+	if m := explainRegexp.FindStringSubmatch(q); m != nil {
+		return &adminStatement{explainAnalyzeStatement, []string{m[1]}}, true, nil
+	}
+
+	if m := reindexRegexp.FindStringSubmatch(q); m != nil {
+		var args []string
+		if m[1] != "" {
+			args = []string{m[1]}
+		}
+
+		return &adminStatement{reindexStatement, args}, true, nil
+	}
+
+	if m := createViewRegexp.FindStringSubmatch(q); m != nil {
+		return &adminStatement{createViewStatement, []string{m[1], m[2]}}, true, nil
+	}
+
+	if m := refreshViewRegexp.FindStringSubmatch(q); m != nil {
+		var args []string
+		if m[1] != "" {
+			args = []string{m[1]}
+		}
+
+		return &adminStatement{refreshViewStatement, args}, true, nil
+	}
+
+	if m := dropViewRegexp.FindStringSubmatch(q); m != nil {
+		return &adminStatement{dropViewStatement, []string{m[1]}}, true, nil
+	}
+
+	m := callRegexp.FindStringSubmatch(q)
+	if m == nil {
+		return nil, false, nil
+	}
+
+	name := strings.ToLower(m[1])
+	arity, ok := procedureArgs[name]
+	if !ok {
+		if strings.HasPrefix(name, "gitbase_") {
+			return nil, true, fmt.Errorf("unknown procedure %s", m[1])
+		}
+		return nil, false, nil
+	}
+
+	args, ok := parseStringLiterals(m[2])
+	if !ok || len(args) < arity[0] || len(args) > arity[1] {
+		if arity[0] == arity[1] && arity[0] == 1 {
+			return nil, true, fmt.Errorf("%s expects a single string argument", name)
+		}
+
+		return nil, true, fmt.Errorf(
+			"%s expects between %d and %d string arguments",
+			name, arity[0], arity[1],
+		)
+	}
+
+	return &adminStatement{procedure: name, args: args}, true, nil
+}
+
+// parseStringLiterals returns the values of a list of single or double
+// quoted strings separated by commas.
+func parseStringLiterals(s string) ([]string, bool) {
+	var values []string
+	for {
+		m := stringLiteralRegexp.FindStringSubmatch(s)
+		if m == nil {
+			return nil, false
+		}
+
+		quote, value := "'", m[1]
+		if strings.HasPrefix(strings.TrimSpace(m[0]), `"`) {
+			quote, value = `"`, m[2]
+		}
+
+		value = strings.Replace(value, quote+quote, quote, -1)
+		var b strings.Builder
+		for i := 0; i < len(value); i++ {
+			if value[i] == '\\' && i+1 < len(value) {
+				i++
+			}
+			b.WriteByte(value[i])
+		}
+
+		values = append(values, b.String())
+		s = s[len(m[0]):]
+		if m[3] == "" {
+			return values, true
+		}
+	}
+}
+
+// runSQL runs a query, which can be an administrative statement.
+func (c *engineOptions) runSQL(ctx *sql.Context, q string) (sql.Schema, sql.RowIter, error) {
+	stmt, ok, err := parseAdminStatement(q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !ok {
+		return c.engine.Query(ctx, q)
+	}
+
+	return c.runAdmin(ctx, stmt)
+}
+
+// runAdmin runs an administrative statement. The procedures need write
+// permission and are not allowed in read-only mode.
+func (c *engineOptions) runAdmin(ctx *sql.Context, stmt *adminStatement) (sql.Schema, sql.RowIter, error) {
+	if stmt.procedure == explainAnalyzeStatement {
+		rows, err := c.explainAnalyze(ctx, stmt.args[0])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return explainAnalyzeSchema, sql.RowsToRowIter(rows...), nil
+	}
+
+	if stmt.procedure == "" {
+		if err := c.userAuth.Allowed(ctx, auth.ReadPerm); err != nil {
+			return nil, nil, err
+		}
+
+		rows, err := c.showLibraries(c.repositoryAccess(ctx))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return librariesSchema, sql.RowsToRowIter(rows...), nil
+	}
+
+	if c.readOnly {
+		return nil, nil, fmt.Errorf("%s is not allowed in read-only mode", stmt.procedure)
+	}
+
+	if err := c.userAuth.Allowed(ctx, auth.WritePerm); err != nil {
+		return nil, nil, err
+	}
+
+	if stmt.procedure == reindexStatement {
+		var id string
+		if len(stmt.args) > 0 {
+			id = stmt.args[0]
+		}
+
+		rows, err := c.reindex(ctx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return reindexSchema, sql.RowsToRowIter(rows...), nil
+	}
+
+	switch stmt.procedure {
+	case createViewStatement, refreshViewStatement, dropViewStatement:
+		rows, err := c.runViewStatement(ctx, stmt)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return viewSchema, sql.RowsToRowIter(rows...), nil
+	}
+
+	var ids []string
+	var err error
+	switch stmt.procedure {
+	case addDirectoryProcedure:
+		ids, err = c.addRuntimeDirectory(stmt.args[0])
+	case removeRepositoryProcedure:
+		if !c.repositoryAccess(ctx).Allowed(stmt.args[0]) {
+			return nil, nil, gitbase.ErrPoolRepoNotFound.New(stmt.args[0])
+		}
+		ids, err = c.removeRepository(stmt.args[0])
+	case fetchProcedure:
+		ids, err = c.fetchRuntimeRepository(ctx, stmt.args...)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows = make([]sql.Row, len(ids))
+	for i, id := range ids {
+		rows[i] = sql.NewRow(id)
+	}
+
+	return adminSchema, sql.RowsToRowIter(rows...), nil
+}
+
+// addRuntimeDirectory adds a directory with repositories, given like the ones
+// in the command line, and returns the repositories added.
+func (c *engineOptions) addRuntimeDirectory(path string) ([]string, error) {
+	d, err := parseDirectory(directory{
+		Path:   path,
+		Format: c.Format,
+		Bare:   c.defaultBare(),
+		Bucket: c.Bucket,
+		Rooted: !c.NonRooted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory %s: %s", path, err)
+	}
+
+	fi, err := os.Stat(d.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", d.Path)
+	}
+
+	c.libMut.Lock()
+	defer c.libMut.Unlock()
+
+	dirs, err := c.directories()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		if dir.Path == d.Path {
+			return nil, fmt.Errorf("directory %s was already added", d.Path)
+		}
+	}
+
+	// the directory is added to an empty library first, so errors are
+	// reported instead of skipping the directory
+	err = c.addDirectory(
+		libraries.New(nil),
+		plain.NewLibrary(borges.LibraryID("plain"), nil),
+		d,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c.runtimeDirectories = append(c.runtimeDirectories, d)
+	added, _, err := c.reloadLocked(false)
+	if err != nil {
+		c.runtimeDirectories = c.runtimeDirectories[:len(c.runtimeDirectories)-1]
+		return nil, err
+	}
+
+	logrus.WithField("path", d.Path).Info("directory added")
+	return added, nil
+}
+
+// removeRepository removes a repository from the pool until the server is
+// restarted. The repository files are not deleted.
+func (c *engineOptions) removeRepository(id string) ([]string, error) {
+	c.libMut.Lock()
+	defer c.libMut.Unlock()
+
+	if _, ok := c.repositories[id]; !ok {
+		return nil, gitbase.ErrPoolRepoNotFound.New(id)
+	}
+
+	if c.removedRepositories == nil {
+		c.removedRepositories = make(map[string]struct{})
+	}
+	c.removedRepositories[id] = struct{}{}
+
+	_, removed, err := c.reloadLocked(false)
+	if err != nil {
+		delete(c.removedRepositories, id)
+		return nil, err
+	}
+
+	return removed, nil
+}
+
+// fetchRuntimeRepository clones or fetches the repository at the URL given
+// in the first argument into the directory given in the second one, which
+// can be omitted if there is only one, and returns its ID. The third
+// argument is the ID of the repository, derived from the URL by default.
+func (c *engineOptions) fetchRuntimeRepository(ctx *sql.Context, args ...string) ([]string, error) {
+	var url, path, id = args[0], "", ""
+	if len(args) > 1 {
+		path = args[1]
+	}
+	if len(args) > 2 {
+		id = args[2]
+	}
+
+	if id == "" {
+		var err error
+		if id, err = repositoryIDFromURL(url); err != nil {
+			return nil, err
+		}
+	}
+
+	if !c.repositoryAccess(ctx).Allowed(id) {
+		return nil, fmt.Errorf("user %s can't access repository %s", ctx.Client().User, id)
+	}
+
+	c.libMut.Lock()
+	defer c.libMut.Unlock()
+
+	d, err := c.fetchDirectory(path)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err = c.fetchRepository(ctx, d, url, id)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(c.removedRepositories, id)
+	if _, _, err := c.reloadLocked(true); err != nil {
+		return nil, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":  id,
+		"url": url,
+	}).Info("repository fetched")
+	return []string{id}, nil
+}
+
+// showLibraries returns a row for each directory with its library options
+// and its number of repositories allowed by the given access, which is NULL
+// if it can't be read.
+func (c *engineOptions) showLibraries(access *gitbase.RepositoryAccess) ([]sql.Row, error) {
+	c.libMut.Lock()
+	defer c.libMut.Unlock()
+
+	dirs, err := c.directories()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []sql.Row
+	for _, d := range dirs {
+		row := sql.NewRow(d.Path, d.Format, nil, nil, nil, nil)
+		if d.Format == "siva" {
+			row[2] = int64(d.Bucket)
+			row[3] = d.Rooted
+		} else {
+			row[4] = d.Bare.String()
+		}
+
+		lib := libraries.New(nil)
+		plainLib := plain.NewLibrary(borges.LibraryID("plain"), nil)
+		if err := c.addDirectory(lib, plainLib, d); err == nil {
+			if d.Format != "siva" {
+				err = lib.Add(plainLib)
+			}
+
+			var repos map[string]struct{}
+			if err == nil {
+				repos, err = libraryRepositories(gitbase.FilterLibrary(
+					c.filterLibrary(lib),
+					access.Allowed,
+				))
+			}
+
+			if err == nil {
+				row[5] = int64(len(repos))
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// filterLibrary hides the removed repositories from the library.
+func (c *engineOptions) filterLibrary(lib borges.Library) borges.Library {
+	if len(c.removedRepositories) == 0 {
+		return lib
+	}
+
+	hidden := make(map[string]struct{}, len(c.removedRepositories))
+	for id := range c.removedRepositories {
+		hidden[id] = struct{}{}
+	}
+
+	return gitbase.FilterLibrary(lib, func(id string) bool {
+		_, ok := hidden[id]
+		return !ok
+	})
+}
+
+// repositoryAccess returns the repositories the user of the context can
+// access, or nil if it can access all of them.
+func (c *engineOptions) repositoryAccess(ctx *sql.Context) *gitbase.RepositoryAccess {
+	if c.repositoryACL == nil {
+		return nil
+	}
+
+	return c.repositoryACL.Access(ctx.Client().User)
+}
+
+// adminHandler wraps the go-mysql-server handler to run the administrative
+// statements, which are not understood by the SQL engine. It also refuses the
+// queries of connections not using TLS when requireSecure is set, as the
+// listener reports the error to those clients but doesn't close them, and
+// writes the slow queries to slowLog, if any.
+type adminHandler struct {
+	*server.Handler
+	sm            *server.SessionManager
+	opts          *engineOptions
+	requireSecure bool
+	slowLog       *slowQueryLog
+	sessions      *sessionRegistry
+}
+
+// ConnectionClosed implements the mysql.Handler interface. It also closes
+// the session of the connection.
+func (h *adminHandler) ConnectionClosed(c *mysql.Conn) {
+	h.Handler.ConnectionClosed(c)
+	if h.sessions != nil {
+		h.sessions.close(c.ConnectionID)
+	}
+}
+
+// ComQuery implements the mysql.Handler interface.
+func (h *adminHandler) ComQuery(
+	c *mysql.Conn,
+	q string,
+	callback func(*sqltypes.Result) error,
+) error {
+	if h.requireSecure && c.Capabilities&mysql.CapabilityClientSSL == 0 {
+		return mysql.NewSQLError(
+			mysql.ERAccessDeniedError,
+			mysql.SSAccessDeniedError,
+			"server does not allow insecure connections, client must use SSL/TLS",
+		)
+	}
+
+	if h.slowLog != nil {
+		return h.slowLog.run(
+			h.sm.NewContext(c).Session, c.User, q, callback,
+			func(callback func(*sqltypes.Result) error) error {
+				return h.comQuery(c, q, callback)
+			},
+		)
+	}
+
+	return h.comQuery(c, q, callback)
+}
+
+// comQuery runs the given query, which can be an administrative statement.
+func (h *adminHandler) comQuery(
+	c *mysql.Conn,
+	q string,
+	callback func(*sqltypes.Result) error,
+) (err error) {
+	stmt, ok, err := parseAdminStatement(q)
+	if !ok && err == nil {
+		return h.Handler.ComQuery(c, q, callback)
+	}
+
+	ctx := h.sm.NewContextWithQuery(c, q)
+	start := time.Now()
+	defer func() {
+		if a, ok := h.opts.userAuth.(*auth.Audit); ok {
+			a.Query(ctx, time.Since(start), err)
+		}
+	}()
+
+	if err != nil {
+		return err
+	}
+
+	schema, iter, err := h.opts.runAdmin(ctx, stmt)
+	if err != nil {
+		return err
+	}
+
+	rows, err := sql.RowIterToRows(iter)
+	if err != nil {
+		return err
+	}
+
+	result := &sqltypes.Result{Fields: make([]*query.Field, len(schema))}
+	for i, col := range schema {
+		result.Fields[i] = &query.Field{
+			Name:    col.Name,
+			Type:    col.Type.Type(),
+			Charset: mysql.CharacterSetUtf8,
+		}
+	}
+
+	for _, row := range rows {
+		values := make([]sqltypes.Value, len(row))
+		for i, v := range row {
+			if values[i], err = schema[i].Type.SQL(v); err != nil {
+				return err
+			}
+		}
+
+		result.Rows = append(result.Rows, values)
+		result.RowsAffected++
+	}
+
+	return callback(result)
+}
+
+func (b bareOpt) String() string {
+	switch b {
+	case bareOn:
+		return "true"
+	case bareOff:
+		return "false"
+	default:
+		return "auto"
+	}
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	var keys = make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
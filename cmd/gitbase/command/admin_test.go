@@ -0,0 +1,238 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/src-d/gitbase"
+
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+	git "gopkg.in/src-d/go-git.v4"
+)
+
+func TestParseAdminStatement(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected *adminStatement
+		admin    bool
+		err      bool
+	}{
+		{"SELECT * FROM repositories", nil, false, false},
+		{"CALL foo('bar')", nil, false, false},
+		{"show gitbase libraries", &adminStatement{}, true, false},
+		{"SHOW GITBASE LIBRARIES;", &adminStatement{}, true, false},
+		{
+			"CALL gitbase_add_directory('file:///repos?format=siva&bucket=2')",
+			&adminStatement{addDirectoryProcedure, []string{"file:///repos?format=siva&bucket=2"}},
+			true, false,
+		},
+		{
+			`call GITBASE_REMOVE_REPOSITORY("it's") ;`,
+			&adminStatement{removeRepositoryProcedure, []string{"it's"}},
+			true, false,
+		},
+		{
+			`CALL gitbase_remove_repository('it''s \'quoted\'')`,
+			&adminStatement{removeRepositoryProcedure, []string{"it's 'quoted'"}},
+			true, false,
+		},
+		{"CALL gitbase_remove_repository(1)", nil, true, true},
+		{"CALL gitbase_remove_repository('a', 'b')", nil, true, true},
+		{
+			`CALL gitbase_fetch('file:///src/repo', "/repos, siva", 'a''b')`,
+			&adminStatement{fetchProcedure, []string{"file:///src/repo", "/repos, siva", "a'b"}},
+			true, false,
+		},
+		{"CALL gitbase_fetch()", nil, true, true},
+		{"CALL gitbase_fetch('a',)", nil, true, true},
+		{"CALL gitbase_fetch('a', 'b', 'c', 'd')", nil, true, true},
+		{"CALL gitbase_foo('a')", nil, true, true},
+		{"EXPLAIN SELECT * FROM refs", nil, false, false},
+		{
+			"explain analyze SELECT * FROM refs ;",
+			&adminStatement{explainAnalyzeStatement, []string{"SELECT * FROM refs"}},
+			true, false,
+		},
+		{"REINDEX", &adminStatement{reindexStatement, nil}, true, false},
+		{
+			"reindex commits_idx ;",
+			&adminStatement{reindexStatement, []string{"commits_idx"}},
+			true, false,
+		},
+		{"REINDEX INDEX commits_idx", nil, false, false},
+		{
+			"CREATE MATERIALIZED VIEW langs AS\n\tSELECT repository_id, COUNT(*) FROM files;",
+			&adminStatement{
+				createViewStatement,
+				[]string{"langs", "SELECT repository_id, COUNT(*) FROM files"},
+			},
+			true, false,
+		},
+		{"refresh materialized view", &adminStatement{refreshViewStatement, nil}, true, false},
+		{
+			"REFRESH MATERIALIZED VIEW langs;",
+			&adminStatement{refreshViewStatement, []string{"langs"}},
+			true, false,
+		},
+		{
+			"DROP MATERIALIZED VIEW langs",
+			&adminStatement{dropViewStatement, []string{"langs"}},
+			true, false,
+		},
+		{"CREATE VIEW langs AS SELECT 1", nil, false, false},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.query, func(t *testing.T) {
+			require := require.New(t)
+			stmt, admin, err := parseAdminStatement(tt.query)
+			require.Equal(tt.admin, admin)
+			if tt.err {
+				require.Error(err)
+				return
+			}
+
+			require.NoError(err)
+			require.Equal(tt.expected, stmt)
+		})
+	}
+}
+
+func TestAdminStatements(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	plainDir := filepath.Join(tmpDir, "plain")
+	_, err = git.PlainInit(filepath.Join(plainDir, "repo"), true)
+	require.NoError(err)
+
+	users := writeConfig(t, tmpDir, "users.json", `[
+		{"name": "root", "password": "", "permissions": ["read", "write"]},
+		{"name": "reader", "password": "", "permissions": ["read"]}
+	]`)
+	userAuth, err := auth.NewNativeFile(users)
+	require.NoError(err)
+
+	server := &Server{engineOptions: engineOptions{
+		CacheSize:   512,
+		Format:      "siva",
+		Bucket:      0,
+		LogLevel:    "info",
+		Directories: []string{"../../../_testdata"},
+		IndexDir:    filepath.Join(tmpDir, "index"),
+		userAuth:    userAuth,
+	}}
+	require.NoError(server.buildDatabase())
+
+	run := func(user, query string) ([]sql.Row, error) {
+		opts := append(server.sessionOptions(), gitbase.WithBaseSession(
+			sql.NewSession("localhost", "127.0.0.1", user, 1),
+		))
+		session := gitbase.NewSession(server.pool, opts...)
+		ctx := sql.NewContext(context.Background(), sql.WithSession(session))
+
+		_, iter, err := server.runSQL(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		return sql.RowIterToRows(iter)
+	}
+
+	rows, err := run("reader", "SHOW GITBASE LIBRARIES")
+	require.NoError(err)
+	require.Equal([]sql.Row{
+		{"../../../_testdata", "siva", int64(0), true, nil, int64(5)},
+	}, rows)
+
+	_, err = run("reader", "CALL gitbase_add_directory('"+plainDir+"')")
+	require.True(auth.ErrNotAuthorized.Is(err))
+
+	rows, err = run("root", "CALL gitbase_add_directory('file://"+plainDir+"?format=git')")
+	require.NoError(err)
+	require.Equal([]sql.Row{{"repo"}}, rows)
+
+	_, err = run("root", "CALL gitbase_add_directory('"+plainDir+"')")
+	require.Error(err)
+
+	_, err = run("root", "CALL gitbase_add_directory('"+filepath.Join(tmpDir, "missing")+"')")
+	require.Error(err)
+
+	src := filepath.Join(tmpDir, "src")
+	commitFile(t, src, "README", "readme")
+
+	_, err = run("reader", "CALL gitbase_fetch('"+src+"')")
+	require.True(auth.ErrNotAuthorized.Is(err))
+
+	// there are two directories, so it must be given
+	_, err = run("root", "CALL gitbase_fetch('"+src+"')")
+	require.Error(err)
+
+	rows, err = run("root", "CALL gitbase_fetch('file://"+src+"', '"+plainDir+"')")
+	require.NoError(err)
+	require.Equal([]sql.Row{{"src"}}, rows)
+
+	rows, err = run("reader", "SELECT COUNT(*) FROM repositories")
+	require.NoError(err)
+	require.Equal([]sql.Row{{int64(7)}}, rows)
+
+	rows, err = run("reader", "SELECT COUNT(*) FROM refs WHERE repository_id = 'src'")
+	require.NoError(err)
+	require.Equal([]sql.Row{{int64(2)}}, rows)
+
+	rows, err = run("root", "CALL gitbase_remove_repository('015da2f4-6d89-7ec8-5ac9-a38329ea875b')")
+	require.NoError(err)
+	require.Equal([]sql.Row{{"015da2f4-6d89-7ec8-5ac9-a38329ea875b"}}, rows)
+
+	_, err = run("root", "CALL gitbase_remove_repository('015da2f4-6d89-7ec8-5ac9-a38329ea875b')")
+	require.True(gitbase.ErrPoolRepoNotFound.Is(err))
+
+	rows, err = run("reader", "SHOW GITBASE LIBRARIES")
+	require.NoError(err)
+	require.Equal([]sql.Row{
+		{"../../../_testdata", "siva", int64(0), true, nil, int64(4)},
+		{plainDir, "git", nil, nil, "auto", int64(2)},
+	}, rows)
+
+	rows, err = run("reader", "SELECT COUNT(*) FROM repositories")
+	require.NoError(err)
+	require.Equal([]sql.Row{{int64(6)}}, rows)
+
+	server.repositoryACL = gitbase.RepositoryACL{
+		"root": &gitbase.RepositoryAccess{Deny: []string{"s*"}},
+	}
+
+	rows, err = run("root", "SELECT COUNT(*) FROM repositories")
+	require.NoError(err)
+	require.Equal([]sql.Row{{int64(5)}}, rows)
+
+	// the repositories denied to the user are not counted
+	rows, err = run("root", "SHOW GITBASE LIBRARIES")
+	require.NoError(err)
+	require.Equal([]sql.Row{
+		{"../../../_testdata", "siva", int64(0), true, nil, int64(4)},
+		{plainDir, "git", nil, nil, "auto", int64(1)},
+	}, rows)
+
+	_, err = run("root", "CALL gitbase_remove_repository('src')")
+	require.True(gitbase.ErrPoolRepoNotFound.Is(err))
+
+	_, err = run("root", "CALL gitbase_fetch('"+src+"', '"+plainDir+"')")
+	require.Error(err)
+
+	server.repositoryACL = nil
+	server.readOnly = true
+	_, err = run("root", "CALL gitbase_remove_repository('repo')")
+	require.Error(err)
+	require.Contains(err.Error(), "read-only")
+
+	_, err = run("root", "CALL gitbase_fetch('"+src+"', '"+plainDir+"')")
+	require.Error(err)
+}
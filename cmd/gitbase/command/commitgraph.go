@@ -0,0 +1,139 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/gitbase/internal/commitgraph"
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-borges/plain"
+)
+
+const (
+	CommitGraphDescription = "Writes the commit-graph files of the repositories"
+	CommitGraphHelp        = CommitGraphDescription + "\n\n" +
+		"Writes a commit-graph file for each repository of the given git\n" +
+		"directories that doesn't have one, so their history is walked\n" +
+		"without decoding the commit objects. With --split, a new layer with\n" +
+		"the commits not in the graph yet is added to a split commit-graph\n" +
+		"chain instead, which is faster for repositories that already have\n" +
+		"one. Siva directories are not supported."
+)
+
+// CommitGraph represents the `commit-graph` command of gitbase cli tool.
+type CommitGraph struct {
+	engineOptions
+
+	Split bool `long:"split" description:"Adds a layer with the new commits to the split commit-graph chain of every repository, creating it if needed"`
+	Force bool `short:"f" long:"force" description:"Writes again the commit-graph of the repositories that already have one"`
+}
+
+// Execute writes the commit-graph files, it honors the go-flags.Commander
+// interface.
+func (c *CommitGraph) Execute(args []string) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+
+	if c.Split && c.Force {
+		return fmt.Errorf("--split and --force can't be used together")
+	}
+
+	dirs, err := c.directories()
+	if err != nil {
+		return err
+	}
+
+	lib := plain.NewLibrary(borges.LibraryID("plain"), nil)
+	for _, d := range dirs {
+		if d.Format == "siva" {
+			logrus.WithField("path", d.Path).
+				Warn("commit-graph files can't be written in siva directories, skipping it")
+			continue
+		}
+
+		loc, err := plainLocation(c.sharedCache, d)
+		if err != nil {
+			return err
+		}
+
+		lib.AddLocation(loc)
+	}
+
+	repos, err := c.filterLibrary(lib).Repositories(borges.ReadOnlyMode)
+	if err != nil {
+		return err
+	}
+
+	var written, failed int
+	err = repos.ForEach(func(r borges.Repository) error {
+		defer r.Close()
+
+		n, err := c.writeCommitGraph(r)
+		if err != nil {
+			failed++
+			logrus.WithFields(logrus.Fields{
+				"repo":  r.ID(),
+				"error": err,
+			}).Error("unable to write commit-graph")
+			return nil
+		}
+
+		if n >= 0 {
+			written++
+			logrus.WithFields(logrus.Fields{
+				"repo":    r.ID(),
+				"commits": n,
+			}).Info("commit-graph written")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.WithField("repositories", written).Info("commit-graph files written")
+	if failed > 0 {
+		return fmt.Errorf("unable to write the commit-graph of %d repositories", failed)
+	}
+
+	return nil
+}
+
+// writeCommitGraph writes the commit-graph of the repository and returns
+// the number of commits written, or -1 if nothing was written.
+func (c *CommitGraph) writeCommitGraph(r borges.Repository) (int, error) {
+	fs := r.FS()
+	if fs == nil {
+		return -1, fmt.Errorf("filesystem inaccessible")
+	}
+
+	if c.Split {
+		n, err := commitgraph.WriteLayer(fs, r.R().Storer)
+		if n == 0 && err == nil {
+			return -1, nil
+		}
+
+		return n, err
+	}
+
+	if !c.Force {
+		g, err := commitgraph.Open(fs)
+		if err == nil {
+			_ = g.Close()
+			logrus.WithField("repo", r.ID()).
+				Debug("repository already has a commit-graph, skipping it")
+			return -1, nil
+		}
+
+		if !commitgraph.ErrNotFound.Is(err) {
+			logrus.WithFields(logrus.Fields{
+				"repo":  r.ID(),
+				"error": err,
+			}).Warn("invalid commit-graph, writing it again")
+		}
+	}
+
+	return commitgraph.Write(fs, r.R().Storer)
+}
@@ -0,0 +1,71 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/src-d/gitbase/internal/commitgraph"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+func TestCommitGraph(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	dir := filepath.Join(tmpDir, "repos")
+	path := filepath.Join(dir, "project")
+	commitFile(t, path, "README", "first")
+	commitFile(t, path, "LICENSE", "second")
+
+	newCmd := func() *CommitGraph {
+		return &CommitGraph{engineOptions: engineOptions{
+			Format:      "git",
+			LogLevel:    "info",
+			Directories: []string{dir},
+		}}
+	}
+
+	fs := osfs.New(filepath.Join(path, ".git"))
+	graphLen := func() (int, int) {
+		g, err := commitgraph.Open(fs)
+		require.NoError(err)
+		defer g.Close()
+		return g.Len(), g.Layers()
+	}
+
+	require.NoError(newCmd().Execute(nil))
+	commits, layers := graphLen()
+	require.Equal(2, commits)
+	require.Equal(1, layers)
+
+	// repositories with a commit-graph are skipped
+	commitFile(t, path, "NOTICE", "third")
+	require.NoError(newCmd().Execute(nil))
+	commits, _ = graphLen()
+	require.Equal(2, commits)
+
+	cmd := newCmd()
+	cmd.Split = true
+	require.NoError(cmd.Execute(nil))
+	commits, layers = graphLen()
+	require.Equal(3, commits)
+	require.Equal(2, layers)
+
+	cmd = newCmd()
+	cmd.Force = true
+	require.NoError(cmd.Execute(nil))
+	commits, layers = graphLen()
+	require.Equal(3, commits)
+	require.Equal(1, layers)
+
+	cmd = newCmd()
+	cmd.Split = true
+	cmd.Force = true
+	require.Error(cmd.Execute(nil))
+}
@@ -0,0 +1,556 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/src-d/gitbase"
+	"github.com/src-d/gitbase/internal/function"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// yamlFieldNotFound matches the errors of unknown keys in YAML files.
+var yamlFieldNotFound = regexp.MustCompile(`field (\S+) not found in type \S+`)
+
+const (
+	skipGitErrorsKey  = "GITBASE_SKIP_GIT_ERRORS"
+	bblfshEndpointKey = "BBLFSH_ENDPOINT"
+)
+
+// Configurable is implemented by the commands that can read their options
+// from a configuration file. LoadConfig must be called after parsing the
+// command line and before executing the command, receiving the parsed
+// command, so options given in the command line or the environment take
+// precedence over the ones in the file.
+type Configurable interface {
+	LoadConfig(cmd *flags.Command) error
+}
+
+// Config is the content of a configuration file, in YAML or TOML format.
+// Keys are named like the long command line options. Options that are not
+// present keep their value.
+type Config struct {
+	DB            *string `yaml:"db" toml:"db"`
+	Host          *string `yaml:"host" toml:"host"`
+	Port          *int    `yaml:"port" toml:"port"`
+	User          *string `yaml:"user" toml:"user"`
+	Password      *string `yaml:"password" toml:"password"`
+	UserFile      *string `yaml:"user-file" toml:"user-file"`
+	Timeout       *int    `yaml:"timeout" toml:"timeout"`
+	Trace         *bool   `yaml:"trace" toml:"trace"`
+	Metrics       *bool   `yaml:"metrics" toml:"metrics"`
+	MetricsPort   *int    `yaml:"metrics-port" toml:"metrics-port"`
+	HTTP          *bool   `yaml:"http" toml:"http"`
+	HTTPPort      *int    `yaml:"http-port" toml:"http-port"`
+	ReadOnly      *bool   `yaml:"readonly" toml:"readonly"`
+	Index         *string `yaml:"index" toml:"index"`
+	Cache         *int    `yaml:"cache" toml:"cache"`
+	Parallelism   *int    `yaml:"parallelism" toml:"parallelism"`
+	NoSquash      *bool   `yaml:"no-squash" toml:"no-squash"`
+	SplitRepos    *int    `yaml:"split-repositories" toml:"split-repositories"`
+	SplitMinSize  *int    `yaml:"split-min-size" toml:"split-min-size"`
+	SkipGitErrors *bool   `yaml:"skip-git-errors" toml:"skip-git-errors"`
+	Verbose       *bool   `yaml:"verbose" toml:"verbose"`
+	LogLevel      *string `yaml:"log-level" toml:"log-level"`
+
+	// Format, Bucket, Bare, NonBare and NonRooted are the default library
+	// options of the directories.
+	Format    *string `yaml:"format" toml:"format"`
+	Bucket    *int    `yaml:"bucket" toml:"bucket"`
+	Bare      *bool   `yaml:"bare" toml:"bare"`
+	NonBare   *bool   `yaml:"non-bare" toml:"non-bare"`
+	NonRooted *bool   `yaml:"non-rooted" toml:"non-rooted"`
+
+	Directories []DirectoryConfig `yaml:"directories" toml:"directories"`
+
+	// RescanInterval is a duration such as 30s or 5m.
+	RescanInterval *string `yaml:"rescan-interval" toml:"rescan-interval"`
+
+	// ReindexInterval is a duration such as 30s or 5m.
+	ReindexInterval *string `yaml:"reindex-interval" toml:"reindex-interval"`
+
+	// MaxExecutionTime is a duration such as 30s or 5m.
+	MaxExecutionTime *string `yaml:"max-execution-time" toml:"max-execution-time"`
+	MaxRows          *int64  `yaml:"max-rows" toml:"max-rows"`
+	MaxBlobBytes     *int64  `yaml:"max-blob-bytes" toml:"max-blob-bytes"`
+
+	SlowQueryLog *string `yaml:"slow-query-log" toml:"slow-query-log"`
+	// SlowQueryThreshold is a duration such as 500ms or 1s.
+	SlowQueryThreshold *string `yaml:"slow-query-threshold" toml:"slow-query-threshold"`
+
+	// ShutdownTimeout is a duration such as 30s or 5m.
+	ShutdownTimeout *string `yaml:"shutdown-timeout" toml:"shutdown-timeout"`
+
+	ResultCache struct {
+		Size *int `yaml:"size" toml:"size"`
+		// TTL is a duration such as 30s or 5m.
+		TTL *string `yaml:"ttl" toml:"ttl"`
+	} `yaml:"result-cache" toml:"result-cache"`
+
+	Blobs struct {
+		MaxSize     *int  `yaml:"max-size" toml:"max-size"`
+		AllowBinary *bool `yaml:"allow-binary" toml:"allow-binary"`
+	} `yaml:"blobs" toml:"blobs"`
+
+	Bblfsh struct {
+		Endpoint    *string `yaml:"endpoint" toml:"endpoint"`
+		MaxBlobSize *int    `yaml:"max-blob-size" toml:"max-blob-size"`
+		Concurrency *int    `yaml:"concurrency" toml:"concurrency"`
+	} `yaml:"bblfsh" toml:"bblfsh"`
+
+	TLS struct {
+		Cert                   *string `yaml:"cert" toml:"cert"`
+		Key                    *string `yaml:"key" toml:"key"`
+		CA                     *string `yaml:"ca" toml:"ca"`
+		RequireSecureTransport *bool   `yaml:"require-secure-transport" toml:"require-secure-transport"`
+	} `yaml:"tls" toml:"tls"`
+
+	UASTCacheSize     *int `yaml:"uast-cache-size" toml:"uast-cache-size"`
+	LanguageCacheSize *int `yaml:"language-cache-size" toml:"language-cache-size"`
+
+	PersistentCache struct {
+		Dir  *string `yaml:"dir" toml:"dir"`
+		Size *int    `yaml:"size" toml:"size"`
+	} `yaml:"persistent-cache" toml:"persistent-cache"`
+}
+
+// DirectoryConfig is a directory with repositories and the options of its
+// library. Options that are not present take the default library options.
+type DirectoryConfig struct {
+	Path   string  `yaml:"path" toml:"path"`
+	Format *string `yaml:"format" toml:"format"`
+	Bucket *int    `yaml:"bucket" toml:"bucket"`
+	Rooted *bool   `yaml:"rooted" toml:"rooted"`
+	// Bare can be true, false or "auto".
+	Bare interface{} `yaml:"bare" toml:"bare"`
+}
+
+// ReadConfig reads and validates a configuration file. The format is chosen
+// by the file extension: .yaml or .yml for YAML and .toml for TOML. All the
+// problems found in the file are reported in the returned error.
+func ReadConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	var problems []string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.UnmarshalStrict(content, &cfg); err != nil {
+			if e, ok := err.(*yaml.TypeError); ok {
+				for _, msg := range e.Errors {
+					problems = append(problems, yamlFieldNotFound.ReplaceAllString(msg, "unknown option $1"))
+				}
+			} else {
+				return nil, fmt.Errorf("invalid configuration file %s: %s", path, err)
+			}
+		}
+	case ".toml":
+		md, err := toml.Decode(string(content), &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: %s", path, err)
+		}
+
+		for _, k := range md.Undecoded() {
+			problems = append(problems, fmt.Sprintf("unknown option %s", k))
+		}
+	default:
+		return nil, fmt.Errorf("unknown format of configuration file %s, use .yaml, .yml or .toml", path)
+	}
+
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf(
+			"invalid configuration file %s:\n  %s",
+			path,
+			strings.Join(problems, "\n  "),
+		)
+	}
+
+	return &cfg, nil
+}
+
+func (c *Config) validate() []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	checkPort := func(name string, port *int) {
+		if port != nil && (*port <= 0 || *port > 65535) {
+			add("%s: invalid port %d", name, *port)
+		}
+	}
+
+	checkPositive := func(name string, v *int) {
+		if v != nil && *v <= 0 {
+			add("%s: must be greater than 0, got %d", name, *v)
+		}
+	}
+
+	checkNotNegative := func(name string, v *int) {
+		if v != nil && *v < 0 {
+			add("%s: must not be negative, got %d", name, *v)
+		}
+	}
+
+	checkFormat := func(name string, format *string) {
+		if format != nil && *format != "git" && *format != "siva" {
+			add("%s: invalid format %q, it can be git or siva", name, *format)
+		}
+	}
+
+	checkPort("port", c.Port)
+	checkPort("metrics-port", c.MetricsPort)
+	checkPort("http-port", c.HTTPPort)
+	checkNotNegative("timeout", c.Timeout)
+	checkPositive("cache", c.Cache)
+	checkNotNegative("parallelism", c.Parallelism)
+	checkNotNegative("split-repositories", c.SplitRepos)
+	checkNotNegative("split-min-size", c.SplitMinSize)
+	checkFormat("format", c.Format)
+	checkNotNegative("bucket", c.Bucket)
+	checkNotNegative("blobs.max-size", c.Blobs.MaxSize)
+	checkNotNegative("bblfsh.max-blob-size", c.Bblfsh.MaxBlobSize)
+	checkPositive("bblfsh.concurrency", c.Bblfsh.Concurrency)
+	checkPositive("uast-cache-size", c.UASTCacheSize)
+	checkPositive("language-cache-size", c.LanguageCacheSize)
+	checkPositive("persistent-cache.size", c.PersistentCache.Size)
+
+	checkDuration := func(name string, v *string) {
+		if v == nil {
+			return
+		}
+
+		if d, err := time.ParseDuration(*v); err != nil {
+			add("%s: invalid duration %q", name, *v)
+		} else if d < 0 {
+			add("%s: must not be negative, got %s", name, d)
+		}
+	}
+
+	checkDuration("rescan-interval", c.RescanInterval)
+	checkDuration("reindex-interval", c.ReindexInterval)
+	checkDuration("max-execution-time", c.MaxExecutionTime)
+	checkDuration("slow-query-threshold", c.SlowQueryThreshold)
+	checkDuration("shutdown-timeout", c.ShutdownTimeout)
+	checkNotNegative("result-cache.size", c.ResultCache.Size)
+	checkDuration("result-cache.ttl", c.ResultCache.TTL)
+
+	if c.MaxRows != nil && *c.MaxRows < 0 {
+		add("max-rows: must not be negative, got %d", *c.MaxRows)
+	}
+
+	if c.MaxBlobBytes != nil && *c.MaxBlobBytes < 0 {
+		add("max-blob-bytes: must not be negative, got %d", *c.MaxBlobBytes)
+	}
+
+	checkFile := func(name string, path *string) {
+		if path == nil {
+			return
+		}
+
+		if fi, err := os.Stat(*path); err != nil {
+			add("%s: %s", name, err)
+		} else if fi.IsDir() {
+			add("%s: %s is a directory", name, *path)
+		}
+	}
+
+	checkFile("tls.cert", c.TLS.Cert)
+	checkFile("tls.key", c.TLS.Key)
+	checkFile("tls.ca", c.TLS.CA)
+
+	if c.LogLevel != nil {
+		if _, err := logrus.ParseLevel(*c.LogLevel); err != nil {
+			add("log-level: invalid level %q", *c.LogLevel)
+		}
+	}
+
+	if c.Bare != nil && c.NonBare != nil && *c.Bare && *c.NonBare {
+		add("bare, non-bare: cannot use both")
+	}
+
+	if c.ReadOnly != nil && *c.ReadOnly && c.UserFile != nil && *c.UserFile != "" {
+		add("readonly, user-file: cannot use both")
+	}
+
+	for i, d := range c.Directories {
+		name := fmt.Sprintf("directories[%d]", i)
+		if d.Path == "" {
+			add("%s.path: missing path", name)
+		} else if fi, err := os.Stat(d.Path); err != nil {
+			add("%s.path: %s", name, err)
+		} else if !fi.IsDir() {
+			add("%s.path: %s is not a directory", name, d.Path)
+		}
+
+		checkFormat(name+".format", d.Format)
+		checkNotNegative(name+".bucket", d.Bucket)
+		if _, err := parseBare(d.Bare); err != nil {
+			add("%s.bare: %s", name, err)
+		}
+	}
+
+	return problems
+}
+
+// parseBare parses the bare option of a directory, which can be a boolean or
+// the strings true, false or auto.
+func parseBare(v interface{}) (bareOpt, error) {
+	switch v := v.(type) {
+	case nil:
+		return bareAuto, nil
+	case bool:
+		if v {
+			return bareOn, nil
+		}
+		return bareOff, nil
+	case string:
+		switch strings.ToLower(v) {
+		case "true":
+			return bareOn, nil
+		case "false":
+			return bareOff, nil
+		case "auto":
+			return bareAuto, nil
+		}
+	}
+
+	return bareAuto, fmt.Errorf("invalid value %v, it can be true, false or auto", v)
+}
+
+// LoadConfig reads the configuration file given with --config, if any, and
+// sets the options that were not given in the command line or the
+// environment. It implements the Configurable interface.
+func (c *engineOptions) LoadConfig(cmd *flags.Command) error {
+	if c.ConfigFile == "" {
+		return nil
+	}
+
+	cfg, err := ReadConfig(c.ConfigFile)
+	if err != nil {
+		return err
+	}
+	c.config = cfg
+
+	setString(cmd, "db", &c.Name, cfg.DB)
+	setString(cmd, "index", &c.IndexDir, cfg.Index)
+	setBool(cmd, "no-squash", &c.DisableSquash, cfg.NoSquash)
+	setString(cmd, "log-level", &c.LogLevel, cfg.LogLevel)
+	setString(cmd, "format", &c.Format, cfg.Format)
+	setInt(cmd, "bucket", &c.Bucket, cfg.Bucket)
+	setBool(cmd, "bare", &c.Bare, cfg.Bare)
+	setBool(cmd, "non-bare", &c.NonBare, cfg.NonBare)
+	setBool(cmd, "non-rooted", &c.NonRooted, cfg.NonRooted)
+
+	if cfg.Cache != nil && !optionSet(cmd, "cache") {
+		c.CacheSize = cache.FileSize(*cfg.Cache)
+	}
+
+	if cfg.Parallelism != nil && !optionSet(cmd, "parallelism") {
+		c.Parallelism = uint(*cfg.Parallelism)
+	}
+
+	if cfg.SplitRepos != nil && !optionSet(cmd, "split-repositories") {
+		c.SplitParts = uint(*cfg.SplitRepos)
+	}
+
+	if cfg.SplitMinSize != nil && !optionSet(cmd, "split-min-size") {
+		c.SplitMinSize = uint(*cfg.SplitMinSize)
+	}
+
+	if cfg.Verbose != nil && !optionSet(cmd, "v") {
+		c.Verbose = *cfg.Verbose
+	}
+
+	if cfg.SkipGitErrors != nil && !envSet(skipGitErrorsKey) {
+		c.SkipGitErrors = *cfg.SkipGitErrors
+	}
+
+	if len(cfg.Directories) > 0 && !optionSet(cmd, "directories") {
+		c.Directories = nil
+		c.configDirectories = cfg.Directories
+	}
+
+	if cfg.Bblfsh.Endpoint != nil && !envSet(bblfshEndpointKey) {
+		c.bblfshEndpoint = *cfg.Bblfsh.Endpoint
+	}
+
+	cfg.applySettings()
+	return nil
+}
+
+// applySettings applies the settings that are otherwise read from
+// environment variables, unless the variables are set.
+func (c *Config) applySettings() {
+	if c.Blobs.MaxSize != nil && !envSet("GITBASE_BLOBS_MAX_SIZE") {
+		gitbase.SetBlobsMaxSize(*c.Blobs.MaxSize)
+	}
+
+	if c.Blobs.AllowBinary != nil && !envSet("GITBASE_BLOBS_ALLOW_BINARY") {
+		gitbase.SetBlobsAllowBinary(*c.Blobs.AllowBinary)
+	}
+
+	if c.Bblfsh.MaxBlobSize != nil && !envSet("GITBASE_MAX_UAST_BLOB_SIZE") {
+		function.SetUASTMaxBlobSize(*c.Bblfsh.MaxBlobSize)
+	}
+
+	if c.Bblfsh.Concurrency != nil && !envSet("GITBASE_UAST_CONCURRENCY") {
+		function.SetUASTConcurrency(*c.Bblfsh.Concurrency)
+	}
+
+	if c.UASTCacheSize != nil && !envSet("GITBASE_UAST_CACHE_SIZE") {
+		function.SetUASTCacheSize(*c.UASTCacheSize)
+	}
+
+	if c.LanguageCacheSize != nil && !envSet("GITBASE_LANGUAGE_CACHE_SIZE") {
+		function.SetLanguageCacheSize(*c.LanguageCacheSize)
+	}
+
+	var cacheDir string
+	var cacheSize int
+	if c.PersistentCache.Dir != nil && !envSet("GITBASE_PERSISTENT_CACHE_DIR") {
+		cacheDir = *c.PersistentCache.Dir
+	}
+
+	if c.PersistentCache.Size != nil && !envSet("GITBASE_PERSISTENT_CACHE_SIZE_MB") {
+		cacheSize = *c.PersistentCache.Size
+	}
+
+	if cacheDir != "" || cacheSize > 0 {
+		function.SetPersistentCache(cacheDir, cacheSize)
+	}
+}
+
+// LoadConfig reads the configuration file given with --config, if any, and
+// sets the options that were not given in the command line or the
+// environment. It implements the Configurable interface.
+func (c *Server) LoadConfig(cmd *flags.Command) error {
+	if err := c.engineOptions.LoadConfig(cmd); err != nil {
+		return err
+	}
+
+	cfg := c.config
+	if cfg == nil {
+		return nil
+	}
+
+	setString(cmd, "host", &c.Host, cfg.Host)
+	setInt(cmd, "port", &c.Port, cfg.Port)
+	setString(cmd, "user", &c.User, cfg.User)
+	setString(cmd, "password", &c.Password, cfg.Password)
+	setString(cmd, "user-file", &c.UserFile, cfg.UserFile)
+	setInt(cmd, "timeout", &c.ConnTimeout, cfg.Timeout)
+	setBool(cmd, "trace", &c.TraceEnabled, cfg.Trace)
+	setBool(cmd, "metrics", &c.MetricsEnabled, cfg.Metrics)
+	setInt(cmd, "metrics-port", &c.MetricsPort, cfg.MetricsPort)
+	setBool(cmd, "http", &c.HTTPEnabled, cfg.HTTP)
+	setInt(cmd, "http-port", &c.HTTPPort, cfg.HTTPPort)
+	setBool(cmd, "readonly", &c.ReadOnly, cfg.ReadOnly)
+	setString(cmd, "tls-cert", &c.TLSCert, cfg.TLS.Cert)
+	setString(cmd, "tls-key", &c.TLSKey, cfg.TLS.Key)
+	setString(cmd, "tls-ca", &c.TLSCA, cfg.TLS.CA)
+	setBool(cmd, "require-secure-transport", &c.RequireSecureTransport, cfg.TLS.RequireSecureTransport)
+
+	if cfg.RescanInterval != nil && !optionSet(cmd, "rescan-interval") {
+		// already validated by ReadConfig
+		c.RescanInterval, _ = time.ParseDuration(*cfg.RescanInterval)
+	}
+
+	if cfg.ReindexInterval != nil && !optionSet(cmd, "reindex-interval") {
+		c.ReindexInterval, _ = time.ParseDuration(*cfg.ReindexInterval)
+	}
+
+	if cfg.MaxExecutionTime != nil && !optionSet(cmd, "max-execution-time") {
+		c.MaxExecutionTime, _ = time.ParseDuration(*cfg.MaxExecutionTime)
+	}
+
+	setString(cmd, "slow-query-log", &c.SlowQueryLog, cfg.SlowQueryLog)
+	if cfg.SlowQueryThreshold != nil && !optionSet(cmd, "slow-query-threshold") {
+		c.SlowQueryThreshold, _ = time.ParseDuration(*cfg.SlowQueryThreshold)
+	}
+
+	if cfg.ShutdownTimeout != nil && !optionSet(cmd, "shutdown-timeout") {
+		c.ShutdownTimeout, _ = time.ParseDuration(*cfg.ShutdownTimeout)
+	}
+
+	if cfg.MaxRows != nil && !optionSet(cmd, "max-rows") {
+		c.MaxRows = *cfg.MaxRows
+	}
+
+	if cfg.MaxBlobBytes != nil && !optionSet(cmd, "max-blob-bytes") {
+		c.MaxBlobBytes = *cfg.MaxBlobBytes
+	}
+
+	if cfg.ResultCache.Size != nil && !optionSet(cmd, "result-cache-size") {
+		c.ResultCacheSize = uint(*cfg.ResultCache.Size)
+	}
+
+	if cfg.ResultCache.TTL != nil && !optionSet(cmd, "result-cache-ttl") {
+		c.ResultCacheTTL, _ = time.ParseDuration(*cfg.ResultCache.TTL)
+	}
+
+	return nil
+}
+
+// optionSet returns whether the option with the given long name, or short
+// name if it has a single character, was given in the command line or with
+// its environment variable.
+func optionSet(cmd *flags.Command, name string) bool {
+	if cmd == nil {
+		return false
+	}
+
+	var opt *flags.Option
+	if len(name) == 1 {
+		opt = cmd.FindOptionByShortName(rune(name[0]))
+	} else {
+		opt = cmd.FindOptionByLongName(name)
+	}
+
+	if opt == nil {
+		return false
+	}
+
+	if opt.IsSet() && !opt.IsSetDefault() {
+		return true
+	}
+
+	return opt.EnvDefaultKey != "" && envSet(opt.EnvDefaultKey)
+}
+
+func envSet(key string) bool {
+	_, ok := os.LookupEnv(key)
+	return ok
+}
+
+func setString(cmd *flags.Command, name string, dst *string, v *string) {
+	if v != nil && !optionSet(cmd, name) {
+		*dst = *v
+	}
+}
+
+func setInt(cmd *flags.Command, name string, dst *int, v *int) {
+	if v != nil && !optionSet(cmd, name) {
+		*dst = *v
+	}
+}
+
+func setBool(cmd *flags.Command, name string, dst *bool, v *bool) {
+	if v != nil && !optionSet(cmd, name) {
+		*dst = *v
+	}
+}
@@ -0,0 +1,270 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestReadConfig(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	yamlFile := writeConfig(t, tmpDir, "config.yaml", `
+port: 3307
+log-level: debug
+directories:
+  - path: `+tmpDir+`
+    format: siva
+    bucket: 0
+    rooted: false
+  - path: `+tmpDir+`
+    bare: auto
+blobs:
+  max-size: 10
+bblfsh:
+  concurrency: 8
+persistent-cache:
+  dir: /var/cache/gitbase
+  size: 2048
+`)
+
+	tomlFile := writeConfig(t, tmpDir, "config.toml", `
+port = 3307
+log-level = "debug"
+
+[[directories]]
+path = "`+tmpDir+`"
+format = "siva"
+bucket = 0
+rooted = false
+
+[[directories]]
+path = "`+tmpDir+`"
+bare = "auto"
+
+[blobs]
+max-size = 10
+
+[bblfsh]
+concurrency = 8
+
+[persistent-cache]
+dir = "/var/cache/gitbase"
+size = 2048
+`)
+
+	for _, path := range []string{yamlFile, tomlFile} {
+		cfg, err := ReadConfig(path)
+		require.NoError(err, path)
+
+		require.Equal(3307, *cfg.Port)
+		require.Equal("debug", *cfg.LogLevel)
+		require.Nil(cfg.Host)
+		require.Len(cfg.Directories, 2)
+		require.Equal("siva", *cfg.Directories[0].Format)
+		require.Equal(0, *cfg.Directories[0].Bucket)
+		require.False(*cfg.Directories[0].Rooted)
+		require.Equal("auto", cfg.Directories[1].Bare)
+		require.Equal(10, *cfg.Blobs.MaxSize)
+		require.Equal(8, *cfg.Bblfsh.Concurrency)
+		require.Equal("/var/cache/gitbase", *cfg.PersistentCache.Dir)
+		require.Equal(2048, *cfg.PersistentCache.Size)
+	}
+
+	_, err = ReadConfig(writeConfig(t, tmpDir, "config.json", "{}"))
+	require.Error(err)
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	path := writeConfig(t, tmpDir, "config.yaml", `
+port: 70000
+portt: 1
+format: foo
+log-level: loud
+directories:
+  - path: /does/not/exist
+    bare: maybe
+  - format: git
+`)
+
+	_, err = ReadConfig(path)
+	require.Error(err)
+	require.Equal("invalid configuration file "+path+`:
+  line 3: unknown option portt
+  port: invalid port 70000
+  format: invalid format "foo", it can be git or siva
+  log-level: invalid level "loud"
+  directories[0].path: stat /does/not/exist: no such file or directory
+  directories[0].bare: invalid value maybe, it can be true, false or auto
+  directories[1].path: missing path`, err.Error())
+
+	path = writeConfig(t, tmpDir, "config.toml", `
+port = 3306
+rescan-interval = "often"
+max-rows = -1
+[tls]
+cert = "/does/not/exist/cert.pem"
+[blobs]
+max-size = -1
+unknown = true
+`)
+
+	_, err = ReadConfig(path)
+	require.Error(err)
+	require.Equal("invalid configuration file "+path+`:
+  unknown option blobs.unknown
+  blobs.max-size: must not be negative, got -1
+  rescan-interval: invalid duration "often"
+  max-rows: must not be negative, got -1
+  tls.cert: stat /does/not/exist/cert.pem: no such file or directory`, err.Error())
+}
+
+func TestLoadConfig(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	certFile := writeConfig(t, tmpDir, "cert.pem", "")
+	keyFile := writeConfig(t, tmpDir, "key.pem", "")
+
+	path := writeConfig(t, tmpDir, "config.yaml", `
+host: 0.0.0.0
+port: 3307
+user: gitbase
+cache: 128
+log-level: debug
+format: siva
+rescan-interval: 1m
+reindex-interval: 1h
+result-cache:
+  size: 64
+  ttl: 10m
+max-execution-time: 30s
+max-rows: 1000
+slow-query-log: "-"
+slow-query-threshold: 500ms
+shutdown-timeout: 1m
+http: true
+http-port: 9090
+tls:
+  cert: `+certFile+`
+  key: `+keyFile+`
+  require-secure-transport: true
+directories:
+  - path: `+tmpDir+`
+    bucket: 0
+`)
+
+	parse := func(args ...string) *Server {
+		var s Server
+		parser := flags.NewParser(nil, flags.None)
+		_, err := parser.AddCommand("server", "", "", &s)
+		require.NoError(err)
+
+		parser.CommandHandler = func(cmd flags.Commander, args []string) error {
+			return cmd.(Configurable).LoadConfig(parser.Active)
+		}
+
+		_, err = parser.ParseArgs(append([]string{"server", "--config", path}, args...))
+		require.NoError(err)
+		return &s
+	}
+
+	s := parse()
+	require.Equal("0.0.0.0", s.Host)
+	require.Equal(3307, s.Port)
+	require.Equal("gitbase", s.User)
+	require.Equal(128, int(s.CacheSize))
+	require.Equal("debug", s.LogLevel)
+	require.Equal("siva", s.Format)
+	require.Empty(s.Directories)
+	require.Len(s.configDirectories, 1)
+	require.Equal(time.Minute, s.RescanInterval)
+	require.Equal(time.Hour, s.ReindexInterval)
+	require.Equal(uint(64), s.ResultCacheSize)
+	require.Equal(10*time.Minute, s.ResultCacheTTL)
+	require.Equal(30*time.Second, s.MaxExecutionTime)
+	require.Equal(int64(1000), s.MaxRows)
+	require.Zero(s.MaxBlobBytes)
+	require.Equal("-", s.SlowQueryLog)
+	require.Equal(500*time.Millisecond, s.SlowQueryThreshold)
+	require.Equal(time.Minute, s.ShutdownTimeout)
+	require.True(s.HTTPEnabled)
+	require.Equal(9090, s.HTTPPort)
+	require.Equal(certFile, s.TLSCert)
+	require.Equal(keyFile, s.TLSKey)
+	require.True(s.RequireSecureTransport)
+
+	s = parse("--port", "4000", "-d", "/foo", "--format", "git", "--rescan-interval", "10s")
+	require.Equal(4000, s.Port)
+	require.Equal(10*time.Second, s.RescanInterval)
+	require.Equal("git", s.Format)
+	require.Equal([]string{"/foo"}, s.Directories)
+	require.Empty(s.configDirectories)
+
+	require.NoError(os.Setenv("GITBASE_CACHESIZE_MB", "256"))
+	defer os.Unsetenv("GITBASE_CACHESIZE_MB")
+
+	s = parse()
+	require.Equal(256, int(s.CacheSize))
+}
+
+func TestConfigDirectories(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	path := writeConfig(t, tmpDir, "config.toml", `
+[[directories]]
+path = "../../../_testdata"
+format = "siva"
+bucket = 0
+`)
+
+	cmd := &Query{
+		engineOptions: engineOptions{
+			ConfigFile: path,
+			CacheSize:  512,
+			Format:     "git",
+			Bucket:     2,
+			LogLevel:   "info",
+			IndexDir:   tmpDir,
+		},
+		Output: "csv",
+	}
+	cmd.Args.Query = "SELECT COUNT(*) AS n FROM repositories"
+
+	var out = new(bytes.Buffer)
+	cmd.out = out
+
+	require.NoError(cmd.LoadConfig(nil))
+	require.NoError(cmd.Execute(nil))
+	require.Equal("n\n5\n", out.String())
+}
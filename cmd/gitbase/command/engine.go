@@ -0,0 +1,580 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/src-d/gitbase"
+	"github.com/src-d/gitbase/internal/function"
+	"github.com/src-d/gitbase/internal/rule"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-borges/legacysiva"
+	"github.com/src-d/go-borges/libraries"
+	"github.com/src-d/go-borges/plain"
+	"github.com/src-d/go-borges/siva"
+	sqle "github.com/src-d/go-mysql-server"
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/analyzer"
+	"github.com/src-d/go-mysql-server/sql/index/pilosa"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+)
+
+// engineOptions holds the options and state shared by all the commands that
+// build a gitbase engine on top of a set of repository directories.
+type engineOptions struct {
+	engine   *sqle.Engine
+	pool     *gitbase.RepositoryPool
+	userAuth auth.Auth
+
+	sharedCache   cache.Object
+	readOnly      bool
+	repositoryACL gitbase.RepositoryACL
+	queryLimits   gitbase.QueryLimits
+	queryStats    bool
+	resultCache   *gitbase.ResultCache
+
+	// libMut guards the state used to build the library of the pool, which
+	// can be changed at runtime by rescans and administrative statements.
+	libMut              sync.Mutex
+	sivaLibraries       map[directory]borges.Library
+	repositories        map[string]struct{}
+	runtimeDirectories  []directory
+	removedRepositories map[string]struct{}
+
+	lastPid uint64
+
+	// reindexMut serializes the updates of the indexes.
+	reindexMut sync.Mutex
+	// viewsMut serializes the changes of the materialized views.
+	viewsMut sync.Mutex
+
+	config            *Config
+	configDirectories []DirectoryConfig
+	bblfshEndpoint    string
+
+	ConfigFile    string         `long:"config" env:"GITBASE_CONFIG" description:"YAML or TOML file with the options. Options given in the command line or the environment take precedence over the ones in the file."`
+	Name          string         `long:"db" default:"gitbase" description:"Database name"`
+	Version       string         // Version of the application.
+	Directories   []string       `short:"d" long:"directories" description:"Path where standard git repositories are located, multiple directories can be defined."`
+	Format        string         `long:"format" default:"git" choice:"git" choice:"siva" description:"Library format"`
+	Bucket        int            `long:"bucket" default:"2" description:"Bucketing level to use with siva libraries"`
+	Bare          bool           `long:"bare" description:"Sets the library to use bare git repositories, used only with git format libraries"`
+	NonBare       bool           `long:"non-bare" description:"Sets the library to use non bare git repositories, used only with git format libraries"`
+	NonRooted     bool           `long:"non-rooted" description:"Disables treating siva files as rooted repositories"`
+	IndexDir      string         `short:"i" long:"index" default:"/var/lib/gitbase/index" description:"Directory where the gitbase indexes information will be persisted." env:"GITBASE_INDEX_DIR"`
+	CacheSize     cache.FileSize `long:"cache" default:"512" description:"Object cache size in megabytes" env:"GITBASE_CACHESIZE_MB"`
+	Parallelism   uint           `long:"parallelism" description:"Maximum number of parallel threads per table. By default, it's the number of CPU cores. 0 means default, 1 means disabled."`
+	DisableSquash bool           `long:"no-squash" description:"Disables the table squashing."`
+	SplitParts    uint           `long:"split-repositories" description:"Number of partitions the big repositories are split in by the refs, ref_commits, blobs and tree_entries tables, so they can be read in parallel. 0 or 1 means disabled."`
+	SplitMinSize  uint           `long:"split-min-size" default:"1024" description:"Minimum size in megabytes of the packfiles of a repository to split it"`
+	SkipGitErrors bool           // SkipGitErrors disables failing when Git errors are found.
+	Verbose       bool           `short:"v" description:"Activates the verbose mode (equivalent to debug logging level), overwriting any passed logging level"`
+	LogLevel      string         `long:"log-level" env:"GITBASE_LOG_LEVEL" choice:"info" choice:"debug" choice:"warning" choice:"error" choice:"fatal" default:"info" description:"logging level; ignored if using -v verbose flag"`
+}
+
+// init validates the common options and configures the logging level.
+func (c *engineOptions) init() error {
+	if c.Verbose {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	if c.Bare && c.NonBare {
+		return fmt.Errorf("cannot use both --bare and --non-bare")
+	}
+
+	// info is the default log level
+	if c.LogLevel != "info" {
+		if c.Verbose {
+			logrus.Infof(
+				"ignoring passed '%s' log-level, using requesed '-v' verbose flag instead",
+				c.LogLevel,
+			)
+		} else {
+			level, err := logrus.ParseLevel(c.LogLevel)
+			if err != nil {
+				return fmt.Errorf("cannot parse log level: %s", err.Error())
+			}
+			logrus.SetLevel(level)
+		}
+	}
+
+	return nil
+}
+
+func NewDatabaseEngine(
+	userAuth auth.Auth,
+	version string,
+	parallelism int,
+	squash bool,
+) *sqle.Engine {
+	catalog := sql.NewCatalog()
+	ab := analyzer.NewBuilder(catalog)
+
+	if parallelism == 0 {
+		parallelism = runtime.NumCPU()
+	}
+
+	if parallelism > 1 {
+		ab = ab.WithParallelism(parallelism)
+	}
+
+	ab = ab.AddPostAnalyzeRule(rule.OutdatedIndexesRule, rule.OutdatedIndexes)
+
+	if squash {
+		ab = ab.AddPostAnalyzeRule(rule.SquashJoinsRule, rule.SquashJoins)
+	}
+
+	ab = ab.AddPostAnalyzeRule(rule.LimitPushdownRule, rule.LimitPushdown)
+	ab = ab.AddPostAnalyzeRule(rule.PipelineUASTsRule, rule.PipelineUASTs)
+	ab = ab.AddPostAnalyzeRule(rule.CacheResultsRule, rule.CacheResults)
+	ab = ab.AddPostValidationRule(rule.TrackQueriesRule, rule.TrackQueries)
+
+	a := ab.Build()
+	engine := sqle.New(catalog, a, &sqle.Config{
+		VersionPostfix: version,
+		Auth:           userAuth,
+	})
+
+	return engine
+}
+
+func (c *engineOptions) buildDatabase() error {
+	if c.engine == nil {
+		c.engine = NewDatabaseEngine(
+			c.userAuth,
+			c.Version,
+			int(c.Parallelism),
+			!c.DisableSquash,
+		)
+	}
+
+	c.sharedCache = cache.NewObjectLRU(c.CacheSize * cache.MiByte)
+
+	if err := c.addDirectories(); err != nil {
+		return err
+	}
+
+	c.engine.AddDatabase(gitbase.NewDatabase(c.Name, c.pool))
+	c.engine.AddDatabase(sql.NewInformationSchemaDatabase(c.engine.Catalog))
+	c.engine.Catalog.SetCurrentDatabase(c.Name)
+	logrus.WithField("db", c.Name).Debug("registered database to catalog")
+
+	c.engine.Catalog.MustRegister(function.Functions...)
+	logrus.Debug("registered all available functions in catalog")
+
+	if err := c.registerDrivers(); err != nil {
+		return err
+	}
+
+	if err := c.loadViews(); err != nil {
+		return err
+	}
+
+	if !c.DisableSquash {
+		logrus.Info("squash tables rule is enabled")
+	} else {
+		logrus.Warn("squash tables rule is disabled")
+	}
+
+	return c.engine.Init()
+}
+
+func (c *engineOptions) registerDrivers() error {
+	if err := os.MkdirAll(c.IndexDir, 0755); err != nil {
+		return err
+	}
+
+	logrus.Debug("created index storage")
+
+	c.engine.Catalog.RegisterIndexDriver(
+		pilosa.NewDriver(filepath.Join(c.IndexDir, pilosa.DriverID)),
+	)
+	logrus.Debug("registered pilosa index driver")
+
+	return nil
+}
+
+// defaultBare returns the bare option of the directories that don't set it.
+func (c *engineOptions) defaultBare() bareOpt {
+	switch {
+	case c.Bare:
+		return bareOn
+	case c.NonBare:
+		return bareOff
+	default:
+		return bareAuto
+	}
+}
+
+// directories returns the repository directories given in the command line,
+// the configuration file and the ones added at runtime, with the default
+// library options applied.
+func (c *engineOptions) directories() ([]directory, error) {
+	defaultBare := c.defaultBare()
+
+	var dirs []directory
+	for _, d := range c.Directories {
+		dir := directory{
+			Path:   d,
+			Format: c.Format,
+			Bare:   defaultBare,
+			Bucket: c.Bucket,
+			Rooted: !c.NonRooted,
+		}
+
+		dir, err := parseDirectory(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		dirs = append(dirs, dir)
+	}
+
+	for _, d := range c.configDirectories {
+		dir := directory{
+			Path:   d.Path,
+			Format: c.Format,
+			Bare:   defaultBare,
+			Bucket: c.Bucket,
+			Rooted: !c.NonRooted,
+		}
+
+		if d.Format != nil {
+			dir.Format = *d.Format
+		}
+
+		if d.Bucket != nil {
+			dir.Bucket = *d.Bucket
+		}
+
+		if d.Rooted != nil {
+			dir.Rooted = *d.Rooted
+		}
+
+		if d.Bare != nil {
+			bare, err := parseBare(d.Bare)
+			if err != nil {
+				return nil, err
+			}
+			dir.Bare = bare
+		}
+
+		dirs = append(dirs, dir)
+	}
+
+	return append(dirs, c.runtimeDirectories...), nil
+}
+
+func (c *engineOptions) addDirectories() error {
+	if len(c.Directories) == 0 && len(c.configDirectories) == 0 {
+		logrus.Error("at least one folder should be provided.")
+	}
+
+	lib, err := c.buildLibrary(false)
+	if err != nil {
+		return err
+	}
+
+	repos, err := libraryRepositories(lib)
+	if err != nil {
+		return err
+	}
+
+	for id := range repos {
+		logrus.WithField("id", id).Debug("repository added")
+	}
+
+	c.repositories = repos
+	c.pool = gitbase.NewRepositoryPool(c.sharedCache, lib)
+	return nil
+}
+
+// buildLibrary creates a library with the repositories of all the
+// directories, without the removed ones. When skipMissing is true, the
+// directories that don't exist or can't be read are skipped instead of
+// failing.
+func (c *engineOptions) buildLibrary(skipMissing bool) (borges.Library, error) {
+	dirs, err := c.directories()
+	if err != nil {
+		return nil, err
+	}
+
+	lib := libraries.New(nil)
+	plainLib := plain.NewLibrary(borges.LibraryID("plain"), nil)
+	var hasPlain bool
+
+	for _, d := range dirs {
+		if skipMissing {
+			if fi, err := os.Stat(d.Path); err != nil || !fi.IsDir() {
+				logrus.WithField("path", d.Path).
+					Warn("repository directory not found, skipping it")
+				continue
+			}
+		}
+
+		if err := c.addDirectory(lib, plainLib, d); err != nil {
+			if !skipMissing {
+				return nil, err
+			}
+
+			logrus.WithFields(logrus.Fields{
+				"path":  d.Path,
+				"error": err,
+			}).Warn("unable to add repository directory, skipping it")
+			continue
+		}
+
+		if d.Format != "siva" {
+			hasPlain = true
+		}
+	}
+
+	if hasPlain {
+		if err := lib.Add(plainLib); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.filterLibrary(lib), nil
+}
+
+// addDirectory adds the repositories of a directory to the given library,
+// as a siva library, or to plainLib as a location in case of git
+// repositories.
+func (c *engineOptions) addDirectory(
+	lib *libraries.Libraries,
+	plainLib *plain.Library,
+	d directory,
+) error {
+	if d.Format == "siva" {
+		sivaLib, err := c.sivaLibrary(d)
+		if err != nil {
+			return err
+		}
+
+		return lib.Add(sivaLib)
+	}
+
+	loc, err := plainLocation(c.sharedCache, d)
+	if err != nil {
+		return err
+	}
+
+	plainLib.AddLocation(loc)
+	return nil
+}
+
+// sivaLibrary returns the siva library of the directory, reusing the one
+// created by a previous call if any, so their caches are kept.
+func (c *engineOptions) sivaLibrary(d directory) (borges.Library, error) {
+	if lib, ok := c.sivaLibraries[d]; ok {
+		return lib, nil
+	}
+
+	var lib borges.Library
+	var err error
+	if d.Rooted {
+		sivaOpts := &siva.LibraryOptions{
+			Transactional: true,
+			RootedRepo:    d.Rooted,
+			Cache:         c.sharedCache,
+			Bucket:        d.Bucket,
+			Performance:   true,
+			RegistryCache: 100000,
+		}
+
+		lib, err = siva.NewLibrary("", osfs.New(d.Path), sivaOpts)
+	} else {
+		sivaOpts := &legacysiva.LibraryOptions{
+			Cache:         c.sharedCache,
+			Bucket:        d.Bucket,
+			RegistryCache: 100000,
+		}
+
+		lib, err = legacysiva.NewLibrary(d.Path, osfs.New(d.Path), sivaOpts)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.sivaLibraries == nil {
+		c.sivaLibraries = make(map[directory]borges.Library)
+	}
+	c.sivaLibraries[d] = lib
+
+	return lib, nil
+}
+
+// plainLocation creates the location of a directory with git repositories.
+// Locations are cheap to create, so they are created again in every rescan
+// to discover again whether the repositories are bare.
+func plainLocation(sharedCache cache.Object, d directory) (*plain.Location, error) {
+	bare, err := discoverBare(d)
+	if err != nil {
+		return nil, err
+	}
+
+	plainOpts := &plain.LocationOptions{
+		Cache:       sharedCache,
+		Performance: true,
+		Bare:        bare,
+	}
+
+	return plain.NewLocation(
+		borges.LocationID(d.Path),
+		osfs.New(d.Path),
+		plainOpts)
+}
+
+// libraryRepositories returns the set of the IDs of the repositories in the
+// library.
+func libraryRepositories(lib borges.Library) (map[string]struct{}, error) {
+	repos, err := lib.Repositories(borges.ReadOnlyMode)
+	if err != nil {
+		return nil, err
+	}
+	defer repos.Close()
+
+	var ids = make(map[string]struct{})
+	err = repos.ForEach(func(r borges.Repository) error {
+		ids[r.ID().String()] = struct{}{}
+		return r.Close()
+	})
+
+	return ids, err
+}
+
+// rescan rebuilds the library with the repositories of the directories every
+// interval, until done is closed.
+func (c *engineOptions) rescan(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if _, _, err := c.reload(); err != nil {
+				logrus.WithField("error", err).
+					Error("unable to rescan repository directories")
+			}
+		}
+	}
+}
+
+// reload rebuilds the library with the repositories of the directories and,
+// if the repositories changed, replaces the library of the pool with it.
+// Replacing it discards the cached checksum of the pool, which makes the
+// indexes created before unusable until they are created again. It returns
+// the IDs of the repositories added and removed.
+func (c *engineOptions) reload() (added, removed []string, err error) {
+	c.libMut.Lock()
+	defer c.libMut.Unlock()
+	return c.reloadLocked(false)
+}
+
+// reloadLocked is like reload, but libMut must be held by the caller. With
+// force, the library is replaced even if the set of repositories didn't
+// change, which is needed when the contents of the repositories changed.
+func (c *engineOptions) reloadLocked(force bool) (added, removed []string, err error) {
+	lib, err := c.buildLibrary(true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repos, err := libraryRepositories(lib)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, id := range sortedKeys(repos) {
+		if _, ok := c.repositories[id]; !ok {
+			logrus.WithField("id", id).Info("repository added")
+			added = append(added, id)
+		}
+	}
+
+	for _, id := range sortedKeys(c.repositories) {
+		if _, ok := repos[id]; !ok {
+			logrus.WithField("id", id).Info("repository removed")
+			removed = append(removed, id)
+		}
+	}
+
+	if len(added) == 0 && len(removed) == 0 && !force {
+		// The repositories may have grown enough to be split since the
+		// partitions were counted.
+		c.pool.InvalidatePartitionCounts()
+		return nil, nil, nil
+	}
+
+	c.repositories = repos
+	c.pool.SetLibrary(lib)
+
+	logrus.WithFields(logrus.Fields{
+		"added":   len(added),
+		"removed": len(removed),
+	}).Info("repository library reloaded")
+
+	return added, removed, nil
+}
+
+// newContext creates a new query context with a fresh gitbase session over
+// the repository pool, used by the commands that run queries in-process.
+func (c *engineOptions) newContext(ctx context.Context) *sql.Context {
+	session := gitbase.NewSession(c.pool, c.sessionOptions()...)
+
+	return sql.NewContext(ctx,
+		sql.WithSession(session),
+		sql.WithPid(atomic.AddUint64(&c.lastPid, 1)),
+		sql.WithMemoryManager(c.engine.Catalog.MemoryManager),
+	)
+}
+
+// sessionOptions returns the options of the gitbase sessions.
+func (c *engineOptions) sessionOptions() []gitbase.SessionOption {
+	opts := []gitbase.SessionOption{
+		gitbase.WithSkipGitErrors(c.SkipGitErrors),
+	}
+
+	if c.bblfshEndpoint != "" {
+		opts = append(opts, gitbase.WithBblfshEndpoint(c.bblfshEndpoint))
+	}
+
+	if c.repositoryACL != nil {
+		opts = append(opts, gitbase.WithRepositoryACL(c.repositoryACL))
+	}
+
+	if c.queryStats {
+		opts = append(opts, gitbase.WithQueryStats(true))
+	}
+
+	if c.queryLimits != (gitbase.QueryLimits{}) {
+		opts = append(opts, gitbase.WithQueryLimits(c.queryLimits))
+	}
+
+	if c.resultCache != nil {
+		opts = append(opts, gitbase.WithResultCache(c.resultCache))
+	}
+
+	if c.SplitParts > 1 {
+		opts = append(opts, gitbase.WithRepositorySplit(gitbase.RepositorySplit{
+			Parts:   int(c.SplitParts),
+			MinSize: int64(c.SplitMinSize) * int64(cache.MiByte),
+		}))
+	}
+
+	return opts
+}
@@ -0,0 +1,114 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/parse"
+	"github.com/src-d/go-mysql-server/sql/plan"
+)
+
+// explainAnalyzeSchema is the schema of the result of EXPLAIN ANALYZE.
+var explainAnalyzeSchema = sql.Schema{
+	{Name: "plan", Type: sql.Text},
+}
+
+// explainAnalyze runs the given query discarding its rows and returns its
+// plan, followed by the rows produced, the time spent and the git objects
+// read by each one of its tables and, for squashed tables, each one of the
+// iterators they are made of.
+func (c *engineOptions) explainAnalyze(ctx *sql.Context, q string) ([]sql.Row, error) {
+	parsed, err := parse.Parse(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	switch parsed.(type) {
+	case *plan.CreateIndex, *plan.DropIndex, *plan.InsertInto, *plan.DeleteFrom,
+		*plan.Update, *plan.LockTables, *plan.UnlockTables, *plan.CreateTable,
+		*plan.DropTable, *plan.Set, *plan.Use, *plan.DescribeQuery:
+		return nil, fmt.Errorf("EXPLAIN ANALYZE is only supported for read queries")
+	}
+
+	if err := c.userAuth.Allowed(ctx, auth.ReadPerm); err != nil {
+		return nil, err
+	}
+
+	ctx, err = c.engine.Catalog.AddProcess(ctx, sql.QueryProcess, q)
+	if err != nil {
+		return nil, err
+	}
+	defer c.engine.Catalog.Done(ctx.Pid())
+
+	analyzed, err := c.engine.Analyzer.Analyze(ctx, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	// The query is always run, even if its results are cached.
+	analyzed, err = plan.TransformUp(analyzed, func(n sql.Node) (sql.Node, error) {
+		if cached, ok := n.(*gitbase.CachedResults); ok {
+			return cached.Child, nil
+		}
+		return n, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	profile := gitbase.NewProfile()
+	node, err := profile.Instrument(analyzed)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	iter, err := node.RowIter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	for {
+		_, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			_ = iter.Close()
+			return nil, err
+		}
+
+		count++
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	elapsed := time.Since(start)
+
+	p := sql.NewTreePrinter()
+	_ = p.WriteNode("Execution [rows=%d, time=%s]", count, elapsed.Round(time.Microsecond))
+	roots := profile.Roots()
+	children := make([]string, len(roots))
+	for i, n := range roots {
+		children[i] = n.String()
+	}
+	_ = p.WriteChildren(children...)
+
+	var rows []sql.Row
+	for _, s := range []string{analyzed.String(), p.String()} {
+		for _, line := range strings.Split(strings.TrimRight(s, "\n"), "\n") {
+			rows = append(rows, sql.NewRow(line))
+		}
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,84 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainAnalyze(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	s := &Server{engineOptions: engineOptions{
+		Name:        "gitbase",
+		CacheSize:   512,
+		Format:      "siva",
+		Bucket:      0,
+		LogLevel:    "info",
+		Directories: []string{"../../../_testdata"},
+		IndexDir:    filepath.Join(tmpDir, "index"),
+		userAuth:    new(auth.None),
+	}}
+	require.NoError(s.buildDatabase())
+
+	run := func(q string) ([]string, error) {
+		_, iter, err := s.runSQL(s.newContext(context.Background()), q)
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err := sql.RowIterToRows(iter)
+		if err != nil {
+			return nil, err
+		}
+
+		lines := make([]string, len(rows))
+		for i, row := range rows {
+			lines[i] = row[0].(string)
+		}
+		return lines, nil
+	}
+
+	lines, err := run(`EXPLAIN ANALYZE SELECT commit_hash
+		FROM refs NATURAL JOIN ref_commits NATURAL JOIN commits`)
+	require.NoError(err)
+
+	var execution int
+	for i, line := range lines {
+		if regexp.MustCompile(`^Execution \[rows=\d+, time=.+\]$`).MatchString(line) {
+			execution = i
+		}
+	}
+	require.NotZero(execution, lines)
+
+	stats := `\[rows=[1-9]\d*, time=[^,]+, objects=\d+\]`
+	expected := []string{
+		`^ └─ SquashedTable\(refs, ref_commits, commits\) \[partitions=5, ` + stats[2:],
+		`^     └─ squashRefCommitCommitsIter ` + stats,
+		`^         └─ squashRefHeadRefCommitsIter ` + stats,
+		`^             └─ squashRefIter ` + stats,
+	}
+
+	profile := lines[execution+1:]
+	require.Len(profile, len(expected), lines)
+	for i, re := range expected {
+		require.Regexp(re, profile[i])
+	}
+
+	_, err = run("EXPLAIN ANALYZE CREATE INDEX foo ON refs USING pilosa (ref_name)")
+	require.Error(err)
+
+	_, err = run("EXPLAIN ANALYZE SELECT * FROM foo")
+	require.Error(err)
+}
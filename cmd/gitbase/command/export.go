@@ -0,0 +1,151 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/src-d/gitbase/internal/export"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/src-d/go-mysql-server/sql"
+)
+
+const (
+	ExportDescription = "Runs a query and writes the results as Parquet or Arrow"
+	ExportHelp        = ExportDescription + "\n\n" +
+		"Builds an in-process gitbase engine over the given directories,\n" +
+		"runs the query passed as argument and writes the results to the\n" +
+		"given output file in a columnar format, keeping the types of the\n" +
+		"columns. The format is guessed from the output file extension\n" +
+		"unless --type is given. Use \"-\" as output to write an Arrow\n" +
+		"stream to the standard output."
+)
+
+// Export represents the `export` command of gitbase cli tool.
+type Export struct {
+	engineOptions
+
+	Output string `short:"o" long:"output" required:"true" description:"File where the results are written. Use - to write to the standard output."`
+	Type   string `short:"t" long:"type" choice:"parquet" choice:"arrow" description:"Format of the output file. By default, it's guessed from the output file extension."`
+
+	Args struct {
+		Query string `positional-arg-name:"query" required:"yes" description:"Query to run"`
+	} `positional-args:"yes" required:"yes"`
+
+	stdout io.Writer
+}
+
+// Execute runs the query and writes its results to the output file, it
+// honors the go-flags.Commander interface.
+func (c *Export) Execute(args []string) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+
+	format, err := c.format()
+	if err != nil {
+		return err
+	}
+
+	c.userAuth = new(auth.None)
+	if err := c.buildDatabase(); err != nil {
+		return err
+	}
+
+	ctx := c.newContext(context.Background())
+	logrus.WithField("query", c.Args.Query).Debug("running query")
+
+	schema, iter, err := c.engine.Query(ctx, c.Args.Query)
+	if err != nil {
+		return err
+	}
+
+	if c.Output == "-" {
+		out := c.stdout
+		if out == nil {
+			out = os.Stdout
+		}
+
+		_, err := exportRows(format, out, schema, iter)
+		return err
+	}
+
+	f, err := os.Create(c.Output)
+	if err != nil {
+		_ = iter.Close()
+		return err
+	}
+
+	rows, err := exportRows(format, f, schema, iter)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		_ = os.Remove(c.Output)
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"file": c.Output,
+		"rows": rows,
+	}).Info("query results exported")
+
+	return nil
+}
+
+func (c *Export) format() (string, error) {
+	if c.Type != "" {
+		return c.Type, nil
+	}
+
+	if c.Output == "-" {
+		return export.Arrow, nil
+	}
+
+	format := export.FormatFromPath(c.Output)
+	if format == "" {
+		return "", fmt.Errorf("unable to guess the format of %s, use --type to set it", c.Output)
+	}
+
+	return format, nil
+}
+
+// exportRows writes all the rows of the iterator to w in the given format
+// and returns the number of rows written. The iterator is always closed.
+func exportRows(format string, w io.Writer, schema sql.Schema, iter sql.RowIter) (int, error) {
+	ew, err := export.NewWriter(format, w, schema)
+	if err != nil {
+		_ = iter.Close()
+		return 0, err
+	}
+
+	var rows int
+	for {
+		row, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			_ = iter.Close()
+			return rows, err
+		}
+
+		if err := ew.Write(row); err != nil {
+			_ = iter.Close()
+			return rows, err
+		}
+
+		rows++
+	}
+
+	if err := iter.Close(); err != nil {
+		return rows, err
+	}
+
+	return rows, ew.Close()
+}
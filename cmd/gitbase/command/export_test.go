@@ -0,0 +1,104 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestExport(indexDir, output, query string) *Export {
+	cmd := &Export{
+		engineOptions: engineOptions{
+			CacheSize:   512,
+			Format:      "siva",
+			Bucket:      0,
+			LogLevel:    "info",
+			Directories: []string{"../../../_testdata"},
+			IndexDir:    indexDir,
+		},
+		Output: output,
+	}
+	cmd.Args.Query = query
+	return cmd
+}
+
+func TestExport(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	query := `SELECT ref_name, commit_hash FROM refs
+		WHERE repository_id = '015da2f4-6d89-7ec8-5ac9-a38329ea875b'
+		ORDER BY ref_name`
+
+	path := filepath.Join(tmpDir, "refs.arrow")
+	require.NoError(newTestExport(tmpDir, path, query).Execute(nil))
+
+	f, err := os.Open(path)
+	require.NoError(err)
+	defer f.Close()
+
+	r, err := ipc.NewFileReader(f)
+	require.NoError(err)
+	defer r.Close()
+
+	rec, err := r.Record(0)
+	require.NoError(err)
+	require.Equal(int64(2), rec.NumRows())
+	require.Equal("ref_name", rec.ColumnName(0))
+	require.Equal("HEAD", rec.Column(0).(*array.String).Value(0))
+
+	path = filepath.Join(tmpDir, "refs.parquet")
+	require.NoError(newTestExport(tmpDir, path, query).Execute(nil))
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(err)
+	require.Equal("PAR1", string(content[:4]))
+	require.Equal("PAR1", string(content[len(content)-4:]))
+}
+
+func TestExportStdout(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	var buf bytes.Buffer
+	cmd := newTestExport(tmpDir, "-", "SELECT 1 AS a")
+	cmd.stdout = &buf
+	require.NoError(cmd.Execute(nil))
+
+	r, err := ipc.NewReader(&buf)
+	require.NoError(err)
+	defer r.Release()
+
+	require.True(r.Next())
+	require.Equal(int64(1), r.Record().NumRows())
+}
+
+func TestExportErrors(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "out.csv")
+	require.Error(newTestExport(tmpDir, path, "SELECT 1").Execute(nil))
+
+	path = filepath.Join(tmpDir, "out.parquet")
+	err = newTestExport(tmpDir, path, "SELECT * FROM foo").Execute(nil)
+	require.Error(err)
+
+	_, err = os.Stat(path)
+	require.True(os.IsNotExist(err))
+}
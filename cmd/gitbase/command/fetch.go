@@ -0,0 +1,335 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-borges/plain"
+	"github.com/src-d/go-borges/siva"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+const (
+	FetchDescription = "Clones or fetches repositories into a repository directory"
+	FetchHelp        = FetchDescription + "\n\n" +
+		"Clones the repositories at the given URLs into one of the given\n" +
+		"directories, or fetches their branches and tags if they were\n" +
+		"already cloned. Local paths and file:// URLs are supported, as well\n" +
+		"as any other URL supported by git. Git directories get a repository\n" +
+		"for each URL and rooted siva directories a siva file. Running\n" +
+		"servers find the new repositories in the next rescan, the\n" +
+		"gitbase_fetch procedure can be used instead to fetch them from SQL."
+)
+
+// fetchRefSpecs are the references fetched from the remotes. Rooted siva
+// repositories store them under the references of their remote.
+var fetchRefSpecs = []config.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// sivaFetchRefSpecs are the references fetched into siva files, which can't
+// store symbolic references, so HEAD is kept as a hash reference.
+var sivaFetchRefSpecs = append([]config.RefSpec{"+HEAD:HEAD"}, fetchRefSpecs...)
+
+// Fetch represents the `fetch` command of gitbase cli tool.
+type Fetch struct {
+	engineOptions
+
+	To string `long:"to" description:"Directory where the repositories are written, it must be one of the given directories. It can be omitted when only one directory is given."`
+	ID string `long:"id" description:"ID of the repository, only valid with a single URL. By default, it's the last element of the URL path without the .git extension."`
+
+	Args struct {
+		URLs []string `positional-arg-name:"url" required:"1" description:"URL or path of the repository to fetch"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Execute fetches the repositories, it honors the go-flags.Commander
+// interface.
+func (c *Fetch) Execute(args []string) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+
+	if c.ID != "" && len(c.Args.URLs) > 1 {
+		return fmt.Errorf("--id can only be used with a single URL")
+	}
+
+	d, err := c.fetchDirectory(c.To)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(d.Path, 0755); err != nil {
+		return err
+	}
+
+	for _, url := range c.Args.URLs {
+		id, err := c.fetchRepository(context.Background(), d, url, c.ID)
+		if err != nil {
+			return fmt.Errorf("unable to fetch %s: %s", url, err)
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"id":  id,
+			"url": url,
+		}).Info("repository fetched")
+	}
+
+	return nil
+}
+
+// fetchDirectory returns the directory with the given path or URI, which can
+// be empty if there is only one directory.
+func (c *engineOptions) fetchDirectory(p string) (directory, error) {
+	dirs, err := c.directories()
+	if err != nil {
+		return directory{}, err
+	}
+
+	if p == "" {
+		switch len(dirs) {
+		case 0:
+			return directory{}, fmt.Errorf("no repository directories were given")
+		case 1:
+			return dirs[0], nil
+		default:
+			return directory{}, fmt.Errorf(
+				"there are several repository directories, one of them must be chosen")
+		}
+	}
+
+	target, err := parseDirectory(directory{Path: p})
+	if err != nil {
+		return directory{}, fmt.Errorf("invalid directory %s: %s", p, err)
+	}
+
+	for _, d := range dirs {
+		if filepath.Clean(d.Path) == filepath.Clean(target.Path) {
+			return d, nil
+		}
+	}
+
+	return directory{}, fmt.Errorf("%s is not a repository directory", p)
+}
+
+// fetchRepository clones the repository at the given URL into the directory,
+// or fetches it if it was already cloned, and returns its ID. If id is empty
+// it's derived from the URL.
+func (c *engineOptions) fetchRepository(
+	ctx context.Context,
+	d directory,
+	url, id string,
+) (string, error) {
+	if id == "" {
+		var err error
+		if id, err = repositoryIDFromURL(url); err != nil {
+			return "", err
+		}
+	}
+
+	if id == "" || path.IsAbs(id) || strings.Contains("/"+id+"/", "/../") {
+		return "", fmt.Errorf("invalid repository id %q", id)
+	}
+
+	if d.Format == "siva" {
+		return id, c.fetchSivaRepository(ctx, d, url, id)
+	}
+
+	return id, c.fetchPlainRepository(ctx, d, url, id)
+}
+
+// fetchPlainRepository fetches a repository into a directory with git
+// repositories. New repositories are bare unless the directory is not.
+func (c *engineOptions) fetchPlainRepository(
+	ctx context.Context,
+	d directory,
+	url, id string,
+) error {
+	fs := osfs.New(d.Path)
+	bare := d.Bare == bareOn
+	if d.Bare == bareAuto {
+		b, err := plain.IsFirstRepositoryBare(fs, "/")
+		switch {
+		case plain.ErrRepositoriesNotFound.Is(err):
+			bare = true
+		case err != nil:
+			return err
+		default:
+			bare = b
+		}
+	}
+
+	loc, err := plain.NewLocation(borges.LocationID(d.Path), fs, &plain.LocationOptions{
+		Cache: c.sharedCache,
+		Bare:  bare,
+	})
+	if err != nil {
+		return err
+	}
+
+	repoID := borges.RepositoryID(id)
+	exists, err := loc.Has(repoID)
+	if err != nil {
+		return err
+	}
+
+	r, err := loc.GetOrInit(repoID)
+	if err != nil {
+		return err
+	}
+
+	refs, err := fetchRemote(ctx, r.R(), "origin", url, fetchRefSpecs)
+	if err == nil {
+		err = setHead(r.R(), refs)
+	}
+
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil && !exists {
+		_ = os.RemoveAll(filepath.Join(d.Path, id))
+	}
+
+	return err
+}
+
+// fetchSivaRepository fetches a repository into a rooted siva directory.
+// New repositories get their own siva file.
+func (c *engineOptions) fetchSivaRepository(
+	ctx context.Context,
+	d directory,
+	url, id string,
+) error {
+	if !d.Rooted {
+		return fmt.Errorf("repositories can only be fetched into rooted siva directories")
+	}
+
+	l, err := c.sivaLibrary(d)
+	if err != nil {
+		return err
+	}
+
+	lib, ok := l.(*siva.Library)
+	if !ok {
+		return fmt.Errorf("siva directory %s is not writable", d.Path)
+	}
+
+	repoID := borges.RepositoryID(id)
+	exists, _, locID, err := lib.Has(repoID)
+	if err != nil {
+		return err
+	}
+
+	var loc borges.Location
+	if exists {
+		loc, err = lib.Location(locID)
+	} else {
+		locID = borges.LocationID(strings.Replace(id, "/", "_", -1))
+		loc, err = lib.AddLocation(locID)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	r, err := loc.GetOrInit(repoID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fetchRemote(ctx, r.R(), id, url, sivaFetchRefSpecs); err != nil {
+		_ = r.Close()
+		return err
+	}
+
+	return r.Commit()
+}
+
+// fetchRemote points the remote with the given name to the URL and fetches
+// the references of the refspecs. It returns the references of the remote.
+func fetchRemote(
+	ctx context.Context,
+	r *git.Repository,
+	name, url string,
+	refSpecs []config.RefSpec,
+) ([]*plumbing.Reference, error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	remoteCfg := &config.RemoteConfig{Name: name, URLs: []string{url}}
+	if prev, ok := cfg.Remotes[name]; ok {
+		remoteCfg.Fetch = prev.Fetch
+	}
+
+	if err := remoteCfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	cfg.Remotes[name] = remoteCfg
+	if err := r.Storer.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	remote := git.NewRemote(r.Storer, remoteCfg)
+	refs, err := remote.List(&git.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = remote.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: name,
+		RefSpecs:   refSpecs,
+		Tags:       git.NoTags,
+		Force:      true,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+// setHead points HEAD to the branch of the HEAD in the given remote
+// references, if any.
+func setHead(r *git.Repository, refs []*plumbing.Reference) error {
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return r.Storer.SetReference(
+				plumbing.NewSymbolicReference(plumbing.HEAD, ref.Target()),
+			)
+		}
+	}
+
+	return nil
+}
+
+// repositoryIDFromURL returns the last element of the path of the URL
+// without the .git extension.
+func repositoryIDFromURL(url string) (string, error) {
+	e, err := transport.NewEndpoint(url)
+	if err != nil {
+		return "", err
+	}
+
+	id := path.Base(strings.TrimRight(filepath.ToSlash(e.Path), "/"))
+	id = strings.TrimSuffix(id, ".git")
+	if id == "" || id == "." || id == "/" {
+		return "", fmt.Errorf("unable to get a repository id from %s", url)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,157 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// commitFile writes a file in the non bare repository at the given path and
+// commits it, returning the hash of the commit.
+func commitFile(t *testing.T, path, name, content string) plumbing.Hash {
+	t.Helper()
+	require := require.New(t)
+
+	r, err := git.PlainOpen(path)
+	if err == git.ErrRepositoryNotExists {
+		r, err = git.PlainInit(path, false)
+	}
+	require.NoError(err)
+
+	wt, err := r.Worktree()
+	require.NoError(err)
+
+	require.NoError(ioutil.WriteFile(filepath.Join(path, name), []byte(content), 0644))
+	_, err = wt.Add(name)
+	require.NoError(err)
+
+	hash, err := wt.Commit("add "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "gitbase", Email: "gitbase@src-d.tech", When: time.Now()},
+	})
+	require.NoError(err)
+
+	return hash
+}
+
+func newTestFetch(dir string, urls ...string) *Fetch {
+	cmd := &Fetch{engineOptions: engineOptions{
+		Format:      "git",
+		Bucket:      0,
+		LogLevel:    "info",
+		Directories: []string{dir},
+	}}
+	cmd.Args.URLs = urls
+	return cmd
+}
+
+func TestFetchPlain(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	src := filepath.Join(tmpDir, "src", "project.git")
+	first := commitFile(t, src, "README", "first")
+
+	dst := filepath.Join(tmpDir, "repos")
+	require.NoError(newTestFetch(dst, "file://"+src).Execute(nil))
+
+	r, err := git.PlainOpen(filepath.Join(dst, "project"))
+	require.NoError(err)
+
+	head, err := r.Head()
+	require.NoError(err)
+	require.Equal(plumbing.Master, head.Name())
+	require.Equal(first, head.Hash())
+
+	second := commitFile(t, src, "LICENSE", "second")
+
+	// local paths are also allowed
+	require.NoError(newTestFetch(dst, src).Execute(nil))
+
+	ref, err := r.Reference(plumbing.Master, true)
+	require.NoError(err)
+	require.Equal(second, ref.Hash())
+
+	cmd := newTestFetch(dst, src)
+	cmd.ID = "other/name"
+	require.NoError(cmd.Execute(nil))
+	_, err = git.PlainOpen(filepath.Join(dst, "other", "name"))
+	require.NoError(err)
+
+	cmd = newTestFetch(dst, src, src)
+	cmd.ID = "name"
+	require.Error(cmd.Execute(nil))
+
+	cmd = newTestFetch(dst, src)
+	cmd.ID = "../outside"
+	require.Error(cmd.Execute(nil))
+
+	// failed clones don't leave empty repositories behind
+	require.Error(newTestFetch(dst, filepath.Join(tmpDir, "missing")).Execute(nil))
+	_, err = os.Stat(filepath.Join(dst, "missing"))
+	require.True(os.IsNotExist(err))
+}
+
+func TestFetchSiva(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	src := filepath.Join(tmpDir, "src")
+	first := commitFile(t, src, "README", "first")
+
+	dst := "file://" + filepath.Join(tmpDir, "siva") + "?format=siva&bucket=0"
+	require.NoError(newTestFetch(dst, src).Execute(nil))
+
+	refs := func() []sql.Row {
+		server := &Server{engineOptions: engineOptions{
+			CacheSize:   512,
+			Format:      "git",
+			LogLevel:    "info",
+			Directories: []string{dst},
+			IndexDir:    filepath.Join(tmpDir, "index"),
+			userAuth:    new(auth.None),
+		}}
+		require.NoError(server.buildDatabase())
+
+		_, iter, err := server.engine.Query(
+			server.newContext(context.Background()),
+			"SELECT repository_id, ref_name, commit_hash FROM refs ORDER BY ref_name",
+		)
+		require.NoError(err)
+
+		rows, err := sql.RowIterToRows(iter)
+		require.NoError(err)
+		return rows
+	}
+
+	require.Equal([]sql.Row{
+		{"src", "HEAD", first.String()},
+		{"src", "refs/heads/master", first.String()},
+	}, refs())
+
+	second := commitFile(t, src, "LICENSE", "second")
+	require.NoError(newTestFetch(dst, src).Execute(nil))
+
+	require.Equal([]sql.Row{
+		{"src", "HEAD", second.String()},
+		{"src", "refs/heads/master", second.String()},
+	}, refs())
+
+	legacy := "file://" + filepath.Join(tmpDir, "legacy") + "?format=siva&rooted=false"
+	require.Error(newTestFetch(legacy, src).Execute(nil))
+}
@@ -0,0 +1,167 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/gitbase"
+)
+
+const (
+	// bblfshCheckInterval is the time between the checks of the connection
+	// with bblfsh done for the readiness endpoint.
+	bblfshCheckInterval = 10 * time.Second
+	bblfshCheckTimeout  = 5 * time.Second
+)
+
+// Values of the checks of the readiness endpoint.
+const (
+	checkOK            = "ok"
+	checkLoading       = "loading"
+	checkShuttingDown  = "shutting down"
+	checkNotConfigured = "not configured"
+)
+
+// healthChecker serves the liveness and readiness endpoints of the server.
+// The server is ready once the libraries are loaded, until it starts to shut
+// down, and as long as bblfsh can be reached if an endpoint was configured.
+type healthChecker struct {
+	mu       sync.RWMutex
+	loaded   bool
+	stopping bool
+	bblfsh   string
+
+	// session is used to connect to bblfsh, it's nil if no endpoint was
+	// configured.
+	session *gitbase.Session
+}
+
+// newHealthChecker creates a health checker that checks the connection with
+// bblfsh at the given endpoint, if any.
+func newHealthChecker(bblfshEndpoint string) *healthChecker {
+	h := &healthChecker{bblfsh: checkNotConfigured}
+	if bblfshEndpoint != "" {
+		h.bblfsh = checkLoading
+		h.session = gitbase.NewSession(nil, gitbase.WithBblfshEndpoint(bblfshEndpoint))
+	}
+
+	return h
+}
+
+// register adds the endpoints to the given mux.
+func (h *healthChecker) register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", h.healthz)
+	mux.HandleFunc("/readyz", h.readyz)
+}
+
+// setLoaded marks the libraries as loaded.
+func (h *healthChecker) setLoaded() {
+	h.mu.Lock()
+	h.loaded = true
+	h.mu.Unlock()
+}
+
+// setStopping marks the server as shutting down, so it's not ready anymore.
+func (h *healthChecker) setStopping() {
+	h.mu.Lock()
+	h.stopping = true
+	h.mu.Unlock()
+}
+
+// checkBblfsh checks the connection with bblfsh every bblfshCheckInterval
+// until done is closed. It does nothing if no endpoint was configured.
+func (h *healthChecker) checkBblfsh(done <-chan struct{}) {
+	if h.session == nil {
+		return
+	}
+	defer h.session.Close()
+
+	ticker := time.NewTicker(bblfshCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		status := checkOK
+		if err := h.pingBblfsh(); err != nil {
+			status = err.Error()
+		}
+
+		h.mu.Lock()
+		if status != h.bblfsh && status != checkOK {
+			logrus.WithField("error", status).Warn("unable to reach bblfsh")
+		}
+		h.bblfsh = status
+		h.mu.Unlock()
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *healthChecker) pingBblfsh() error {
+	client, err := h.session.BblfshClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), bblfshCheckTimeout)
+	defer cancel()
+
+	_, err = client.NewVersionRequest().Context(ctx).Do()
+	return err
+}
+
+// healthz reports that the server is alive.
+func (h *healthChecker) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{"status": checkOK})
+}
+
+// readinessStatus is the response of the readiness endpoint.
+type readinessStatus struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// readyz reports whether the server is ready to accept queries, with the
+// state of each one of the checks.
+func (h *healthChecker) readyz(w http.ResponseWriter, r *http.Request) {
+	status := h.status()
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logrus.WithField("error", err).Warn("unable to write HTTP response")
+	}
+}
+
+func (h *healthChecker) status() readinessStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	status := readinessStatus{Checks: map[string]string{
+		"libraries": checkOK,
+		"server":    checkOK,
+		"bblfsh":    h.bblfsh,
+	}}
+
+	if !h.loaded {
+		status.Checks["libraries"] = checkLoading
+	}
+
+	if h.stopping {
+		status.Checks["server"] = checkShuttingDown
+	}
+
+	status.Ready = h.loaded && !h.stopping &&
+		(h.bblfsh == checkOK || h.bblfsh == checkNotConfigured)
+
+	return status
+}
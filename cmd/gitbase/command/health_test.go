@@ -0,0 +1,74 @@
+package command
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthChecker(t *testing.T) {
+	require := require.New(t)
+
+	h := newHealthChecker("")
+	mux := http.NewServeMux()
+	h.register(mux)
+
+	get := func(path string) (int, readinessStatus) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		var status readinessStatus
+		if path == "/readyz" {
+			require.NoError(json.NewDecoder(w.Body).Decode(&status))
+		}
+		return w.Code, status
+	}
+
+	code, _ := get("/healthz")
+	require.Equal(http.StatusOK, code)
+
+	code, status := get("/readyz")
+	require.Equal(http.StatusServiceUnavailable, code)
+	require.False(status.Ready)
+	require.Equal(map[string]string{
+		"libraries": checkLoading,
+		"server":    checkOK,
+		"bblfsh":    checkNotConfigured,
+	}, status.Checks)
+
+	h.setLoaded()
+	code, status = get("/readyz")
+	require.Equal(http.StatusOK, code)
+	require.True(status.Ready)
+	require.Equal(checkOK, status.Checks["libraries"])
+
+	h.setStopping()
+	code, status = get("/readyz")
+	require.Equal(http.StatusServiceUnavailable, code)
+	require.False(status.Ready)
+	require.Equal(checkShuttingDown, status.Checks["server"])
+
+	code, _ = get("/healthz")
+	require.Equal(http.StatusOK, code)
+}
+
+func TestHealthCheckerBblfsh(t *testing.T) {
+	require := require.New(t)
+
+	h := newHealthChecker("127.0.0.1:1")
+	h.setLoaded()
+	require.False(h.status().Ready)
+	require.Equal(checkLoading, h.status().Checks["bblfsh"])
+
+	done := make(chan struct{})
+	close(done)
+	h.checkBblfsh(done)
+
+	status := h.status()
+	require.False(status.Ready)
+	require.NotEqual(checkOK, status.Checks["bblfsh"])
+	require.NotEqual(checkLoading, status.Checks["bblfsh"])
+}
@@ -0,0 +1,468 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/src-d/go-mysql-server/sql"
+	"vitess.io/vitess/go/mysql"
+)
+
+// Formats of the results of the HTTP query API.
+const (
+	ndjsonFormat = "ndjson"
+	csvFormat    = "csv"
+)
+
+// errorTrailer is the HTTP trailer with the error that interrupted a query
+// after its results started to be written.
+const errorTrailer = "X-Gitbase-Error"
+
+// maxQuerySize is the maximum size of the body of a query request.
+const maxQuerySize = 1 << 20
+
+var errInsecureTransport = fmt.Errorf("server does not allow insecure connections, client must use TLS")
+
+// httpAPI serves the HTTP query API. Requests are authenticated with HTTP
+// basic authentication against the same users as the MySQL server, and every
+// request runs in a new session created like the ones of MySQL connections.
+// Requests not using TLS are refused when requireSecure is set, and slow
+// queries are written to slowLog, if any.
+type httpAPI struct {
+	opts          *engineOptions
+	host          string
+	auth          mysql.AuthServer
+	requireSecure bool
+	slowLog       *slowQueryLog
+
+	// lastID is the number of sessions created. Their connection IDs count
+	// down from the greatest one, so they don't clash with the ones of the
+	// MySQL connections, which count up.
+	lastID uint32
+}
+
+func newHTTPAPI(
+	opts *engineOptions,
+	host string,
+	requireSecure bool,
+	slowLog *slowQueryLog,
+) http.Handler {
+	a := &httpAPI{
+		opts:          opts,
+		host:          host,
+		auth:          opts.userAuth.Mysql(),
+		requireSecure: requireSecure,
+		slowLog:       slowLog,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/query", a.query)
+	mux.HandleFunc("/tables", a.tables)
+	mux.HandleFunc("/functions", a.functions)
+	return mux
+}
+
+// httpAddr is the address of an HTTP client.
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
+
+// newContext authenticates the request and returns a context with a new
+// session of its user. If the request can't be authenticated, the error is
+// written to the response and ok is false.
+func (a *httpAPI) newContext(w http.ResponseWriter, r *http.Request, query string) (ctx *sql.Context, ok bool) {
+	if a.requireSecure && r.TLS == nil {
+		writeHTTPError(w, http.StatusForbidden, errInsecureTransport)
+		return nil, false
+	}
+
+	user, password, _ := r.BasicAuth()
+	salt, err := mysql.NewSalt()
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	scramble := mysql.ScramblePassword(salt, []byte(password))
+	_, err = a.auth.ValidateHash(salt, user, scramble, httpAddr(r.RemoteAddr))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="gitbase"`)
+		writeHTTPError(w, http.StatusUnauthorized, err)
+		return nil, false
+	}
+
+	id := math.MaxUint32 - atomic.AddUint32(&a.lastID, 1) + 1
+	session := gitbase.NewClientSession(
+		a.opts.pool,
+		a.host, r.RemoteAddr, user, id,
+		a.opts.sessionOptions()...,
+	)
+
+	return sql.NewContext(r.Context(),
+		sql.WithSession(session),
+		sql.WithPid(uint64(id)<<32),
+		sql.WithQuery(query),
+		sql.WithMemoryManager(a.opts.engine.Catalog.MemoryManager),
+	), true
+}
+
+// query runs the query in the body of a POST request and streams its rows
+// as JSON lines or CSV. The body is either the query itself or a JSON object
+// with the query in its "query" key.
+func (a *httpAPI) query(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPError(w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	format, err := resultFormat(r)
+	if err != nil {
+		writeHTTPError(w, http.StatusNotAcceptable, err)
+		return
+	}
+
+	q, err := readQuery(w, r)
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, ok := a.newContext(w, r, q)
+	if !ok {
+		return
+	}
+	defer closeSession(ctx.Session)
+
+	run := func() (int64, error) {
+		return a.runQuery(ctx, w, format, q)
+	}
+
+	start := time.Now()
+	if a.slowLog != nil {
+		err = a.slowLog.track(ctx.Session, ctx.Client().User, q, run)
+	} else {
+		_, err = run()
+	}
+
+	if a, ok := a.opts.userAuth.(*auth.Audit); ok {
+		a.Query(ctx, time.Since(start), err)
+	}
+}
+
+// runQuery runs the query and writes its rows to the response, returning
+// the number of rows written.
+func (a *httpAPI) runQuery(ctx *sql.Context, w http.ResponseWriter, format, q string) (int64, error) {
+	schema, iter, err := a.opts.runSQL(ctx, q)
+	if err != nil {
+		writeHTTPError(w, queryErrorStatus(err), err)
+		return 0, err
+	}
+
+	// the first row is read before writing anything, so the errors of
+	// most of the queries that fail can still be reported with the status
+	row, err := iter.Next()
+	if err != nil && err != io.EOF {
+		_ = iter.Close()
+		writeHTTPError(w, queryErrorStatus(err), err)
+		return 0, err
+	}
+
+	iter = &peekedRowIter{RowIter: iter, row: row, eof: err == io.EOF}
+
+	out := &flushWriter{w: w}
+	out.flusher, _ = w.(http.Flusher)
+
+	var rw rowWriter
+	if format == csvFormat {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		rw, _ = newRowWriter(CSVFormat, out)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		rw, _ = newRowWriter(JSONFormat, out)
+	}
+
+	w.Header().Set("Trailer", errorTrailer)
+	w.WriteHeader(http.StatusOK)
+
+	n, err := writeRows(rw, schema, iter)
+	if err != nil {
+		w.Header().Set(errorTrailer, err.Error())
+		logrus.WithField("error", err).Warn("HTTP query interrupted")
+	}
+
+	return int64(n), err
+}
+
+// tableInfo is the description of a table returned by GET /tables.
+type tableInfo struct {
+	Name    string       `json:"name"`
+	Columns []columnInfo `json:"columns"`
+}
+
+type columnInfo struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// tables writes the tables of the database and their columns.
+func (a *httpAPI) tables(w http.ResponseWriter, r *http.Request) {
+	if !a.introspect(w, r) {
+		return
+	}
+
+	db, err := a.opts.engine.Catalog.Database(a.opts.Name)
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var tables = make([]tableInfo, 0, len(db.Tables()))
+	for name, table := range db.Tables() {
+		info := tableInfo{Name: name, Columns: make([]columnInfo, len(table.Schema()))}
+		for i, col := range table.Schema() {
+			info.Columns[i] = columnInfo{
+				Name:     col.Name,
+				Type:     col.Type.String(),
+				Nullable: col.Nullable,
+			}
+		}
+		tables = append(tables, info)
+	}
+
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Name < tables[j].Name
+	})
+
+	writeJSON(w, tables)
+}
+
+// functionInfo is the description of a function returned by GET /functions.
+type functionInfo struct {
+	Name string `json:"name"`
+	// Arguments is the number of arguments, or -1 if the function takes a
+	// variable number of them.
+	Arguments int `json:"arguments"`
+}
+
+// functions writes the functions that can be used in the queries.
+func (a *httpAPI) functions(w http.ResponseWriter, r *http.Request) {
+	if !a.introspect(w, r) {
+		return
+	}
+
+	var functions = make([]functionInfo, 0, len(a.opts.engine.Catalog.FunctionRegistry))
+	for name, fn := range a.opts.engine.Catalog.FunctionRegistry {
+		functions = append(functions, functionInfo{
+			Name:      name,
+			Arguments: functionArguments(fn),
+		})
+	}
+
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].Name < functions[j].Name
+	})
+
+	writeJSON(w, functions)
+}
+
+// introspect checks the method, user and permissions of an introspection
+// request, writing the error to the response if it's not allowed.
+func (a *httpAPI) introspect(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet)
+		writeHTTPError(w, http.StatusMethodNotAllowed, nil)
+		return false
+	}
+
+	ctx, ok := a.newContext(w, r, "")
+	if !ok {
+		return false
+	}
+	defer closeSession(ctx.Session)
+
+	if err := a.opts.userAuth.Allowed(ctx, auth.ReadPerm); err != nil {
+		writeHTTPError(w, http.StatusForbidden, err)
+		return false
+	}
+
+	return true
+}
+
+func functionArguments(fn sql.Function) int {
+	switch fn.(type) {
+	case sql.Function0:
+		return 0
+	case sql.Function1:
+		return 1
+	case sql.Function2:
+		return 2
+	case sql.Function3:
+		return 3
+	case sql.Function4:
+		return 4
+	case sql.Function5:
+		return 5
+	case sql.Function6:
+		return 6
+	case sql.Function7:
+		return 7
+	default:
+		return -1
+	}
+}
+
+// resultFormat returns the format of the results requested with the format
+// parameter or, if it's not given, the Accept header.
+func resultFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		switch strings.ToLower(format) {
+		case ndjsonFormat, JSONFormat:
+			return ndjsonFormat, nil
+		case csvFormat:
+			return csvFormat, nil
+		default:
+			return "", ErrUnknownFormat.New(format)
+		}
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		typ, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && typ == "text/csv" {
+			return csvFormat, nil
+		}
+	}
+
+	return ndjsonFormat, nil
+}
+
+// readQuery returns the query in the body of the request.
+func readQuery(w http.ResponseWriter, r *http.Request) (string, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxQuerySize))
+	if err != nil {
+		return "", err
+	}
+
+	typ, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if typ == "application/json" {
+		var req struct {
+			Query string `json:"query"`
+		}
+
+		if err := json.Unmarshal(body, &req); err != nil {
+			return "", err
+		}
+
+		body = []byte(req.Query)
+	}
+
+	q := strings.TrimSpace(string(body))
+	if q == "" {
+		return "", fmt.Errorf("a query must be provided")
+	}
+
+	return q, nil
+}
+
+// queryErrorStatus returns the HTTP status of an error running a query.
+func queryErrorStatus(err error) int {
+	if auth.ErrNotAuthorized.Is(err) {
+		return http.StatusForbidden
+	}
+
+	return http.StatusBadRequest
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	msg := http.StatusText(status)
+	if err != nil {
+		msg = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.WithField("error", err).Warn("unable to write HTTP response")
+	}
+}
+
+// peekedRowIter is a row iterator whose first row was already read.
+type peekedRowIter struct {
+	sql.RowIter
+	row    sql.Row
+	eof    bool
+	peeked bool
+}
+
+func (i *peekedRowIter) Next() (sql.Row, error) {
+	if i.eof {
+		return nil, io.EOF
+	}
+
+	if !i.peeked {
+		i.peeked = true
+		return i.row, nil
+	}
+
+	return i.RowIter.Next()
+}
+
+// flushWriter flushes the response after every write, so the rows are sent
+// to the client as they are produced.
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if f.flusher != nil {
+		f.flusher.Flush()
+	}
+	return n, err
+}
+
+// newHTTPServer returns the server of the HTTP query API. It can't be
+// created without TLS if the server requires secure transport, as the
+// credentials of the requests would be sent in plain text.
+func (c *Server) newHTTPServer() (*http.Server, error) {
+	if c.RequireSecureTransport && c.tlsConfig == nil {
+		return nil, fmt.Errorf("--require-secure-transport requires TLS to serve the HTTP query API")
+	}
+
+	// HTTP basic authentication sends the passwords in plain text, so they
+	// can only be used over TLS.
+	if (c.Password != "" || c.UserFile != "") && c.tlsConfig == nil {
+		return nil, fmt.Errorf("the HTTP query API requires TLS with --tls-cert and --tls-key when users have passwords")
+	}
+
+	return &http.Server{
+		Addr: net.JoinHostPort(c.Host, strconv.Itoa(c.HTTPPort)),
+		Handler: newHTTPAPI(
+			&c.engineOptions, c.Host,
+			c.RequireSecureTransport, c.slowLog,
+		),
+		TLSConfig: c.tlsConfig,
+	}, nil
+}
@@ -0,0 +1,267 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPAPI(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	users := writeConfig(t, tmpDir, "users.json", `[
+		{"name": "root", "password": "secret", "permissions": ["read", "write"]},
+		{"name": "reader", "password": "", "permissions": ["read"]}
+	]`)
+	userAuth, err := auth.NewNativeFile(users)
+	require.NoError(err)
+
+	s := &Server{engineOptions: engineOptions{
+		Name:        "gitbase",
+		CacheSize:   512,
+		Format:      "siva",
+		Bucket:      0,
+		LogLevel:    "info",
+		Directories: []string{"../../../_testdata"},
+		IndexDir:    filepath.Join(tmpDir, "index"),
+		userAuth:    userAuth,
+	}}
+	require.NoError(s.buildDatabase())
+
+	srv := httptest.NewServer(newHTTPAPI(&s.engineOptions, "localhost", false, nil))
+	defer srv.Close()
+
+	do := func(method, path, user, password, contentType, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(err)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(err)
+		return resp
+	}
+
+	errorOf := func(resp *http.Response) string {
+		defer resp.Body.Close()
+		var e struct {
+			Error string `json:"error"`
+		}
+		require.NoError(json.NewDecoder(resp.Body).Decode(&e))
+		return e.Error
+	}
+
+	const query = "SELECT repository_id, ref_name FROM refs WHERE ref_name = 'HEAD' ORDER BY repository_id"
+
+	resp := do("POST", "/query", "", "", "", query)
+	require.Equal(http.StatusUnauthorized, resp.StatusCode)
+	require.NotEmpty(resp.Header.Get("WWW-Authenticate"))
+	require.NotEmpty(errorOf(resp))
+
+	resp = do("POST", "/query", "root", "wrong", "", query)
+	require.Equal(http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+
+	resp = do("GET", "/query", "root", "secret", "", query)
+	require.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+	resp.Body.Close()
+
+	resp = do("POST", "/query", "root", "secret", "", query)
+	require.Equal(http.StatusOK, resp.StatusCode)
+	require.Equal("application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	var rows []map[string]interface{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var row map[string]interface{}
+		require.NoError(json.Unmarshal(scanner.Bytes(), &row))
+		rows = append(rows, row)
+	}
+	require.NoError(scanner.Err())
+	resp.Body.Close()
+	require.Empty(resp.Trailer.Get(errorTrailer))
+
+	require.Len(rows, 5)
+	for _, row := range rows {
+		require.Equal("HEAD", row["ref_name"])
+		require.NotEmpty(row["repository_id"])
+	}
+
+	resp = do("POST", "/query?format=csv", "reader", "", "application/json",
+		`{"query": "`+query+`"}`)
+	require.Equal(http.StatusOK, resp.StatusCode)
+	require.Equal("text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	require.NoError(err)
+	resp.Body.Close()
+	require.Len(records, 6)
+	require.Equal([]string{"repository_id", "ref_name"}, records[0])
+	require.Equal(rows[0]["repository_id"], records[1][0])
+
+	resp = do("POST", "/query?format=xml", "reader", "", "", query)
+	require.Equal(http.StatusNotAcceptable, resp.StatusCode)
+	resp.Body.Close()
+
+	resp = do("POST", "/query", "reader", "", "", "   ")
+	require.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	resp = do("POST", "/query", "reader", "", "", "SELECT * FROM foo")
+	require.Equal(http.StatusBadRequest, resp.StatusCode)
+	require.Contains(errorOf(resp), "foo")
+
+	resp = do("POST", "/query", "reader", "", "",
+		"CREATE INDEX foo ON refs USING pilosa (ref_name)")
+	require.Equal(http.StatusForbidden, resp.StatusCode)
+	resp.Body.Close()
+
+	resp = do("GET", "/tables", "reader", "", "", "")
+	require.Equal(http.StatusOK, resp.StatusCode)
+	var tables []tableInfo
+	require.NoError(json.NewDecoder(resp.Body).Decode(&tables))
+	resp.Body.Close()
+
+	var commits *tableInfo
+	for i, table := range tables {
+		if table.Name == "commits" {
+			commits = &tables[i]
+		}
+	}
+	require.NotNil(commits)
+	require.Equal(columnInfo{"repository_id", "TEXT", false}, commits.Columns[0])
+
+	resp = do("GET", "/functions", "reader", "", "", "")
+	require.Equal(http.StatusOK, resp.StatusCode)
+	var functions []functionInfo
+	require.NoError(json.NewDecoder(resp.Body).Decode(&functions))
+	resp.Body.Close()
+	require.Contains(functions, functionInfo{"is_tag", 1})
+	require.Contains(functions, functionInfo{"language", -1})
+
+	resp = do("GET", "/tables", "nobody", "", "", "")
+	require.Equal(http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestHTTPAPITracking(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	s := &Server{engineOptions: engineOptions{
+		Name:        "gitbase",
+		CacheSize:   512,
+		Format:      "siva",
+		Bucket:      0,
+		LogLevel:    "info",
+		Directories: []string{"../../../_testdata"},
+		IndexDir:    filepath.Join(tmpDir, "index"),
+		userAuth:    new(auth.None),
+		queryStats:  true,
+	}}
+	require.NoError(s.buildDatabase())
+
+	_, err = s.newHTTPServer()
+	require.NoError(err)
+
+	// the HTTP API can't be served without TLS if it's required
+	s.RequireSecureTransport = true
+	_, err = s.newHTTPServer()
+	require.Error(err)
+	s.RequireSecureTransport = false
+
+	// nor if the users have passwords, which would be sent in plain text
+	s.Password = "secret"
+	_, err = s.newHTTPServer()
+	require.Error(err)
+	s.Password = ""
+
+	s.UserFile = "users.json"
+	_, err = s.newHTTPServer()
+	require.Error(err)
+	s.UserFile = ""
+
+	srv := httptest.NewServer(newHTTPAPI(&s.engineOptions, "localhost", true, nil))
+	resp, err := http.Post(srv.URL+"/query", "", strings.NewReader("SELECT 1"))
+	require.NoError(err)
+	resp.Body.Close()
+	srv.Close()
+	require.Equal(http.StatusForbidden, resp.StatusCode)
+
+	var buf bytes.Buffer
+	srv = httptest.NewServer(newHTTPAPI(&s.engineOptions, "localhost", false, newSlowQueryLog(&buf, 0)))
+	req, err := http.NewRequest("POST", srv.URL+"/query",
+		strings.NewReader("SELECT repository_id FROM refs WHERE ref_name = 'HEAD'"))
+	require.NoError(err)
+	req.SetBasicAuth("root", "")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(err)
+	_, err = ioutil.ReadAll(resp.Body)
+	require.NoError(err)
+	resp.Body.Close()
+	require.Equal(http.StatusOK, resp.StatusCode)
+
+	// closing the server waits for the query to be logged
+	srv.Close()
+
+	var entry slowQueryEntry
+	require.NoError(json.NewDecoder(&buf).Decode(&entry))
+	require.Equal("root", entry.User)
+	require.Equal(int64(5), entry.RowsReturned)
+	require.True(entry.RowsRead >= 5, entry.RowsRead)
+	require.Equal(5, entry.Repositories)
+}
+
+func TestResultFormat(t *testing.T) {
+	testCases := []struct {
+		url      string
+		accept   string
+		expected string
+		err      bool
+	}{
+		{"/query", "", ndjsonFormat, false},
+		{"/query", "text/csv", csvFormat, false},
+		{"/query", "application/json, text/csv;q=0.5", csvFormat, false},
+		{"/query?format=CSV", "", csvFormat, false},
+		{"/query?format=json", "text/csv", ndjsonFormat, false},
+		{"/query?format=xml", "", "", true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.url+" "+tt.accept, func(t *testing.T) {
+			require := require.New(t)
+			r := httptest.NewRequest("POST", tt.url, nil)
+			r.Header.Set("Accept", tt.accept)
+
+			format, err := resultFormat(r)
+			if tt.err {
+				require.Error(err)
+				return
+			}
+
+			require.NoError(err)
+			require.Equal(tt.expected, format)
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package command
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/src-d/go-mysql-server/sql"
+	errors "gopkg.in/src-d/go-errors.v1"
+)
+
+// Output formats supported by the commands that print query results.
+const (
+	TableFormat    = "table"
+	CSVFormat      = "csv"
+	TSVFormat      = "tsv"
+	JSONFormat     = "json"
+	MarkdownFormat = "markdown"
+)
+
+// ErrUnknownFormat is returned when an output format is not supported.
+var ErrUnknownFormat = errors.NewKind("unknown output format: %s")
+
+// rowWriter writes the results of a query to some output.
+type rowWriter interface {
+	// WriteHeader is called once with the schema of the result before
+	// any row is written.
+	WriteHeader(sql.Schema) error
+	// WriteRow writes a single row of the result.
+	WriteRow(sql.Row) error
+	// Flush is called after the last row of the result is written.
+	Flush() error
+}
+
+// newRowWriter returns a rowWriter for the given format writing to w.
+func newRowWriter(format string, w io.Writer) (rowWriter, error) {
+	switch strings.ToLower(format) {
+	case TableFormat:
+		return &tableWriter{w: w}, nil
+	case MarkdownFormat:
+		return &tableWriter{w: w, markdown: true}, nil
+	case CSVFormat:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case TSVFormat:
+		cw := csv.NewWriter(w)
+		cw.Comma = '\t'
+		return &csvWriter{w: cw}, nil
+	case JSONFormat:
+		return &jsonWriter{w: w}, nil
+	default:
+		return nil, ErrUnknownFormat.New(format)
+	}
+}
+
+// writeRows writes all the rows of the iterator using the given writer and
+// returns the number of rows written. The iterator is always closed.
+func writeRows(w rowWriter, schema sql.Schema, iter sql.RowIter) (int, error) {
+	if err := w.WriteHeader(schema); err != nil {
+		_ = iter.Close()
+		return 0, err
+	}
+
+	var n int
+	for {
+		row, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			_ = iter.Close()
+			return n, err
+		}
+
+		if err := w.WriteRow(row); err != nil {
+			_ = iter.Close()
+			return n, err
+		}
+		n++
+	}
+
+	if err := iter.Close(); err != nil {
+		return n, err
+	}
+
+	return n, w.Flush()
+}
+
+// textValue returns the textual representation of a value, the same one
+// a MySQL client would receive. ok is false if the value is NULL.
+func textValue(typ sql.Type, v interface{}) (s string, ok bool, err error) {
+	if v == nil {
+		return "", false, nil
+	}
+
+	val, err := typ.SQL(v)
+	if err != nil {
+		return "", false, err
+	}
+
+	if val.IsNull() {
+		return "", false, nil
+	}
+
+	return val.ToString(), true, nil
+}
+
+// tableWriter buffers all the rows of a result and prints them as a table
+// with aligned columns, either in the MySQL client or Markdown style.
+type tableWriter struct {
+	w        io.Writer
+	markdown bool
+	header   []string
+	rows     [][]string
+	schema   sql.Schema
+}
+
+func (t *tableWriter) WriteHeader(schema sql.Schema) error {
+	t.schema = schema
+	t.rows = nil
+	t.header = make([]string, len(schema))
+	for i, col := range schema {
+		t.header[i] = col.Name
+	}
+	return nil
+}
+
+func (t *tableWriter) WriteRow(row sql.Row) error {
+	var cells = make([]string, len(row))
+	for i, v := range row {
+		s, ok, err := textValue(t.schema[i].Type, v)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			s = "NULL"
+		}
+
+		if t.markdown {
+			s = strings.Replace(s, "|", `\|`, -1)
+			s = strings.Replace(s, "\n", "<br>", -1)
+		}
+
+		cells[i] = s
+	}
+
+	t.rows = append(t.rows, cells)
+	return nil
+}
+
+func (t *tableWriter) Flush() error {
+	var widths = make([]int, len(t.header))
+	for i, h := range t.header {
+		widths[i] = utf8.RuneCountInString(h)
+	}
+
+	for _, row := range t.rows {
+		for i, c := range row {
+			if n := utf8.RuneCountInString(c); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if t.markdown {
+		t.writeLine(&buf, t.header, widths, "|")
+		var sep = make([]string, len(widths))
+		for i, w := range widths {
+			sep[i] = strings.Repeat("-", w)
+		}
+		t.writeLine(&buf, sep, widths, "|")
+		for _, row := range t.rows {
+			t.writeLine(&buf, row, widths, "|")
+		}
+	} else {
+		if len(t.header) > 0 {
+			t.writeSeparator(&buf, widths)
+			t.writeLine(&buf, t.header, widths, "|")
+			t.writeSeparator(&buf, widths)
+			for _, row := range t.rows {
+				t.writeLine(&buf, row, widths, "|")
+			}
+			t.writeSeparator(&buf, widths)
+		}
+		fmt.Fprintf(&buf, "%d %s in set\n", len(t.rows), pluralize(len(t.rows), "row", "rows"))
+	}
+
+	t.rows = nil
+	_, err := t.w.Write(buf.Bytes())
+	return err
+}
+
+func (t *tableWriter) writeSeparator(buf *bytes.Buffer, widths []int) {
+	buf.WriteString("+")
+	for _, w := range widths {
+		buf.WriteString(strings.Repeat("-", w+2))
+		buf.WriteString("+")
+	}
+	buf.WriteString("\n")
+}
+
+func (t *tableWriter) writeLine(buf *bytes.Buffer, cells []string, widths []int, sep string) {
+	buf.WriteString(sep)
+	for i, c := range cells {
+		buf.WriteString(" ")
+		buf.WriteString(c)
+		buf.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(c)))
+		buf.WriteString(" ")
+		buf.WriteString(sep)
+	}
+	buf.WriteString("\n")
+}
+
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
+
+// csvWriter streams the rows as comma or tab separated values. NULL values
+// are written as empty fields.
+type csvWriter struct {
+	w      *csv.Writer
+	schema sql.Schema
+}
+
+func (c *csvWriter) WriteHeader(schema sql.Schema) error {
+	c.schema = schema
+	var header = make([]string, len(schema))
+	for i, col := range schema {
+		header[i] = col.Name
+	}
+	return c.w.Write(header)
+}
+
+func (c *csvWriter) WriteRow(row sql.Row) error {
+	var record = make([]string, len(row))
+	for i, v := range row {
+		s, _, err := textValue(c.schema[i].Type, v)
+		if err != nil {
+			return err
+		}
+		record[i] = s
+	}
+
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonWriter streams every row as a JSON object in its own line, keyed by
+// column name and keeping the column order of the schema.
+type jsonWriter struct {
+	w      io.Writer
+	schema sql.Schema
+	buf    bytes.Buffer
+}
+
+func (j *jsonWriter) WriteHeader(schema sql.Schema) error {
+	j.schema = schema
+	return nil
+}
+
+func (j *jsonWriter) WriteRow(row sql.Row) error {
+	j.buf.Reset()
+	j.buf.WriteByte('{')
+	for i, v := range row {
+		if i > 0 {
+			j.buf.WriteByte(',')
+		}
+
+		name, err := json.Marshal(j.schema[i].Name)
+		if err != nil {
+			return err
+		}
+		j.buf.Write(name)
+		j.buf.WriteByte(':')
+
+		val, err := json.Marshal(jsonValue(j.schema[i].Type, v))
+		if err != nil {
+			return err
+		}
+		j.buf.Write(val)
+	}
+	j.buf.WriteString("}\n")
+
+	_, err := j.w.Write(j.buf.Bytes())
+	return err
+}
+
+func (j *jsonWriter) Flush() error {
+	return nil
+}
+
+// jsonValue converts a value of the given type to a value that can be
+// encoded as JSON.
+func jsonValue(typ sql.Type, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	switch {
+	case sql.IsArray(typ):
+		vals, ok := v.([]interface{})
+		if !ok {
+			return v
+		}
+
+		elemType := sql.UnderlyingType(typ)
+		var result = make([]interface{}, len(vals))
+		for i, val := range vals {
+			result[i] = jsonValue(elemType, val)
+		}
+		return result
+	case typ == sql.JSON:
+		val, err := typ.Convert(v)
+		if err != nil {
+			return v
+		}
+		return json.RawMessage(val.([]byte))
+	}
+
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(sql.TimestampLayout)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,204 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/go-mysql-server/auth"
+)
+
+const (
+	QueryDescription = "Runs queries against the repositories and prints the results"
+	QueryHelp        = QueryDescription + "\n\n" +
+		"Builds an in-process gitbase engine over the given directories,\n" +
+		"runs the query passed as argument or all the queries contained in\n" +
+		"the file passed with --file and writes the results to the\n" +
+		"standard output. Use \"-\" as file to read the queries from the\n" +
+		"standard input."
+)
+
+// Query represents the `query` command of gitbase cli tool.
+type Query struct {
+	engineOptions
+
+	File   string `short:"f" long:"file" description:"File with the queries to run, separated by semicolons. Use - to read from the standard input."`
+	Output string `short:"o" long:"output" default:"table" choice:"table" choice:"csv" choice:"tsv" choice:"json" choice:"markdown" description:"Format of the results"`
+
+	Args struct {
+		Query string `positional-arg-name:"query" description:"Query to run"`
+	} `positional-args:"yes"`
+
+	out io.Writer
+	in  io.Reader
+}
+
+// Execute runs the given queries and prints their results, it honors the
+// go-flags.Commander interface.
+func (c *Query) Execute(args []string) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+
+	queries, err := c.queries()
+	if err != nil {
+		return err
+	}
+
+	if len(queries) == 0 {
+		return fmt.Errorf("a query or a file with queries must be provided")
+	}
+
+	c.userAuth = new(auth.None)
+	if err := c.buildDatabase(); err != nil {
+		return err
+	}
+
+	out := c.out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	w, err := newRowWriter(c.Output, out)
+	if err != nil {
+		return err
+	}
+
+	for _, q := range queries {
+		if err := c.run(w, q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Query) queries() ([]string, error) {
+	if c.File != "" && c.Args.Query != "" {
+		return nil, fmt.Errorf("cannot use both a query argument and --file")
+	}
+
+	if c.File == "" {
+		return splitQueries(c.Args.Query), nil
+	}
+
+	var in io.Reader
+	if c.File == "-" {
+		in = c.in
+		if in == nil {
+			in = os.Stdin
+		}
+	} else {
+		f, err := os.Open(c.File)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	content, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitQueries(string(content)), nil
+}
+
+func (c *Query) run(w rowWriter, query string) error {
+	ctx := c.newContext(context.Background())
+	logrus.WithField("query", query).Debug("running query")
+
+	schema, iter, err := c.runSQL(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	_, err = writeRows(w, schema, iter)
+	return err
+}
+
+// splitQueries splits a text with several queries separated by semicolons.
+// Semicolons inside quoted strings or identifiers and comments are ignored.
+func splitQueries(text string) []string {
+	queries, rest := scanQueries(text)
+	return appendQuery(queries, rest)
+}
+
+// scanQueries returns all the queries terminated by a semicolon in the given
+// text and the remaining text after the last one.
+func scanQueries(text string) (queries []string, rest string) {
+	var current strings.Builder
+	var quote rune
+	var lineComment, blockComment bool
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		var next rune
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		switch {
+		case lineComment:
+			if r == '\n' {
+				lineComment = false
+			}
+		case blockComment:
+			if r == '*' && next == '/' {
+				blockComment = false
+				current.WriteRune(r)
+				current.WriteRune(next)
+				i++
+				continue
+			}
+		case quote != 0:
+			if r == '\\' && quote != '`' && next != 0 {
+				current.WriteRune(r)
+				current.WriteRune(next)
+				i++
+				continue
+			}
+
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '#' || (r == '-' && next == '-'):
+			lineComment = true
+		case r == '/' && next == '*':
+			blockComment = true
+		case r == ';':
+			queries = appendQuery(queries, current.String())
+			current.Reset()
+			continue
+		}
+
+		current.WriteRune(r)
+	}
+
+	return queries, current.String()
+}
+
+func appendQuery(queries []string, query string) []string {
+	if q := strings.TrimSpace(query); q != "" && !isOnlyComments(q) {
+		return append(queries, q)
+	}
+	return queries
+}
+
+func isOnlyComments(query string) bool {
+	for _, line := range strings.Split(query, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return true
+}
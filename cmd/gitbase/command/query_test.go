@@ -0,0 +1,160 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitQueries(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{
+			name:     "single",
+			text:     "SELECT 1",
+			expected: []string{"SELECT 1"},
+		},
+		{
+			name:     "trailing semicolon",
+			text:     "SELECT 1;\n",
+			expected: []string{"SELECT 1"},
+		},
+		{
+			name:     "multiple",
+			text:     "SELECT 1; SELECT 2;\nSELECT 3",
+			expected: []string{"SELECT 1", "SELECT 2", "SELECT 3"},
+		},
+		{
+			name:     "quoted",
+			text:     `SELECT 'a;b'; SELECT "c;d"; SELECT 1 AS ` + "`e;f`",
+			expected: []string{`SELECT 'a;b'`, `SELECT "c;d"`, "SELECT 1 AS `e;f`"},
+		},
+		{
+			name:     "escaped quote",
+			text:     `SELECT 'a\';b'; SELECT 2`,
+			expected: []string{`SELECT 'a\';b'`, "SELECT 2"},
+		},
+		{
+			name:     "comments",
+			text:     "-- first; query\nSELECT 1; # second;\n/* a; b */ SELECT 2;\n-- nothing else",
+			expected: []string{"-- first; query\nSELECT 1", "# second;\n/* a; b */ SELECT 2"},
+		},
+		{
+			name: "empty",
+			text: " ;\n; ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, splitQueries(tt.text))
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	query := `SELECT repository_id, COUNT(*) AS refs
+		FROM refs
+		WHERE repository_id = '015da2f4-6d89-7ec8-5ac9-a38329ea875b'
+		GROUP BY repository_id`
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format: "table",
+			expected: `+--------------------------------------+------+
+| repository_id                        | refs |
++--------------------------------------+------+
+| 015da2f4-6d89-7ec8-5ac9-a38329ea875b | 2    |
++--------------------------------------+------+
+1 row in set
+`,
+		},
+		{
+			format:   "csv",
+			expected: "repository_id,refs\n015da2f4-6d89-7ec8-5ac9-a38329ea875b,2\n",
+		},
+		{
+			format:   "tsv",
+			expected: "repository_id\trefs\n015da2f4-6d89-7ec8-5ac9-a38329ea875b\t2\n",
+		},
+		{
+			format:   "json",
+			expected: `{"repository_id":"015da2f4-6d89-7ec8-5ac9-a38329ea875b","refs":2}` + "\n",
+		},
+		{
+			format: "markdown",
+			expected: `| repository_id                        | refs |
+| ------------------------------------ | ---- |
+| 015da2f4-6d89-7ec8-5ac9-a38329ea875b | 2    |
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			require := require.New(t)
+			var buf bytes.Buffer
+
+			cmd := &Query{
+				engineOptions: engineOptions{
+					CacheSize:   512,
+					Format:      "siva",
+					Bucket:      0,
+					LogLevel:    "info",
+					Directories: []string{"../../../_testdata"},
+					IndexDir:    tmpDir,
+				},
+				Output: tt.format,
+				out:    &buf,
+			}
+			cmd.Args.Query = query
+
+			require.NoError(cmd.Execute(nil))
+			require.Equal(tt.expected, buf.String())
+		})
+	}
+}
+
+func TestQueryFile(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	var buf bytes.Buffer
+	cmd := &Query{
+		engineOptions: engineOptions{
+			CacheSize:   512,
+			Format:      "siva",
+			Bucket:      0,
+			LogLevel:    "info",
+			Directories: []string{"../../../_testdata"},
+			IndexDir:    tmpDir,
+		},
+		File:   "-",
+		Output: "csv",
+		in:     strings.NewReader("SELECT 1 AS a;\nSELECT 'x;y' AS b;\n"),
+		out:    &buf,
+	}
+
+	require.NoError(cmd.Execute(nil))
+	require.Equal("a\n1\nb\nx;y\n", buf.String())
+
+	cmd.Args.Query = "SELECT 1"
+	require.Error(cmd.Execute(nil))
+}
@@ -0,0 +1,557 @@
+package command
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/expression"
+	"github.com/src-d/go-mysql-server/sql/index"
+	"github.com/src-d/go-mysql-server/sql/index/pilosa"
+	"github.com/src-d/go-mysql-server/sql/parse"
+	"github.com/src-d/go-mysql-server/sql/plan"
+)
+
+// reindexSchema is the schema of the result of REINDEX, with the number of
+// repositories re-indexed and removed from each index.
+var reindexSchema = sql.Schema{
+	{Name: "index", Type: sql.Text},
+	{Name: "table", Type: sql.Text},
+	{Name: "reindexed", Type: sql.Int64},
+	{Name: "removed", Type: sql.Int64},
+}
+
+// repositoryChecksumPrefix is the prefix of the keys of the pilosa
+// configuration of the indexes with the checksum of each repository indexed.
+const repositoryChecksumPrefix = "gitbase.checksum."
+
+// reindex updates the pilosa indexes of the database, or only the one with
+// the given ID if it's not empty, so they have the current contents of the
+// repositories. Only the repositories whose checksum changed since they were
+// indexed are read again, and the rows of the repositories that are not in
+// the pool anymore are removed. It returns a row for each index with the
+// number of repositories re-indexed and removed.
+//
+// Indexes created with CREATE INDEX don't have the checksum of each
+// repository, so they are re-indexed completely the first time unless the
+// repositories didn't change since they were created.
+func (c *engineOptions) reindex(ctx *sql.Context, id string) ([]sql.Row, error) {
+	c.reindexMut.Lock()
+	defer c.reindexMut.Unlock()
+
+	db, err := c.engine.Catalog.Database(c.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	repos, checksum, err := c.pool.RepositoryChecksums()
+	if err != nil {
+		return nil, err
+	}
+
+	tables := db.Tables()
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rows []sql.Row
+	var found bool
+	for _, name := range names {
+		indexes := c.engine.Catalog.IndexesByTable(c.Name, name)
+		for _, idx := range indexes {
+			c.engine.Catalog.ReleaseIndex(idx)
+		}
+
+		for _, idx := range indexes {
+			if id != "" && idx.ID() != strings.ToLower(id) {
+				continue
+			}
+
+			found = true
+			if idx.Driver() != pilosa.DriverID {
+				continue
+			}
+
+			reindexed, removed, err := c.reindexIndex(ctx, tables[name], idx, repos, checksum)
+			if err != nil {
+				return nil, fmt.Errorf("unable to reindex %s: %s", idx.ID(), err)
+			}
+
+			rows = append(rows, sql.NewRow(
+				idx.ID(),
+				idx.Table(),
+				int64(reindexed),
+				int64(removed),
+			))
+		}
+	}
+
+	if id != "" && !found {
+		return nil, sql.ErrIndexNotFound.New(id)
+	}
+
+	return rows, nil
+}
+
+// reindexIndex re-indexes the repositories of the index whose checksum
+// changed and removes the ones not in the given checksums. The index is
+// unregistered while it's updated, so queries don't use it, and loaded
+// again afterwards with the new checksum.
+func (c *engineOptions) reindexIndex(
+	ctx *sql.Context,
+	table sql.Table,
+	idx sql.Index,
+	repos map[string]string,
+	checksum string,
+) (reindexed, removed int, err error) {
+	catalog := c.engine.Catalog
+	if !catalog.CanRemoveIndex(idx) {
+		return 0, 0, fmt.Errorf("the index is not ready")
+	}
+
+	cfgPath := filepath.Join(c.indexDir(idx), pilosa.ConfigFileName)
+	cfg, err := index.ReadConfigFile(cfgPath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	driverCfg := cfg.Driver(pilosa.DriverID)
+	indexed, stored := indexedRepositories(driverCfg)
+
+	// Indexes without the checksum of each repository are up to date only
+	// if the checksum of all of them didn't change, and then it's enough to
+	// save the checksums.
+	if len(stored) == 0 && driverCfg[sql.ChecksumKey] == checksum {
+		setRepositoryChecksums(driverCfg, repos, checksum)
+		return 0, 0, index.WriteConfigFile(cfgPath, cfg)
+	}
+
+	for id := range stored {
+		indexed[id] = struct{}{}
+	}
+
+	var changed, deleted []string
+	for id, sum := range repos {
+		if stored[id] != sum {
+			changed = append(changed, id)
+		}
+	}
+
+	for id := range indexed {
+		if _, ok := repos[id]; !ok {
+			deleted = append(deleted, id)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(deleted)
+
+	upToDate := len(changed) == 0 && len(deleted) == 0 &&
+		driverCfg[sql.ChecksumKey] == checksum
+	if upToDate {
+		return 0, 0, nil
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"id":        idx.ID(),
+		"table":     idx.Table(),
+		"reindexed": len(changed),
+		"removed":   len(deleted),
+	})
+	log.Info("updating index")
+
+	done, err := catalog.DeleteIndex(idx.Database(), idx.ID(), false)
+	if err != nil {
+		return 0, 0, err
+	}
+	<-done
+
+	if len(deleted) > 0 {
+		if err := c.deletePartitions(idx, deleted); err != nil {
+			log.WithField("error", err).Error("unable to update index, removing it")
+			if derr := c.deleteIndex(ctx, idx, indexed); derr != nil {
+				log.WithField("error", derr).Error("unable to remove index")
+			}
+
+			return 0, 0, fmt.Errorf("%s, the index was removed and must be created again", err)
+		}
+	}
+
+	if len(changed) > 0 {
+		if err := c.saveIndex(ctx, table, idx, changed); err != nil {
+			// A failed save leaves the index half written, so it's removed.
+			log.WithField("error", err).Error("unable to update index, removing it")
+			if derr := c.deleteIndex(ctx, idx, indexed); derr != nil {
+				log.WithField("error", derr).Error("unable to remove index")
+			}
+
+			return 0, 0, fmt.Errorf("%s, the index was removed and must be created again", err)
+		}
+
+		// The configuration was written again by the driver with the
+		// mappings of the saved repositories.
+		if cfg, err = index.ReadConfigFile(cfgPath); err != nil {
+			return 0, 0, err
+		}
+		driverCfg = cfg.Driver(pilosa.DriverID)
+	}
+
+	dir := c.indexDir(idx)
+	for _, id := range deleted {
+		key := hex.EncodeToString([]byte(id))
+		if file, ok := driverCfg[key]; ok {
+			if err := os.Remove(filepath.Join(dir, file)); err != nil && !os.IsNotExist(err) {
+				return 0, 0, err
+			}
+		}
+
+		delete(driverCfg, key)
+	}
+
+	setRepositoryChecksums(driverCfg, repos, checksum)
+	if err := index.WriteConfigFile(cfgPath, cfg); err != nil {
+		return 0, 0, err
+	}
+
+	if err := c.loadIndex(idx); err != nil {
+		return 0, 0, err
+	}
+
+	log.Info("index updated")
+	return len(changed), len(deleted), nil
+}
+
+// indexedRepositories returns the repositories with rows in the index, read
+// from the mappings of its partitions, and the checksums of the repositories
+// when they were indexed, if the index has them.
+func indexedRepositories(driverCfg map[string]string) (indexed map[string]struct{}, checksums map[string]string) {
+	indexed = make(map[string]struct{})
+	checksums = make(map[string]string)
+	for k, v := range driverCfg {
+		if strings.HasPrefix(k, repositoryChecksumPrefix) {
+			checksums[strings.TrimPrefix(k, repositoryChecksumPrefix)] = v
+			continue
+		}
+
+		if !strings.HasPrefix(v, pilosa.MappingFileNamePrefix) ||
+			!strings.HasSuffix(v, pilosa.MappingFileNameExtension) {
+			continue
+		}
+
+		// Index values are stored in a partition per repository, whose key
+		// is the repository ID.
+		id, err := hex.DecodeString(k)
+		if err != nil {
+			continue
+		}
+
+		indexed[string(id)] = struct{}{}
+	}
+
+	return indexed, checksums
+}
+
+// setRepositoryChecksums replaces the checksums of the index configuration
+// with the given ones.
+func setRepositoryChecksums(driverCfg map[string]string, repos map[string]string, checksum string) {
+	for key := range driverCfg {
+		if strings.HasPrefix(key, repositoryChecksumPrefix) {
+			delete(driverCfg, key)
+		}
+	}
+
+	for id, sum := range repos {
+		driverCfg[repositoryChecksumPrefix+id] = sum
+	}
+	driverCfg[sql.ChecksumKey] = checksum
+}
+
+// indexDir returns the directory with the files of the given pilosa index.
+func (c *engineOptions) indexDir(idx sql.Index) string {
+	return filepath.Join(c.IndexDir, pilosa.DriverID, idx.Database(), idx.Table(), idx.ID())
+}
+
+// saveIndex indexes again the rows of the changed repositories, leaving the
+// rest of the index untouched.
+func (c *engineOptions) saveIndex(
+	ctx *sql.Context,
+	table sql.Table,
+	idx sql.Index,
+	changed []string,
+) error {
+	indexable, ok := table.(sql.IndexableTable)
+	if !ok {
+		return plan.ErrNotIndexable.New()
+	}
+
+	exprs, err := c.indexExpressions(ctx, idx)
+	if err != nil {
+		return err
+	}
+
+	columns, exprs := indexColumns(exprs)
+
+	session := gitbase.NewSession(
+		c.pool.WithRepositories(changed...),
+		gitbase.WithSkipGitErrors(c.SkipGitErrors),
+	)
+	sctx := sql.NewContext(ctx,
+		sql.WithSession(session),
+		sql.WithPid(ctx.Pid()),
+		sql.WithMemoryManager(ctx.Memory),
+	)
+
+	iter, err := indexable.IndexKeyValues(sctx, columns)
+	if err != nil {
+		return err
+	}
+
+	driver := c.engine.Catalog.IndexDriver(pilosa.DriverID)
+	return driver.Save(sctx, idx, &reindexKeyValueIter{
+		ctx:   sctx,
+		exprs: exprs,
+		iter:  iter,
+	})
+}
+
+// deletePartitions removes the fields of the partitions of the deleted
+// repositories from the index. The driver removes the whole directory of the
+// index when it deletes partitions, so it's moved aside meanwhile to keep the
+// configuration and the mappings of the other repositories.
+func (c *engineOptions) deletePartitions(idx sql.Index, deleted []string) error {
+	partitions := make([]sql.Partition, len(deleted))
+	for i, id := range deleted {
+		partitions[i] = gitbase.RepositoryPartition{ID: id}
+	}
+
+	dir := c.indexDir(idx)
+	tmp := dir + ".reindex"
+	if err := os.Rename(dir, tmp); err != nil {
+		return err
+	}
+
+	driver := c.engine.Catalog.IndexDriver(pilosa.DriverID)
+	err := driver.Delete(idx, &partitionIter{partitions: partitions})
+	if rerr := os.Rename(tmp, dir); rerr != nil && err == nil {
+		err = rerr
+	}
+
+	return err
+}
+
+// deleteIndex removes the files of the index.
+func (c *engineOptions) deleteIndex(
+	ctx *sql.Context,
+	idx sql.Index,
+	indexed map[string]struct{},
+) error {
+	var partitions []sql.Partition
+	for id := range indexed {
+		partitions = append(partitions, gitbase.RepositoryPartition{ID: id})
+	}
+
+	driver := c.engine.Catalog.IndexDriver(pilosa.DriverID)
+	return driver.Delete(idx, &partitionIter{partitions: partitions})
+}
+
+// loadIndex loads the index from disk again and registers it.
+func (c *engineOptions) loadIndex(idx sql.Index) error {
+	// Loading the indexes of a table removes the ones being created, as
+	// they look corrupted.
+	tableDir := filepath.Dir(c.indexDir(idx))
+	dirs, err := filepath.Glob(filepath.Join(tableDir, "*", pilosa.ProcessingFileName))
+	if err != nil {
+		return err
+	}
+
+	if len(dirs) > 0 {
+		return fmt.Errorf("other indexes of table %s are being created, "+
+			"the index will be loaded again when gitbase is restarted", idx.Table())
+	}
+
+	driver := c.engine.Catalog.IndexDriver(pilosa.DriverID)
+	indexes, err := driver.LoadAll(idx.Database(), idx.Table())
+	if err != nil {
+		return err
+	}
+
+	for _, loaded := range indexes {
+		if loaded.ID() != idx.ID() {
+			continue
+		}
+
+		created, ready, err := c.engine.Catalog.AddIndex(loaded)
+		if err != nil {
+			return err
+		}
+
+		close(created)
+		<-ready
+		return nil
+	}
+
+	return sql.ErrIndexNotFound.New(idx.ID())
+}
+
+// indexExpressions returns the resolved expressions of the index, which are
+// saved as strings.
+func (c *engineOptions) indexExpressions(ctx *sql.Context, idx sql.Index) ([]sql.Expression, error) {
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s",
+		strings.Join(idx.Expressions(), ", "),
+		idx.Table(),
+	)
+
+	node, err := parse.Parse(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err = c.engine.Analyzer.Analyze(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+
+	var exprs []sql.Expression
+	plan.Inspect(node, func(n sql.Node) bool {
+		if p, ok := n.(*plan.Project); ok && exprs == nil {
+			exprs = p.Projections
+			return false
+		}
+		return true
+	})
+
+	if len(exprs) != len(idx.Expressions()) {
+		return nil, fmt.Errorf("unable to resolve expressions %v", idx.Expressions())
+	}
+
+	return exprs, nil
+}
+
+// indexColumns returns the columns used by the expressions and the
+// expressions with their fields pointing to a row with only those columns,
+// like CREATE INDEX does.
+func indexColumns(exprs []sql.Expression) ([]string, []sql.Expression) {
+	var columns []string
+	var seen = make(map[string]int)
+	var result = make([]sql.Expression, len(exprs))
+	for i, e := range exprs {
+		result[i], _ = expression.TransformUp(e, func(e sql.Expression) (sql.Expression, error) {
+			gf, ok := e.(*expression.GetField)
+			if !ok {
+				return e, nil
+			}
+
+			idx, ok := seen[gf.Name()]
+			if !ok {
+				idx = len(columns)
+				columns = append(columns, gf.Name())
+				seen[gf.Name()] = idx
+			}
+
+			return expression.NewGetFieldWithTable(
+				idx,
+				gf.Type(),
+				gf.Table(),
+				gf.Name(),
+				gf.IsNullable(),
+			), nil
+		})
+	}
+
+	return columns, result
+}
+
+// reindexKeyValueIter returns the values of the index expressions for the
+// partitions of the repositories re-indexed.
+type reindexKeyValueIter struct {
+	ctx   *sql.Context
+	exprs []sql.Expression
+	iter  sql.PartitionIndexKeyValueIter
+}
+
+func (i *reindexKeyValueIter) Next() (sql.Partition, sql.IndexKeyValueIter, error) {
+	p, kv, err := i.iter.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return p, &evalKeyValueIter{i.ctx, i.exprs, kv}, nil
+}
+
+func (i *reindexKeyValueIter) Close() error {
+	return i.iter.Close()
+}
+
+// evalKeyValueIter returns the values of the expressions for the values of
+// the columns returned by its iterator.
+type evalKeyValueIter struct {
+	ctx   *sql.Context
+	exprs []sql.Expression
+	iter  sql.IndexKeyValueIter
+}
+
+func (i *evalKeyValueIter) Next() ([]interface{}, []byte, error) {
+	values, location, err := i.iter.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := make([]interface{}, len(i.exprs))
+	for j, e := range i.exprs {
+		result[j], err = e.Eval(i.ctx, values)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return result, location, nil
+}
+
+func (i *evalKeyValueIter) Close() error {
+	return i.iter.Close()
+}
+
+type partitionIter struct {
+	partitions []sql.Partition
+}
+
+func (i *partitionIter) Next() (sql.Partition, error) {
+	if len(i.partitions) == 0 {
+		return nil, io.EOF
+	}
+
+	p := i.partitions[0]
+	i.partitions = i.partitions[1:]
+	return p, nil
+}
+
+func (i *partitionIter) Close() error { return nil }
+
+// reindexPeriodically updates the indexes every interval, until done is
+// closed.
+func (c *engineOptions) reindexPeriodically(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if _, err := c.reindex(c.newContext(context.Background()), ""); err != nil {
+				logrus.WithField("error", err).Error("unable to update indexes")
+			}
+		}
+	}
+}
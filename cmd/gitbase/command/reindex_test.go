@@ -0,0 +1,101 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/index/pilosa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReindex(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	plainDir := filepath.Join(tmpDir, "plain")
+	commitFile(t, filepath.Join(plainDir, "a"), "README", "a")
+	commitFile(t, filepath.Join(plainDir, "b"), "README", "b")
+
+	server := &Server{engineOptions: engineOptions{
+		CacheSize:   512,
+		Format:      "git",
+		Bucket:      0,
+		LogLevel:    "info",
+		Directories: []string{plainDir},
+		IndexDir:    filepath.Join(tmpDir, "index"),
+		userAuth:    new(auth.None),
+	}}
+	require.NoError(server.buildDatabase())
+
+	run := func(query string) []sql.Row {
+		t.Helper()
+		session := gitbase.NewSession(server.pool, server.sessionOptions()...)
+		ctx := sql.NewContext(context.Background(), sql.WithSession(session))
+
+		_, iter, err := server.runSQL(ctx, query)
+		require.NoError(err)
+
+		rows, err := sql.RowIterToRows(iter)
+		require.NoError(err)
+		return rows
+	}
+
+	run(`CREATE INDEX commits_idx ON commits USING pilosa (commit_hash)
+		WITH (async = false)`)
+
+	require.Equal([]sql.Row{
+		{"commits_idx", "commits", int64(0), int64(0)},
+	}, run("REINDEX"))
+
+	hash := commitFile(t, filepath.Join(plainDir, "a"), "LICENSE", "a")
+
+	require.Equal([]sql.Row{
+		{"commits_idx", "commits", int64(1), int64(0)},
+	}, run("REINDEX commits_idx"))
+
+	require.Equal([]sql.Row{
+		{"a"},
+	}, run("SELECT repository_id FROM commits WHERE commit_hash = '"+hash.String()+"'"))
+
+	require.Equal([]sql.Row{
+		{"commits_idx", "commits", int64(0), int64(0)},
+	}, run("REINDEX"))
+
+	// Each repository has its own pilosa field for the indexed expression.
+	fields := func() []string {
+		t.Helper()
+		matches, err := filepath.Glob(filepath.Join(
+			server.IndexDir, pilosa.DriverID, "."+pilosa.DriverID,
+			pilosa.IndexNamePrefix+"-*", pilosa.FieldNamePrefix+"-*",
+		))
+		require.NoError(err)
+		return matches
+	}
+	require.Len(fields(), 2)
+
+	run("CALL gitbase_remove_repository('b')")
+
+	require.Equal([]sql.Row{
+		{"commits_idx", "commits", int64(0), int64(1)},
+	}, run("REINDEX"))
+
+	require.Len(fields(), 1)
+
+	require.Equal([]sql.Row{
+		{int64(2)},
+	}, run("SELECT COUNT(*) FROM commits WHERE commit_hash IN (SELECT commit_hash FROM commits)"))
+
+	session := gitbase.NewSession(server.pool, server.sessionOptions()...)
+	ctx := sql.NewContext(context.Background(), sql.WithSession(session))
+	_, err = server.reindex(ctx, "missing_idx")
+	require.True(sql.ErrIndexNotFound.Is(err))
+}
@@ -2,16 +2,18 @@ package command
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-kit/kit/metrics/prometheus"
@@ -20,25 +22,19 @@ import (
 
 	"github.com/src-d/gitbase"
 	"github.com/src-d/gitbase/internal/function"
-	"github.com/src-d/gitbase/internal/rule"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/sirupsen/logrus"
-	"github.com/src-d/go-borges"
-	"github.com/src-d/go-borges/legacysiva"
-	"github.com/src-d/go-borges/libraries"
 	"github.com/src-d/go-borges/plain"
-	"github.com/src-d/go-borges/siva"
 	sqle "github.com/src-d/go-mysql-server"
 	"github.com/src-d/go-mysql-server/auth"
 	"github.com/src-d/go-mysql-server/server"
-	"github.com/src-d/go-mysql-server/sql"
 	"github.com/src-d/go-mysql-server/sql/analyzer"
 	"github.com/src-d/go-mysql-server/sql/index/pilosa"
 	"github.com/uber/jaeger-client-go/config"
 	"gopkg.in/src-d/go-billy.v4/osfs"
-	"gopkg.in/src-d/go-git.v4/plumbing/cache"
 	"vitess.io/vitess/go/mysql"
+	"vitess.io/vitess/go/vt/vttls"
 )
 
 const (
@@ -52,39 +48,45 @@ const (
 
 // Server represents the `server` command of gitbase cli tool.
 type Server struct {
-	engine   *sqle.Engine
-	pool     *gitbase.RepositoryPool
-	userAuth auth.Auth
-
-	rootLibrary  *libraries.Libraries
-	plainLibrary *plain.Library
-	sharedCache  cache.Object
-
-	Name           string         `long:"db" default:"gitbase" description:"Database name"`
-	Version        string         // Version of the application.
-	Directories    []string       `short:"d" long:"directories" description:"Path where standard git repositories are located, multiple directories can be defined."`
-	Format         string         `long:"format" default:"git" choice:"git" choice:"siva" description:"Library format"`
-	Bucket         int            `long:"bucket" default:"2" description:"Bucketing level to use with siva libraries"`
-	Bare           bool           `long:"bare" description:"Sets the library to use bare git repositories, used only with git format libraries"`
-	NonBare        bool           `long:"non-bare" description:"Sets the library to use non bare git repositories, used only with git format libraries"`
-	NonRooted      bool           `long:"non-rooted" description:"Disables treating siva files as rooted repositories"`
-	Host           string         `long:"host" default:"localhost" description:"Host where the server is going to listen"`
-	Port           int            `short:"p" long:"port" default:"3306" description:"Port where the server is going to listen"`
-	User           string         `short:"u" long:"user" default:"root" description:"User name used for connection"`
-	Password       string         `short:"P" long:"password" default:"" description:"Password used for connection"`
-	UserFile       string         `short:"U" long:"user-file" env:"GITBASE_USER_FILE" default:"" description:"JSON file with credentials list"`
-	ConnTimeout    int            `short:"t" long:"timeout" env:"GITBASE_CONNECTION_TIMEOUT" description:"Timeout in seconds used for connections"`
-	IndexDir       string         `short:"i" long:"index" default:"/var/lib/gitbase/index" description:"Directory where the gitbase indexes information will be persisted." env:"GITBASE_INDEX_DIR"`
-	CacheSize      cache.FileSize `long:"cache" default:"512" description:"Object cache size in megabytes" env:"GITBASE_CACHESIZE_MB"`
-	Parallelism    uint           `long:"parallelism" description:"Maximum number of parallel threads per table. By default, it's the number of CPU cores. 0 means default, 1 means disabled."`
-	DisableSquash  bool           `long:"no-squash" description:"Disables the table squashing."`
-	TraceEnabled   bool           `long:"trace" env:"GITBASE_TRACE" description:"Enables jaeger tracing"`
-	MetricsEnabled bool           `long:"metrics" env:"GITBASE_METRICS" description:"Enables prometheus metrics"`
-	MetricsPort    int            `long:"metrics-port" env:"GITBASE_METRICS_PORT" default:"2112" description:"Port where the server is going to expose prometheus metrics"`
-	ReadOnly       bool           `short:"r" long:"readonly" description:"Only allow read queries. This disables creating and deleting indexes as well. Cannot be used with --user-file." env:"GITBASE_READONLY"`
-	SkipGitErrors  bool           // SkipGitErrors disables failing when Git errors are found.
-	Verbose        bool           `short:"v" description:"Activates the verbose mode (equivalent to debug logging level), overwriting any passed logging level"`
-	LogLevel       string         `long:"log-level" env:"GITBASE_LOG_LEVEL" choice:"info" choice:"debug" choice:"warning" choice:"error" choice:"fatal" default:"info" description:"logging level; ignored if using -v verbose flag"`
+	engineOptions
+
+	Host           string `long:"host" default:"localhost" description:"Host where the server is going to listen"`
+	Port           int    `short:"p" long:"port" default:"3306" description:"Port where the server is going to listen"`
+	User           string `short:"u" long:"user" default:"root" description:"User name used for connection"`
+	Password       string `short:"P" long:"password" default:"" description:"Password used for connection"`
+	UserFile       string `short:"U" long:"user-file" env:"GITBASE_USER_FILE" default:"" description:"JSON file with credentials list"`
+	ConnTimeout    int    `short:"t" long:"timeout" env:"GITBASE_CONNECTION_TIMEOUT" description:"Timeout in seconds used for connections"`
+	TraceEnabled   bool   `long:"trace" env:"GITBASE_TRACE" description:"Enables jaeger tracing"`
+	MetricsEnabled bool   `long:"metrics" env:"GITBASE_METRICS" description:"Enables prometheus metrics"`
+	MetricsPort    int    `long:"metrics-port" env:"GITBASE_METRICS_PORT" default:"2112" description:"Port where the server is going to expose prometheus metrics"`
+	HTTPEnabled    bool   `long:"http" env:"GITBASE_HTTP" description:"Enables the HTTP query API"`
+	HTTPPort       int    `long:"http-port" env:"GITBASE_HTTP_PORT" default:"8080" description:"Port where the server is going to expose the HTTP query API"`
+	ReadOnly       bool   `short:"r" long:"readonly" description:"Only allow read queries. This disables creating and deleting indexes as well. Cannot be used with --user-file." env:"GITBASE_READONLY"`
+
+	RescanInterval  time.Duration `long:"rescan-interval" env:"GITBASE_RESCAN_INTERVAL" description:"Interval to scan again the directories to add and remove repositories without restarting the server, such as 30s or 5m. By default, directories are only scanned at startup."`
+	ReindexInterval time.Duration `long:"reindex-interval" env:"GITBASE_REINDEX_INTERVAL" description:"Interval to update the indexes with the repositories that changed, such as 30s or 5m. By default, indexes are only updated with REINDEX."`
+
+	MaxExecutionTime time.Duration `long:"max-execution-time" env:"GITBASE_MAX_EXECUTION_TIME" description:"Default maximum time a query can run, such as 30s or 5m. Sessions can change it with the max_execution_time variable, in milliseconds. By default, there is no limit."`
+	MaxRows          int64         `long:"max-rows" env:"GITBASE_MAX_ROWS" description:"Default maximum number of rows a query can return. Sessions can change it with the gitbase_max_rows variable. By default, there is no limit."`
+	MaxBlobBytes     int64         `long:"max-blob-bytes" env:"GITBASE_MAX_BLOB_BYTES" description:"Default maximum number of bytes of blob contents a query can read from the tables. Sessions can change it with the gitbase_max_blob_bytes variable. By default, there is no limit."`
+
+	ResultCacheSize uint          `long:"result-cache-size" env:"GITBASE_RESULT_CACHE_SIZE_MB" description:"Maximum size in MiB of the rows kept in memory by the query result cache. Queries are cached only when it's greater than 0, and sessions can disable it with the gitbase_result_cache variable."`
+	ResultCacheTTL  time.Duration `long:"result-cache-ttl" env:"GITBASE_RESULT_CACHE_TTL" description:"Maximum time the results of a query are kept in the query result cache, such as 30s or 5m. By default, they are kept until the repositories change or they are evicted."`
+
+	SlowQueryLog       string        `long:"slow-query-log" env:"GITBASE_SLOW_QUERY_LOG" description:"File where the queries slower than --slow-query-threshold are written as JSON lines, use - for the standard error"`
+	SlowQueryThreshold time.Duration `long:"slow-query-threshold" env:"GITBASE_SLOW_QUERY_THRESHOLD" default:"1s" description:"Minimum duration of the queries written to the slow query log"`
+
+	TLSCert                string `long:"tls-cert" env:"GITBASE_TLS_CERT" description:"PEM file with the certificate of the server. It enables TLS connections and must be used with --tls-key."`
+	TLSKey                 string `long:"tls-key" env:"GITBASE_TLS_KEY" description:"PEM file with the private key of the certificate of the server"`
+	TLSCA                  string `long:"tls-ca" env:"GITBASE_TLS_CA" description:"PEM file with the certificate authorities used to verify the certificates of the clients. When given, clients must present a valid certificate."`
+	RequireSecureTransport bool   `long:"require-secure-transport" env:"GITBASE_REQUIRE_SECURE_TRANSPORT" description:"Rejects the connections not using TLS"`
+
+	ShutdownTimeout time.Duration `long:"shutdown-timeout" env:"GITBASE_SHUTDOWN_TIMEOUT" default:"30s" description:"Maximum time to wait for the running queries when the server receives SIGTERM or SIGINT before killing them"`
+
+	tlsConfig *tls.Config
+	slowLog   *slowQueryLog
+	health    *healthChecker
+	sessions  *sessionRegistry
 }
 
 type jaegerLogrus struct {
@@ -95,64 +97,59 @@ func (l *jaegerLogrus) Error(s string) {
 	l.Entry.Error(s)
 }
 
-func NewDatabaseEngine(
-	userAuth auth.Auth,
-	version string,
-	parallelism int,
-	squash bool,
-) *sqle.Engine {
-	catalog := sql.NewCatalog()
-	ab := analyzer.NewBuilder(catalog)
-
-	if parallelism == 0 {
-		parallelism = runtime.NumCPU()
+// Execute starts a new gitbase server based on provided configuration, it
+// honors the go-flags.Commander interface.
+func (c *Server) Execute(args []string) error {
+	if err := c.init(); err != nil {
+		return err
 	}
 
-	if parallelism > 1 {
-		ab = ab.WithParallelism(parallelism)
+	var err error
+	if c.tlsConfig, err = c.buildTLSConfig(); err != nil {
+		return err
 	}
 
-	if squash {
-		ab = ab.AddPostAnalyzeRule(rule.SquashJoinsRule, rule.SquashJoins)
+	if c.queryLimits, err = c.buildQueryLimits(); err != nil {
+		return err
 	}
 
-	a := ab.Build()
-	engine := sqle.New(catalog, a, &sqle.Config{
-		VersionPostfix: version,
-		Auth:           userAuth,
-	})
-
-	return engine
-}
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("--shutdown-timeout must not be negative")
+	}
 
-// Execute starts a new gitbase server based on provided configuration, it
-// honors the go-flags.Commander interface.
-func (c *Server) Execute(args []string) error {
-	if c.Verbose {
-		logrus.SetLevel(logrus.DebugLevel)
+	if c.ResultCacheTTL < 0 {
+		return fmt.Errorf("--result-cache-ttl must not be negative")
 	}
 
-	if c.Bare && c.NonBare {
-		return fmt.Errorf("cannot use both --bare and --non-bare")
+	if c.ResultCacheSize > 0 {
+		c.resultCache = gitbase.NewResultCache(
+			int64(c.ResultCacheSize)*1024*1024,
+			c.ResultCacheTTL,
+		)
+		logrus.WithFields(logrus.Fields{
+			"size": c.ResultCacheSize,
+			"ttl":  c.ResultCacheTTL,
+		}).Info("query result cache enabled")
 	}
 
-	// info is the default log level
-	if c.LogLevel != "info" {
-		if c.Verbose {
-			logrus.Infof(
-				"ignoring passed '%s' log-level, using requesed '-v' verbose flag instead",
-				c.LogLevel,
-			)
-		} else {
-			level, err := logrus.ParseLevel(c.LogLevel)
-			if err != nil {
-				return fmt.Errorf("cannot parse log level: %s", err.Error())
-			}
-			logrus.SetLevel(level)
+	if c.SlowQueryLog != "" {
+		if c.SlowQueryThreshold < 0 {
+			return fmt.Errorf("--slow-query-threshold must not be negative")
 		}
+
+		c.slowLog, err = openSlowQueryLog(c.SlowQueryLog, c.SlowQueryThreshold)
+		if err != nil {
+			return fmt.Errorf("unable to open slow query log: %s", err)
+		}
+		defer c.slowLog.Close()
+
+		c.queryStats = true
+		logrus.WithFields(logrus.Fields{
+			"file":      c.SlowQueryLog,
+			"threshold": c.SlowQueryThreshold,
+		}).Info("slow query log enabled")
 	}
 
-	var err error
 	if c.UserFile != "" {
 		if c.ReadOnly {
 			return fmt.Errorf("cannot use both --user-file and --readonly")
@@ -162,15 +159,49 @@ func (c *Server) Execute(args []string) error {
 		if err != nil {
 			return err
 		}
+
+		c.repositoryACL, err = gitbase.NewRepositoryACLFile(c.UserFile)
+		if err != nil {
+			return err
+		}
 	} else {
 		permissions := auth.AllPermissions
 		if c.ReadOnly {
 			permissions = auth.ReadPerm
+			c.readOnly = true
 		}
 		c.userAuth = auth.NewNativeSingle(c.User, c.Password, permissions)
 	}
 
 	c.userAuth = auth.NewAudit(c.userAuth, auth.NewAuditLog(logrus.StandardLogger()))
+
+	// the metrics server is started before loading the libraries, so the
+	// health endpoints can be used while they are loaded
+	if c.MetricsEnabled {
+		bblfshEndpoint := c.bblfshEndpoint
+		if bblfshEndpoint == "" {
+			bblfshEndpoint = os.Getenv(bblfshEndpointKey)
+		}
+
+		c.health = newHealthChecker(bblfshEndpoint)
+		done := make(chan struct{})
+		defer close(done)
+		go c.health.checkBblfsh(done)
+
+		metricsSrv := enableMetrics(c.Host, c.MetricsPort, c.health)
+		defer func() {
+			if err := metricsSrv.Shutdown(context.Background()); err != nil {
+				logrus.Errorln(err)
+			}
+		}()
+		go func() {
+			logrus.Infof("metrics server started and listening on %s", metricsSrv.Addr)
+			if err := metricsSrv.ListenAndServe(); err != http.ErrServerClosed {
+				logrus.Errorln(err)
+			}
+		}()
+	}
+
 	if err := c.buildDatabase(); err != nil {
 		logrus.WithField("error", err).Fatal("unable to initialize database engine")
 		return err
@@ -209,7 +240,7 @@ func (c *Server) Execute(args []string) error {
 
 	hostString := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
 	timeout := time.Duration(c.ConnTimeout) * time.Second
-	s, err := server.NewServer(
+	s, err := c.newServer(
 		server.Config{
 			Protocol:         "tcp",
 			Address:          hostString,
@@ -218,203 +249,174 @@ func (c *Server) Execute(args []string) error {
 			ConnReadTimeout:  timeout,
 			ConnWriteTimeout: timeout,
 		},
-		c.engine,
-		gitbase.NewSessionBuilder(c.pool,
-			gitbase.WithSkipGitErrors(c.SkipGitErrors),
-		),
+		gitbase.NewSessionBuilder(c.pool, c.sessionOptions()...),
 	)
 	if err != nil {
 		return err
 	}
 
-	if c.MetricsEnabled {
-		metricsSrv := enableMetrics(c.Host, c.MetricsPort)
-		defer func() {
-			if err := metricsSrv.Shutdown(context.Background()); err != nil {
+	var httpSrv *http.Server
+	if c.HTTPEnabled {
+		httpSrv, err = c.newHTTPServer()
+		if err != nil {
+			return err
+		}
+		defer httpSrv.Close()
+		go func() {
+			logrus.WithField("tls", httpSrv.TLSConfig != nil).
+				Infof("HTTP query API started and listening on %s", httpSrv.Addr)
+			var err error
+			if httpSrv.TLSConfig != nil {
+				err = httpSrv.ListenAndServeTLS("", "")
+			} else {
+				err = httpSrv.ListenAndServe()
+			}
+
+			if err != http.ErrServerClosed {
 				logrus.Errorln(err)
 			}
 		}()
-		go func() {
-			logrus.Infof("metrics server started and listening on %s", metricsSrv.Addr)
-			logrus.Errorln(metricsSrv.ListenAndServe())
-		}()
 	}
 
-	logrus.Infof("server started and listening on %s:%d", c.Host, c.Port)
-	return s.Start()
-}
-
-func (c *Server) buildDatabase() error {
-	if c.engine == nil {
-		c.engine = NewDatabaseEngine(
-			c.userAuth,
-			c.Version,
-			int(c.Parallelism),
-			!c.DisableSquash,
-		)
+	if c.RescanInterval > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go c.rescan(c.RescanInterval, done)
+		logrus.WithField("interval", c.RescanInterval).
+			Info("rescanning repository directories periodically")
 	}
 
-	c.sharedCache = cache.NewObjectLRU(c.CacheSize * cache.MiByte)
-
-	c.rootLibrary = libraries.New(nil)
-	c.pool = gitbase.NewRepositoryPool(c.sharedCache, c.rootLibrary)
-
-	if err := c.addDirectories(); err != nil {
-		return err
+	if c.ReindexInterval > 0 {
+		if c.ReadOnly {
+			logrus.Warn("indexes are not updated periodically in read-only mode")
+		} else {
+			done := make(chan struct{})
+			defer close(done)
+			go c.reindexPeriodically(c.ReindexInterval, done)
+			logrus.WithField("interval", c.ReindexInterval).
+				Info("updating indexes periodically")
+		}
 	}
 
-	c.engine.AddDatabase(gitbase.NewDatabase(c.Name, c.pool))
-	c.engine.AddDatabase(sql.NewInformationSchemaDatabase(c.engine.Catalog))
-	c.engine.Catalog.SetCurrentDatabase(c.Name)
-	logrus.WithField("db", c.Name).Debug("registered database to catalog")
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Start()
+	}()
 
-	c.engine.Catalog.MustRegister(function.Functions...)
-	logrus.Debug("registered all available functions in catalog")
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
 
-	if err := c.registerDrivers(); err != nil {
-		return err
+	if c.health != nil {
+		c.health.setLoaded()
 	}
 
-	if !c.DisableSquash {
-		logrus.Info("squash tables rule is enabled")
-	} else {
-		logrus.Warn("squash tables rule is disabled")
-	}
-
-	return c.engine.Init()
-}
-
-func (c *Server) registerDrivers() error {
-	if err := os.MkdirAll(c.IndexDir, 0755); err != nil {
+	logrus.Infof("server started and listening on %s:%d", c.Host, c.Port)
+	select {
+	case err := <-errc:
 		return err
+	case sig := <-signals:
+		logrus.WithFields(logrus.Fields{
+			"signal":  sig,
+			"timeout": c.ShutdownTimeout,
+		}).Info("shutting down server")
 	}
 
-	logrus.Debug("created index storage")
-
-	c.engine.Catalog.RegisterIndexDriver(
-		pilosa.NewDriver(filepath.Join(c.IndexDir, pilosa.DriverID)),
-	)
-	logrus.Debug("registered pilosa index driver")
-
-	return nil
+	c.shutdown(s, httpSrv)
+	return <-errc
 }
 
-func (c *Server) addDirectories() error {
-	if len(c.Directories) == 0 {
-		logrus.Error("at least one folder should be provided.")
+// newServer creates a MySQL server like server.NewServer does, with a handler
+// that also runs the gitbase administrative statements.
+func (c *Server) newServer(cfg server.Config, sb server.SessionBuilder) (*server.Server, error) {
+	if cfg.Tracer == nil {
+		cfg.Tracer = opentracing.NoopTracer{}
 	}
 
-	defaultBare := bareAuto
-	switch {
-	case c.Bare:
-		defaultBare = bareOn
-	case c.NonBare:
-		defaultBare = bareOff
-	}
-
-	for _, d := range c.Directories {
-		dir := directory{
-			Path:   d,
-			Format: c.Format,
-			Bare:   defaultBare,
-			Bucket: c.Bucket,
-			Rooted: !c.NonRooted,
-		}
-
-		dir, err := parseDirectory(dir)
-		if err != nil {
-			return err
-		}
-
-		err = c.addDirectory(dir)
-		if err != nil {
-			return err
-		}
+	if c.sessions == nil {
+		c.sessions = newSessionRegistry()
 	}
 
-	repos, err := c.rootLibrary.Repositories(borges.ReadOnlyMode)
+	sm := server.NewSessionManager(
+		c.sessions.wrap(sb), cfg.Tracer,
+		c.engine.Catalog.MemoryManager,
+		cfg.Address,
+	)
+	h := server.NewHandler(c.engine, sm, cfg.ConnReadTimeout)
+
+	l, err := server.NewListener(cfg.Protocol, cfg.Address, h)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	vtListener, err := mysql.NewFromListener(
+		l,
+		cfg.Auth.Mysql(),
+		&adminHandler{
+			Handler:       h,
+			sm:            sm,
+			opts:          &c.engineOptions,
+			requireSecure: c.RequireSecureTransport,
+			slowLog:       c.slowLog,
+			sessions:      c.sessions,
+		},
+		cfg.ConnReadTimeout,
+		cfg.ConnWriteTimeout,
+	)
+	if err != nil {
+		return nil, err
 	}
-	defer repos.Close()
 
-	return repos.ForEach(func(r borges.Repository) error {
-		id := r.ID().String()
-		logrus.WithField("id", id).Debug("repository added")
-		return r.Close()
-	})
-}
+	vtListener.TLSConfig = c.tlsConfig
+	vtListener.RequireSecureTransport = c.RequireSecureTransport
 
-func (c *Server) addDirectory(d directory) error {
-	if d.Format == "siva" {
-		var lib borges.Library
-		var err error
-
-		if d.Rooted {
-			sivaOpts := &siva.LibraryOptions{
-				Transactional: true,
-				RootedRepo:    d.Rooted,
-				Cache:         c.sharedCache,
-				Bucket:        d.Bucket,
-				Performance:   true,
-				RegistryCache: 100000,
-			}
+	return &server.Server{Listener: vtListener}, nil
+}
 
-			lib, err = siva.NewLibrary("", osfs.New(d.Path), sivaOpts)
-			if err != nil {
-				return err
-			}
-		} else {
-			sivaOpts := &legacysiva.LibraryOptions{
-				Cache:         c.sharedCache,
-				Bucket:        d.Bucket,
-				RegistryCache: 100000,
-			}
+// buildQueryLimits returns the default limits of the queries.
+func (c *Server) buildQueryLimits() (gitbase.QueryLimits, error) {
+	switch {
+	case c.MaxExecutionTime < 0:
+		return gitbase.QueryLimits{}, fmt.Errorf("--max-execution-time must not be negative")
+	case c.MaxRows < 0:
+		return gitbase.QueryLimits{}, fmt.Errorf("--max-rows must not be negative")
+	case c.MaxBlobBytes < 0:
+		return gitbase.QueryLimits{}, fmt.Errorf("--max-blob-bytes must not be negative")
+	}
+
+	return gitbase.QueryLimits{
+		MaxExecutionTime: c.MaxExecutionTime,
+		MaxRows:          c.MaxRows,
+		MaxBlobBytes:     c.MaxBlobBytes,
+	}, nil
+}
 
-			lib, err = legacysiva.NewLibrary(d.Path, osfs.New(d.Path), sivaOpts)
-			if err != nil {
-				return err
-			}
+// buildTLSConfig returns the TLS configuration of the server, or nil if TLS
+// is not enabled.
+func (c *Server) buildTLSConfig() (*tls.Config, error) {
+	if c.TLSCert == "" && c.TLSKey == "" {
+		if c.TLSCA != "" {
+			return nil, fmt.Errorf("--tls-ca can only be used with --tls-cert and --tls-key")
 		}
 
-		err = c.rootLibrary.Add(lib)
-		if err != nil {
-			return err
+		if c.RequireSecureTransport {
+			return nil, fmt.Errorf("--require-secure-transport can only be used with --tls-cert and --tls-key")
 		}
 
-		return nil
-	}
-
-	bare, err := discoverBare(d)
-	if err != nil {
-		return err
-	}
-
-	plainOpts := &plain.LocationOptions{
-		Cache:       c.sharedCache,
-		Performance: true,
-		Bare:        bare,
+		return nil, nil
 	}
 
-	if c.plainLibrary == nil {
-		c.plainLibrary = plain.NewLibrary(borges.LibraryID("plain"), nil)
-		err := c.rootLibrary.Add(c.plainLibrary)
-		if err != nil {
-			return err
-		}
+	if c.TLSCert == "" || c.TLSKey == "" {
+		return nil, fmt.Errorf("--tls-cert and --tls-key must be used together")
 	}
 
-	loc, err := plain.NewLocation(
-		borges.LocationID(d.Path),
-		osfs.New(d.Path),
-		plainOpts)
+	cfg, err := vttls.ServerConfig(c.TLSCert, c.TLSKey, c.TLSCA)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("invalid TLS configuration: %s", err)
 	}
 
-	c.plainLibrary.AddLocation(loc)
-
-	return nil
+	logrus.WithField("client-certificates", c.TLSCA != "").Info("TLS enabled")
+	return cfg, nil
 }
 
 type bareOpt int
@@ -535,7 +537,7 @@ func discoverBare(d directory) (bool, error) {
 	return bare, nil
 }
 
-func enableMetrics(host string, port int) *http.Server {
+func enableMetrics(host string, port int, health *healthChecker) *http.Server {
 	// Engine metrics
 	sqle.QueryCounter = prometheus.NewCounterFrom(promopts.CounterOpts{
 		Namespace: "go_mysql_server",
@@ -630,9 +632,13 @@ func enableMetrics(host string, port int) *http.Server {
 		"duration",
 	})
 
-	// metrics http server
+	// metrics http server, with the health endpoints
+	mux := http.NewServeMux()
+	mux.Handle("/", promhttp.Handler())
+	health.register(mux)
+
 	return &http.Server{
 		Addr:    net.JoinHostPort(host, strconv.Itoa(port)),
-		Handler: promhttp.Handler(),
+		Handler: mux,
 	}
 }
@@ -1,14 +1,33 @@
 package command
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	gosql "database/sql"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/src-d/gitbase"
+
+	"github.com/go-sql-driver/mysql"
 	fixtures "github.com/src-d/go-git-fixtures"
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/src-d/go-mysql-server/server"
+	"github.com/src-d/go-mysql-server/sql"
 	"github.com/stretchr/testify/require"
+	git "gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
@@ -284,14 +303,14 @@ func TestCache(t *testing.T) {
 		require.NoError(os.RemoveAll(tmpDir))
 	}()
 
-	server := &Server{
+	server := &Server{engineOptions: engineOptions{
 		CacheSize:   512,
 		Format:      "siva",
 		Bucket:      0,
 		LogLevel:    "debug",
 		Directories: []string{"../../../_testdata"},
 		IndexDir:    tmpDir,
-	}
+	}}
 
 	err = server.buildDatabase()
 	require.NoError(err)
@@ -319,3 +338,332 @@ func TestCache(t *testing.T) {
 	_, ok = repo.Cache().Get(hash)
 	require.True(ok)
 }
+
+func TestReload(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	plainDir := filepath.Join(tmpDir, "plain")
+	sivaDir := filepath.Join(tmpDir, "siva")
+	require.NoError(os.Mkdir(plainDir, 0755))
+	require.NoError(os.Mkdir(sivaDir, 0755))
+
+	server := &Server{engineOptions: engineOptions{
+		CacheSize: 512,
+		Format:    "git",
+		LogLevel:  "info",
+		Directories: []string{
+			plainDir,
+			"file://" + sivaDir + "?format=siva&bucket=0",
+		},
+		IndexDir: filepath.Join(tmpDir, "index"),
+	}}
+	require.NoError(server.buildDatabase())
+
+	count := func() int {
+		repos, err := libraryRepositories(server.pool.Library())
+		require.NoError(err)
+		return len(repos)
+	}
+	require.Equal(0, count())
+
+	added, removed, err := server.reload()
+	require.NoError(err)
+	require.Empty(added)
+	require.Empty(removed)
+	require.Equal(uint64(0), server.pool.Generation())
+
+	// the directory was empty at startup, so it must discover that the new
+	// repositories are bare
+	_, err = git.PlainInit(filepath.Join(plainDir, "bare"), true)
+	require.NoError(err)
+
+	siva := "fff840f8784ef162dc83a1465fc5763d890b68ba.siva"
+	content, err := ioutil.ReadFile(filepath.Join("../../../_testdata", siva))
+	require.NoError(err)
+	require.NoError(ioutil.WriteFile(filepath.Join(sivaDir, siva), content, 0644))
+
+	added, removed, err = server.reload()
+	require.NoError(err)
+	require.Contains(added, "bare")
+	require.Len(added, 3)
+	require.Empty(removed)
+	require.Equal(uint64(1), server.pool.Generation())
+	require.True(count() > 1)
+
+	_, err = server.pool.GetRepo("bare")
+	require.NoError(err)
+
+	require.NoError(os.RemoveAll(plainDir))
+
+	added, removed, err = server.reload()
+	require.NoError(err)
+	require.Empty(added)
+	require.Equal([]string{"bare"}, removed)
+
+	_, err = server.pool.GetRepo("bare")
+	require.True(gitbase.ErrPoolRepoNotFound.Is(err))
+	require.True(count() > 0)
+}
+
+// writeCertificate writes a self-signed certificate for localhost and its
+// key to the given directory and returns their paths.
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	require := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(ioutil.WriteFile(certFile, pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: der},
+	), 0644))
+	require.NoError(ioutil.WriteFile(keyFile, pem.EncodeToMemory(
+		&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER},
+	), 0600))
+
+	return certFile, keyFile
+}
+
+func TestServerTLS(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	certFile, keyFile := writeCertificate(t, tmpDir)
+
+	invalid := []*Server{
+		{TLSCert: certFile},
+		{TLSKey: keyFile},
+		{TLSCA: certFile},
+		{RequireSecureTransport: true},
+		{TLSCert: keyFile, TLSKey: certFile},
+	}
+	for _, s := range invalid {
+		_, err := s.buildTLSConfig()
+		require.Error(err)
+	}
+
+	cert, err := ioutil.ReadFile(certFile)
+	require.NoError(err)
+	roots := x509.NewCertPool()
+	require.True(roots.AppendCertsFromPEM(cert))
+	require.NoError(mysql.RegisterTLSConfig("gitbase-test", &tls.Config{
+		RootCAs:    roots,
+		ServerName: "localhost",
+	}))
+	defer mysql.DeregisterTLSConfig("gitbase-test")
+
+	// start runs a server with its own engine, so connection ids of
+	// different servers don't clash, and returns its address.
+	start := func(requireSecure bool) (string, func()) {
+		s := &Server{
+			engineOptions: engineOptions{
+				Name:        "gitbase",
+				CacheSize:   512,
+				Format:      "siva",
+				Bucket:      0,
+				LogLevel:    "info",
+				Directories: []string{"../../../_testdata"},
+				IndexDir:    filepath.Join(tmpDir, "index"),
+				userAuth:    auth.NewNativeSingle("root", "", auth.AllPermissions),
+			},
+			TLSCert:                certFile,
+			TLSKey:                 keyFile,
+			RequireSecureTransport: requireSecure,
+		}
+
+		var err error
+		s.tlsConfig, err = s.buildTLSConfig()
+		require.NoError(err)
+		require.NotNil(s.tlsConfig)
+		require.NoError(s.buildDatabase())
+
+		srv, err := s.newServer(
+			server.Config{
+				Protocol: "tcp",
+				Address:  "127.0.0.1:0",
+				Auth:     s.userAuth,
+			},
+			gitbase.NewSessionBuilder(s.pool),
+		)
+		require.NoError(err)
+		go srv.Start()
+
+		return srv.Listener.Addr().String(), func() { _ = srv.Close() }
+	}
+
+	count := func(addr, params string) (int, error) {
+		db, err := gosql.Open("mysql", fmt.Sprintf(
+			"root:@tcp(%s)/gitbase%s", addr, params,
+		))
+		require.NoError(err)
+		defer db.Close()
+
+		var n int
+		err = db.QueryRow("SELECT COUNT(*) FROM repositories").Scan(&n)
+		return n, err
+	}
+
+	addr, stop := start(true)
+	defer stop()
+
+	n, err := count(addr, "?tls=gitbase-test")
+	require.NoError(err)
+	require.Equal(5, n)
+
+	_, err = count(addr, "")
+	require.Error(err)
+
+	// without requiring it, plain connections are also allowed
+	addr, stop = start(false)
+	defer stop()
+
+	n, err = count(addr, "")
+	require.NoError(err)
+	require.Equal(5, n)
+
+	n, err = count(addr, "?tls=gitbase-test")
+	require.NoError(err)
+	require.Equal(5, n)
+}
+
+func TestServerQueryLimits(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	s := &Server{
+		engineOptions: engineOptions{
+			Name:        "gitbase",
+			CacheSize:   512,
+			Format:      "siva",
+			Bucket:      0,
+			LogLevel:    "info",
+			Directories: []string{"../../../_testdata"},
+			IndexDir:    filepath.Join(tmpDir, "index"),
+			userAuth:    new(auth.None),
+		},
+		MaxRows: -1,
+	}
+
+	_, err = s.buildQueryLimits()
+	require.Error(err)
+
+	s.MaxRows = 3
+	s.queryLimits, err = s.buildQueryLimits()
+	require.NoError(err)
+	require.NoError(s.buildDatabase())
+
+	ctx := s.newContext(context.Background())
+	query := func(q string) error {
+		ctx = sql.NewContext(context.Background(),
+			sql.WithSession(ctx.Session),
+			sql.WithPid(ctx.Pid()+1),
+		)
+
+		_, iter, err := s.engine.Query(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		_, err = sql.RowIterToRows(iter)
+		return err
+	}
+
+	err = query("SELECT * FROM repositories")
+	require.True(gitbase.ErrMaxRows.Is(err), err)
+
+	// only the rows returned count
+	require.NoError(query("SELECT COUNT(*) FROM repositories"))
+
+	require.NoError(query("SET gitbase_max_rows = 0"))
+	require.NoError(query("SELECT * FROM repositories"))
+}
+
+func TestServerResultCache(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	repo := filepath.Join(tmpDir, "plain", "repo")
+	commitFile(t, repo, "README", "readme")
+
+	s := &Server{
+		engineOptions: engineOptions{
+			Name:        "gitbase",
+			CacheSize:   512,
+			Format:      "git",
+			Bucket:      0,
+			LogLevel:    "info",
+			Directories: []string{filepath.Dir(repo)},
+			IndexDir:    filepath.Join(tmpDir, "index"),
+			userAuth:    new(auth.None),
+		},
+	}
+
+	cache := gitbase.NewResultCache(1024*1024, 0)
+	s.resultCache = cache
+	require.NoError(s.buildDatabase())
+
+	ctx := s.newContext(context.Background())
+	query := func(q string) []sql.Row {
+		ctx = sql.NewContext(context.Background(),
+			sql.WithSession(ctx.Session),
+			sql.WithPid(ctx.Pid()+1),
+			sql.WithQuery(q),
+		)
+
+		_, iter, err := s.engine.Query(ctx, q)
+		require.NoError(err)
+
+		rows, err := sql.RowIterToRows(iter)
+		require.NoError(err)
+		return rows
+	}
+
+	require.Equal([]sql.Row{{int64(1)}}, query("SELECT COUNT(*) FROM commits"))
+	require.Equal(1, cache.Len())
+
+	require.Equal([]sql.Row{{int64(1)}}, query("select count(*)  from commits;"))
+	require.Equal(1, cache.Len())
+
+	// the results are cached again when a commit is added to the repository
+	commitFile(t, repo, "LICENSE", "license")
+	require.Equal([]sql.Row{{int64(2)}}, query("SELECT COUNT(*) FROM commits"))
+	require.Equal(2, cache.Len())
+
+	query("SET gitbase_result_cache = 0")
+	query("SELECT COUNT(*) FROM refs")
+	require.Equal(2, cache.Len())
+}
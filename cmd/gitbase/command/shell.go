@@ -0,0 +1,327 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/peterh/liner"
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/go-mysql-server/auth"
+)
+
+const (
+	ShellDescription = "Starts an interactive SQL shell"
+	ShellHelp        = ShellDescription + "\n\n" +
+		"Builds an in-process gitbase engine over the given directories\n" +
+		"and reads queries from an interactive prompt, without the need\n" +
+		"of a server or a MySQL client. Queries can span several lines and\n" +
+		"must be terminated with a semicolon. Type \\help to see the\n" +
+		"available shell commands."
+
+	shellPrompt         = "gitbase> "
+	shellContinuePrompt = "      -> "
+	shellHelp           = `Shell commands:
+  \help, \?           Show this help
+  \quit, \q           Exit the shell
+  \timing             Toggle printing the time taken by each query
+  \explain <query>    Show the execution plan of a query
+  \format <format>    Change the output format: table, csv, tsv, json or markdown
+  \clear, \c          Discard the query being written
+`
+)
+
+// Shell represents the `shell` command of gitbase cli tool.
+type Shell struct {
+	engineOptions
+
+	Output      string `short:"o" long:"output" default:"table" choice:"table" choice:"csv" choice:"tsv" choice:"json" choice:"markdown" description:"Initial format of the results"`
+	HistoryFile string `long:"history" env:"GITBASE_HISTORY_FILE" description:"File where the shell history is kept. By default, .gitbase_history in the user home directory."`
+
+	out    io.Writer
+	timing bool
+	buf    []string
+	words  []string
+}
+
+// Execute starts the interactive shell, it honors the go-flags.Commander
+// interface.
+func (c *Shell) Execute(args []string) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+
+	c.userAuth = new(auth.None)
+	if err := c.buildDatabase(); err != nil {
+		return err
+	}
+
+	if c.out == nil {
+		c.out = os.Stdout
+	}
+
+	if _, err := newRowWriter(c.Output, c.out); err != nil {
+		return err
+	}
+
+	c.words = c.completionWords()
+
+	line := liner.NewLiner()
+	defer line.Close()
+
+	line.SetCtrlCAborts(true)
+	line.SetMultiLineMode(true)
+	line.SetTabCompletionStyle(liner.TabPrints)
+	line.SetWordCompleter(c.complete)
+
+	historyFile := c.historyFile()
+	if historyFile != "" {
+		if f, err := os.Open(historyFile); err == nil {
+			if _, err := line.ReadHistory(f); err != nil {
+				logrus.WithField("error", err).Warn("unable to read shell history")
+			}
+			_ = f.Close()
+		}
+
+		defer func() {
+			f, err := os.Create(historyFile)
+			if err != nil {
+				logrus.WithField("error", err).Warn("unable to write shell history")
+				return
+			}
+
+			if _, err := line.WriteHistory(f); err != nil {
+				logrus.WithField("error", err).Warn("unable to write shell history")
+			}
+			_ = f.Close()
+		}()
+	}
+
+	for {
+		prompt := shellPrompt
+		if len(c.buf) > 0 {
+			prompt = shellContinuePrompt
+		}
+
+		input, err := line.Prompt(prompt)
+		if err == liner.ErrPromptAborted {
+			c.buf = nil
+			continue
+		}
+
+		if err == io.EOF {
+			fmt.Fprintln(c.out)
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		exit, entry := c.process(input)
+		if entry != "" {
+			line.AppendHistory(entry)
+		}
+
+		if exit {
+			return nil
+		}
+	}
+}
+
+func (c *Shell) historyFile() string {
+	if c.HistoryFile != "" {
+		return c.HistoryFile
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".gitbase_history")
+}
+
+// process handles a line of input. It returns whether the shell must exit
+// and, when a query or command is complete, the entry to add to the
+// history.
+func (c *Shell) process(input string) (exit bool, entry string) {
+	trimmed := strings.TrimSpace(input)
+	if strings.HasPrefix(trimmed, `\`) {
+		return c.command(trimmed), trimmed
+	}
+
+	if len(c.buf) == 0 && trimmed == "" {
+		return false, ""
+	}
+
+	lower := strings.ToLower(strings.TrimSuffix(trimmed, ";"))
+	if len(c.buf) == 0 && (lower == "quit" || lower == "exit") {
+		return true, trimmed
+	}
+
+	c.buf = append(c.buf, input)
+	queries, rest := scanQueries(strings.Join(c.buf, "\n"))
+	if len(queries) == 0 {
+		if strings.TrimSpace(rest) == "" {
+			c.buf = nil
+		}
+		return false, ""
+	}
+
+	c.buf = nil
+	if strings.TrimSpace(rest) != "" {
+		c.buf = []string{rest}
+	}
+
+	for _, q := range queries {
+		c.runQuery(q)
+	}
+
+	return false, strings.Join(strings.Fields(strings.Join(queries, "; ")+";"), " ")
+}
+
+// command runs a shell command and returns whether the shell must exit.
+func (c *Shell) command(input string) bool {
+	name := input
+	var arg string
+	if idx := strings.IndexFunc(input, unicode.IsSpace); idx > 0 {
+		name = input[:idx]
+		arg = strings.TrimSpace(input[idx:])
+	}
+
+	switch strings.ToLower(name) {
+	case `\q`, `\quit`:
+		return true
+	case `\?`, `\h`, `\help`:
+		fmt.Fprint(c.out, shellHelp)
+	case `\c`, `\clear`:
+		c.buf = nil
+	case `\timing`:
+		c.timing = !c.timing
+		state := "off"
+		if c.timing {
+			state = "on"
+		}
+		fmt.Fprintf(c.out, "Timing is %s.\n", state)
+	case `\format`, `\f`:
+		if _, err := newRowWriter(arg, c.out); err != nil {
+			c.printError(err)
+			break
+		}
+		c.Output = strings.ToLower(arg)
+		fmt.Fprintf(c.out, "Output format is %s.\n", c.Output)
+	case `\explain`:
+		query := strings.TrimSuffix(arg, ";")
+		if strings.TrimSpace(query) == "" {
+			c.printError(fmt.Errorf(`\explain requires a query`))
+			break
+		}
+		c.runQuery("DESCRIBE FORMAT=TREE " + query)
+	default:
+		c.printError(fmt.Errorf("unknown command %s, type \\help to see the available commands", name))
+	}
+
+	return false
+}
+
+func (c *Shell) runQuery(query string) {
+	w, err := newRowWriter(c.Output, c.out)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Now()
+	schema, iter, err := c.runSQL(c.newContext(ctx), query)
+	if err != nil {
+		c.printError(err)
+		return
+	}
+
+	if _, err := writeRows(w, schema, iter); err != nil {
+		c.printError(err)
+		return
+	}
+
+	if c.timing {
+		fmt.Fprintf(c.out, "Time: %s\n", time.Since(start))
+	}
+}
+
+func (c *Shell) printError(err error) {
+	fmt.Fprintf(c.out, "ERROR: %s\n", err)
+}
+
+// completionWords returns the sorted list of table names, column names and
+// function names available in the current database.
+func (c *Shell) completionWords() []string {
+	var seen = make(map[string]struct{})
+	add := func(w string) {
+		seen[strings.ToLower(w)] = struct{}{}
+	}
+
+	db, err := c.engine.Catalog.Database(c.Name)
+	if err == nil {
+		for name, table := range db.Tables() {
+			add(name)
+			for _, col := range table.Schema() {
+				add(col.Name)
+			}
+		}
+	}
+
+	for name := range c.engine.Catalog.FunctionRegistry {
+		add(name)
+	}
+
+	var words = make([]string, 0, len(seen))
+	for w := range seen {
+		words = append(words, w)
+	}
+	sort.Strings(words)
+
+	return words
+}
+
+// complete is the word completer of the shell. It completes the word before
+// the cursor with table, column and function names, or shell commands.
+func (c *Shell) complete(line string, pos int) (head string, completions []string, tail string) {
+	head, tail = line[:pos], line[pos:]
+
+	start := strings.LastIndexFunc(head, func(r rune) bool {
+		return !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '\\')
+	}) + 1
+	word := head[start:]
+	head = head[:start]
+	if word == "" {
+		return head, nil, tail
+	}
+
+	candidates := c.words
+	if strings.HasPrefix(word, `\`) {
+		candidates = []string{`\clear`, `\explain`, `\format`, `\help`, `\quit`, `\timing`}
+	}
+
+	lower := strings.ToLower(word)
+	upper := word == strings.ToUpper(word) && word != lower
+	for _, w := range candidates {
+		if strings.HasPrefix(w, lower) {
+			if upper {
+				w = strings.ToUpper(w)
+			}
+			completions = append(completions, w)
+		}
+	}
+
+	return head, completions, tail
+}
@@ -0,0 +1,138 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestShell(t *testing.T) (*Shell, *bytes.Buffer, func()) {
+	t.Helper()
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	shell := &Shell{
+		engineOptions: engineOptions{
+			CacheSize:   512,
+			Format:      "siva",
+			Bucket:      0,
+			LogLevel:    "info",
+			Directories: []string{"../../../_testdata"},
+			IndexDir:    tmpDir,
+			userAuth:    new(auth.None),
+		},
+		Output: "csv",
+		out:    &buf,
+	}
+
+	require.NoError(t, shell.buildDatabase())
+	shell.words = shell.completionWords()
+
+	return shell, &buf, func() {
+		require.NoError(t, os.RemoveAll(tmpDir))
+	}
+}
+
+func TestShellProcess(t *testing.T) {
+	require := require.New(t)
+	shell, buf, cleanup := newTestShell(t)
+	defer cleanup()
+
+	exit, entry := shell.process("SELECT COUNT(*) AS c")
+	require.False(exit)
+	require.Empty(entry)
+	require.Empty(buf.String())
+
+	exit, entry = shell.process("FROM refs;")
+	require.False(exit)
+	require.Equal("SELECT COUNT(*) AS c FROM refs;", entry)
+	require.Equal("c\n57\n", buf.String())
+
+	buf.Reset()
+	exit, entry = shell.process("SELECT 'a;")
+	require.False(exit)
+	require.Empty(entry)
+
+	_, entry = shell.process("b' AS x; SELECT")
+	require.Equal("SELECT 'a; b' AS x;", entry)
+	require.Equal("x\n\"a;\nb\"\n", buf.String())
+	require.Equal([]string{" SELECT"}, shell.buf)
+
+	_, _ = shell.process(`\clear`)
+	require.Empty(shell.buf)
+
+	buf.Reset()
+	_, _ = shell.process("SELECT * FROM nope;")
+	require.Contains(buf.String(), "ERROR: ")
+
+	exit, _ = shell.process("exit")
+	require.True(exit)
+}
+
+func TestShellCommands(t *testing.T) {
+	require := require.New(t)
+	shell, buf, cleanup := newTestShell(t)
+	defer cleanup()
+
+	require.False(shell.command(`\format json`))
+	require.Equal("Output format is json.\n", buf.String())
+	require.Equal("json", shell.Output)
+
+	buf.Reset()
+	require.False(shell.command(`\format nope`))
+	require.Equal("ERROR: unknown output format: nope\n", buf.String())
+	require.Equal("json", shell.Output)
+
+	buf.Reset()
+	require.False(shell.command(`\timing`))
+	require.Equal("Timing is on.\n", buf.String())
+	require.True(shell.timing)
+
+	buf.Reset()
+	_, _ = shell.process("SELECT 1 AS a;")
+	require.Regexp(`^\{"a":1\}\nTime: .+\n$`, buf.String())
+
+	require.False(shell.command(`\timing`))
+	require.False(shell.timing)
+
+	buf.Reset()
+	require.False(shell.command(`\explain SELECT ref_name FROM refs`))
+	require.Contains(buf.String(), "Table(refs)")
+
+	buf.Reset()
+	require.False(shell.command(`\nope`))
+	require.Contains(buf.String(), "ERROR: unknown command")
+
+	require.True(shell.command(`\q`))
+}
+
+func TestShellComplete(t *testing.T) {
+	require := require.New(t)
+	shell, _, cleanup := newTestShell(t)
+	defer cleanup()
+
+	head, completions, tail := shell.complete("SELECT * FROM ref_co", 20)
+	require.Equal("SELECT * FROM ", head)
+	require.Equal([]string{"ref_commits"}, completions)
+	require.Equal("", tail)
+
+	head, completions, tail = shell.complete("SELECT UAST_X(", 13)
+	require.Equal("SELECT ", head)
+	require.Equal([]string{"UAST_XPATH"}, completions)
+	require.Equal("(", tail)
+
+	_, completions, _ = shell.complete("SELECT commit_author_w", 22)
+	require.Equal([]string{"commit_author_when"}, completions)
+
+	_, completions, _ = shell.complete(`\ti`, 3)
+	require.Equal([]string{`\timing`}, completions)
+
+	_, completions, _ = shell.complete("SELECT ", 7)
+	require.Empty(completions)
+}
@@ -0,0 +1,159 @@
+package command
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/go-mysql-server/server"
+	"github.com/src-d/go-mysql-server/sql"
+	"vitess.io/vitess/go/mysql"
+)
+
+// shutdownPollInterval is the time between the checks of the running
+// queries while the server shuts down.
+const shutdownPollInterval = 100 * time.Millisecond
+
+// sessionRegistry keeps the sessions of the open MySQL connections, so they
+// are closed when their connection is closed or the server shuts down.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[uint32]sql.Session
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{sessions: make(map[uint32]sql.Session)}
+}
+
+// wrap returns a session builder that registers the sessions built by the
+// given one.
+func (r *sessionRegistry) wrap(sb server.SessionBuilder) server.SessionBuilder {
+	return func(c *mysql.Conn, addr string) sql.Session {
+		s := sb(c, addr)
+
+		r.mu.Lock()
+		r.sessions[c.ConnectionID] = s
+		r.mu.Unlock()
+
+		return s
+	}
+}
+
+// close closes the session of the given connection, if any.
+func (r *sessionRegistry) close(id uint32) {
+	r.mu.Lock()
+	s, ok := r.sessions[id]
+	delete(r.sessions, id)
+	r.mu.Unlock()
+
+	if ok {
+		closeSession(s)
+	}
+}
+
+// closeAll closes all the registered sessions.
+func (r *sessionRegistry) closeAll() {
+	r.mu.Lock()
+	sessions := r.sessions
+	r.sessions = make(map[uint32]sql.Session)
+	r.mu.Unlock()
+
+	for _, s := range sessions {
+		closeSession(s)
+	}
+}
+
+// closeSession closes the session if it holds any resource.
+func closeSession(s sql.Session) {
+	c, ok := s.(io.Closer)
+	if !ok {
+		return
+	}
+
+	if err := c.Close(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"id":    s.ID(),
+		}).Warn("unable to close session")
+	}
+}
+
+// shutdown stops the server gracefully. It stops accepting connections and
+// HTTP requests, waits for the running queries until the shutdown timeout
+// passes, kills the ones still running and then closes the sessions and the
+// libraries.
+func (c *Server) shutdown(s *server.Server, httpSrv *http.Server) {
+	if c.health != nil {
+		c.health.setStopping()
+	}
+
+	s.Listener.Shutdown()
+	deadline := time.Now().Add(c.ShutdownTimeout)
+
+	if httpSrv != nil {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		if err := httpSrv.Shutdown(ctx); err != nil {
+			logrus.WithField("error", err).Warn("HTTP requests still running")
+		}
+		cancel()
+	}
+
+	if killed := c.waitQueries(deadline); killed > 0 {
+		logrus.WithField("queries", killed).
+			Warn("shutdown timeout reached, killed the running queries")
+	}
+
+	if c.sessions != nil {
+		c.sessions.closeAll()
+	}
+
+	c.closeLibraries()
+	logrus.Info("server stopped")
+}
+
+// waitQueries waits until there are no running queries or the deadline
+// passes, and then kills the queries still running. It returns the number of
+// queries killed.
+func (c *engineOptions) waitQueries(deadline time.Time) int {
+	for {
+		procs := c.engine.Catalog.Processes()
+		if len(procs) == 0 {
+			return 0
+		}
+
+		if !time.Now().Before(deadline) {
+			for _, p := range procs {
+				p.Kill()
+			}
+			return len(procs)
+		}
+
+		time.Sleep(shutdownPollInterval)
+	}
+}
+
+// closeLibraries closes the libraries of the repository directories that
+// hold any resource and releases them with the object cache.
+func (c *engineOptions) closeLibraries() {
+	c.libMut.Lock()
+	defer c.libMut.Unlock()
+
+	for d, lib := range c.sivaLibraries {
+		if closer, ok := lib.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+					"path":  d.Path,
+				}).Warn("unable to close library")
+			}
+		}
+	}
+
+	c.sivaLibraries = nil
+	if c.sharedCache != nil {
+		c.sharedCache.Clear()
+	}
+}
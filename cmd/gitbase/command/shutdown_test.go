@@ -0,0 +1,76 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	sqle "github.com/src-d/go-mysql-server"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+	"vitess.io/vitess/go/mysql"
+)
+
+type closerSession struct {
+	sql.Session
+	closed bool
+}
+
+func (s *closerSession) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestSessionRegistry(t *testing.T) {
+	require := require.New(t)
+
+	r := newSessionRegistry()
+	sessions := make(map[uint32]*closerSession)
+	sb := r.wrap(func(c *mysql.Conn, addr string) sql.Session {
+		s := &closerSession{Session: sql.NewBaseSession()}
+		sessions[c.ConnectionID] = s
+		return s
+	})
+
+	for i := uint32(1); i <= 3; i++ {
+		sb(&mysql.Conn{ConnectionID: i}, "localhost")
+	}
+
+	r.close(2)
+	require.False(sessions[1].closed)
+	require.True(sessions[2].closed)
+	require.False(sessions[3].closed)
+	require.Len(r.sessions, 2)
+
+	r.closeAll()
+	require.True(sessions[1].closed)
+	require.True(sessions[3].closed)
+	require.Empty(r.sessions)
+}
+
+func TestWaitQueries(t *testing.T) {
+	require := require.New(t)
+
+	s := &Server{engineOptions: engineOptions{engine: sqle.NewDefault()}}
+	catalog := s.engine.Catalog
+
+	require.Zero(s.waitQueries(time.Now().Add(time.Second)))
+
+	ctx, err := catalog.AddProcess(sql.NewEmptyContext(), sql.QueryProcess, "SELECT 1")
+	require.NoError(err)
+
+	go func() {
+		time.Sleep(2 * shutdownPollInterval)
+		catalog.Done(ctx.Pid())
+	}()
+	require.Zero(s.waitQueries(time.Now().Add(time.Minute)))
+
+	ctx, err = catalog.AddProcess(sql.NewEmptyContext(), sql.QueryProcess, "SELECT 2")
+	require.NoError(err)
+
+	require.Equal(1, s.waitQueries(time.Now().Add(shutdownPollInterval)))
+	select {
+	case <-ctx.Done():
+	default:
+		require.FailNow("query was not killed")
+	}
+}
@@ -0,0 +1,147 @@
+package command
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-mysql-server/sql"
+	"vitess.io/vitess/go/sqltypes"
+)
+
+// slowQueryLog writes the queries that take longer than a threshold as JSON
+// lines.
+type slowQueryLog struct {
+	threshold time.Duration
+
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// slowQueryEntry is a line of the slow query log.
+type slowQueryEntry struct {
+	Time         time.Time `json:"time"`
+	User         string    `json:"user"`
+	Query        string    `json:"query"`
+	Duration     float64   `json:"duration_ms"`
+	RowsReturned int64     `json:"rows_returned"`
+	RowsRead     int64     `json:"rows_read"`
+	Repositories int       `json:"repositories"`
+	Objects      int64     `json:"objects_read"`
+	BlobBytes    int64     `json:"blob_bytes_read"`
+	Squashed     bool      `json:"squashed"`
+	Cached       bool      `json:"cached"`
+	Error        string    `json:"error,omitempty"`
+}
+
+func newSlowQueryLog(w io.Writer, threshold time.Duration) *slowQueryLog {
+	return &slowQueryLog{
+		threshold: threshold,
+		w:         w,
+		enc:       json.NewEncoder(w),
+	}
+}
+
+// openSlowQueryLog opens the slow query log in the given file, or in the
+// standard error if path is "-".
+func openSlowQueryLog(path string, threshold time.Duration) (*slowQueryLog, error) {
+	if path == "-" {
+		return newSlowQueryLog(os.Stderr, threshold), nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSlowQueryLog(f, threshold), nil
+}
+
+// run runs a query with the given function, passing it the callback of the
+// results, and logs the query if it's slow. The statistics of the query are
+// taken from the given session.
+func (l *slowQueryLog) run(
+	session sql.Session,
+	user, query string,
+	callback func(*sqltypes.Result) error,
+	fn func(func(*sqltypes.Result) error) error,
+) error {
+	return l.track(session, user, query, func() (int64, error) {
+		var rows int64
+		err := fn(func(r *sqltypes.Result) error {
+			rows += int64(len(r.Rows))
+			return callback(r)
+		})
+		return rows, err
+	})
+}
+
+// track runs a query with the given function, which returns the number of
+// rows it returned, and logs the query if it's slow. The statistics of the
+// query are taken from the given session.
+func (l *slowQueryLog) track(
+	session sql.Session,
+	user, query string,
+	fn func() (int64, error),
+) error {
+	sess, _ := session.(*gitbase.Session)
+	if sess != nil {
+		sess.ResetQueryStats()
+	}
+
+	start := time.Now()
+	rows, err := fn()
+
+	duration := time.Since(start)
+	if duration < l.threshold {
+		return err
+	}
+
+	entry := slowQueryEntry{
+		Time:         start,
+		User:         user,
+		Query:        query,
+		Duration:     float64(duration) / float64(time.Millisecond),
+		RowsReturned: rows,
+	}
+
+	if sess != nil {
+		stats := sess.QueryStats()
+		entry.RowsRead = stats.Rows
+		entry.Repositories = len(stats.Repositories)
+		entry.Objects = stats.Objects
+		entry.BlobBytes = stats.BlobBytes
+		entry.Squashed = stats.Squashed
+		entry.Cached = stats.Cached
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	l.write(entry)
+	return err
+}
+
+func (l *slowQueryLog) write(entry slowQueryEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.enc.Encode(entry); err != nil {
+		logrus.WithField("error", err).Error("unable to write to the slow query log")
+	}
+}
+
+// Close closes the file of the log, if any.
+func (l *slowQueryLog) Close() error {
+	if f, ok := l.w.(*os.File); ok && f != os.Stderr {
+		return f.Close()
+	}
+
+	return nil
+}
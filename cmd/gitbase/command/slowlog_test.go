@@ -0,0 +1,120 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+	"vitess.io/vitess/go/sqltypes"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	s := &Server{engineOptions: engineOptions{
+		Name:        "gitbase",
+		CacheSize:   512,
+		Format:      "siva",
+		Bucket:      0,
+		LogLevel:    "info",
+		Directories: []string{"../../../_testdata"},
+		IndexDir:    filepath.Join(tmpDir, "index"),
+		userAuth:    new(auth.None),
+		queryStats:  true,
+		resultCache: gitbase.NewResultCache(1024*1024, 0),
+	}}
+	require.NoError(s.buildDatabase())
+
+	var buf bytes.Buffer
+	log := newSlowQueryLog(&buf, 0)
+	ctx := s.newContext(context.Background())
+
+	run := func(q string) error {
+		return log.run(ctx.Session, "root", q,
+			func(*sqltypes.Result) error { return nil },
+			func(callback func(*sqltypes.Result) error) error {
+				ctx = sql.NewContext(context.Background(),
+					sql.WithSession(ctx.Session),
+					sql.WithPid(ctx.Pid()+1),
+					sql.WithQuery(q),
+				)
+
+				_, iter, err := s.engine.Query(ctx, q)
+				if err != nil {
+					return err
+				}
+
+				rows, err := sql.RowIterToRows(iter)
+				if err != nil {
+					return err
+				}
+
+				return callback(&sqltypes.Result{Rows: make([][]sqltypes.Value, len(rows))})
+			},
+		)
+	}
+
+	entries := func() []slowQueryEntry {
+		var result []slowQueryEntry
+		dec := json.NewDecoder(&buf)
+		for dec.More() {
+			var e slowQueryEntry
+			require.NoError(dec.Decode(&e))
+			result = append(result, e)
+		}
+		return result
+	}
+
+	require.NoError(run("SELECT COUNT(*) FROM commits"))
+	require.NoError(run("SELECT * FROM refs NATURAL JOIN commits"))
+	require.Error(run("SELECT * FROM foo"))
+
+	logged := entries()
+	require.Len(logged, 3)
+
+	e := logged[0]
+	require.Equal("root", e.User)
+	require.Equal("SELECT COUNT(*) FROM commits", e.Query)
+	require.Equal(int64(1), e.RowsReturned)
+	require.True(e.RowsRead > 1, e.RowsRead)
+	require.Equal(5, e.Repositories)
+	require.True(e.Objects >= e.RowsRead, fmt.Sprint(e.Objects))
+	require.False(e.Squashed)
+	require.False(e.Cached)
+	require.Empty(e.Error)
+
+	require.True(logged[1].Squashed)
+	require.True(logged[1].RowsReturned > 0)
+
+	// queries that don't read any table don't take the stats of the
+	// previous one
+	require.Zero(logged[2].RowsRead)
+	require.Zero(logged[2].Repositories)
+	require.NotEmpty(logged[2].Error)
+
+	// queries answered by the result cache are logged too
+	require.NoError(run("SELECT COUNT(*) FROM commits"))
+	logged = entries()
+	require.Len(logged, 1)
+	require.True(logged[0].Cached)
+	require.Equal(int64(1), logged[0].RowsReturned)
+	require.Zero(logged[0].RowsRead)
+
+	log.threshold = time.Hour
+	require.NoError(run("SELECT COUNT(*) FROM commits"))
+	require.Empty(entries())
+}
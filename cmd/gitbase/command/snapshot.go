@@ -0,0 +1,317 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/src-d/gitbase"
+	"github.com/src-d/gitbase/internal/export"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/src-d/go-mysql-server/sql"
+)
+
+const (
+	SnapshotDescription = "Writes the repositories data to a SQLite database"
+	SnapshotHelp        = SnapshotDescription + "\n\n" +
+		"Builds an in-process gitbase engine over the given directories and\n" +
+		"copies the repositories, refs, commits and commit_files tables, and\n" +
+		"optionally the blobs table, to a standalone SQLite database that\n" +
+		"can be opened with any tool supporting SQLite. Repositories and\n" +
+		"references can be filtered, in which case only the commits\n" +
+		"reachable from the selected references are written."
+)
+
+// Snapshot represents the `snapshot` command of gitbase cli tool.
+type Snapshot struct {
+	engineOptions
+
+	Output       string   `short:"o" long:"out" required:"true" description:"SQLite database file to create"`
+	Repositories []string `long:"repo" description:"ID of a repository to include, multiple repositories can be given. By default, all the repositories are included."`
+	Refs         []string `long:"ref" description:"Pattern of the names of the references to include, such as refs/heads/*, multiple patterns can be given. By default, all the references are included."`
+	Blobs        bool     `long:"blobs" description:"Also write the blobs of the files of the written commits"`
+	Force        bool     `short:"f" long:"force" description:"Overwrite the output file if it exists"`
+}
+
+// snapshotKey identifies an object in a repository.
+type snapshotKey struct {
+	repository string
+	hash       string
+}
+
+// Execute writes the snapshot, it honors the go-flags.Commander interface.
+func (c *Snapshot) Execute(args []string) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+
+	for _, p := range c.Refs {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid reference pattern %q: %s", p, err)
+		}
+	}
+
+	if _, err := os.Stat(c.Output); err == nil {
+		if !c.Force {
+			return fmt.Errorf("%s already exists, use --force to overwrite it", c.Output)
+		}
+
+		if err := os.Remove(c.Output); err != nil {
+			return err
+		}
+	}
+
+	c.userAuth = new(auth.None)
+	if err := c.buildDatabase(); err != nil {
+		return err
+	}
+
+	db, err := export.OpenSQLite(c.Output)
+	if err != nil {
+		return err
+	}
+
+	err = c.snapshot(db)
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		_ = os.Remove(c.Output)
+		return err
+	}
+
+	logrus.WithField("file", c.Output).Info("snapshot written")
+	return nil
+}
+
+func (c *Snapshot) snapshot(db *export.SQLite) error {
+	err := c.copyTable(db, gitbase.RepositoriesTableName, nil)
+	if err != nil {
+		return err
+	}
+
+	var refs []string
+	err = c.copyTable(db, gitbase.ReferencesTableName, func(s sql.Schema, row sql.Row) bool {
+		name := columnString(s, row, "ref_name")
+		if !c.matchRef(name) {
+			return false
+		}
+
+		refs = append(refs, name)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	// When references are filtered, only the commits reachable from them
+	// are written. Otherwise, commits is nil and all commits are written.
+	var commits map[snapshotKey]struct{}
+	if len(c.Refs) > 0 {
+		commits, err = c.reachableCommits(refs)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.copyTable(db, gitbase.CommitsTableName, func(s sql.Schema, row sql.Row) bool {
+		return commits == nil || containsRow(commits, s, row, "commit_hash")
+	})
+	if err != nil {
+		return err
+	}
+
+	var blobs = make(map[snapshotKey]struct{})
+	err = c.copyTable(db, gitbase.CommitFilesTableName, func(s sql.Schema, row sql.Row) bool {
+		if commits != nil && !containsRow(commits, s, row, "commit_hash") {
+			return false
+		}
+
+		if c.Blobs {
+			blobs[rowKey(s, row, "blob_hash")] = struct{}{}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.Blobs {
+		err = c.copyTable(db, gitbase.BlobsTableName, func(s sql.Schema, row sql.Row) bool {
+			return containsRow(blobs, s, row, "blob_hash")
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	indexes := [][]string{
+		{gitbase.ReferencesTableName, "repository_id", "ref_name"},
+		{gitbase.CommitsTableName, "repository_id", "commit_hash"},
+		{gitbase.CommitFilesTableName, "repository_id", "commit_hash"},
+	}
+	if c.Blobs {
+		indexes = append(indexes, []string{gitbase.BlobsTableName, "repository_id", "blob_hash"})
+	}
+
+	for _, idx := range indexes {
+		if err := db.CreateIndex(idx[0], idx[1:]...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reachableCommits returns the commits reachable from the given references.
+func (c *Snapshot) reachableCommits(refs []string) (map[snapshotKey]struct{}, error) {
+	var commits = make(map[snapshotKey]struct{})
+	if len(refs) == 0 {
+		return commits, nil
+	}
+
+	query := fmt.Sprintf(
+		"SELECT repository_id, commit_hash FROM %s WHERE ref_name IN (%s)",
+		gitbase.RefCommitsTableName,
+		quoteList(refs),
+	)
+
+	schema, iter, err := c.query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	err = forEachRow(iter, func(row sql.Row) error {
+		commits[rowKey(schema, row, "commit_hash")] = struct{}{}
+		return nil
+	})
+
+	return commits, err
+}
+
+// copyTable writes to db all the rows of the given table that belong to the
+// selected repositories and pass the filter, if any.
+func (c *Snapshot) copyTable(
+	db *export.SQLite,
+	table string,
+	filter func(sql.Schema, sql.Row) bool,
+) error {
+	query := "SELECT * FROM " + table
+	if len(c.Repositories) > 0 {
+		query += fmt.Sprintf(" WHERE repository_id IN (%s)", quoteList(c.Repositories))
+	}
+
+	schema, iter, err := c.query(query)
+	if err != nil {
+		return err
+	}
+
+	w, err := db.CreateTable(table, schema)
+	if err != nil {
+		_ = iter.Close()
+		return err
+	}
+
+	var rows int
+	err = forEachRow(iter, func(row sql.Row) error {
+		if filter != nil && !filter(schema, row) {
+			return nil
+		}
+
+		rows++
+		return w.Write(row)
+	})
+
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"table": table,
+		"rows":  rows,
+	}).Info("table written")
+
+	return nil
+}
+
+func (c *Snapshot) query(query string) (sql.Schema, sql.RowIter, error) {
+	logrus.WithField("query", query).Debug("running query")
+	return c.engine.Query(c.newContext(context.Background()), query)
+}
+
+// forEachRow calls fn with every row of the iterator, which is always closed.
+func forEachRow(iter sql.RowIter, fn func(sql.Row) error) error {
+	for {
+		row, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			_ = iter.Close()
+			return err
+		}
+
+		if err := fn(row); err != nil {
+			_ = iter.Close()
+			return err
+		}
+	}
+
+	return iter.Close()
+}
+
+func (c *Snapshot) matchRef(name string) bool {
+	if len(c.Refs) == 0 {
+		return true
+	}
+
+	for _, p := range c.Refs {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func columnString(schema sql.Schema, row sql.Row, column string) string {
+	for i, col := range schema {
+		if col.Name == column {
+			s, _ := row[i].(string)
+			return s
+		}
+	}
+	return ""
+}
+
+func rowKey(schema sql.Schema, row sql.Row, column string) snapshotKey {
+	return snapshotKey{
+		repository: columnString(schema, row, "repository_id"),
+		hash:       columnString(schema, row, column),
+	}
+}
+
+func containsRow(set map[snapshotKey]struct{}, schema sql.Schema, row sql.Row, column string) bool {
+	_, ok := set[rowKey(schema, row, column)]
+	return ok
+}
+
+func quoteList(values []string) string {
+	var quoted = make([]string, len(values))
+	for i, v := range values {
+		v = strings.Replace(v, `\`, `\\`, -1)
+		quoted[i] = "'" + strings.Replace(v, "'", `\'`, -1) + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,89 @@
+package command
+
+import (
+	gosql "database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSnapshot(indexDir, output string) *Snapshot {
+	return &Snapshot{
+		engineOptions: engineOptions{
+			CacheSize:   512,
+			Format:      "siva",
+			Bucket:      0,
+			LogLevel:    "info",
+			Directories: []string{"../../../_testdata"},
+			IndexDir:    indexDir,
+		},
+		Output: output,
+	}
+}
+
+func countRows(t *testing.T, path string, query string) int {
+	t.Helper()
+
+	db, err := gosql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var count int
+	require.NoError(t, db.QueryRow(query).Scan(&count))
+	return count
+}
+
+func TestSnapshot(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "all.sqlite")
+	require.NoError(newTestSnapshot(tmpDir, path).Execute(nil))
+
+	require.Equal(5, countRows(t, path, "SELECT COUNT(*) FROM repositories"))
+	require.Equal(57, countRows(t, path, "SELECT COUNT(*) FROM refs"))
+	require.True(countRows(t, path, "SELECT COUNT(*) FROM commits") > 0)
+	require.True(countRows(t, path, "SELECT COUNT(*) FROM commit_files") > 0)
+
+	db, err := gosql.Open("sqlite3", path)
+	require.NoError(err)
+	_, err = db.Exec("SELECT COUNT(*) FROM blobs")
+	require.Error(err)
+	require.NoError(db.Close())
+
+	// the output already exists
+	require.Error(newTestSnapshot(tmpDir, path).Execute(nil))
+
+	cmd := newTestSnapshot(tmpDir, path)
+	cmd.Force = true
+	cmd.Repositories = []string{"015da2f4-6d89-7ec8-5ac9-a38329ea875b"}
+	cmd.Refs = []string{"refs/heads/*"}
+	cmd.Blobs = true
+	require.NoError(cmd.Execute(nil))
+
+	require.Equal(1, countRows(t, path, "SELECT COUNT(*) FROM repositories"))
+	require.Equal(1, countRows(t, path, "SELECT COUNT(*) FROM refs"))
+	require.Equal(0, countRows(t, path, `SELECT COUNT(*) FROM commits
+		WHERE repository_id <> '015da2f4-6d89-7ec8-5ac9-a38329ea875b'`))
+	require.True(countRows(t, path, "SELECT COUNT(*) FROM commits") > 0)
+	require.Equal(0, countRows(t, path, `SELECT COUNT(*) FROM commit_files cf
+		WHERE NOT EXISTS (SELECT 1 FROM commits c WHERE c.commit_hash = cf.commit_hash)`))
+	require.Equal(0, countRows(t, path, `SELECT COUNT(*) FROM commit_files cf
+		WHERE NOT EXISTS (SELECT 1 FROM blobs b WHERE b.blob_hash = cf.blob_hash)`))
+}
+
+func TestSnapshotInvalidRef(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	cmd := newTestSnapshot(tmpDir, filepath.Join(tmpDir, "out.sqlite"))
+	cmd.Refs = []string{"refs/heads/["}
+	require.Error(t, cmd.Execute(nil))
+}
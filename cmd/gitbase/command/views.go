@@ -0,0 +1,475 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/expression"
+	"github.com/src-d/go-mysql-server/sql/parse"
+	"github.com/src-d/go-mysql-server/sql/plan"
+)
+
+// viewsDir is the directory of the index directory with the materialized
+// views of each database.
+const viewsDir = "views"
+
+// viewSchema is the schema of the result of CREATE, REFRESH and DROP
+// MATERIALIZED VIEW, with the number of repositories refreshed and removed
+// from each view.
+var viewSchema = sql.Schema{
+	{Name: "view", Type: sql.Text},
+	{Name: "refreshed", Type: sql.Int64},
+	{Name: "removed", Type: sql.Int64},
+}
+
+// runViewStatement runs a CREATE, REFRESH or DROP MATERIALIZED VIEW
+// statement.
+func (c *engineOptions) runViewStatement(ctx *sql.Context, stmt *adminStatement) ([]sql.Row, error) {
+	switch stmt.procedure {
+	case createViewStatement:
+		return c.createView(ctx, stmt.args[0], stmt.args[1])
+	case refreshViewStatement:
+		var name string
+		if len(stmt.args) > 0 {
+			name = stmt.args[0]
+		}
+
+		return c.refreshViews(ctx, name)
+	default:
+		return c.dropView(stmt.args[0])
+	}
+}
+
+// createView creates a materialized view with the given name and the rows
+// of the given query for each repository. The query must return the
+// repository_id column and its rows must not depend on other repositories,
+// as it's run once for each repository.
+func (c *engineOptions) createView(ctx *sql.Context, name, q string) ([]sql.Row, error) {
+	name = strings.ToLower(name)
+
+	c.viewsMut.Lock()
+	defer c.viewsMut.Unlock()
+
+	db, err := c.database()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := db.Tables()[name]; ok {
+		return nil, gitbase.ErrTableExists.New(name)
+	}
+
+	node, err := c.analyzeViewQuery(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := node.Schema()
+	if !schemaHasColumn(schema, "repository_id") {
+		return nil, fmt.Errorf("the query of a materialized view must return the repository_id column")
+	}
+
+	if err := checkViewPlan(node); err != nil {
+		return nil, err
+	}
+
+	view, err := gitbase.NewMaterializedView(c.viewDir(name), name, q, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed, removed, err := c.refreshView(ctx, view)
+	if err == nil {
+		err = db.AddMaterializedView(view)
+	}
+
+	if err != nil {
+		if derr := view.Delete(); derr != nil {
+			logrus.WithFields(logrus.Fields{
+				"view":  name,
+				"error": derr,
+			}).Error("unable to remove materialized view")
+		}
+
+		return nil, err
+	}
+
+	return []sql.Row{sql.NewRow(name, int64(refreshed), int64(removed))}, nil
+}
+
+// refreshViews updates the materialized views of the database, or only the
+// one with the given name if it's not empty, with the rows of the
+// repositories whose checksum changed since they were refreshed, removing
+// the rows of the repositories that are not in the pool anymore. It returns
+// a row for each view with the number of repositories refreshed and removed.
+func (c *engineOptions) refreshViews(ctx *sql.Context, name string) ([]sql.Row, error) {
+	name = strings.ToLower(name)
+
+	c.viewsMut.Lock()
+	defer c.viewsMut.Unlock()
+
+	db, err := c.database()
+	if err != nil {
+		return nil, err
+	}
+
+	views := db.MaterializedViews()
+	if name != "" {
+		view, ok := db.MaterializedView(name)
+		if !ok {
+			return nil, fmt.Errorf("materialized view %s not found", name)
+		}
+		views = []*gitbase.MaterializedView{view}
+	}
+
+	var rows []sql.Row
+	for _, view := range views {
+		refreshed, removed, err := c.refreshView(ctx, view)
+		if err != nil {
+			return nil, fmt.Errorf("unable to refresh %s: %s", view.Name(), err)
+		}
+
+		rows = append(rows, sql.NewRow(view.Name(), int64(refreshed), int64(removed)))
+	}
+
+	return rows, nil
+}
+
+// dropView removes the materialized view with the given name.
+func (c *engineOptions) dropView(name string) ([]sql.Row, error) {
+	name = strings.ToLower(name)
+
+	c.viewsMut.Lock()
+	defer c.viewsMut.Unlock()
+
+	db, err := c.database()
+	if err != nil {
+		return nil, err
+	}
+
+	view, ok := db.MaterializedView(name)
+	if !ok {
+		return nil, fmt.Errorf("materialized view %s not found", name)
+	}
+
+	db.RemoveMaterializedView(name)
+	if err := view.Delete(); err != nil {
+		return nil, err
+	}
+
+	logrus.WithField("view", name).Info("materialized view removed")
+	return []sql.Row{sql.NewRow(name, int64(0), int64(len(view.Repositories())))}, nil
+}
+
+// refreshView runs the query of the view for each repository whose checksum
+// changed and removes the repositories not in the pool anymore.
+func (c *engineOptions) refreshView(
+	ctx *sql.Context,
+	view *gitbase.MaterializedView,
+) (refreshed, removed int, err error) {
+	repos, _, err := c.pool.RepositoryChecksums()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	stored := view.Repositories()
+	var changed, deleted []string
+	for id, sum := range repos {
+		if stored[id] != sum {
+			changed = append(changed, id)
+		}
+	}
+
+	for id := range stored {
+		if _, ok := repos[id]; !ok {
+			deleted = append(deleted, id)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(deleted)
+
+	if len(changed) == 0 && len(deleted) == 0 {
+		return 0, 0, nil
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"view":      view.Name(),
+		"refreshed": len(changed),
+		"removed":   len(deleted),
+	})
+	log.Info("refreshing materialized view")
+
+	for _, id := range changed {
+		if err := c.refreshRepository(ctx, view, id, repos[id]); err != nil {
+			return 0, 0, fmt.Errorf("repository %s: %s", id, err)
+		}
+	}
+
+	for _, id := range deleted {
+		if err := view.RemoveRepository(id); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	log.Info("materialized view refreshed")
+	return len(changed), len(deleted), nil
+}
+
+// refreshRepository replaces the rows of the view of the repository with
+// the given ID with the ones returned by the query of the view over only
+// that repository.
+func (c *engineOptions) refreshRepository(
+	ctx *sql.Context,
+	view *gitbase.MaterializedView,
+	id, checksum string,
+) error {
+	opts := []gitbase.SessionOption{gitbase.WithSkipGitErrors(c.SkipGitErrors)}
+	if c.bblfshEndpoint != "" {
+		opts = append(opts, gitbase.WithBblfshEndpoint(c.bblfshEndpoint))
+	}
+
+	session := gitbase.NewSession(c.pool.WithRepositories(id), opts...)
+	sctx := sql.NewContext(ctx,
+		sql.WithSession(session),
+		sql.WithPid(ctx.Pid()),
+		sql.WithMemoryManager(ctx.Memory),
+		sql.WithQuery(view.Query()),
+	)
+
+	sctx, err := c.engine.Catalog.AddProcess(sctx, sql.QueryProcess, view.Query())
+	if err != nil {
+		return err
+	}
+	defer c.engine.Catalog.Done(sctx.Pid())
+
+	node, err := c.analyzeViewQuery(sctx, view.Query())
+	if err != nil {
+		return err
+	}
+
+	if !sameColumns(node.Schema(), view.Schema()) {
+		return fmt.Errorf("the schema of the query changed")
+	}
+
+	iter, err := node.RowIter(sctx)
+	if err != nil {
+		return err
+	}
+
+	if err := view.SetRepository(id, checksum, iter); err != nil {
+		_ = iter.Close()
+		return err
+	}
+
+	return iter.Close()
+}
+
+// analyzeViewQuery parses and analyzes the query of a materialized view,
+// which must be a read query.
+func (c *engineOptions) analyzeViewQuery(ctx *sql.Context, q string) (sql.Node, error) {
+	parsed, err := parse.Parse(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	switch parsed.(type) {
+	case *plan.CreateIndex, *plan.DropIndex, *plan.InsertInto, *plan.DeleteFrom,
+		*plan.Update, *plan.LockTables, *plan.UnlockTables, *plan.CreateTable,
+		*plan.DropTable, *plan.Set, *plan.Use, *plan.DescribeQuery:
+		return nil, fmt.Errorf("materialized views are only supported for read queries")
+	}
+
+	return c.engine.Analyzer.Analyze(ctx, parsed)
+}
+
+// checkViewPlan returns an error if the rows of the plan of a view query
+// depend on the rows of several repositories, as the query is run for each
+// repository alone and their rows are just put together. That's the case of
+// LIMIT, ORDER BY and DISTINCT, and of GROUP BY without grouping by the
+// repository_id column of a table.
+func checkViewPlan(node sql.Node) error {
+	var err error
+	inspect := func(n sql.Node) bool {
+		if err != nil {
+			return false
+		}
+
+		switch n := n.(type) {
+		case *plan.Limit, *plan.Offset:
+			err = fmt.Errorf("the query of a materialized view can't have LIMIT or OFFSET")
+		case *plan.Sort:
+			err = fmt.Errorf("the query of a materialized view can't have ORDER BY")
+		case *plan.Distinct, *plan.OrderedDistinct:
+			err = fmt.Errorf("the query of a materialized view can't have DISTINCT")
+		case *plan.GroupBy:
+			if !groupsByRepository(n) {
+				err = fmt.Errorf("the query of a materialized view must group by repository_id")
+			}
+		}
+
+		return err == nil
+	}
+
+	var check func(sql.Node)
+	check = func(node sql.Node) {
+		plan.Inspect(node, inspect)
+		for _, sq := range subqueries(node) {
+			if err == nil {
+				check(sq)
+			}
+		}
+	}
+
+	check(node)
+	return err
+}
+
+// subqueries returns the plans of the subqueries of the node, including the
+// ones in the filters pushed down to its tables.
+func subqueries(node sql.Node) []sql.Node {
+	var result []sql.Node
+	collect := func(e sql.Expression) bool {
+		if sq, ok := e.(*expression.Subquery); ok {
+			result = append(result, sq.Query)
+		}
+
+		return true
+	}
+
+	plan.InspectExpressions(node, collect)
+	plan.Inspect(node, func(n sql.Node) bool {
+		rt, ok := n.(*plan.ResolvedTable)
+		if !ok {
+			return true
+		}
+
+		var t sql.Table = rt.Table
+		for {
+			if ft, ok := t.(interface{ Filters() []sql.Expression }); ok {
+				for _, f := range ft.Filters() {
+					expression.Inspect(f, collect)
+				}
+			}
+
+			switch w := t.(type) {
+			case sql.TableWrapper:
+				t = w.Underlying()
+			case interface{ Unwrap() sql.Table }:
+				t = w.Unwrap()
+			default:
+				return true
+			}
+		}
+	})
+
+	return result
+}
+
+// groupsByRepository returns whether the grouping of the node has the
+// repository_id column of a table.
+func groupsByRepository(n *plan.GroupBy) bool {
+	for _, e := range n.Grouping {
+		if f, ok := e.(*expression.GetField); ok &&
+			f.Table() != "" && strings.EqualFold(f.Name(), "repository_id") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadViews registers the materialized views of the database kept in the
+// index directory. Views that can't be opened are skipped.
+func (c *engineOptions) loadViews() error {
+	db, err := c.database()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(c.IndexDir, viewsDir, c.Name)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		log := logrus.WithField("view", e.Name())
+		view, err := gitbase.OpenMaterializedView(filepath.Join(dir, e.Name()))
+		if err == nil {
+			err = db.AddMaterializedView(view)
+		}
+
+		if err != nil {
+			log.WithField("error", err).Error("unable to load materialized view, skipping it")
+			continue
+		}
+
+		log.Debug("registered materialized view")
+	}
+
+	return nil
+}
+
+// viewDir returns the directory of the materialized view with the given
+// name.
+func (c *engineOptions) viewDir(name string) string {
+	return filepath.Join(c.IndexDir, viewsDir, c.Name, name)
+}
+
+// database returns the gitbase database of the engine.
+func (c *engineOptions) database() (*gitbase.Database, error) {
+	db, err := c.engine.Catalog.Database(c.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	gdb, ok := db.(*gitbase.Database)
+	if !ok {
+		return nil, fmt.Errorf("database %s is not a gitbase database", c.Name)
+	}
+
+	return gdb, nil
+}
+
+// sameColumns returns whether the schemas have columns with the same names
+// and types, regardless of their source.
+func sameColumns(a, b sql.Schema) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Type != b[i].Type {
+			return false
+		}
+	}
+
+	return true
+}
+
+// schemaHasColumn returns whether the schema has a column with the given
+// name.
+func schemaHasColumn(schema sql.Schema, name string) bool {
+	for _, col := range schema {
+		if strings.EqualFold(col.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
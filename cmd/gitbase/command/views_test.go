@@ -0,0 +1,150 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-mysql-server/auth"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaterializedViews(t *testing.T) {
+	require := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "gitbase")
+	require.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	plainDir := filepath.Join(tmpDir, "plain")
+	commitFile(t, filepath.Join(plainDir, "a"), "README", "a")
+	commitFile(t, filepath.Join(plainDir, "b"), "README", "b")
+
+	newServer := func() *Server {
+		server := &Server{engineOptions: engineOptions{
+			CacheSize:   512,
+			Format:      "git",
+			Bucket:      0,
+			LogLevel:    "info",
+			Directories: []string{plainDir},
+			IndexDir:    filepath.Join(tmpDir, "index"),
+			userAuth:    new(auth.None),
+		}}
+		require.NoError(server.buildDatabase())
+		return server
+	}
+	server := newServer()
+
+	query := func(query string) ([]sql.Row, error) {
+		session := gitbase.NewSession(server.pool, server.sessionOptions()...)
+		ctx := sql.NewContext(context.Background(), sql.WithSession(session))
+
+		_, iter, err := server.runSQL(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		return sql.RowIterToRows(iter)
+	}
+
+	run := func(q string) []sql.Row {
+		t.Helper()
+		rows, err := query(q)
+		require.NoError(err)
+		return rows
+	}
+
+	require.Equal([]sql.Row{
+		{"commit_counts", int64(2), int64(0)},
+	}, run(`CREATE MATERIALIZED VIEW commit_counts AS
+		SELECT repository_id, COUNT(*) AS commits
+		FROM commits
+		GROUP BY repository_id`))
+
+	countsQuery := "SELECT repository_id, commits FROM commit_counts ORDER BY repository_id"
+	require.Equal([]sql.Row{
+		{"a", int64(1)},
+		{"b", int64(1)},
+	}, run(countsQuery))
+
+	_, err = query("CREATE MATERIALIZED VIEW commit_counts AS SELECT repository_id FROM commits")
+	require.True(gitbase.ErrTableExists.Is(err))
+
+	_, err = query("CREATE MATERIALIZED VIEW commits AS SELECT repository_id FROM commits")
+	require.True(gitbase.ErrTableExists.Is(err))
+
+	_, err = query("CREATE MATERIALIZED VIEW hashes AS SELECT commit_hash FROM commits")
+	require.Error(err)
+	_, err = os.Stat(server.viewDir("hashes"))
+	require.True(os.IsNotExist(err))
+
+	// queries whose rows depend on several repositories are rejected
+	for _, q := range []string{
+		"SELECT repository_id FROM commits LIMIT 1",
+		"SELECT repository_id FROM commits ORDER BY commit_author_when",
+		"SELECT DISTINCT repository_id FROM commits",
+		"SELECT 'x' AS repository_id, COUNT(*) FROM commits",
+		"SELECT repository_id, COUNT(*) FROM commits GROUP BY repository_id ORDER BY 2",
+		"SELECT repository_id FROM commits WHERE commit_hash IN (SELECT commit_hash FROM commits LIMIT 1)",
+	} {
+		_, err = query("CREATE MATERIALIZED VIEW bad AS " + q)
+		require.Error(err, q)
+		_, err = os.Stat(server.viewDir("bad"))
+		require.True(os.IsNotExist(err), q)
+	}
+
+	commitFile(t, filepath.Join(plainDir, "a"), "LICENSE", "a")
+
+	require.Equal([]sql.Row{
+		{"commit_counts", int64(1), int64(0)},
+	}, run("REFRESH MATERIALIZED VIEW commit_counts"))
+
+	require.Equal([]sql.Row{
+		{"commit_counts", int64(0), int64(0)},
+	}, run("REFRESH MATERIALIZED VIEW"))
+
+	require.Equal([]sql.Row{
+		{"a", int64(2)},
+		{"b", int64(1)},
+	}, run(countsQuery))
+
+	run("CALL gitbase_remove_repository('b')")
+
+	require.Equal([]sql.Row{
+		{"commit_counts", int64(0), int64(1)},
+	}, run("REFRESH MATERIALIZED VIEW commit_counts"))
+
+	require.Equal([]sql.Row{
+		{"a", int64(2)},
+	}, run(countsQuery))
+
+	// views are loaded again when the server starts, which adds the
+	// removed repository back
+	server = newServer()
+	require.Equal([]sql.Row{
+		{"a", int64(2)},
+	}, run(countsQuery))
+
+	require.Equal([]sql.Row{
+		{"commit_counts", int64(1), int64(0)},
+	}, run("REFRESH MATERIALIZED VIEW COMMIT_COUNTS"))
+
+	require.Equal([]sql.Row{
+		{"a", int64(2)},
+		{"b", int64(1)},
+	}, run(countsQuery))
+
+	require.Equal([]sql.Row{
+		{"commit_counts", int64(0), int64(2)},
+	}, run("DROP MATERIALIZED VIEW commit_counts"))
+
+	_, err = query(countsQuery)
+	require.True(sql.ErrTableNotFound.Is(err))
+
+	_, err = query("REFRESH MATERIALIZED VIEW commit_counts")
+	require.Error(err)
+}
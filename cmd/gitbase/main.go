@@ -17,6 +17,8 @@ var (
 )
 
 func main() {
+	skipGitErrors := os.Getenv("GITBASE_SKIP_GIT_ERRORS") != ""
+
 	parser := flags.NewNamedParser(name, flags.Default)
 	parser.UnknownOptionHandler = func(option string, arg flags.SplitArgument, args []string) ([]string, error) {
 		if option != "g" {
@@ -30,11 +32,78 @@ func main() {
 		return append(append(args, "-d"), args[0]), nil
 	}
 
-	_, err := parser.AddCommand("server", command.ServerDescription, command.ServerHelp,
-		&command.Server{
-			SkipGitErrors: os.Getenv("GITBASE_SKIP_GIT_ERRORS") != "",
-			Version:       version,
-		})
+	parser.CommandHandler = func(cmd flags.Commander, args []string) error {
+		if cmd == nil {
+			return nil
+		}
+
+		if c, ok := cmd.(command.Configurable); ok {
+			if err := c.LoadConfig(parser.Active); err != nil {
+				return err
+			}
+		}
+
+		return cmd.Execute(args)
+	}
+
+	server := &command.Server{}
+	server.SkipGitErrors = skipGitErrors
+	server.Version = version
+
+	_, err := parser.AddCommand("server", command.ServerDescription, command.ServerHelp, server)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	query := &command.Query{}
+	query.SkipGitErrors = skipGitErrors
+	query.Version = version
+
+	_, err = parser.AddCommand("query", command.QueryDescription, command.QueryHelp, query)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	shell := &command.Shell{}
+	shell.SkipGitErrors = skipGitErrors
+	shell.Version = version
+
+	_, err = parser.AddCommand("shell", command.ShellDescription, command.ShellHelp, shell)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	export := &command.Export{}
+	export.SkipGitErrors = skipGitErrors
+	export.Version = version
+
+	_, err = parser.AddCommand("export", command.ExportDescription, command.ExportHelp, export)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	snapshot := &command.Snapshot{}
+	snapshot.SkipGitErrors = skipGitErrors
+	snapshot.Version = version
+
+	_, err = parser.AddCommand("snapshot", command.SnapshotDescription, command.SnapshotHelp, snapshot)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	fetch := &command.Fetch{}
+	fetch.Version = version
+
+	_, err = parser.AddCommand("fetch", command.FetchDescription, command.FetchHelp, fetch)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	commitGraph := &command.CommitGraph{}
+	commitGraph.SkipGitErrors = skipGitErrors
+	commitGraph.Version = version
+
+	_, err = parser.AddCommand("commit-graph", command.CommitGraphDescription, command.CommitGraphHelp, commitGraph)
 	if err != nil {
 		logrus.Fatal(err)
 	}
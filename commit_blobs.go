@@ -116,7 +116,7 @@ func (t *commitBlobsTable) PartitionRows(
 		return nil, errorWithRepo(repo, err)
 	}
 
-	return sql.NewSpanIter(span, newRepoRowIter(repo, iter)), nil
+	return sql.NewSpanIter(span, newRepoRowIter(ctx, repo, iter)), nil
 }
 
 func (commitBlobsTable) HandledFilters(filters []sql.Expression) []sql.Expression {
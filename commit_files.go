@@ -128,7 +128,7 @@ func (t *commitFilesTable) PartitionRows(
 		return nil, errorWithRepo(repo, err)
 	}
 
-	return sql.NewSpanIter(span, newRepoRowIter(repo, iter)), nil
+	return sql.NewSpanIter(span, newRepoRowIter(ctx, repo, iter)), nil
 }
 
 func (commitFilesTable) HandledFilters(filters []sql.Expression) []sql.Expression {
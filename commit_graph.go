@@ -0,0 +1,72 @@
+package gitbase
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/gitbase/internal/commitgraph"
+	gitgraph "gopkg.in/src-d/go-git.v4/plumbing/object/commitgraph"
+)
+
+// repositoryGraph is the commit-graph of a repository, opened the first time
+// it's needed.
+type repositoryGraph struct {
+	mu     sync.Mutex
+	opened bool
+	graph  *commitgraph.Graph
+}
+
+// CommitNodes returns an index of the commits of the repository. The commits
+// in the commit-graph files of the repository, if any, are read from them
+// with their parents, generation numbers and dates without decoding their
+// objects. The rest are read from the object storage.
+func (r *Repository) CommitNodes() gitgraph.CommitNodeIndex {
+	if g := r.commitGraph(); g != nil {
+		return gitgraph.NewGraphCommitNodeIndex(g, r.Storer)
+	}
+
+	return gitgraph.NewObjectCommitNodeIndex(r.Storer)
+}
+
+// commitGraph returns the commit-graph of the repository or nil if it does
+// not have one.
+func (r *Repository) commitGraph() *commitgraph.Graph {
+	if r.graph == nil {
+		return nil
+	}
+
+	r.graph.mu.Lock()
+	defer r.graph.mu.Unlock()
+
+	if !r.graph.opened {
+		r.graph.opened = true
+
+		fs, err := r.FS()
+		if err != nil {
+			return nil
+		}
+
+		r.graph.graph, err = commitgraph.Open(fs)
+		if err != nil && !commitgraph.ErrNotFound.Is(err) {
+			logrus.WithFields(logrus.Fields{
+				"repo":  r.ID(),
+				"error": err,
+			}).Warn("unable to open commit-graph, reading commit objects")
+		}
+	}
+
+	return r.graph.graph
+}
+
+// close closes the files of the commit-graph. They are opened again if the
+// graph is used after closing it.
+func (g *repositoryGraph) close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.graph == nil {
+		return nil
+	}
+
+	return g.graph.Close()
+}
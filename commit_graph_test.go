@@ -0,0 +1,60 @@
+package gitbase
+
+import (
+	"io"
+	"testing"
+
+	"github.com/src-d/gitbase/internal/commitgraph"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitGraph(t *testing.T) {
+	require := require.New(t)
+	ctx, path, cleanup := setup(t)
+	defer cleanup()
+
+	refCommits, err := tableToRows(ctx, newRefCommitsTable(poolFromCtx(t, ctx)))
+	require.NoError(err)
+	commits, err := tableToRows(ctx, newCommitsTable(poolFromCtx(t, ctx)))
+	require.NoError(err)
+
+	repo, err := poolFromCtx(t, ctx).GetRepo(path)
+	require.NoError(err)
+	require.Nil(repo.commitGraph())
+
+	fs, err := repo.FS()
+	require.NoError(err)
+	n, err := commitgraph.Write(fs, repo.Storer)
+	require.NoError(err)
+	require.Equal(len(commits), n)
+	require.NoError(repo.Close())
+
+	repo, err = poolFromCtx(t, ctx).GetRepo(path)
+	require.NoError(err)
+	require.NotNil(repo.commitGraph())
+
+	head, err := repo.Head()
+	require.NoError(err)
+
+	// the history is walked without reading any object
+	var objects int64
+	iter := newIndexedCommitIter(false, countObjects(repo, &objects), head.Hash())
+	for {
+		node, _, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(err)
+		require.NotZero(node.Generation())
+		require.False(node.CommitTime().IsZero())
+	}
+	require.Zero(objects)
+
+	rows, err := tableToRows(ctx, newRefCommitsTable(poolFromCtx(t, ctx)))
+	require.NoError(err)
+	require.Equal(refCommits, rows)
+
+	rows, err = tableToRows(ctx, newCommitsTable(poolFromCtx(t, ctx)))
+	require.NoError(err)
+	require.Equal(commits, rows)
+}
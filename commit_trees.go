@@ -109,7 +109,7 @@ func (t *commitTreesTable) PartitionRows(
 		return nil, errorWithRepo(repo, err)
 	}
 
-	return sql.NewSpanIter(span, newRepoRowIter(repo, iter)), nil
+	return sql.NewSpanIter(span, newRepoRowIter(ctx, repo, iter)), nil
 }
 
 // IndexKeyValues implements the sql.IndexableTable interface.
@@ -2,14 +2,24 @@ package gitbase
 
 import (
 	"io"
+	"time"
 
 	"github.com/src-d/go-mysql-server/sql"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	gitgraph "gopkg.in/src-d/go-git.v4/plumbing/object/commitgraph"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 )
 
+// CommitWalkSlackVar is the session variable with the number of seconds
+// a commit can be committed before the commits in its history. When it's
+// greater than 0, the history of a reference stops being walked by the
+// commits table once all the commits left were committed more than that
+// before the lower bound of the committer_when filters. 0 means the whole
+// history is always walked.
+const CommitWalkSlackVar = "gitbase_commit_walk_slack"
+
 type commitsTable struct {
 	checksumable
 	partitioned
@@ -116,18 +126,30 @@ func (r *commitsTable) PartitionRows(
 				), nil
 			}
 
+			authorWhen := selectors.timeRange("commit_author_when")
+			committerWhen := selectors.timeRange("committer_when")
+
 			var iter object.CommitIter
 			if len(hashes) > 0 {
 				iter = newCommitsByHashIter(repo, stringsToHashes(hashes))
 			} else {
-				var err error
-				iter, err = newCommitIter(repo, shouldSkipErrors(ctx))
+				ci, err := newCommitIter(repo, shouldSkipErrors(ctx))
 				if err != nil {
 					return nil, err
 				}
+
+				ci.committerWhen = committerWhen
+				ci.stopBefore = commitWalkStop(ctx, committerWhen)
+				iter = ci
 			}
 
-			return &commitRowIter{repo, iter, shouldSkipErrors(ctx)}, nil
+			return &commitRowIter{
+				repo:          repo,
+				iter:          iter,
+				authorWhen:    authorWhen,
+				committerWhen: committerWhen,
+				skipGitErrors: shouldSkipErrors(ctx),
+			}, nil
 		},
 	)
 
@@ -136,7 +158,24 @@ func (r *commitsTable) PartitionRows(
 		return nil, errorWithRepo(repo, err)
 	}
 
-	return sql.NewSpanIter(span, newRepoRowIter(repo, iter)), nil
+	return sql.NewSpanIter(span, newRepoRowIter(ctx, repo, iter)), nil
+}
+
+// commitWalkStop returns the range whose lower bound is the commit time
+// before which the history of a reference stops being walked, according to
+// the slack set in the session, or an unbounded range if it's not stopped.
+func commitWalkStop(ctx *sql.Context, committerWhen timeRange) timeRange {
+	s, err := getSession(ctx)
+	if err != nil || committerWhen.from.IsZero() {
+		return timeRange{}
+	}
+
+	slack := intSessionVar(s, CommitWalkSlackVar)
+	if slack == 0 {
+		return timeRange{}
+	}
+
+	return timeRange{from: committerWhen.from.Add(-time.Duration(slack) * time.Second)}
 }
 
 func (commitsTable) HandledFilters(filters []sql.Expression) []sql.Expression {
@@ -163,6 +202,8 @@ func (r *commitsTable) IndexKeyValues(
 type commitRowIter struct {
 	repo          *Repository
 	iter          object.CommitIter
+	authorWhen    timeRange
+	committerWhen timeRange
 	skipGitErrors bool
 }
 
@@ -180,6 +221,11 @@ func (i *commitRowIter) Next() (sql.Row, error) {
 			return nil, err
 		}
 
+		if !i.authorWhen.contains(c.Author.When) ||
+			!i.committerWhen.contains(c.Committer.When) {
+			continue
+		}
+
 		return commitToRow(i.repo.ID(), c), nil
 	}
 }
@@ -189,13 +235,36 @@ func (i *commitRowIter) Close() error {
 	return nil
 }
 
+// commitIter iterates over all the commits reachable from the references of
+// a repository. The parents of the commits are read from the commit-graph of
+// the repository when available.
 type commitIter struct {
 	repo          *Repository
 	skipGitErrors bool
-	refs          storer.ReferenceIter
-	seen          map[plumbing.Hash]struct{}
-	ref           *plumbing.Reference
-	queue         []plumbing.Hash
+	// committerWhen skips the commits committed out of the range. With a
+	// commit-graph the skipped commits are not decoded.
+	committerWhen timeRange
+	// stopBefore stops walking the history of a reference once all the
+	// commits left to walk were committed before its lower bound. It's
+	// unbounded by default, as commits can be committed before their
+	// parents, and then the whole history is walked.
+	stopBefore timeRange
+	refs       storer.ReferenceIter
+	nodes      gitgraph.CommitNodeIndex
+	seen       map[plumbing.Hash]struct{}
+	ref        *plumbing.Reference
+	queue      []queuedCommit
+	// recent is the number of commits in the queue not committed before
+	// the lower bound of stopBefore.
+	recent int
+}
+
+// queuedCommit is a commit left to walk by commitIter.
+type queuedCommit struct {
+	hash plumbing.Hash
+	// old is true if the commit was committed before the lower bound of
+	// stopBefore.
+	old bool
 }
 
 func newCommitIter(
@@ -213,6 +282,7 @@ func newCommitIter(
 		skipGitErrors: skipGitErrors,
 		refs:          refs,
 		repo:          repo,
+		nodes:         repo.CommitNodes(),
 		seen:          make(map[plumbing.Hash]struct{}),
 	}, nil
 }
@@ -266,14 +336,52 @@ func (i *commitIter) Next() (*object.Commit, error) {
 				continue
 			}
 
-			hash := i.queue[0]
+			queued := i.queue[0]
 			i.queue = i.queue[1:]
-			if _, ok := i.seen[hash]; ok {
+			if !queued.old {
+				i.recent--
+			}
+
+			if queued.old && i.recent == 0 {
+				// Every commit left in the history of the reference was
+				// committed before the range, and so are their parents
+				// within the slack.
+				i.queue = nil
+				i.ref = nil
 				continue
 			}
-			i.seen[hash] = struct{}{}
 
-			commit, err = i.repo.CommitObject(hash)
+			if _, ok := i.seen[queued.hash]; ok {
+				continue
+			}
+			i.seen[queued.hash] = struct{}{}
+
+			node, err := i.nodes.Get(queued.hash)
+			if err != nil {
+				if i.skipGitErrors {
+					continue
+				}
+
+				return nil, err
+			}
+
+			// parents are queued before decoding the commit, so the rest of
+			// the history is still walked if it can't be decoded
+			i.enqueue(node.ParentHashes())
+			if !i.committerWhen.contains(node.CommitTime()) {
+				continue
+			}
+
+			commit, err = node.Commit()
+			if err != nil {
+				if i.skipGitErrors {
+					continue
+				}
+
+				return nil, err
+			}
+
+			return commit, nil
 		}
 
 		if err != nil {
@@ -284,12 +392,36 @@ func (i *commitIter) Next() (*object.Commit, error) {
 			return nil, err
 		}
 
-		i.queue = append(i.queue, commit.ParentHashes...)
+		i.enqueue(commit.ParentHashes)
+		if !i.committerWhen.contains(commit.Committer.When) {
+			continue
+		}
 
 		return commit, nil
 	}
 }
 
+// enqueue adds the given commits to the queue of commits to walk. If the
+// walk can stop, their commit time is read to know whether they were
+// committed before the lower bound of stopBefore, which doesn't decode them
+// with a commit-graph.
+func (i *commitIter) enqueue(hashes []plumbing.Hash) {
+	for _, h := range hashes {
+		var old bool
+		if !i.stopBefore.from.IsZero() {
+			if node, err := i.nodes.Get(h); err == nil {
+				old = i.stopBefore.before(node.CommitTime())
+			}
+		}
+
+		if !old {
+			i.recent++
+		}
+
+		i.queue = append(i.queue, queuedCommit{hash: h, old: old})
+	}
+}
+
 func (i *commitIter) Close() {
 	if i.refs != nil {
 		i.refs.Close()
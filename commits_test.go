@@ -2,10 +2,12 @@ package gitbase
 
 import (
 	"testing"
+	"time"
 
 	"github.com/src-d/go-mysql-server/sql"
 	"github.com/src-d/go-mysql-server/sql/expression"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
 )
 
 func TestCommitsTable(t *testing.T) {
@@ -83,6 +85,111 @@ func TestCommitsPushdown(t *testing.T) {
 	rows, err = tableToRows(ctx, t4)
 	require.NoError(err)
 	require.Len(rows, 1)
+
+	t5 := table.WithFilters([]sql.Expression{
+		expression.NewGreaterThanOrEqual(
+			expression.NewGetFieldWithTable(7, sql.Timestamp, CommitsTableName, "committer_when", false),
+			expression.NewLiteral("2015-04-05", sql.Text),
+		),
+	})
+
+	rows, err = tableToRows(ctx, t5)
+	require.NoError(err)
+	require.Len(rows, 1)
+	require.Equal("6ecf0ef2c2dffb796033e5a02219af86ec6584e5", rows[0][1])
+
+	t6 := table.WithFilters([]sql.Expression{
+		expression.NewBetween(
+			expression.NewGetFieldWithTable(4, sql.Timestamp, CommitsTableName, "commit_author_when", false),
+			expression.NewLiteral("2015-01-01", sql.Text),
+			expression.NewLiteral("2015-03-01", sql.Text),
+		),
+	})
+
+	rows, err = tableToRows(ctx, t6)
+	require.NoError(err)
+	require.Len(rows, 0)
+}
+
+func TestCommitIterStopsBeforeRange(t *testing.T) {
+	require := require.New(t)
+	ctx, _, cleanup := setup(t)
+	defer cleanup()
+
+	session, err := getSession(ctx)
+	require.NoError(err)
+
+	walk := func(from time.Time) (commits int, objects int64) {
+		iter, err := session.Pool.RepoIter()
+		require.NoError(err)
+		repo, err := iter.Next()
+		require.NoError(err)
+		require.NoError(iter.Close())
+
+		ci, err := newCommitIter(countObjects(repo, &objects), false)
+		require.NoError(err)
+		ci.committerWhen = timeRange{from: from}
+		ci.stopBefore = timeRange{from: from}
+
+		require.NoError(ci.ForEach(func(*object.Commit) error {
+			commits++
+			return nil
+		}))
+		return commits, objects
+	}
+
+	all, allObjects := walk(time.Time{})
+	require.Equal(9, all)
+
+	// only the last commit was committed after this date, minus the slack
+	commits, objects := walk(time.Date(2015, time.April, 4, 0, 0, 0, 0, time.UTC))
+	require.Equal(1, commits)
+	require.True(objects < allObjects, "%d objects read, %d without range", objects, allObjects)
+}
+
+func TestCommitsCommittedBeforeParents(t *testing.T) {
+	require := require.New(t)
+
+	// c was committed before its parent b, which is in the range
+	ctx, hashes, cleanup := setupHistory(t, []testCommit{
+		{"a", nil, time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"b", []string{"a"}, time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)},
+		{"c", []string{"b"}, time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"d", []string{"c"}, time.Date(2018, time.February, 1, 0, 0, 0, 0, time.UTC)},
+	}, "d")
+	defer cleanup()
+
+	session, err := getSession(ctx)
+	require.NoError(err)
+
+	read := func() []string {
+		table := newCommitsTable(session.Pool).WithFilters([]sql.Expression{
+			expression.NewGreaterThanOrEqual(
+				expression.NewGetFieldWithTable(7, sql.Timestamp, CommitsTableName, "committer_when", false),
+				expression.NewLiteral(time.Date(2019, time.May, 1, 0, 0, 0, 0, time.UTC), sql.Timestamp),
+			),
+		})
+
+		rows, err := tableToRows(ctx, table)
+		require.NoError(err)
+
+		var result []string
+		for _, row := range rows {
+			result = append(result, row[1].(string))
+		}
+		return result
+	}
+
+	// the whole history is walked by default
+	require.Equal([]string{hashes["b"].String()}, read())
+
+	// and with a slack longer than the time c was committed before b
+	session.Set(CommitWalkSlackVar, sql.Int64, int64(2*365*24*60*60))
+	require.Equal([]string{hashes["b"].String()}, read())
+
+	// a shorter slack stops the walk at c
+	session.Set(CommitWalkSlackVar, sql.Int64, int64(60*60))
+	require.Empty(read())
 }
 
 type commitAndParents struct {
@@ -3,11 +3,13 @@ package gitbase
 import (
 	"context"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/src-d/go-borges"
 	"github.com/src-d/go-borges/libraries"
@@ -20,7 +22,10 @@ import (
 	"gopkg.in/src-d/go-billy.v4"
 	"gopkg.in/src-d/go-billy.v4/memfs"
 	"gopkg.in/src-d/go-billy.v4/osfs"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
 )
 
 type CleanupFunc func()
@@ -76,6 +81,76 @@ func buildSession(
 	return ctx, paths, cleanup
 }
 
+// testCommit is a commit of the repositories built by setupHistory.
+type testCommit struct {
+	name    string
+	parents []string
+	when    time.Time
+}
+
+// setupHistory returns a context with a repository whose commits have the
+// given parents and committer dates, and the hashes of the commits by name.
+// Commits are created in order, so parents must go before their children,
+// and there is a branch with the name of each commit given in refs. The
+// returned function removes the repository.
+func setupHistory(
+	t *testing.T,
+	commits []testCommit,
+	refs ...string,
+) (*sql.Context, map[string]plumbing.Hash, CleanupFunc) {
+	require := require.New(t)
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "gitbase-history")
+	require.NoError(err)
+	cleanup := func() { os.RemoveAll(dir) }
+
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(err)
+
+	store := func(o interface {
+		Encode(plumbing.EncodedObject) error
+	}) plumbing.Hash {
+		obj := repo.Storer.NewEncodedObject()
+		require.NoError(o.Encode(obj))
+		h, err := repo.Storer.SetEncodedObject(obj)
+		require.NoError(err)
+		return h
+	}
+
+	tree := store(&object.Tree{})
+	hashes := make(map[string]plumbing.Hash, len(commits))
+	for _, c := range commits {
+		sig := object.Signature{Name: "gitbase", Email: "gitbase@src-d.com", When: c.when}
+		commit := &object.Commit{
+			Author:    sig,
+			Committer: sig,
+			Message:   c.name,
+			TreeHash:  tree,
+		}
+
+		for _, p := range c.parents {
+			commit.ParentHashes = append(commit.ParentHashes, hashes[p])
+		}
+
+		hashes[c.name] = store(commit)
+	}
+
+	for _, name := range refs {
+		require.NoError(repo.Storer.SetReference(plumbing.NewHashReference(
+			plumbing.NewBranchReferenceName(name),
+			hashes[name],
+		)))
+	}
+
+	lib, pool, err := newMultiPool()
+	require.NoError(err)
+	require.NoError(lib.AddPlain("history", dir, nil))
+
+	session := NewSession(pool)
+	return sql.NewContext(context.TODO(), sql.WithSession(session)), hashes, cleanup
+}
+
 func tableToRows(ctx *sql.Context, t sql.Table) ([]sql.Row, error) {
 	return sql.NodeToRows(ctx, plan.NewResolvedTable(t))
 }
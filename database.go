@@ -1,7 +1,11 @@
 package gitbase
 
 import (
+	"sort"
+	"sync"
+
 	"github.com/src-d/go-mysql-server/sql"
+	errors "gopkg.in/src-d/go-errors.v1"
 )
 
 const (
@@ -43,8 +47,15 @@ type Database struct {
 	commitBlobs  sql.Table
 	commitFiles  sql.Table
 	files        sql.Table
+
+	viewsMu sync.RWMutex
+	views   map[string]*MaterializedView
 }
 
+// ErrTableExists is returned when a materialized view is added with the name
+// of an existing table.
+var ErrTableExists = errors.NewKind("table %s already exists")
+
 // NewDatabase creates a new Database structure and initializes its
 // tables with the given pool
 func NewDatabase(name string, pool *RepositoryPool) sql.Database {
@@ -61,6 +72,7 @@ func NewDatabase(name string, pool *RepositoryPool) sql.Database {
 		commitBlobs:  newCommitBlobsTable(pool),
 		commitFiles:  newCommitFilesTable(pool),
 		files:        newFilesTable(pool),
+		views:        make(map[string]*MaterializedView),
 	}
 }
 
@@ -69,9 +81,10 @@ func (d *Database) Name() string {
 	return d.name
 }
 
-// Tables returns a map with all initialized tables
+// Tables returns a map with all initialized tables and the materialized
+// views.
 func (d *Database) Tables() map[string]sql.Table {
-	return map[string]sql.Table{
+	tables := map[string]sql.Table{
 		CommitsTableName:      d.commits,
 		ReferencesTableName:   d.references,
 		BlobsTableName:        d.blobs,
@@ -84,4 +97,58 @@ func (d *Database) Tables() map[string]sql.Table {
 		CommitFilesTableName:  d.commitFiles,
 		FilesTableName:        d.files,
 	}
+
+	d.viewsMu.RLock()
+	for name, v := range d.views {
+		tables[name] = v
+	}
+	d.viewsMu.RUnlock()
+
+	return tables
+}
+
+// AddMaterializedView adds a materialized view to the tables of the
+// database.
+func (d *Database) AddMaterializedView(v *MaterializedView) error {
+	if _, ok := d.Tables()[v.Name()]; ok {
+		return ErrTableExists.New(v.Name())
+	}
+
+	d.viewsMu.Lock()
+	defer d.viewsMu.Unlock()
+	d.views[v.Name()] = v
+	return nil
+}
+
+// MaterializedView returns the materialized view with the given name.
+func (d *Database) MaterializedView(name string) (*MaterializedView, bool) {
+	d.viewsMu.RLock()
+	defer d.viewsMu.RUnlock()
+	v, ok := d.views[name]
+	return v, ok
+}
+
+// MaterializedViews returns the materialized views of the database.
+func (d *Database) MaterializedViews() []*MaterializedView {
+	d.viewsMu.RLock()
+	defer d.viewsMu.RUnlock()
+
+	views := make([]*MaterializedView, 0, len(d.views))
+	for _, v := range d.views {
+		views = append(views, v)
+	}
+
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].Name() < views[j].Name()
+	})
+
+	return views
+}
+
+// RemoveMaterializedView removes the materialized view with the given name
+// from the tables of the database.
+func (d *Database) RemoveMaterializedView(name string) {
+	d.viewsMu.Lock()
+	defer d.viewsMu.Unlock()
+	delete(d.views, name)
 }
@@ -106,6 +106,12 @@ func (r *filesTable) PartitionRows(
 				return nil, err
 			}
 
+			var pathPatterns []textPattern
+			pathPatterns, err = selectors.textPatterns("file_path")
+			if err != nil {
+				return nil, err
+			}
+
 			if r.index != nil {
 				var values sql.IndexValueIter
 				values, err = r.index.Values(p)
@@ -134,6 +140,7 @@ func (r *filesTable) PartitionRows(
 				treeHashes:    stringsToHashes(treeHashes),
 				blobHashes:    stringsToHashes(blobHashes),
 				filePaths:     filePaths,
+				pathPatterns:  pathPatterns,
 				readContent:   shouldReadContent(r.projection),
 				skipGitErrors: shouldSkipErrors(ctx),
 			}, nil
@@ -145,7 +152,7 @@ func (r *filesTable) PartitionRows(
 		return nil, errorWithRepo(repo, err)
 	}
 
-	return sql.NewSpanIter(span, newRepoRowIter(repo, iter)), nil
+	return sql.NewSpanIter(span, newRepoRowIter(ctx, repo, iter)), nil
 }
 
 func (filesTable) HandledFilters(filters []sql.Expression) []sql.Expression {
@@ -190,9 +197,10 @@ type filesRowIter struct {
 	skipGitErrors bool
 
 	// selectors for faster filtering
-	filePaths  []string
-	blobHashes []plumbing.Hash
-	treeHashes []plumbing.Hash
+	filePaths    []string
+	pathPatterns []textPattern
+	blobHashes   []plumbing.Hash
+	treeHashes   []plumbing.Hash
 }
 
 func (i *filesRowIter) init() error {
@@ -219,6 +227,10 @@ func (i *filesRowIter) shouldVisitFile(file *object.File) bool {
 		return false
 	}
 
+	if !matchesPatterns(i.pathPatterns, file.Name) {
+		return false
+	}
+
 	if len(i.blobHashes) > 0 && !hashContains(i.blobHashes, file.Blob.Hash) {
 		return false
 	}
@@ -132,6 +132,22 @@ func TestFilesTablePushdownFilters(t *testing.T) {
 				{"LICENSE", "c192bd6a24ea1ab01d78686e417c8bdc7c3d197f", "c2d30fa8ef288618f65f6eed6e168e0d514886f4", "0100644"},
 			},
 		},
+		{
+			"file_path pattern filter",
+			[]sql.Expression{
+				expression.NewLike(
+					expression.NewGetFieldWithTable(1, sql.Text, FilesTableName, "file_path", false),
+					expression.NewLiteral("LICENS_", sql.Text),
+				),
+				expression.NewEquals(
+					expression.NewGetFieldWithTable(0, sql.Text, FilesTableName, "tree_hash", false),
+					expression.NewLiteral("aa9b383c260e1d05fbbf6b30a02914555e20c725", sql.Text),
+				),
+			},
+			[]sql.Row{
+				{"LICENSE", "c192bd6a24ea1ab01d78686e417c8bdc7c3d197f", "aa9b383c260e1d05fbbf6b30a02914555e20c725", "0100644"},
+			},
+		},
 	}
 
 	for _, tt := range testCases {
@@ -2,6 +2,9 @@ package gitbase
 
 import (
 	"reflect"
+	"regexp"
+	"strings"
+	"time"
 
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"github.com/src-d/go-mysql-server/sql"
@@ -22,13 +25,53 @@ type selector []interface{}
 // retrieved, all the selectors must match.
 // Let's say one selector is [1, 2] and another is [3, 4]. 1 or 2 can't be 3 or
 // 4, so the result will always be zero rows.
+// Besides the values, a column can have range and pattern selectors, which
+// are only hints to skip rows early. The filters they come from are still
+// applied to the rows.
 type selectors map[string][]selector
 
+// valueRange is a selector value with the bounds of the comparisons of a
+// field with literals. Nil bounds mean the range is unbounded.
+type valueRange struct {
+	lower interface{}
+	upper interface{}
+}
+
+// likePattern is a selector value with the pattern of a LIKE comparison of
+// a field with a literal.
+type likePattern string
+
+// isHint returns whether the selector is a range or a pattern.
+func (sel selector) isHint() bool {
+	if len(sel) != 1 {
+		return false
+	}
+
+	switch sel[0].(type) {
+	case valueRange, likePattern:
+		return true
+	default:
+		return false
+	}
+}
+
+// values returns the selectors of values for the given key, that is, all
+// but the range and pattern selectors.
+func (s selectors) values(key string) []selector {
+	var result []selector
+	for _, sel := range s[key] {
+		if !sel.isHint() {
+			result = append(result, sel)
+		}
+	}
+	return result
+}
+
 // isValid returns whether the list of selectors for the given key is valid.
 // A list of selectors is not valid when its length is bigger than one and all
 // the elements are not equal.
 func (s selectors) isValid(key string) bool {
-	vals := s[key]
+	vals := s.values(key)
 	if len(vals) > 1 {
 		first := vals[0]
 		for _, sel := range vals[1:] {
@@ -43,7 +86,7 @@ func (s selectors) isValid(key string) bool {
 // textValues returns all values associated to the given key as strings.
 // If the selector list is not valid, an empty slice will be returned.
 func (s selectors) textValues(key string) ([]string, error) {
-	vals := s[key]
+	vals := s.values(key)
 	if len(vals) == 0 {
 		return nil, nil
 	}
@@ -66,6 +109,354 @@ func (s selectors) textValues(key string) ([]string, error) {
 	return result, nil
 }
 
+// int64Values returns all values associated to the given key as int64.
+// If the selector list is not valid, an empty slice will be returned.
+func (s selectors) int64Values(key string) ([]int64, error) {
+	vals := s.values(key)
+	if len(vals) == 0 {
+		return nil, nil
+	}
+
+	if !s.isValid(key) {
+		return nil, nil
+	}
+
+	var result = make([]int64, len(vals[0]))
+
+	for i, v := range vals[0] {
+		val, err := sql.Int64.Convert(v)
+		if err != nil {
+			return nil, err
+		}
+
+		result[i] = val.(int64)
+	}
+
+	return result, nil
+}
+
+// int64UpperBound returns the lowest upper bound of the range selectors for
+// the given key as an integer, which is inclusive, and whether there is any.
+// Bounds that can't be converted to integers are ignored.
+func (s selectors) int64UpperBound(key string) (int64, bool) {
+	var result int64
+	var found bool
+	for _, sel := range s[key] {
+		r, ok := sel[0].(valueRange)
+		if !sel.isHint() || !ok || r.upper == nil {
+			continue
+		}
+
+		v, err := sql.Int64.Convert(r.upper)
+		if err != nil {
+			continue
+		}
+
+		if bound := v.(int64); !found || bound < result {
+			result, found = bound, true
+		}
+	}
+
+	return result, found
+}
+
+// timeRangeSlack is added to the bounds of time ranges. Timestamps compared
+// with text literals are compared as text in their own time zone, which can
+// be up to 14 hours away from UTC, and dates without time are shorter than
+// the timestamps they are compared with.
+const timeRangeSlack = 24 * time.Hour
+
+// timeRange is a range of times. Zero bounds mean the range is unbounded.
+type timeRange struct {
+	from time.Time
+	to   time.Time
+}
+
+// contains returns whether the range contains the given time. Times whose
+// year has not four digits are always contained, because they are not
+// ordered the same way as text.
+func (r timeRange) contains(t time.Time) bool {
+	if t.Year() < 1000 || t.Year() > 9999 {
+		return true
+	}
+
+	if !r.from.IsZero() && t.Before(r.from) {
+		return false
+	}
+
+	if !r.to.IsZero() && t.After(r.to) {
+		return false
+	}
+
+	return true
+}
+
+// before returns whether the given time is before the lower bound of the
+// range. Like in contains, times whose year has not four digits are never
+// before it.
+func (r timeRange) before(t time.Time) bool {
+	if r.from.IsZero() || t.Year() < 1000 || t.Year() > 9999 {
+		return false
+	}
+
+	return t.Before(r.from)
+}
+
+// timeBound converts a literal compared with a timestamp to a time. Only
+// times and text in the timestamp or date formats are converted, so they
+// are ordered as text the same way as times.
+func timeBound(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range []string{sql.TimestampLayout, "2006-01-02"} {
+			t, err := time.Parse(layout, v)
+			if err == nil && t.Format(layout) == v {
+				return t, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// timeRange returns the intersection of the range selectors for the given
+// key as times, with some slack. Bounds that can't be converted to times are
+// ignored.
+func (s selectors) timeRange(key string) timeRange {
+	var result timeRange
+	for _, sel := range s[key] {
+		r, ok := sel[0].(valueRange)
+		if !sel.isHint() || !ok {
+			continue
+		}
+
+		if t, ok := timeBound(r.lower); ok {
+			t = t.Add(-timeRangeSlack)
+			if result.from.IsZero() || t.After(result.from) {
+				result.from = t
+			}
+		}
+
+		if t, ok := timeBound(r.upper); ok {
+			t = t.Add(timeRangeSlack)
+			if result.to.IsZero() || t.Before(result.to) {
+				result.to = t
+			}
+		}
+	}
+
+	return result
+}
+
+// textPattern is a LIKE pattern with its literal prefix, which is checked
+// before the whole pattern.
+type textPattern struct {
+	// prefix is the text before the first wildcard of the pattern.
+	prefix string
+	// exact is true if the pattern has no wildcards, so it only matches
+	// its prefix.
+	exact bool
+	// re is the pattern as a regular expression, or nil if checking the
+	// prefix is enough.
+	re *regexp.Regexp
+}
+
+// match returns whether the text matches the pattern.
+func (p textPattern) match(text string) bool {
+	if p.exact {
+		return text == p.prefix
+	}
+
+	if !strings.HasPrefix(text, p.prefix) {
+		return false
+	}
+
+	return p.re == nil || p.re.MatchString(text)
+}
+
+// newTextPattern returns the text pattern of the given LIKE pattern without
+// escaped characters.
+func newTextPattern(pattern string) (textPattern, error) {
+	i := strings.IndexAny(pattern, "%_")
+	if i < 0 {
+		return textPattern{prefix: pattern, exact: true}, nil
+	}
+
+	p := textPattern{prefix: pattern[:i]}
+	if strings.Trim(pattern[i:], "%") == "" {
+		return p, nil
+	}
+
+	re, err := regexp.Compile(likeToRegexp(pattern))
+	if err != nil {
+		return textPattern{}, err
+	}
+
+	p.re = re
+	return p, nil
+}
+
+// textPatterns returns the pattern selectors for the given key.
+func (s selectors) textPatterns(key string) ([]textPattern, error) {
+	var result []textPattern
+	for _, sel := range s[key] {
+		p, ok := sel[0].(likePattern)
+		if !sel.isHint() || !ok {
+			continue
+		}
+
+		tp, err := newTextPattern(string(p))
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, tp)
+	}
+
+	return result, nil
+}
+
+// likeToRegexp converts a LIKE pattern without escaped characters to a
+// regular expression matching the same text.
+func likeToRegexp(pattern string) string {
+	var buf strings.Builder
+	buf.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			buf.WriteString(".*")
+		case '_':
+			buf.WriteString(".")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteString("$")
+	return buf.String()
+}
+
+// matchesPatterns returns whether the text matches all the given patterns.
+func matchesPatterns(patterns []textPattern, text string) bool {
+	for _, p := range patterns {
+		if !p.match(text) {
+			return false
+		}
+	}
+	return true
+}
+
+// patternsPrefix returns the longest literal prefix of the given patterns,
+// which all the texts matching them have, and whether one of them is exact,
+// in which case only that prefix can match them.
+func patternsPrefix(patterns []textPattern) (prefix string, exact bool) {
+	for _, p := range patterns {
+		if p.exact {
+			return p.prefix, true
+		}
+
+		if len(p.prefix) > len(prefix) {
+			prefix = p.prefix
+		}
+	}
+
+	return prefix, false
+}
+
+// fieldAndLiteral returns the field and the value of the literal of a
+// binary expression comparing a field of the given table with a literal.
+// The flipped result is true if the literal is on the left side.
+func fieldAndLiteral(
+	schema sql.Schema,
+	tableName string,
+	left, right sql.Expression,
+) (field string, value interface{}, flipped bool, ok bool) {
+	if lit, isLit := left.(*expression.Literal); isLit {
+		left, right = right, lit
+		flipped = true
+	}
+
+	gf, isField := left.(*expression.GetField)
+	lit, isLit := right.(*expression.Literal)
+	if !isField || !isLit || gf.Table() != tableName || !schema.Contains(gf.Name(), tableName) {
+		return "", nil, false, false
+	}
+
+	value, err := lit.Eval(nil, nil)
+	if err != nil || value == nil {
+		return "", nil, false, false
+	}
+
+	return gf.Name(), value, flipped, true
+}
+
+// getRange returns the field and range of the given comparison or between
+// expression, if it compares a field of the table with literals.
+func getRange(schema sql.Schema, tableName string, e sql.Expression) (string, valueRange, bool) {
+	if b, ok := e.(*expression.Between); ok {
+		field, lower, _, ok := fieldAndLiteral(schema, tableName, b.Val, b.Lower)
+		if !ok {
+			return "", valueRange{}, false
+		}
+
+		_, upper, _, ok := fieldAndLiteral(schema, tableName, b.Val, b.Upper)
+		if !ok {
+			return "", valueRange{}, false
+		}
+
+		return field, valueRange{lower, upper}, true
+	}
+
+	cmp, ok := e.(expression.Comparer)
+	if !ok {
+		return "", valueRange{}, false
+	}
+
+	field, value, flipped, ok := fieldAndLiteral(schema, tableName, cmp.Left(), cmp.Right())
+	if !ok {
+		return "", valueRange{}, false
+	}
+
+	var isLower bool
+	switch e.(type) {
+	case *expression.GreaterThan, *expression.GreaterThanOrEqual:
+		isLower = !flipped
+	case *expression.LessThan, *expression.LessThanOrEqual:
+		isLower = flipped
+	default:
+		return "", valueRange{}, false
+	}
+
+	if isLower {
+		return field, valueRange{lower: value}, true
+	}
+
+	return field, valueRange{upper: value}, true
+}
+
+// getLikePattern returns the field and pattern of the given LIKE expression,
+// if it compares a field of the table with a literal.
+func getLikePattern(schema sql.Schema, tableName string, like *expression.Like) (string, likePattern, bool) {
+	gf, ok := like.Left.(*expression.GetField)
+	if !ok {
+		return "", "", false
+	}
+
+	field, value, flipped, ok := fieldAndLiteral(schema, tableName, gf, like.Right)
+	if !ok || flipped {
+		return "", "", false
+	}
+
+	// Patterns with escaped characters are left to the filter.
+	pattern, ok := value.(string)
+	if !ok || strings.Contains(pattern, "\\") {
+		return "", "", false
+	}
+
+	return field, likePattern(pattern), true
+}
+
 // canHandleEquals returns whether the given equals expression can be handled
 // as a selector. For that to happen one of the sides must be a GetField expr
 // that exists in the given schema and the other must be a literal.
@@ -189,7 +580,9 @@ func handledFilters(
 // classifyFilters classifies the given filters (only handled filters) and
 // splits them into selectors and filters. Selectors will be all filters
 // that are comparing a field to a literal and are present in handledCols.
-// Filters will be all the remaining expressions.
+// Filters will be all the remaining expressions. Ranges and LIKE patterns
+// comparing any field to literals are also added as selectors, but they are
+// kept as filters too.
 func classifyFilters(
 	schema sql.Schema,
 	table string,
@@ -224,6 +617,18 @@ func classifyFilters(
 					continue
 				}
 			}
+		case *expression.GreaterThan,
+			*expression.GreaterThanOrEqual,
+			*expression.LessThan,
+			*expression.LessThanOrEqual,
+			*expression.Between:
+			if field, r, ok := getRange(schema, table, f); ok {
+				selectors[field] = append(selectors[field], selector{r})
+			}
+		case *expression.Like:
+			if field, p, ok := getLikePattern(schema, table, f); ok {
+				selectors[field] = append(selectors[field], selector{p})
+			}
 		}
 		conditions = append(conditions, f)
 	}
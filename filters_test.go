@@ -1,7 +1,9 @@
 package gitbase
 
 import (
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/src-d/go-mysql-server/sql"
@@ -330,9 +332,147 @@ func TestClassifyFilters(t *testing.T) {
 	require.Equal(selectors{
 		"a": []selector{
 			selector{1},
+			selector{valueRange{lower: 0}},
 			selector{5, 6, 7},
 		},
 	}, sels)
 
 	require.Equal(notSelectors, f)
 }
+
+func TestClassifyFiltersHints(t *testing.T) {
+	require := require.New(t)
+	schema := sql.Schema{
+		{Name: "a", Source: "foo"},
+		{Name: "b", Source: "foo"},
+	}
+
+	a := expression.NewGetFieldWithTable(0, sql.Timestamp, "foo", "a", false)
+	b := expression.NewGetFieldWithTable(1, sql.Text, "foo", "b", false)
+
+	filters := []sql.Expression{
+		expression.NewGreaterThan(a, expression.NewLiteral("2019-01-01", sql.Text)),
+		expression.NewGreaterThanOrEqual(expression.NewLiteral("2019-06-01", sql.Text), a),
+		expression.NewLike(b, expression.NewLiteral("refs/heads/release-%", sql.Text)),
+		expression.NewLike(b, expression.NewLiteral(`refs/heads/foo\_%`, sql.Text)),
+		expression.NewBetween(
+			b,
+			expression.NewLiteral("a", sql.Text),
+			expression.NewLiteral("z", sql.Text),
+		),
+	}
+
+	sels, f, err := classifyFilters(schema, "foo", filters)
+	require.NoError(err)
+	require.Equal(filters, f)
+	require.Equal(selectors{
+		"a": []selector{
+			selector{valueRange{lower: "2019-01-01"}},
+			selector{valueRange{upper: "2019-06-01"}},
+		},
+		"b": []selector{
+			selector{likePattern("refs/heads/release-%")},
+			selector{valueRange{"a", "z"}},
+		},
+	}, sels)
+
+	vals, err := sels.textValues("b")
+	require.NoError(err)
+	require.Nil(vals)
+
+	r := sels.timeRange("a")
+	require.False(r.contains(time.Date(2018, time.December, 30, 0, 0, 0, 0, time.UTC)))
+	require.True(r.contains(time.Date(2018, time.December, 31, 12, 0, 0, 0, time.UTC)))
+	require.True(r.contains(time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC)))
+	require.False(r.contains(time.Date(2019, time.June, 3, 0, 0, 0, 0, time.UTC)))
+	require.True(r.contains(time.Date(10000, time.June, 3, 0, 0, 0, 0, time.UTC)))
+
+	patterns, err := sels.textPatterns("b")
+	require.NoError(err)
+	require.True(matchesPatterns(patterns, "refs/heads/release-1.0"))
+	require.False(matchesPatterns(patterns, "refs/heads/master"))
+	require.False(matchesPatterns(patterns, "refs/heads/Release-1.0"))
+}
+
+func TestTextPatterns(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		prefix  string
+		exact   bool
+		re      bool
+	}{
+		{"refs/heads/master", "refs/heads/master", true, false},
+		{"refs/heads/%", "refs/heads/", false, false},
+		{"refs/heads/%%", "refs/heads/", false, false},
+		{"refs/heads/%-1._", "refs/heads/", false, true},
+		{"%.go", "", false, true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.pattern, func(t *testing.T) {
+			require := require.New(t)
+			p, err := newTextPattern(tt.pattern)
+			require.NoError(err)
+			require.Equal(tt.prefix, p.prefix)
+			require.Equal(tt.exact, p.exact)
+			require.Equal(tt.re, p.re != nil)
+		})
+	}
+
+	p, err := newTextPattern("refs/heads/%")
+	require.NoError(t, err)
+	require.True(t, p.match("refs/heads/master"))
+	require.False(t, p.match("refs/tags/v1"))
+
+	sels := selectors{"a": []selector{
+		selector{likePattern("refs/%")},
+		selector{likePattern("refs/heads/%")},
+	}}
+	patterns, err := sels.textPatterns("a")
+	require.NoError(t, err)
+	prefix, exact := patternsPrefix(patterns)
+	require.Equal(t, "refs/heads/", prefix)
+	require.False(t, exact)
+
+	sels["a"] = append(sels["a"], selector{likePattern("refs/heads/master")})
+	patterns, err = sels.textPatterns("a")
+	require.NoError(t, err)
+	prefix, exact = patternsPrefix(patterns)
+	require.Equal(t, "refs/heads/master", prefix)
+	require.True(t, exact)
+}
+
+func TestTimeRangeNonCanonical(t *testing.T) {
+	require := require.New(t)
+
+	sels := selectors{
+		"a": []selector{selector{valueRange{lower: "2019-1-1", upper: 5}}},
+	}
+
+	require.Equal(timeRange{}, sels.timeRange("a"))
+}
+
+func TestLikeToRegexp(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		text    string
+		matches bool
+	}{
+		{"refs/heads/%", "refs/heads/master", true},
+		{"refs/heads/%", "refs/tags/v1", false},
+		{"%.go", "cmd/main.go", true},
+		{"%.go", "cmd/main.gox", false},
+		{"%.go", "cmd/mainxgo", false},
+		{"v_._", "v1.0", true},
+		{"v_._", "v1.10", false},
+		{"a(b)+", "a(b)+", true},
+		{"%", "multi\nline", true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.pattern+"/"+tt.text, func(t *testing.T) {
+			re := regexp.MustCompile(likeToRegexp(tt.pattern))
+			require.Equal(t, tt.matches, re.MatchString(tt.text))
+		})
+	}
+}
@@ -3,15 +3,20 @@ module github.com/src-d/gitbase
 go 1.12
 
 require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/apache/arrow/go/arrow v0.0.0-20200601151325-b2287a20f230
 	github.com/bblfsh/go-client/v4 v4.1.0
 	github.com/bblfsh/sdk/v3 v3.2.2
 	github.com/go-kit/kit v0.8.0
 	github.com/go-sql-driver/mysql v1.4.1
+	github.com/google/go-cmp v0.4.0 // indirect
 	github.com/gorilla/handlers v1.4.0 // indirect
 	github.com/hhatto/gocloc v0.3.0
 	github.com/jessevdk/go-flags v1.4.0
+	github.com/mattn/go-sqlite3 v1.11.0
 	github.com/miekg/dns v1.1.1 // indirect
 	github.com/opentracing/opentracing-go v1.1.0
+	github.com/peterh/liner v1.1.0
 	github.com/prometheus/client_golang v1.0.0
 	github.com/sirupsen/logrus v1.4.2
 	github.com/src-d/enry/v2 v2.0.0
@@ -313,6 +313,18 @@ func TestIntegration(t *testing.T) {
 				{"6ecf0ef2c2dffb796033e5a02219af86ec6584e5", "vendor stuff\n", "vendor", "a8d315b2b1c615d43042c3a62402b8a54288cf5c"},
 			},
 		},
+		{
+			`SELECT commit_hash, history_index
+			FROM ref_commits
+			WHERE ref_name = 'HEAD' AND repository_id = 'worktree'
+			ORDER BY history_index
+			LIMIT 3`,
+			[]sql.Row{
+				{"6ecf0ef2c2dffb796033e5a02219af86ec6584e5", int64(0)},
+				{"918c48b83bd081e863dbe1b80f8998f058cd8294", int64(1)},
+				{"af2d6a6954d532f8ffb47615169c8fdf9d383a1a", int64(2)},
+			},
+		},
 		{
 			`SELECT
 				file_path, array_length(uast_extract(uast(blob_content, language(file_path)), "@type"))
@@ -546,6 +558,8 @@ func TestIntegration(t *testing.T) {
 
 	a := analyzer.NewBuilder(engine.Catalog).
 		AddPostAnalyzeRule(rule.SquashJoinsRule, rule.SquashJoins).
+		AddPostAnalyzeRule(rule.LimitPushdownRule, rule.LimitPushdown).
+		AddPostAnalyzeRule(rule.PipelineUASTsRule, rule.PipelineUASTs).
 		Build()
 
 	engine.Analyzer = a
@@ -1153,6 +1167,8 @@ func newSquashEngine(pool *gitbase.RepositoryPool) *sqle.Engine {
 	engine := newBaseEngine(pool)
 	engine.Analyzer = analyzer.NewBuilder(engine.Catalog).
 		AddPostAnalyzeRule(rule.SquashJoinsRule, rule.SquashJoins).
+		AddPostAnalyzeRule(rule.LimitPushdownRule, rule.LimitPushdown).
+		AddPostAnalyzeRule(rule.PipelineUASTsRule, rule.PipelineUASTs).
 		Build()
 	return engine
 }
@@ -0,0 +1,516 @@
+// Package commitgraph reads and writes git commit-graph files, both single
+// files and split chains of them, as described in
+// https://github.com/git/git/blob/master/Documentation/technical/commit-graph-format.txt
+//
+// A Graph implements the commitgraph.Index interface of go-git, so it can be
+// used to walk the history of a repository reading the parents, generation
+// numbers and dates of the commits without decoding their objects.
+package commitgraph
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	billy "gopkg.in/src-d/go-billy.v4"
+	errors "gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/commitgraph"
+)
+
+var (
+	// ErrNotFound is returned by Open when the repository has no
+	// commit-graph.
+	ErrNotFound = errors.NewKind("commit-graph not found")
+	// ErrMalformed is returned when a commit-graph file is corrupt or not
+	// consistent with the rest of its chain.
+	ErrMalformed = errors.NewKind("malformed commit-graph file %s: %s")
+)
+
+// Paths of the commit-graph files, relative to the git directory.
+var (
+	FilePath  = path.Join("objects", "info", "commit-graph")
+	ChainDir  = path.Join("objects", "info", "commit-graphs")
+	ChainPath = path.Join(ChainDir, "commit-graph-chain")
+)
+
+var (
+	fileSignature      = []byte("CGPH")
+	oidFanoutChunk     = []byte("OIDF")
+	oidLookupChunk     = []byte("OIDL")
+	commitDataChunk    = []byte("CDAT")
+	extraEdgeListChunk = []byte("EDGE")
+	baseGraphsChunk    = []byte("BASE")
+)
+
+const (
+	fileVersion = 1
+	hashVersion = 1
+	hashSize    = 20
+
+	headerSize     = 8
+	chunkEntrySize = 12
+	fanoutSize     = 256 * 4
+	commitDataSize = hashSize + 16
+
+	parentNone        = uint32(0x70000000)
+	parentOctopusUsed = uint32(0x80000000)
+	parentOctopusMask = uint32(0x7fffffff)
+	parentLast        = uint32(0x80000000)
+
+	// maxGeneration is the greatest generation number that can be stored,
+	// commits with greater ones store this value.
+	maxGeneration = 0x3fffffff
+	dateMask      = 0x3ffffffff
+)
+
+// Graph is a commit-graph made of one or more layers. A single commit-graph
+// file is a graph with one layer. The positions of the commits are global to
+// the graph: the ones of each layer follow the ones of the layers below it.
+type Graph struct {
+	// layers are sorted from the base to the tip of the chain.
+	layers []*layer
+}
+
+// Open opens the commit-graph of the git directory in the given filesystem.
+// As git does, the single commit-graph file is used if it exists, and the
+// split chain otherwise. It returns an ErrNotFound error if there is none.
+func Open(fs billy.Filesystem) (*Graph, error) {
+	l, err := openLayer(fs, FilePath, 0)
+	if err == nil {
+		if len(l.bases) > 0 {
+			_ = l.close()
+			return nil, ErrMalformed.New(FilePath, "unexpected base graphs")
+		}
+
+		return &Graph{layers: []*layer{l}}, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	hashes, err := readChain(fs)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound.New()
+		}
+
+		return nil, err
+	}
+
+	g := &Graph{}
+	var offset int
+	for i, h := range hashes {
+		l, err := openLayer(fs, layerPath(h), offset)
+		if err != nil {
+			_ = g.Close()
+			return nil, err
+		}
+		g.layers = append(g.layers, l)
+
+		if len(l.bases) != i {
+			_ = g.Close()
+			return nil, ErrMalformed.New(l.path, "wrong number of base graphs")
+		}
+
+		for j, base := range l.bases {
+			if base != hashes[j] {
+				_ = g.Close()
+				return nil, ErrMalformed.New(l.path, "base graphs don't match the chain")
+			}
+		}
+
+		offset += l.count
+	}
+
+	if len(g.layers) == 0 {
+		return nil, ErrNotFound.New()
+	}
+
+	return g, nil
+}
+
+// readChain returns the hashes of the layers listed in the chain file.
+func readChain(fs billy.Filesystem) ([]plumbing.Hash, error) {
+	f, err := fs.Open(ChainPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hashes []plumbing.Hash
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if len(line) != 2*hashSize {
+			return nil, ErrMalformed.New(ChainPath, "invalid hash "+line)
+		}
+
+		hashes = append(hashes, plumbing.NewHash(line))
+	}
+
+	return hashes, scanner.Err()
+}
+
+func layerPath(h plumbing.Hash) string {
+	return path.Join(ChainDir, "graph-"+h.String()+".graph")
+}
+
+// Len returns the number of commits in the graph.
+func (g *Graph) Len() int {
+	last := g.layers[len(g.layers)-1]
+	return last.offset + last.count
+}
+
+// Layers returns the number of files the graph is made of.
+func (g *Graph) Layers() int {
+	return len(g.layers)
+}
+
+// Close closes the files of the graph. The graph can still be used after
+// closing it, the files are opened again when needed.
+func (g *Graph) Close() error {
+	var err error
+	for _, l := range g.layers {
+		if e := l.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// GetIndexByHash implements the commitgraph.Index interface. It returns the
+// position of the commit with the given hash in the graph.
+func (g *Graph) GetIndexByHash(h plumbing.Hash) (int, error) {
+	for i := len(g.layers) - 1; i >= 0; i-- {
+		l := g.layers[i]
+		pos, err := l.find(h)
+		if err != nil {
+			return 0, err
+		}
+
+		if pos >= 0 {
+			return l.offset + pos, nil
+		}
+	}
+
+	return 0, plumbing.ErrObjectNotFound
+}
+
+// GetCommitDataByIndex implements the commitgraph.Index interface. It
+// returns the data of the commit at the given position of the graph.
+func (g *Graph) GetCommitDataByIndex(pos int) (*commitgraph.CommitData, error) {
+	l, err := g.layerAt(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	local := pos - l.offset
+	var buf [commitDataSize]byte
+	if err := l.readAt(buf[:], l.commitData+int64(local)*commitDataSize); err != nil {
+		return nil, err
+	}
+
+	var tree plumbing.Hash
+	copy(tree[:], buf[:hashSize])
+	parent1 := binary.BigEndian.Uint32(buf[hashSize:])
+	parent2 := binary.BigEndian.Uint32(buf[hashSize+4:])
+	genAndTime := binary.BigEndian.Uint64(buf[hashSize+8:])
+
+	var parents []int
+	switch {
+	case parent1 == parentNone:
+	case parent2 == parentNone:
+		parents = []int{int(parent1)}
+	case parent2&parentOctopusUsed == 0:
+		parents = []int{int(parent1), int(parent2)}
+	default:
+		parents, err = l.extraEdges(int(parent1), parent2&parentOctopusMask)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var hashes []plumbing.Hash
+	if len(parents) > 0 {
+		hashes = make([]plumbing.Hash, len(parents))
+	}
+
+	for i, p := range parents {
+		// parents can only be in the same layer or below it
+		if p >= l.offset+l.count {
+			return nil, ErrMalformed.New(l.path, "parent out of range")
+		}
+
+		if hashes[i], err = g.hashAt(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return &commitgraph.CommitData{
+		TreeHash:      tree,
+		ParentIndexes: parents,
+		ParentHashes:  hashes,
+		Generation:    int(genAndTime >> 34),
+		When:          time.Unix(int64(genAndTime&dateMask), 0),
+	}, nil
+}
+
+// Hashes implements the commitgraph.Index interface. It returns the hashes
+// of all the commits in the graph, in the order of their positions.
+func (g *Graph) Hashes() []plumbing.Hash {
+	hashes := make([]plumbing.Hash, 0, g.Len())
+	for _, l := range g.layers {
+		buf := make([]byte, l.count*hashSize)
+		if err := l.readAt(buf, l.oidLookup); err != nil {
+			return nil
+		}
+
+		for i := 0; i < l.count; i++ {
+			var h plumbing.Hash
+			copy(h[:], buf[i*hashSize:])
+			hashes = append(hashes, h)
+		}
+	}
+
+	return hashes
+}
+
+func (g *Graph) layerAt(pos int) (*layer, error) {
+	for _, l := range g.layers {
+		if pos >= l.offset && pos < l.offset+l.count {
+			return l, nil
+		}
+	}
+
+	return nil, plumbing.ErrObjectNotFound
+}
+
+func (g *Graph) hashAt(pos int) (plumbing.Hash, error) {
+	var h plumbing.Hash
+	l, err := g.layerAt(pos)
+	if err != nil {
+		return h, err
+	}
+
+	err = l.readAt(h[:], l.oidLookup+int64(pos-l.offset)*hashSize)
+	return h, err
+}
+
+// layer is a single commit-graph file.
+type layer struct {
+	fs   billy.Filesystem
+	path string
+
+	mu   sync.Mutex
+	file billy.File
+
+	// offset is the position of the first commit of the layer in the graph.
+	offset int
+	count  int
+	fanout [256]uint32
+	bases  []plumbing.Hash
+
+	oidLookup  int64
+	commitData int64
+	edges      int64
+}
+
+func openLayer(fs billy.Filesystem, p string, offset int) (*layer, error) {
+	f, err := fs.Open(p)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &layer{fs: fs, path: p, file: f, offset: offset, edges: -1}
+	if err := l.readHeader(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *layer) readHeader() error {
+	var header [headerSize]byte
+	if err := l.readAt(header[:], 0); err != nil {
+		return ErrMalformed.New(l.path, err)
+	}
+
+	if !bytes.Equal(header[:4], fileSignature) {
+		return ErrMalformed.New(l.path, "invalid signature")
+	}
+
+	if header[4] != fileVersion {
+		return ErrMalformed.New(l.path, "unsupported version")
+	}
+
+	if header[5] != hashVersion {
+		return ErrMalformed.New(l.path, "unsupported hash version")
+	}
+
+	chunks := int(header[6])
+	table := make([]byte, (chunks+1)*chunkEntrySize)
+	if err := l.readAt(table, headerSize); err != nil {
+		return ErrMalformed.New(l.path, err)
+	}
+
+	fanout, bases := int64(-1), int64(-1)
+	l.oidLookup, l.commitData = -1, -1
+	for i := 0; i < chunks; i++ {
+		entry := table[i*chunkEntrySize:]
+		offset := int64(binary.BigEndian.Uint64(entry[4:]))
+		switch id := entry[:4]; {
+		case bytes.Equal(id, oidFanoutChunk):
+			fanout = offset
+		case bytes.Equal(id, oidLookupChunk):
+			l.oidLookup = offset
+		case bytes.Equal(id, commitDataChunk):
+			l.commitData = offset
+		case bytes.Equal(id, extraEdgeListChunk):
+			l.edges = offset
+		case bytes.Equal(id, baseGraphsChunk):
+			bases = offset
+		}
+	}
+
+	if fanout < 0 || l.oidLookup < 0 || l.commitData < 0 {
+		return ErrMalformed.New(l.path, "missing required chunks")
+	}
+
+	buf := make([]byte, fanoutSize)
+	if err := l.readAt(buf, fanout); err != nil {
+		return ErrMalformed.New(l.path, err)
+	}
+
+	for i := range l.fanout {
+		l.fanout[i] = binary.BigEndian.Uint32(buf[i*4:])
+		if i > 0 && l.fanout[i] < l.fanout[i-1] {
+			return ErrMalformed.New(l.path, "invalid fanout")
+		}
+	}
+	l.count = int(l.fanout[255])
+
+	if n := int(header[7]); n > 0 {
+		if bases < 0 {
+			return ErrMalformed.New(l.path, "missing base graphs chunk")
+		}
+
+		buf := make([]byte, n*hashSize)
+		if err := l.readAt(buf, bases); err != nil {
+			return ErrMalformed.New(l.path, err)
+		}
+
+		l.bases = make([]plumbing.Hash, n)
+		for i := range l.bases {
+			copy(l.bases[i][:], buf[i*hashSize:])
+		}
+	}
+
+	return nil
+}
+
+// find returns the position of the given hash in the layer, or -1 if it's
+// not in the layer.
+func (l *layer) find(h plumbing.Hash) (int, error) {
+	low := 0
+	if h[0] > 0 {
+		low = int(l.fanout[h[0]-1])
+	}
+	high := int(l.fanout[h[0]])
+
+	var candidate plumbing.Hash
+	for low < high {
+		mid := (low + high) / 2
+		if err := l.readAt(candidate[:], l.oidLookup+int64(mid)*hashSize); err != nil {
+			return -1, err
+		}
+
+		switch cmp := bytes.Compare(h[:], candidate[:]); {
+		case cmp == 0:
+			return mid, nil
+		case cmp < 0:
+			high = mid
+		default:
+			low = mid + 1
+		}
+	}
+
+	return -1, nil
+}
+
+// extraEdges returns the parents of an octopus merge, given its first parent
+// and the position of the rest in the extra edge list.
+func (l *layer) extraEdges(first int, pos uint32) ([]int, error) {
+	if l.edges < 0 {
+		return nil, ErrMalformed.New(l.path, "missing extra edge list chunk")
+	}
+
+	parents := []int{first}
+	offset := l.edges + int64(pos)*4
+	var buf [4]byte
+	for {
+		if err := l.readAt(buf[:], offset); err != nil {
+			return nil, err
+		}
+
+		edge := binary.BigEndian.Uint32(buf[:])
+		parents = append(parents, int(edge&parentOctopusMask))
+		if edge&parentLast != 0 {
+			return parents, nil
+		}
+
+		offset += 4
+	}
+}
+
+// readAt fills the buffer with the contents of the file at the given offset,
+// opening the file again if it was closed.
+func (l *layer) readAt(buf []byte, offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		f, err := l.fs.Open(l.path)
+		if err != nil {
+			return err
+		}
+		l.file = f
+	}
+
+	n, err := l.file.ReadAt(buf, offset)
+	if n == len(buf) {
+		return nil
+	}
+
+	if err == io.EOF || err == nil {
+		return ErrMalformed.New(l.path, "unexpected end of file")
+	}
+
+	return err
+}
+
+func (l *layer) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
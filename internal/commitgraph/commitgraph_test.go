@@ -0,0 +1,298 @@
+package commitgraph
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/commitgraph"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+type testRepo struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+	fs   billy.Filesystem
+	n    int
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	dir, err := ioutil.TempDir("", "commitgraph")
+	require.NoError(t, err)
+
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+
+	return &testRepo{
+		t:    t,
+		dir:  dir,
+		repo: repo,
+		fs:   osfs.New(filepath.Join(dir, ".git")),
+	}
+}
+
+func (r *testRepo) close() {
+	require.NoError(r.t, os.RemoveAll(r.dir))
+}
+
+// commit creates a commit with the given parents, or the HEAD commit if
+// none is given, and moves HEAD to it.
+func (r *testRepo) commit(parents ...plumbing.Hash) plumbing.Hash {
+	r.n++
+	name := fmt.Sprintf("file%d", r.n)
+	require.NoError(r.t, ioutil.WriteFile(filepath.Join(r.dir, name), []byte(name), 0644))
+
+	wt, err := r.repo.Worktree()
+	require.NoError(r.t, err)
+	_, err = wt.Add(name)
+	require.NoError(r.t, err)
+
+	sig := &object.Signature{
+		Name:  "foo",
+		Email: "foo@example.com",
+		When:  time.Unix(int64(1500000000+r.n*60), 0),
+	}
+
+	h, err := wt.Commit(name, &git.CommitOptions{
+		Author:    sig,
+		Committer: sig,
+		Parents:   parents,
+	})
+	require.NoError(r.t, err)
+	return h
+}
+
+// history creates a history with two branches starting at the second
+// commit, an octopus merge of the third, fourth and fifth commits and a
+// commit on top of it. It returns the generation number of each commit.
+func (r *testRepo) history() map[plumbing.Hash]int {
+	c1 := r.commit()
+	c2 := r.commit(c1)
+	c3 := r.commit(c2)
+	c4 := r.commit(c2)
+	c5 := r.commit(c3)
+	c6 := r.commit(c3, c4, c5)
+	c7 := r.commit(c6)
+
+	return map[plumbing.Hash]int{
+		c1: 1, c2: 2, c3: 3, c4: 3, c5: 4, c6: 5, c7: 6,
+	}
+}
+
+func (r *testRepo) requireGraph(g commitgraph.Index, generations map[plumbing.Hash]int) {
+	require := require.New(r.t)
+
+	for h, generation := range generations {
+		pos, err := g.GetIndexByHash(h)
+		require.NoError(err, h.String())
+
+		data, err := g.GetCommitDataByIndex(pos)
+		require.NoError(err)
+
+		commit, err := r.repo.CommitObject(h)
+		require.NoError(err)
+
+		require.Equal(commit.TreeHash, data.TreeHash)
+		require.Len(data.ParentHashes, len(commit.ParentHashes))
+		for i, p := range commit.ParentHashes {
+			require.Equal(p, data.ParentHashes[i])
+		}
+		require.Equal(commit.Committer.When.Unix(), data.When.Unix())
+		if generation > 0 {
+			require.Equal(generation, data.Generation, h.String())
+		}
+
+		for i, p := range data.ParentIndexes {
+			ppos, err := g.GetIndexByHash(data.ParentHashes[i])
+			require.NoError(err)
+			require.Equal(ppos, p)
+		}
+	}
+
+	_, err := g.GetIndexByHash(plumbing.NewHash("ffffffffffffffffffffffffffffffffffffffff"))
+	require.Equal(plumbing.ErrObjectNotFound, err)
+}
+
+// gitVerify checks the commit-graph with git, if it's installed.
+func (r *testRepo) gitVerify() {
+	if _, err := exec.LookPath("git"); err != nil {
+		return
+	}
+
+	out, err := exec.Command("git", "-C", r.dir, "commit-graph", "verify").CombinedOutput()
+	require.NoError(r.t, err, string(out))
+}
+
+func TestWriteOpen(t *testing.T) {
+	require := require.New(t)
+	r := newTestRepo(t)
+	defer r.close()
+
+	_, err := Open(r.fs)
+	require.True(ErrNotFound.Is(err))
+
+	generations := r.history()
+
+	n, err := Write(r.fs, r.repo.Storer)
+	require.NoError(err)
+	require.Equal(7, n)
+
+	g, err := Open(r.fs)
+	require.NoError(err)
+	require.Equal(1, g.Layers())
+	require.Equal(7, g.Len())
+	require.Len(g.Hashes(), 7)
+	r.requireGraph(g, generations)
+
+	// the graph is opened again after closing it
+	require.NoError(g.Close())
+	r.requireGraph(g, generations)
+	require.NoError(g.Close())
+
+	// go-git can read the file written
+	f, err := r.fs.Open(FilePath)
+	require.NoError(err)
+	defer f.Close()
+
+	idx, err := commitgraph.OpenFileIndex(f)
+	require.NoError(err)
+	r.requireGraph(idx, generations)
+
+	r.gitVerify()
+}
+
+func TestWriteLayer(t *testing.T) {
+	require := require.New(t)
+	r := newTestRepo(t)
+	defer r.close()
+
+	generations := r.history()
+
+	n, err := WriteLayer(r.fs, r.repo.Storer)
+	require.NoError(err)
+	require.Equal(7, n)
+
+	n, err = WriteLayer(r.fs, r.repo.Storer)
+	require.NoError(err)
+	require.Zero(n)
+
+	head, err := r.repo.Head()
+	require.NoError(err)
+
+	c8 := r.commit(head.Hash())
+	c9 := r.commit(c8)
+	generations[c8] = 7
+	generations[c9] = 8
+
+	n, err = WriteLayer(r.fs, r.repo.Storer)
+	require.NoError(err)
+	require.Equal(2, n)
+
+	g, err := Open(r.fs)
+	require.NoError(err)
+	defer g.Close()
+
+	require.Equal(2, g.Layers())
+	require.Equal(9, g.Len())
+	r.requireGraph(g, generations)
+
+	r.gitVerify()
+}
+
+func TestWriteLayerFromFile(t *testing.T) {
+	require := require.New(t)
+	r := newTestRepo(t)
+	defer r.close()
+
+	generations := r.history()
+	_, err := Write(r.fs, r.repo.Storer)
+	require.NoError(err)
+
+	head, err := r.repo.Head()
+	require.NoError(err)
+	c8 := r.commit(head.Hash())
+	generations[c8] = 7
+
+	n, err := WriteLayer(r.fs, r.repo.Storer)
+	require.NoError(err)
+	require.Equal(1, n)
+
+	_, err = r.fs.Stat(FilePath)
+	require.True(os.IsNotExist(err))
+
+	g, err := Open(r.fs)
+	require.NoError(err)
+	defer g.Close()
+
+	require.Equal(2, g.Layers())
+	r.requireGraph(g, generations)
+}
+
+func TestOpenGitChain(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	require := require.New(t)
+	r := newTestRepo(t)
+	defer r.close()
+
+	gitWrite := func() {
+		out, err := exec.Command(
+			"git", "-C", r.dir, "commit-graph", "write", "--reachable", "--split=no-merge",
+		).CombinedOutput()
+		require.NoError(err, string(out))
+	}
+
+	generations := r.history()
+	gitWrite()
+
+	head, err := r.repo.Head()
+	require.NoError(err)
+	c8 := r.commit(head.Hash())
+	generations[c8] = 0
+	gitWrite()
+
+	g, err := Open(r.fs)
+	require.NoError(err)
+	defer g.Close()
+
+	require.Equal(2, g.Layers())
+	r.requireGraph(g, generations)
+}
+
+func TestOpenMalformed(t *testing.T) {
+	require := require.New(t)
+	r := newTestRepo(t)
+	defer r.close()
+
+	require.NoError(r.fs.MkdirAll(ChainDir, 0755))
+	f, err := r.fs.Create(FilePath)
+	require.NoError(err)
+	_, err = f.Write([]byte("CGPX\x01\x01\x03\x00"))
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	_, err = Open(r.fs)
+	require.True(ErrMalformed.Is(err))
+
+	require.NoError(r.fs.Remove(FilePath))
+	f, err = r.fs.Create(ChainPath)
+	require.NoError(err)
+	_, err = f.Write([]byte("foo\n"))
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	_, err = Open(r.fs)
+	require.True(ErrMalformed.Is(err))
+}
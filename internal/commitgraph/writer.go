@@ -0,0 +1,513 @@
+package commitgraph
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+	errors "gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// ErrMissingParent is returned when writing a commit-graph of a repository
+// that doesn't have the parent of one of its commits, such as shallow
+// clones.
+var ErrMissingParent = errors.NewKind("parent %s of commit %s not found")
+
+// Write writes a commit-graph file with all the commits in the storer to the
+// git directory in the given filesystem, replacing the existing one. It
+// returns the number of commits written.
+func Write(fs billy.Filesystem, s storer.EncodedObjectStorer) (int, error) {
+	w, err := newWriter(s, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := w.writeFile(fs, FilePath); err != nil {
+		return 0, err
+	}
+
+	return len(w.commits), nil
+}
+
+// WriteLayer adds a layer to the split commit-graph chain of the git
+// directory in the given filesystem with the commits in the storer that are
+// not in the chain yet, creating the chain if it does not exist. A single
+// commit-graph file becomes the base layer of the chain. It returns the
+// number of commits written, which is zero if the chain already had all of
+// them.
+func WriteLayer(fs billy.Filesystem, s storer.EncodedObjectStorer) (int, error) {
+	if err := moveToChain(fs); err != nil {
+		return 0, err
+	}
+
+	base, err := Open(fs)
+	if err != nil && !ErrNotFound.Is(err) {
+		return 0, err
+	}
+
+	var chain []plumbing.Hash
+	if base != nil {
+		defer base.Close()
+		chain, err = readChain(fs)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	w, err := newWriter(s, base)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(w.commits) == 0 {
+		return 0, nil
+	}
+
+	w.bases = chain
+	tmp, err := w.writeFile(fs, "")
+	if err != nil {
+		return 0, err
+	}
+
+	if err := fs.Rename(tmp, layerPath(w.checksum)); err != nil {
+		return 0, err
+	}
+
+	if err := writeChain(fs, append(chain, w.checksum)); err != nil {
+		return 0, err
+	}
+
+	return len(w.commits), nil
+}
+
+// moveToChain makes the single commit-graph file, if any, the first layer
+// of a new chain, so the chain is used instead of it.
+func moveToChain(fs billy.Filesystem) error {
+	f, err := fs.Open(FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	stat, err := fs.Stat(FilePath)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	var checksum plumbing.Hash
+	_, err = f.ReadAt(checksum[:], stat.Size()-hashSize)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := fs.MkdirAll(ChainDir, 0755); err != nil {
+		return err
+	}
+
+	if err := fs.Rename(FilePath, layerPath(checksum)); err != nil {
+		return err
+	}
+
+	// any previous chain was ignored in favor of the single file
+	return writeChain(fs, []plumbing.Hash{checksum})
+}
+
+func writeChain(fs billy.Filesystem, hashes []plumbing.Hash) error {
+	var buf bytes.Buffer
+	for _, h := range hashes {
+		buf.WriteString(h.String())
+		buf.WriteByte('\n')
+	}
+
+	f, err := util.TempFile(fs, ChainDir, "tmp_chain_")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(buf.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = fs.Remove(f.Name())
+		return err
+	}
+
+	_ = fs.Remove(ChainPath)
+	return fs.Rename(f.Name(), ChainPath)
+}
+
+// commitInfo is the data of a commit written to a commit-graph.
+type commitInfo struct {
+	hash       plumbing.Hash
+	tree       plumbing.Hash
+	parents    []plumbing.Hash
+	when       int64
+	generation uint32
+}
+
+type writer struct {
+	base *Graph
+	// commits are sorted by hash.
+	commits []*commitInfo
+	pos     map[plumbing.Hash]int
+	bases   []plumbing.Hash
+
+	checksum plumbing.Hash
+}
+
+// newWriter reads the commits of the storer that are not in the given base
+// graph, if any, and computes their generation numbers.
+func newWriter(s storer.EncodedObjectStorer, base *Graph) (*writer, error) {
+	w := &writer{base: base, pos: make(map[plumbing.Hash]int)}
+
+	iter, err := s.IterEncodedObjects(plumbing.CommitObject)
+	if err != nil {
+		return nil, err
+	}
+
+	err = iter.ForEach(func(o plumbing.EncodedObject) error {
+		if base != nil {
+			if _, err := base.GetIndexByHash(o.Hash()); err == nil {
+				return nil
+			}
+		}
+
+		c, err := object.DecodeCommit(s, o)
+		if err != nil {
+			return err
+		}
+
+		when := c.Committer.When.Unix()
+		if when < 0 {
+			when = 0
+		}
+
+		w.commits = append(w.commits, &commitInfo{
+			hash:    c.Hash,
+			tree:    c.TreeHash,
+			parents: c.ParentHashes,
+			when:    when & dateMask,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(w.commits, func(i, j int) bool {
+		return bytes.Compare(w.commits[i].hash[:], w.commits[j].hash[:]) < 0
+	})
+
+	for i, c := range w.commits {
+		w.pos[c.hash] = i
+	}
+
+	if err := w.computeGenerations(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// computeGenerations sets the generation number of the commits, which is
+// one more than the greatest generation of their parents. Commits are
+// visited with an explicit stack, so long histories don't exhaust the call
+// stack.
+func (w *writer) computeGenerations() error {
+	for _, c := range w.commits {
+		stack := []*commitInfo{c}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if top.generation > 0 {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+
+			var generation uint32
+			pending := false
+			for _, p := range top.parents {
+				if i, ok := w.pos[p]; ok {
+					parent := w.commits[i]
+					if parent.generation == 0 {
+						stack = append(stack, parent)
+						pending = true
+					} else if parent.generation > generation {
+						generation = parent.generation
+					}
+					continue
+				}
+
+				g, err := w.baseGeneration(p)
+				if err != nil {
+					if err == plumbing.ErrObjectNotFound {
+						return ErrMissingParent.New(p, top.hash)
+					}
+					return err
+				}
+
+				if g > generation {
+					generation = g
+				}
+			}
+
+			if pending {
+				continue
+			}
+
+			if generation < maxGeneration {
+				generation++
+			}
+
+			top.generation = generation
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	return nil
+}
+
+func (w *writer) baseGeneration(h plumbing.Hash) (uint32, error) {
+	if w.base == nil {
+		return 0, plumbing.ErrObjectNotFound
+	}
+
+	pos, err := w.base.GetIndexByHash(h)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := w.base.GetCommitDataByIndex(pos)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(data.Generation), nil
+}
+
+// position returns the position of the given commit in the graph being
+// written.
+func (w *writer) position(h plumbing.Hash) (uint32, error) {
+	var offset int
+	if w.base != nil {
+		offset = w.base.Len()
+	}
+
+	if i, ok := w.pos[h]; ok {
+		return uint32(offset + i), nil
+	}
+
+	if w.base == nil {
+		return 0, plumbing.ErrObjectNotFound
+	}
+
+	pos, err := w.base.GetIndexByHash(h)
+	return uint32(pos), err
+}
+
+// writeFile writes the commit-graph file and renames it to the given path,
+// or leaves it in a temporary file if the path is empty. It returns the
+// path of the file written.
+func (w *writer) writeFile(fs billy.Filesystem, p string) (string, error) {
+	dir := ChainDir
+	if p != "" {
+		dir = path.Dir(p)
+	}
+
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	f, err := util.TempFile(fs, dir, "tmp_graph_")
+	if err != nil {
+		return "", err
+	}
+
+	err = w.encode(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = fs.Remove(f.Name())
+		return "", err
+	}
+
+	if p == "" {
+		return f.Name(), nil
+	}
+
+	_ = fs.Remove(p)
+	if err := fs.Rename(f.Name(), p); err != nil {
+		return "", err
+	}
+
+	return p, nil
+}
+
+func (w *writer) encode(out io.Writer) error {
+	edges, err := w.extraEdges()
+	if err != nil {
+		return err
+	}
+
+	chunks := [][]byte{oidFanoutChunk, oidLookupChunk, commitDataChunk}
+	sizes := []uint64{
+		fanoutSize,
+		uint64(len(w.commits) * hashSize),
+		uint64(len(w.commits) * commitDataSize),
+	}
+
+	if len(edges) > 0 {
+		chunks = append(chunks, extraEdgeListChunk)
+		sizes = append(sizes, uint64(len(edges)*4))
+	}
+
+	if len(w.bases) > 0 {
+		chunks = append(chunks, baseGraphsChunk)
+		sizes = append(sizes, uint64(len(w.bases)*hashSize))
+	}
+
+	h := sha1.New()
+	bw := bufio.NewWriter(io.MultiWriter(out, h))
+	e := &encoder{w: bw}
+
+	e.write(fileSignature)
+	e.write([]byte{fileVersion, hashVersion, byte(len(chunks)), byte(len(w.bases))})
+
+	offset := uint64(headerSize + (len(chunks)+1)*chunkEntrySize)
+	for i, id := range chunks {
+		e.write(id)
+		e.uint64(offset)
+		offset += sizes[i]
+	}
+	e.write([]byte{0, 0, 0, 0})
+	e.uint64(offset)
+
+	var fanout [256]uint32
+	for _, c := range w.commits {
+		fanout[c.hash[0]]++
+	}
+
+	var count uint32
+	for _, n := range fanout {
+		count += n
+		e.uint32(count)
+	}
+
+	for _, c := range w.commits {
+		e.write(c.hash[:])
+	}
+
+	var edge uint32
+	for _, c := range w.commits {
+		e.write(c.tree[:])
+
+		parent1, parent2 := parentNone, parentNone
+		if len(c.parents) > 0 {
+			if parent1, err = w.position(c.parents[0]); err != nil {
+				return err
+			}
+		}
+
+		switch len(c.parents) {
+		case 0, 1:
+		case 2:
+			if parent2, err = w.position(c.parents[1]); err != nil {
+				return err
+			}
+		default:
+			parent2 = edge | parentOctopusUsed
+			edge += uint32(len(c.parents) - 1)
+		}
+
+		e.uint32(parent1)
+		e.uint32(parent2)
+		e.uint64(uint64(c.generation)<<34 | uint64(c.when))
+	}
+
+	for _, edge := range edges {
+		e.uint32(edge)
+	}
+
+	for _, base := range w.bases {
+		e.write(base[:])
+	}
+
+	if e.err != nil {
+		return e.err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	copy(w.checksum[:], h.Sum(nil))
+	_, err = out.Write(w.checksum[:])
+	return err
+}
+
+// extraEdges returns the extra edge list with the parents of the octopus
+// merges after the first one.
+func (w *writer) extraEdges() ([]uint32, error) {
+	var edges []uint32
+	for _, c := range w.commits {
+		if len(c.parents) <= 2 {
+			continue
+		}
+
+		for _, p := range c.parents[1:] {
+			pos, err := w.position(p)
+			if err != nil {
+				return nil, err
+			}
+			edges = append(edges, pos)
+		}
+		edges[len(edges)-1] |= parentLast
+	}
+
+	return edges, nil
+}
+
+// encoder writes big endian values, keeping the first error.
+type encoder struct {
+	w   io.Writer
+	err error
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+func (e *encoder) uint32(v uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	e.write(buf[:])
+}
+
+func (e *encoder) uint64(v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	e.write(buf[:])
+}
@@ -0,0 +1,283 @@
+package export
+
+import (
+	"io"
+	"time"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	"github.com/src-d/go-mysql-server/sql"
+)
+
+// arrowBatchRows is the maximum number of rows of each record batch.
+const arrowBatchRows = 64 * 1024
+
+// arrowRecordWriter is implemented by both the ipc.Writer and the
+// ipc.FileWriter.
+type arrowRecordWriter interface {
+	Write(array.Record) error
+	Close() error
+}
+
+// arrowWriter writes rows as record batches using the Arrow IPC format.
+type arrowWriter struct {
+	w        arrowRecordWriter
+	schema   *arrow.Schema
+	columns  []arrowColumn
+	builders []array.Builder
+	rows     int64
+}
+
+type arrowColumn struct {
+	name string
+	typ  sql.Type
+	kind kind
+}
+
+func newArrowWriter(w io.Writer, schema sql.Schema) (*arrowWriter, error) {
+	var (
+		mem     = memory.NewGoAllocator()
+		fields  = make([]arrow.Field, len(schema))
+		columns = make([]arrowColumn, len(schema))
+	)
+
+	for i, col := range schema {
+		k, depth, ok := columnKind(col.Type)
+		if !ok || (depth > 0 && (k == kindTimestamp || k == kindDate)) {
+			return nil, ErrUnsupportedType.New(col.Name, col.Type.String())
+		}
+
+		typ := arrowType(k)
+		for j := 0; j < depth; j++ {
+			typ = arrow.ListOf(typ)
+		}
+
+		fields[i] = arrow.Field{Name: col.Name, Type: typ, Nullable: true}
+		if k == kindJSON {
+			fields[i].Metadata = arrow.NewMetadata(
+				[]string{"ARROW:extension:name"},
+				[]string{"arrow.json"},
+			)
+		}
+
+		columns[i] = arrowColumn{name: col.Name, typ: col.Type, kind: k}
+	}
+
+	aw := &arrowWriter{
+		schema:  arrow.NewSchema(fields, nil),
+		columns: columns,
+	}
+
+	opts := []ipc.Option{ipc.WithSchema(aw.schema), ipc.WithAllocator(mem)}
+	if ws, ok := w.(io.WriteSeeker); ok {
+		fw, err := ipc.NewFileWriter(ws, opts...)
+		if err != nil {
+			return nil, err
+		}
+		aw.w = fw
+	} else {
+		aw.w = ipc.NewWriter(w, opts...)
+	}
+
+	aw.builders = make([]array.Builder, len(fields))
+	for i, f := range fields {
+		aw.builders[i] = newArrowBuilder(mem, f.Type)
+	}
+
+	return aw, nil
+}
+
+func arrowType(k kind) arrow.DataType {
+	switch k {
+	case kindString, kindJSON:
+		return arrow.BinaryTypes.String
+	case kindBinary:
+		return arrow.BinaryTypes.Binary
+	case kindBool:
+		return arrow.FixedWidthTypes.Boolean
+	case kindInt8:
+		return arrow.PrimitiveTypes.Int8
+	case kindInt16:
+		return arrow.PrimitiveTypes.Int16
+	case kindInt32:
+		return arrow.PrimitiveTypes.Int32
+	case kindInt64:
+		return arrow.PrimitiveTypes.Int64
+	case kindUint8:
+		return arrow.PrimitiveTypes.Uint8
+	case kindUint16:
+		return arrow.PrimitiveTypes.Uint16
+	case kindUint32:
+		return arrow.PrimitiveTypes.Uint32
+	case kindUint64:
+		return arrow.PrimitiveTypes.Uint64
+	case kindFloat32:
+		return arrow.PrimitiveTypes.Float32
+	case kindFloat64:
+		return arrow.PrimitiveTypes.Float64
+	case kindTimestamp:
+		return arrow.FixedWidthTypes.Timestamp_ms
+	default: // kindDate
+		return arrow.FixedWidthTypes.Date32
+	}
+}
+
+// newArrowBuilder creates a builder for the given type.
+func newArrowBuilder(mem memory.Allocator, typ arrow.DataType) array.Builder {
+	switch typ := typ.(type) {
+	case *arrow.ListType:
+		return array.NewListBuilder(mem, typ.Elem())
+	case *arrow.StringType:
+		return array.NewStringBuilder(mem)
+	case *arrow.BinaryType:
+		return array.NewBinaryBuilder(mem, typ)
+	case *arrow.BooleanType:
+		return array.NewBooleanBuilder(mem)
+	case *arrow.Int8Type:
+		return array.NewInt8Builder(mem)
+	case *arrow.Int16Type:
+		return array.NewInt16Builder(mem)
+	case *arrow.Int32Type:
+		return array.NewInt32Builder(mem)
+	case *arrow.Int64Type:
+		return array.NewInt64Builder(mem)
+	case *arrow.Uint8Type:
+		return array.NewUint8Builder(mem)
+	case *arrow.Uint16Type:
+		return array.NewUint16Builder(mem)
+	case *arrow.Uint32Type:
+		return array.NewUint32Builder(mem)
+	case *arrow.Uint64Type:
+		return array.NewUint64Builder(mem)
+	case *arrow.Float32Type:
+		return array.NewFloat32Builder(mem)
+	case *arrow.Float64Type:
+		return array.NewFloat64Builder(mem)
+	case *arrow.TimestampType:
+		return array.NewTimestampBuilder(mem, typ)
+	default: // *arrow.Date32Type
+		return array.NewDate32Builder(mem)
+	}
+}
+
+// Write implements the Writer interface.
+func (w *arrowWriter) Write(row sql.Row) error {
+	for i, c := range w.columns {
+		if err := c.append(w.builders[i], c.typ, row[i]); err != nil {
+			return err
+		}
+	}
+
+	w.rows++
+	if w.rows >= arrowBatchRows {
+		return w.flush()
+	}
+
+	return nil
+}
+
+// Close implements the Writer interface.
+func (w *arrowWriter) Close() error {
+	defer func() {
+		for _, b := range w.builders {
+			b.Release()
+		}
+	}()
+
+	if w.rows > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+
+	return w.w.Close()
+}
+
+func (w *arrowWriter) flush() error {
+	var cols = make([]array.Interface, len(w.builders))
+	for i, b := range w.builders {
+		cols[i] = b.NewArray()
+	}
+
+	rec := array.NewRecord(w.schema, cols, w.rows)
+	for _, col := range cols {
+		col.Release()
+	}
+	defer rec.Release()
+
+	w.rows = 0
+	return w.w.Write(rec)
+}
+
+func (c arrowColumn) append(b array.Builder, typ sql.Type, v interface{}) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+
+	if lb, ok := b.(*array.ListBuilder); ok {
+		values, ok := v.([]interface{})
+		if !ok {
+			return ErrUnexpectedValue.New(v, c.name)
+		}
+
+		lb.Append(true)
+		elemType := sql.UnderlyingType(typ)
+		for _, val := range values {
+			if err := c.append(lb.ValueBuilder(), elemType, val); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	v, err := convertValue(c.kind, typ, v)
+	if err != nil {
+		return err
+	}
+
+	switch b := b.(type) {
+	case *array.StringBuilder:
+		if bs, ok := v.([]byte); ok {
+			b.Append(string(bs))
+		} else {
+			b.Append(v.(string))
+		}
+	case *array.BinaryBuilder:
+		b.Append(v.([]byte))
+	case *array.BooleanBuilder:
+		b.Append(v.(bool))
+	case *array.Int8Builder:
+		b.Append(int8(v.(int64)))
+	case *array.Int16Builder:
+		b.Append(int16(v.(int64)))
+	case *array.Int32Builder:
+		b.Append(int32(v.(int64)))
+	case *array.Int64Builder:
+		b.Append(v.(int64))
+	case *array.Uint8Builder:
+		b.Append(uint8(v.(uint64)))
+	case *array.Uint16Builder:
+		b.Append(uint16(v.(uint64)))
+	case *array.Uint32Builder:
+		b.Append(uint32(v.(uint64)))
+	case *array.Uint64Builder:
+		b.Append(v.(uint64))
+	case *array.Float32Builder:
+		b.Append(float32(v.(float64)))
+	case *array.Float64Builder:
+		b.Append(v.(float64))
+	case *array.TimestampBuilder:
+		millis := v.(time.Time).UnixNano() / int64(time.Millisecond)
+		b.Append(arrow.Timestamp(millis))
+	case *array.Date32Builder:
+		b.Append(arrow.Date32(daysSinceEpoch(v.(time.Time))))
+	default:
+		return ErrUnexpectedValue.New(v, c.name)
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+// Package export implements writers of query results in columnar file
+// formats, mapping the types of the result schema to the types of each
+// format.
+package export
+
+import (
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/src-d/go-mysql-server/sql"
+	errors "gopkg.in/src-d/go-errors.v1"
+	"vitess.io/vitess/go/sqltypes"
+)
+
+// Formats supported by the export writers.
+const (
+	// Parquet is the Apache Parquet file format.
+	Parquet = "parquet"
+	// Arrow is the Apache Arrow IPC format. The file format is used when
+	// the destination can seek, and the stream format otherwise.
+	Arrow = "arrow"
+)
+
+var (
+	// ErrUnknownFormat is returned when the export format is not supported.
+	ErrUnknownFormat = errors.NewKind("unknown export format: %s")
+	// ErrUnsupportedType is returned when a column of the schema has a type
+	// that cannot be represented in the export format.
+	ErrUnsupportedType = errors.NewKind("column %q has a type that can't be exported: %s")
+	// ErrUnexpectedValue is returned when a value does not match the type
+	// of its column.
+	ErrUnexpectedValue = errors.NewKind("unexpected value of type %T in column %q")
+)
+
+// Writer writes rows to an export file.
+type Writer interface {
+	// Write writes a single row.
+	Write(sql.Row) error
+	// Close flushes any pending rows and writes the file footer, if any.
+	// It does not close the underlying writer.
+	Close() error
+}
+
+// NewWriter creates a Writer of the given format that writes rows with the
+// given schema to w.
+func NewWriter(format string, w io.Writer, schema sql.Schema) (Writer, error) {
+	switch strings.ToLower(format) {
+	case Parquet:
+		return newParquetWriter(w, schema)
+	case Arrow:
+		return newArrowWriter(w, schema)
+	default:
+		return nil, ErrUnknownFormat.New(format)
+	}
+}
+
+// FormatFromPath guesses the export format from the extension of the given
+// path. It returns an empty string if the extension is not known.
+func FormatFromPath(path string) string {
+	path = strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(path, ".parquet"), strings.HasSuffix(path, ".pq"):
+		return Parquet
+	case strings.HasSuffix(path, ".arrow"),
+		strings.HasSuffix(path, ".arrows"),
+		strings.HasSuffix(path, ".ipc"),
+		strings.HasSuffix(path, ".feather"):
+		return Arrow
+	default:
+		return ""
+	}
+}
+
+// kind is the kind of value of a column, which decides how it's stored.
+type kind int
+
+const (
+	kindString kind = iota
+	kindBinary
+	kindJSON
+	kindBool
+	kindInt8
+	kindInt16
+	kindInt32
+	kindInt64
+	kindUint8
+	kindUint16
+	kindUint32
+	kindUint64
+	kindFloat32
+	kindFloat64
+	kindTimestamp
+	kindDate
+)
+
+// columnKind returns the kind of values of the given type. For arrays, it's
+// the kind of the innermost element, and depth is the number of nested
+// arrays.
+func columnKind(typ sql.Type) (k kind, depth int, ok bool) {
+	typ, depth = elementType(typ)
+
+	switch typ.Type() {
+	case sqltypes.Null, sqltypes.Char, sqltypes.VarChar, sqltypes.Text:
+		k = kindString
+	case sqltypes.Blob:
+		k = kindBinary
+	case sqltypes.TypeJSON:
+		k = kindJSON
+	case sqltypes.Bit:
+		k = kindBool
+	case sqltypes.Int8:
+		k = kindInt8
+	case sqltypes.Int16:
+		k = kindInt16
+	case sqltypes.Int24, sqltypes.Int32:
+		k = kindInt32
+	case sqltypes.Int64:
+		k = kindInt64
+	case sqltypes.Uint8:
+		k = kindUint8
+	case sqltypes.Uint16:
+		k = kindUint16
+	case sqltypes.Uint24, sqltypes.Uint32:
+		k = kindUint32
+	case sqltypes.Uint64:
+		k = kindUint64
+	case sqltypes.Float32:
+		k = kindFloat32
+	case sqltypes.Float64:
+		k = kindFloat64
+	case sqltypes.Timestamp, sqltypes.Datetime:
+		k = kindTimestamp
+	case sqltypes.Date:
+		k = kindDate
+	default:
+		return 0, 0, false
+	}
+
+	return k, depth, true
+}
+
+// elementType returns the type of the innermost element of the given type
+// and the number of nested arrays containing it.
+func elementType(typ sql.Type) (sql.Type, int) {
+	var depth int
+	for sql.IsArray(typ) {
+		typ = sql.UnderlyingType(typ)
+		depth++
+	}
+	return typ, depth
+}
+
+// convertValue converts a non-nil value of a column to the Go type used to
+// store values of the given kind: string, []byte, bool, int64, uint64,
+// float64 or time.Time.
+func convertValue(k kind, typ sql.Type, v interface{}) (interface{}, error) {
+	switch k {
+	case kindString:
+		if typ.Type() == sqltypes.Null {
+			return sql.Text.Convert(v)
+		}
+		return typ.Convert(v)
+	case kindBinary:
+		return typ.Convert(v)
+	case kindJSON:
+		if b, ok := v.([]byte); ok {
+			return b, nil
+		}
+		return typ.Convert(v)
+	case kindBool:
+		switch v := v.(type) {
+		case bool:
+			return v, nil
+		default:
+			i, err := sql.Int64.Convert(v)
+			if err != nil {
+				return nil, err
+			}
+			return i.(int64) != 0, nil
+		}
+	case kindInt8, kindInt16, kindInt32, kindInt64:
+		return sql.Int64.Convert(v)
+	case kindUint8, kindUint16, kindUint32, kindUint64:
+		return sql.Uint64.Convert(v)
+	case kindFloat32, kindFloat64:
+		return sql.Float64.Convert(v)
+	case kindTimestamp, kindDate:
+		t, err := sql.Timestamp.Convert(v)
+		if err != nil {
+			return nil, err
+		}
+		return t.(time.Time).UTC(), nil
+	}
+
+	return nil, ErrUnexpectedValue.New(v, typ.String())
+}
+
+// daysSinceEpoch returns the number of days since the UNIX epoch of t.
+func daysSinceEpoch(t time.Time) int32 {
+	return int32(math.Floor(float64(t.Unix()) / (24 * 60 * 60)))
+}
@@ -0,0 +1,216 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+)
+
+var testSchema = sql.Schema{
+	{Name: "name", Type: sql.Text, Nullable: true},
+	{Name: "size", Type: sql.Int64, Nullable: true},
+	{Name: "when", Type: sql.Timestamp, Nullable: true},
+	{Name: "parents", Type: sql.Array(sql.Text), Nullable: true},
+	{Name: "blob", Type: sql.Blob, Nullable: true},
+}
+
+var testRows = []sql.Row{
+	sql.NewRow("a", int64(1), time.Unix(1500000000, 0), []interface{}{"x", "y"}, []byte{0, 1}),
+	sql.NewRow("b", int64(2), nil, []interface{}{}, nil),
+	sql.NewRow(nil, nil, time.Unix(0, 0), nil, []byte("foo")),
+}
+
+func TestFormatFromPath(t *testing.T) {
+	require.Equal(t, Parquet, FormatFromPath("/foo/bar.parquet"))
+	require.Equal(t, Parquet, FormatFromPath("bar.PQ"))
+	require.Equal(t, Arrow, FormatFromPath("bar.arrow"))
+	require.Equal(t, Arrow, FormatFromPath("bar.feather"))
+	require.Equal(t, "", FormatFromPath("bar.csv"))
+}
+
+func TestNewWriterErrors(t *testing.T) {
+	_, err := NewWriter("csv", new(bytes.Buffer), testSchema)
+	require.True(t, ErrUnknownFormat.Is(err))
+
+	schema := sql.Schema{{Name: "t", Type: sql.Tuple(sql.Int64, sql.Int64)}}
+	_, err = NewWriter(Parquet, new(bytes.Buffer), schema)
+	require.True(t, ErrUnsupportedType.Is(err))
+
+	schema = sql.Schema{{Name: "t", Type: sql.Array(sql.Timestamp)}}
+	_, err = NewWriter(Arrow, new(bytes.Buffer), schema)
+	require.True(t, ErrUnsupportedType.Is(err))
+}
+
+func TestArrowFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitbase-export")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out.arrow")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+
+	writeRows(t, Arrow, f, testSchema, testRows)
+	require.NoError(t, f.Close())
+
+	f, err = os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	r, err := ipc.NewFileReader(f)
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Equal(t, 1, r.NumRecords())
+	rec, err := r.Record(0)
+	require.NoError(t, err)
+	requireArrowRecord(t, rec)
+}
+
+func TestArrowStream(t *testing.T) {
+	var buf bytes.Buffer
+	writeRows(t, Arrow, &buf, testSchema, testRows)
+
+	r, err := ipc.NewReader(&buf)
+	require.NoError(t, err)
+	defer r.Release()
+
+	require.True(t, r.Next())
+	requireArrowRecord(t, r.Record())
+	require.False(t, r.Next())
+}
+
+func requireArrowRecord(t *testing.T, rec array.Record) {
+	t.Helper()
+	require := require.New(t)
+
+	require.Equal(int64(3), rec.NumRows())
+	require.Equal("name", rec.ColumnName(0))
+	require.Equal(arrow.FixedWidthTypes.Timestamp_ms, rec.Schema().Field(2).Type)
+
+	names := rec.Column(0).(*array.String)
+	require.Equal("a", names.Value(0))
+	require.True(names.IsNull(2))
+
+	sizes := rec.Column(1).(*array.Int64)
+	require.Equal(int64(2), sizes.Value(1))
+
+	when := rec.Column(2).(*array.Timestamp)
+	require.Equal(arrow.Timestamp(1500000000000), when.Value(0))
+	require.True(when.IsNull(1))
+
+	parents := rec.Column(3).(*array.List)
+	offsets := parents.Offsets()
+	require.Equal([]int32{0, 2, 2, 2}, offsets)
+	require.True(parents.IsValid(1))
+	require.True(parents.IsNull(2))
+	require.Equal("y", parents.ListValues().(*array.String).Value(1))
+
+	blobs := rec.Column(4).(*array.Binary)
+	require.Equal([]byte{0, 1}, blobs.Value(0))
+	require.True(blobs.IsNull(1))
+}
+
+func TestParquet(t *testing.T) {
+	require := require.New(t)
+
+	var buf bytes.Buffer
+	writeRows(t, Parquet, &buf, testSchema, testRows)
+
+	data := buf.Bytes()
+	require.Equal(parquetMagic, string(data[:4]))
+	require.Equal(parquetMagic, string(data[len(data)-4:]))
+
+	footerSize := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-footerSize : len(data)-8]
+	require.True(bytes.Contains(footer, []byte("parents")))
+	require.True(bytes.Contains(footer, []byte(parquetCreatedBy)))
+}
+
+func TestParquetShred(t *testing.T) {
+	require := require.New(t)
+
+	c := &parquetColumn{
+		name:   "a",
+		typ:    sql.Array(sql.Array(sql.Int64)),
+		kind:   kindInt64,
+		depth:  2,
+		maxDef: 5,
+		maxRep: 2,
+	}
+
+	values := []interface{}{
+		nil,
+		[]interface{}{},
+		[]interface{}{nil, []interface{}{int64(1), nil}},
+		[]interface{}{[]interface{}{int64(2)}, []interface{}{}},
+	}
+
+	for _, v := range values {
+		require.NoError(c.shred(v, 0, 0, 0))
+	}
+
+	require.Equal([]int32{0, 1, 2, 5, 4, 5, 3}, c.defs)
+	require.Equal([]int32{0, 0, 0, 1, 2, 0, 1}, c.reps)
+	require.Equal(16, c.values.Len())
+
+	require.Error(c.shred("foo", 0, 0, 0))
+}
+
+func TestWriteLevels(t *testing.T) {
+	var buf bytes.Buffer
+	writeLevels(&buf, []int32{1, 1, 1, 0, 2}, 2)
+
+	expected := []byte{
+		6, 0, 0, 0, // length
+		3 << 1, 1, // 3 times 1
+		1 << 1, 0, // 1 time 0
+		1 << 1, 2, // 1 time 2
+	}
+	require.Equal(t, expected, buf.Bytes())
+}
+
+func TestThriftWriter(t *testing.T) {
+	w := new(thriftWriter)
+	w.StructBegin()
+	w.FieldI32(1, 1)
+	w.FieldString(4, "ab")
+	w.FieldI64(20, -1)
+	w.FieldListBegin(21, thriftI32, 2)
+	w.I32(3)
+	w.I32(-3)
+	w.StructEnd()
+
+	expected := []byte{
+		0x15, 0x02, // field 1, i32, zigzag(1)
+		0x38, 0x02, 'a', 'b', // field 4 (delta 3), binary
+		0x06, 0x28, 0x01, // field 20 (delta 16), i64, zigzag(-1)
+		0x19, 0x25, 0x06, 0x05, // field 21 (delta 1), list of 2 i32
+		0x00, // stop
+	}
+	require.Equal(t, expected, w.Bytes())
+}
+
+func writeRows(t *testing.T, format string, w io.Writer, schema sql.Schema, rows []sql.Row) {
+	t.Helper()
+
+	ew, err := NewWriter(format, w, schema)
+	require.NoError(t, err)
+
+	for _, row := range rows {
+		require.NoError(t, ew.Write(row))
+	}
+
+	require.NoError(t, ew.Close())
+}
@@ -0,0 +1,512 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+
+	"github.com/src-d/go-mysql-server/sql"
+)
+
+// Parquet physical types.
+const (
+	parquetBoolean   int32 = 0
+	parquetInt32     int32 = 1
+	parquetInt64     int32 = 2
+	parquetFloat     int32 = 4
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+)
+
+// Parquet converted types.
+const (
+	parquetNoConvertedType int32 = -1
+	parquetUTF8            int32 = 0
+	parquetList            int32 = 3
+	parquetDate            int32 = 6
+	parquetTimestampMillis int32 = 9
+	parquetUint8           int32 = 11
+	parquetUint16          int32 = 12
+	parquetUint32          int32 = 13
+	parquetUint64          int32 = 14
+	parquetInt8            int32 = 15
+	parquetInt16           int32 = 16
+	parquetInt32Converted  int32 = 17
+	parquetJSON            int32 = 19
+)
+
+// Parquet repetition types.
+const (
+	parquetOptional int32 = 1
+	parquetRepeated int32 = 2
+)
+
+// Parquet encodings.
+const (
+	parquetPlain int32 = 0
+	parquetRLE   int32 = 3
+)
+
+const (
+	parquetMagic        = "PAR1"
+	parquetCreatedBy    = "gitbase"
+	parquetDataPage     = 0
+	parquetUncompressed = 0
+
+	// maxRowGroupRows and maxRowGroupBytes limit the size of the row
+	// groups, which are kept in memory until they are written.
+	maxRowGroupRows  = 1 << 20
+	maxRowGroupBytes = 64 << 20
+)
+
+// parquetWriter writes rows as an uncompressed parquet file. Values are
+// written with PLAIN encoding and all columns are optional. Arrays are
+// written as 3-level LIST groups, as defined by the parquet format.
+type parquetWriter struct {
+	w         io.Writer
+	offset    int64
+	columns   []*parquetColumn
+	rows      int64
+	totalRows int64
+	rowGroups []parquetRowGroup
+}
+
+type parquetRowGroup struct {
+	numRows   int64
+	totalSize int64
+	chunks    []parquetColumnChunk
+}
+
+type parquetColumnChunk struct {
+	offset    int64
+	numValues int64
+	size      int64
+}
+
+// parquetColumn holds the values of a column in the current row group.
+type parquetColumn struct {
+	name      string
+	typ       sql.Type
+	kind      kind
+	depth     int
+	physical  int32
+	converted int32
+	maxDef    int
+	maxRep    int
+
+	defs   []int32
+	reps   []int32
+	values bytes.Buffer
+	bools  []bool
+}
+
+func newParquetWriter(w io.Writer, schema sql.Schema) (*parquetWriter, error) {
+	var columns = make([]*parquetColumn, len(schema))
+	for i, col := range schema {
+		k, depth, ok := columnKind(col.Type)
+		if !ok {
+			return nil, ErrUnsupportedType.New(col.Name, col.Type.String())
+		}
+
+		physical, converted := parquetTypes(k)
+		columns[i] = &parquetColumn{
+			name:      col.Name,
+			typ:       col.Type,
+			kind:      k,
+			depth:     depth,
+			physical:  physical,
+			converted: converted,
+			maxDef:    1 + 2*depth,
+			maxRep:    depth,
+		}
+	}
+
+	pw := &parquetWriter{w: w, columns: columns}
+	if err := pw.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+func parquetTypes(k kind) (physical int32, converted int32) {
+	switch k {
+	case kindString:
+		return parquetByteArray, parquetUTF8
+	case kindBinary:
+		return parquetByteArray, parquetNoConvertedType
+	case kindJSON:
+		return parquetByteArray, parquetJSON
+	case kindBool:
+		return parquetBoolean, parquetNoConvertedType
+	case kindInt8:
+		return parquetInt32, parquetInt8
+	case kindInt16:
+		return parquetInt32, parquetInt16
+	case kindInt32:
+		return parquetInt32, parquetInt32Converted
+	case kindInt64:
+		return parquetInt64, parquetNoConvertedType
+	case kindUint8:
+		return parquetInt32, parquetUint8
+	case kindUint16:
+		return parquetInt32, parquetUint16
+	case kindUint32:
+		return parquetInt32, parquetUint32
+	case kindUint64:
+		return parquetInt64, parquetUint64
+	case kindFloat32:
+		return parquetFloat, parquetNoConvertedType
+	case kindFloat64:
+		return parquetDouble, parquetNoConvertedType
+	case kindTimestamp:
+		return parquetInt64, parquetTimestampMillis
+	default: // kindDate
+		return parquetInt32, parquetDate
+	}
+}
+
+func (w *parquetWriter) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	return err
+}
+
+// Write implements the Writer interface.
+func (w *parquetWriter) Write(row sql.Row) error {
+	for i, c := range w.columns {
+		if err := c.shred(row[i], 0, 0, 0); err != nil {
+			return err
+		}
+	}
+
+	w.rows++
+	if w.rows >= maxRowGroupRows || w.bufferedBytes() >= maxRowGroupBytes {
+		return w.flushRowGroup()
+	}
+
+	return nil
+}
+
+func (w *parquetWriter) bufferedBytes() int {
+	var size int
+	for _, c := range w.columns {
+		size += c.values.Len() + len(c.bools)/8 + len(c.defs) + len(c.reps)
+	}
+	return size
+}
+
+// Close implements the Writer interface.
+func (w *parquetWriter) Close() error {
+	if w.rows > 0 {
+		if err := w.flushRowGroup(); err != nil {
+			return err
+		}
+	}
+
+	footer := w.footer()
+	if err := w.write(footer); err != nil {
+		return err
+	}
+
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+	if err := w.write(size[:]); err != nil {
+		return err
+	}
+
+	return w.write([]byte(parquetMagic))
+}
+
+func (w *parquetWriter) flushRowGroup() error {
+	rg := parquetRowGroup{numRows: w.rows}
+	for _, c := range w.columns {
+		page := c.page()
+		chunk := parquetColumnChunk{
+			offset:    w.offset,
+			numValues: int64(len(c.defs)),
+			size:      int64(len(page)),
+		}
+
+		if err := w.write(page); err != nil {
+			return err
+		}
+
+		rg.totalSize += chunk.size
+		rg.chunks = append(rg.chunks, chunk)
+		c.reset()
+	}
+
+	w.rowGroups = append(w.rowGroups, rg)
+	w.totalRows += w.rows
+	w.rows = 0
+	return nil
+}
+
+func (w *parquetWriter) footer() []byte {
+	t := new(thriftWriter)
+	t.StructBegin()
+	t.FieldI32(1, 1)
+
+	var elements int
+	for _, c := range w.columns {
+		elements += 1 + 2*c.depth
+	}
+
+	t.FieldListBegin(2, thriftStruct, elements+1)
+	t.StructBegin()
+	t.FieldString(4, "schema")
+	t.FieldI32(5, int32(len(w.columns)))
+	t.StructEnd()
+	for _, c := range w.columns {
+		c.writeSchema(t)
+	}
+
+	t.FieldI64(3, w.totalRows)
+
+	t.FieldListBegin(4, thriftStruct, len(w.rowGroups))
+	for _, rg := range w.rowGroups {
+		t.StructBegin()
+		t.FieldListBegin(1, thriftStruct, len(rg.chunks))
+		for i, chunk := range rg.chunks {
+			w.columns[i].writeColumnChunk(t, chunk)
+		}
+		t.FieldI64(2, rg.totalSize)
+		t.FieldI64(3, rg.numRows)
+		t.StructEnd()
+	}
+
+	t.FieldString(6, parquetCreatedBy)
+	t.StructEnd()
+
+	return t.Bytes()
+}
+
+func (c *parquetColumn) path() []string {
+	var path = []string{c.name}
+	for i := 0; i < c.depth; i++ {
+		path = append(path, "list", "element")
+	}
+	return path
+}
+
+func (c *parquetColumn) writeSchema(t *thriftWriter) {
+	name := c.name
+	for i := 0; i < c.depth; i++ {
+		t.StructBegin()
+		t.FieldI32(3, parquetOptional)
+		t.FieldString(4, name)
+		t.FieldI32(5, 1)
+		t.FieldI32(6, parquetList)
+		t.StructEnd()
+
+		t.StructBegin()
+		t.FieldI32(3, parquetRepeated)
+		t.FieldString(4, "list")
+		t.FieldI32(5, 1)
+		t.StructEnd()
+
+		name = "element"
+	}
+
+	t.StructBegin()
+	t.FieldI32(1, c.physical)
+	t.FieldI32(3, parquetOptional)
+	t.FieldString(4, name)
+	if c.converted != parquetNoConvertedType {
+		t.FieldI32(6, c.converted)
+	}
+	t.StructEnd()
+}
+
+func (c *parquetColumn) writeColumnChunk(t *thriftWriter, chunk parquetColumnChunk) {
+	t.StructBegin()
+	t.FieldI64(2, chunk.offset)
+	t.FieldStructBegin(3)
+	t.FieldI32(1, c.physical)
+	t.FieldListBegin(2, thriftI32, 2)
+	t.I32(parquetPlain)
+	t.I32(parquetRLE)
+	path := c.path()
+	t.FieldListBegin(3, thriftBinary, len(path))
+	for _, p := range path {
+		t.String(p)
+	}
+	t.FieldI32(4, parquetUncompressed)
+	t.FieldI64(5, chunk.numValues)
+	t.FieldI64(6, chunk.size)
+	t.FieldI64(7, chunk.size)
+	t.FieldI64(9, chunk.offset)
+	t.StructEnd()
+	t.StructEnd()
+}
+
+// shred adds the value v of a row to the column, computing the repetition
+// and definition levels of every leaf value. level is the number of arrays
+// containing v.
+func (c *parquetColumn) shred(v interface{}, rep, def int32, level int) error {
+	if v == nil {
+		c.addLevels(rep, def)
+		return nil
+	}
+
+	if level == c.depth {
+		c.addLevels(rep, def+1)
+		return c.addValue(v)
+	}
+
+	values, ok := v.([]interface{})
+	if !ok {
+		return ErrUnexpectedValue.New(v, c.name)
+	}
+
+	// the optional list group is defined
+	def++
+	if len(values) == 0 {
+		c.addLevels(rep, def)
+		return nil
+	}
+
+	for i, val := range values {
+		r := rep
+		if i > 0 {
+			r = int32(level + 1)
+		}
+
+		if err := c.shred(val, r, def+1, level+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *parquetColumn) addLevels(rep, def int32) {
+	c.reps = append(c.reps, rep)
+	c.defs = append(c.defs, def)
+}
+
+func (c *parquetColumn) addValue(v interface{}) error {
+	elem, _ := elementType(c.typ)
+	v, err := convertValue(c.kind, elem, v)
+	if err != nil {
+		return err
+	}
+
+	var buf [8]byte
+	switch c.kind {
+	case kindString:
+		s := v.(string)
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(s)))
+		c.values.Write(buf[:4])
+		c.values.WriteString(s)
+	case kindBinary, kindJSON:
+		b := v.([]byte)
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(b)))
+		c.values.Write(buf[:4])
+		c.values.Write(b)
+	case kindBool:
+		c.bools = append(c.bools, v.(bool))
+	case kindInt8, kindInt16, kindInt32:
+		binary.LittleEndian.PutUint32(buf[:4], uint32(int32(v.(int64))))
+		c.values.Write(buf[:4])
+	case kindUint8, kindUint16, kindUint32:
+		binary.LittleEndian.PutUint32(buf[:4], uint32(v.(uint64)))
+		c.values.Write(buf[:4])
+	case kindInt64:
+		binary.LittleEndian.PutUint64(buf[:], uint64(v.(int64)))
+		c.values.Write(buf[:])
+	case kindUint64:
+		binary.LittleEndian.PutUint64(buf[:], v.(uint64))
+		c.values.Write(buf[:])
+	case kindFloat32:
+		binary.LittleEndian.PutUint32(buf[:4], math.Float32bits(float32(v.(float64))))
+		c.values.Write(buf[:4])
+	case kindFloat64:
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v.(float64)))
+		c.values.Write(buf[:])
+	case kindTimestamp:
+		millis := v.(time.Time).UnixNano() / int64(time.Millisecond)
+		binary.LittleEndian.PutUint64(buf[:], uint64(millis))
+		c.values.Write(buf[:])
+	case kindDate:
+		binary.LittleEndian.PutUint32(buf[:4], uint32(daysSinceEpoch(v.(time.Time))))
+		c.values.Write(buf[:4])
+	}
+
+	return nil
+}
+
+// page returns the header and the content of a data page with all the
+// values of the column.
+func (c *parquetColumn) page() []byte {
+	var data bytes.Buffer
+	if c.maxRep > 0 {
+		writeLevels(&data, c.reps, c.maxRep)
+	}
+	writeLevels(&data, c.defs, c.maxDef)
+
+	if c.kind == kindBool {
+		var packed = make([]byte, (len(c.bools)+7)/8)
+		for i, b := range c.bools {
+			if b {
+				packed[i/8] |= 1 << uint(i%8)
+			}
+		}
+		data.Write(packed)
+	} else {
+		data.Write(c.values.Bytes())
+	}
+
+	t := new(thriftWriter)
+	t.StructBegin()
+	t.FieldI32(1, parquetDataPage)
+	t.FieldI32(2, int32(data.Len()))
+	t.FieldI32(3, int32(data.Len()))
+	t.FieldStructBegin(5)
+	t.FieldI32(1, int32(len(c.defs)))
+	t.FieldI32(2, parquetPlain)
+	t.FieldI32(3, parquetRLE)
+	t.FieldI32(4, parquetRLE)
+	t.StructEnd()
+	t.StructEnd()
+
+	return append(t.Bytes(), data.Bytes()...)
+}
+
+func (c *parquetColumn) reset() {
+	c.defs = c.defs[:0]
+	c.reps = c.reps[:0]
+	c.bools = c.bools[:0]
+	c.values.Reset()
+}
+
+// writeLevels writes the given levels using the RLE encoding, prefixed by
+// their length, as it's done in data pages.
+func writeLevels(buf *bytes.Buffer, levels []int32, maxLevel int) {
+	width := (bits.Len(uint(maxLevel)) + 7) / 8
+	var encoded bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+
+		n := binary.PutUvarint(tmp[:], uint64(j-i)<<1)
+		encoded.Write(tmp[:n])
+		for b := 0; b < width; b++ {
+			encoded.WriteByte(byte(levels[i] >> uint(8*b)))
+		}
+
+		i = j
+	}
+
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(encoded.Len()))
+	buf.Write(size[:])
+	buf.Write(encoded.Bytes())
+}
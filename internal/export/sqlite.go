@@ -0,0 +1,306 @@
+package export
+
+import (
+	gosql "database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	// load the SQLite driver
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/src-d/go-mysql-server/sql"
+)
+
+const (
+	// sqliteBatchRows is the number of rows inserted in each transaction.
+	sqliteBatchRows = 10000
+
+	sqliteTimestampLayout = "2006-01-02 15:04:05"
+	sqliteDateLayout      = "2006-01-02"
+)
+
+// SQLite is a SQLite database where tables are exported. Timestamps are
+// stored as text in UTC, using a layout understood by the SQLite date and
+// time functions, and arrays are stored as JSON text.
+type SQLite struct {
+	db *gosql.DB
+}
+
+// OpenSQLite opens the SQLite database in the given path, creating it if it
+// does not exist.
+func OpenSQLite(path string) (*SQLite, error) {
+	db, err := gosql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the database is written by a single process and it's not usable
+	// until the export finishes, so there's no need for a journal.
+	for _, pragma := range []string{
+		"PRAGMA journal_mode = OFF",
+		"PRAGMA synchronous = OFF",
+	} {
+		if _, err := db.Exec(pragma); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+
+	return &SQLite{db: db}, nil
+}
+
+// CreateTable creates a table with the given schema and returns a Writer to
+// insert rows into it. The writer must be closed before creating another
+// table.
+func (s *SQLite) CreateTable(name string, schema sql.Schema) (Writer, error) {
+	var (
+		columns      = make([]sqliteColumn, len(schema))
+		definitions  = make([]string, len(schema))
+		placeholders = make([]string, len(schema))
+	)
+
+	for i, col := range schema {
+		k, depth, ok := columnKind(col.Type)
+		if !ok {
+			return nil, ErrUnsupportedType.New(col.Name, col.Type.String())
+		}
+
+		elem, _ := elementType(col.Type)
+		columns[i] = sqliteColumn{name: col.Name, typ: elem, kind: k, depth: depth}
+		definitions[i] = fmt.Sprintf("%s %s", quoteIdentifier(col.Name), sqliteType(k, depth))
+		placeholders[i] = "?"
+	}
+
+	create := fmt.Sprintf(
+		"CREATE TABLE %s (%s)",
+		quoteIdentifier(name),
+		strings.Join(definitions, ", "),
+	)
+	if _, err := s.db.Exec(create); err != nil {
+		return nil, err
+	}
+
+	w := &sqliteWriter{
+		db:      s.db,
+		columns: columns,
+		insert: fmt.Sprintf(
+			"INSERT INTO %s VALUES (%s)",
+			quoteIdentifier(name),
+			strings.Join(placeholders, ", "),
+		),
+	}
+
+	if err := w.begin(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// CreateIndex creates an index on the given columns of a table.
+func (s *SQLite) CreateIndex(table string, columns ...string) error {
+	var quoted = make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = quoteIdentifier(c)
+	}
+
+	_, err := s.db.Exec(fmt.Sprintf(
+		"CREATE INDEX %s ON %s (%s)",
+		quoteIdentifier(fmt.Sprintf("%s_%s_idx", table, strings.Join(columns, "_"))),
+		quoteIdentifier(table),
+		strings.Join(quoted, ", "),
+	))
+	return err
+}
+
+// Close closes the database.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+func sqliteType(k kind, depth int) string {
+	if depth > 0 {
+		return "TEXT"
+	}
+
+	switch k {
+	case kindBinary:
+		return "BLOB"
+	case kindBool, kindInt8, kindInt16, kindInt32, kindInt64,
+		kindUint8, kindUint16, kindUint32, kindUint64:
+		return "INTEGER"
+	case kindFloat32, kindFloat64:
+		return "REAL"
+	default:
+		return "TEXT"
+	}
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+type sqliteColumn struct {
+	name  string
+	typ   sql.Type
+	kind  kind
+	depth int
+}
+
+// sqliteWriter inserts rows in a table, committing them in batches.
+type sqliteWriter struct {
+	db      *gosql.DB
+	insert  string
+	columns []sqliteColumn
+	tx      *gosql.Tx
+	stmt    *gosql.Stmt
+	rows    int
+	values  []interface{}
+}
+
+func (w *sqliteWriter) begin() error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(w.insert)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	w.tx, w.stmt = tx, stmt
+	return nil
+}
+
+func (w *sqliteWriter) commit() error {
+	if err := w.stmt.Close(); err != nil {
+		_ = w.tx.Rollback()
+		return err
+	}
+
+	return w.tx.Commit()
+}
+
+// Write implements the Writer interface.
+func (w *sqliteWriter) Write(row sql.Row) error {
+	if w.values == nil {
+		w.values = make([]interface{}, len(w.columns))
+	}
+
+	for i, c := range w.columns {
+		v, err := c.value(row[i])
+		if err != nil {
+			return err
+		}
+		w.values[i] = v
+	}
+
+	if _, err := w.stmt.Exec(w.values...); err != nil {
+		return err
+	}
+
+	w.rows++
+	if w.rows%sqliteBatchRows == 0 {
+		if err := w.commit(); err != nil {
+			return err
+		}
+		return w.begin()
+	}
+
+	return nil
+}
+
+// Close implements the Writer interface.
+func (w *sqliteWriter) Close() error {
+	return w.commit()
+}
+
+// value returns the value to store in SQLite for a value of the column.
+func (c sqliteColumn) value(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	if c.depth > 0 {
+		v, err := c.jsonValue(v, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	}
+
+	return c.leafValue(v)
+}
+
+// leafValue converts a non-nil value that is not an array.
+func (c sqliteColumn) leafValue(v interface{}) (interface{}, error) {
+	v, err := convertValue(c.kind, c.typ, v)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := v.(type) {
+	case uint64:
+		return int64(v), nil
+	case time.Time:
+		if c.kind == kindDate {
+			return v.Format(sqliteDateLayout), nil
+		}
+		return v.Format(sqliteTimestampLayout), nil
+	default:
+		if c.kind == kindJSON {
+			return string(v.([]byte)), nil
+		}
+		return v, nil
+	}
+}
+
+// jsonValue converts the elements of an array to values that can be encoded
+// as JSON.
+func (c sqliteColumn) jsonValue(v interface{}, level int) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	if level == c.depth {
+		v, err := c.leafValue(v)
+		if err != nil {
+			return nil, err
+		}
+
+		switch v := v.(type) {
+		case []byte:
+			return string(v), nil
+		case string:
+			if c.kind == kindJSON {
+				return json.RawMessage(v), nil
+			}
+		}
+
+		return v, nil
+	}
+
+	values, ok := v.([]interface{})
+	if !ok {
+		return nil, ErrUnexpectedValue.New(v, c.name)
+	}
+
+	var result = make([]interface{}, len(values))
+	for i, val := range values {
+		val, err := c.jsonValue(val, level+1)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = val
+	}
+
+	return result, nil
+}
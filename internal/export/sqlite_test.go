@@ -0,0 +1,75 @@
+package export
+
+import (
+	gosql "database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLite(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitbase-export")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out.sqlite")
+	db, err := OpenSQLite(path)
+	require.NoError(err)
+
+	w, err := db.CreateTable("test", testSchema)
+	require.NoError(err)
+	for _, row := range testRows {
+		require.NoError(w.Write(row))
+	}
+	require.NoError(w.Close())
+	require.NoError(db.CreateIndex("test", "name", "size"))
+
+	_, err = db.CreateTable("invalid", sql.Schema{
+		{Name: "t", Type: sql.Tuple(sql.Int64, sql.Int64)},
+	})
+	require.True(ErrUnsupportedType.Is(err))
+	require.NoError(db.Close())
+
+	conn, err := gosql.Open("sqlite3", path)
+	require.NoError(err)
+	defer conn.Close()
+
+	rows, err := conn.Query(`SELECT name, size, "when", parents, blob FROM test`)
+	require.NoError(err)
+	defer rows.Close()
+
+	type result struct {
+		name, when, parents gosql.NullString
+		size                gosql.NullInt64
+		blob                []byte
+	}
+
+	var results []result
+	for rows.Next() {
+		var r result
+		require.NoError(rows.Scan(&r.name, &r.size, &r.when, &r.parents, &r.blob))
+		results = append(results, r)
+	}
+	require.NoError(rows.Err())
+	require.Len(results, 3)
+
+	require.Equal("a", results[0].name.String)
+	require.Equal(int64(1), results[0].size.Int64)
+	require.Equal("2017-07-14 02:40:00", results[0].when.String)
+	require.Equal(`["x","y"]`, results[0].parents.String)
+	require.Equal([]byte{0, 1}, results[0].blob)
+
+	require.False(results[1].when.Valid)
+	require.Equal("[]", results[1].parents.String)
+	require.Nil(results[1].blob)
+
+	require.False(results[2].name.Valid)
+	require.False(results[2].parents.Valid)
+	require.Equal("1970-01-01 00:00:00", results[2].when.String)
+}
@@ -0,0 +1,110 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Types of the thrift compact protocol.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs using the thrift compact protocol, which is
+// the one used by the parquet metadata. It only implements the types needed
+// to write parquet files.
+type thriftWriter struct {
+	buf bytes.Buffer
+	// fields is the stack of the last field id written in each of the
+	// structs being written.
+	fields []int16
+	tmp    [binary.MaxVarintLen64]byte
+}
+
+func (w *thriftWriter) Bytes() []byte {
+	return w.buf.Bytes()
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	n := binary.PutUvarint(w.tmp[:], v)
+	w.buf.Write(w.tmp[:n])
+}
+
+func (w *thriftWriter) zigzag(v int64) {
+	w.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *thriftWriter) fieldHeader(id int16, typ byte) {
+	last := w.fields[len(w.fields)-1]
+	if delta := id - last; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.zigzag(int64(id))
+	}
+	w.fields[len(w.fields)-1] = id
+}
+
+// StructBegin starts a struct, either at the top level or as an element of
+// a list.
+func (w *thriftWriter) StructBegin() {
+	w.fields = append(w.fields, 0)
+}
+
+// FieldStructBegin starts a struct as the field with the given id.
+func (w *thriftWriter) FieldStructBegin(id int16) {
+	w.fieldHeader(id, thriftStruct)
+	w.StructBegin()
+}
+
+// StructEnd finishes the current struct.
+func (w *thriftWriter) StructEnd() {
+	w.buf.WriteByte(0)
+	w.fields = w.fields[:len(w.fields)-1]
+}
+
+// FieldI32 writes a 32 bit integer field.
+func (w *thriftWriter) FieldI32(id int16, v int32) {
+	w.fieldHeader(id, thriftI32)
+	w.zigzag(int64(v))
+}
+
+// FieldI64 writes a 64 bit integer field.
+func (w *thriftWriter) FieldI64(id int16, v int64) {
+	w.fieldHeader(id, thriftI64)
+	w.zigzag(v)
+}
+
+// FieldString writes a string field.
+func (w *thriftWriter) FieldString(id int16, s string) {
+	w.fieldHeader(id, thriftBinary)
+	w.varint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+// FieldListBegin starts a list field with size elements of the given type.
+// Elements must be written right after it.
+func (w *thriftWriter) FieldListBegin(id int16, elemType byte, size int) {
+	w.fieldHeader(id, thriftList)
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		w.buf.WriteByte(0xf0 | elemType)
+		w.varint(uint64(size))
+	}
+}
+
+// I32 writes a 32 bit integer list element.
+func (w *thriftWriter) I32(v int32) {
+	w.zigzag(int64(v))
+}
+
+// String writes a string list element.
+func (w *thriftWriter) String(s string) {
+	w.varint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
@@ -16,7 +16,23 @@ const (
 	defaultLanguageCacheSize = 10000
 )
 
+// languageCacheSizeValue overrides the size of the cache set with the
+// environment variable when it's greater than zero.
+var languageCacheSizeValue int
+
+// SetLanguageCacheSize sets the maximum number of elements kept in the cache
+// of the language function. It must be called before running any query.
+func SetLanguageCacheSize(size int) {
+	languageMut.Lock()
+	languageCacheSizeValue = size
+	languageMut.Unlock()
+}
+
 func languageCacheSize() int {
+	if languageCacheSizeValue > 0 {
+		return languageCacheSizeValue
+	}
+
 	v := os.Getenv(languageCacheSizeKey)
 	size, err := strconv.Atoi(v)
 	if err != nil || size <= 0 {
@@ -37,12 +53,26 @@ func getLanguageCache(ctx *sql.Context) sql.KeyValueCache {
 	if languageCache == nil {
 		// Dispose function is ignored because the cache will never be disposed
 		// until the program dies.
-		languageCache, _ = ctx.Memory.NewLRUCache(uint(languageCacheSize()))
+		memory, _ := ctx.Memory.NewLRUCache(uint(languageCacheSize()))
+		languageCache = newTieredCache(memory, "language", encodeLanguage, decodeLanguage)
 	}
 
 	return languageCache
 }
 
+func encodeLanguage(v interface{}) ([]byte, error) {
+	lang, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid language of type %T", v)
+	}
+
+	return []byte(lang), nil
+}
+
+func decodeLanguage(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
 // Language gets the language of a file given its path and
 // the optional content of the file.
 type Language struct {
@@ -0,0 +1,384 @@
+package function
+
+import (
+	"container/list"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/go-mysql-server/sql"
+)
+
+const (
+	persistentCacheDirKey      = "GITBASE_PERSISTENT_CACHE_DIR"
+	persistentCacheSizeKey     = "GITBASE_PERSISTENT_CACHE_SIZE_MB"
+	defaultPersistentCacheSize = 1024 // MiB
+)
+
+var (
+	persistentCacheMut     sync.Mutex
+	persistentCacheDir     string
+	persistentCacheSize    int64
+	persistentCacheOpened  bool
+	persistentCacheStorage *persistentCache
+)
+
+// SetPersistentCache sets the directory and the maximum size in MiB of the
+// persistent cache of the uast, uast_mode and language functions, which
+// keeps their results between restarts. An empty directory or a size lower
+// than one use the ones set with the GITBASE_PERSISTENT_CACHE_DIR and
+// GITBASE_PERSISTENT_CACHE_SIZE_MB environment variables. The cache is
+// disabled if there is no directory. It must be called before running any
+// query.
+func SetPersistentCache(dir string, sizeMB int) {
+	persistentCacheMut.Lock()
+	persistentCacheDir = dir
+	persistentCacheSize = int64(sizeMB) * 1024 * 1024
+	persistentCacheMut.Unlock()
+}
+
+// getPersistentCache returns the persistent cache, opening it the first time
+// it's used, or nil if it's disabled or can't be opened.
+func getPersistentCache() *persistentCache {
+	persistentCacheMut.Lock()
+	defer persistentCacheMut.Unlock()
+
+	if persistentCacheOpened {
+		return persistentCacheStorage
+	}
+	persistentCacheOpened = true
+
+	dir := persistentCacheDir
+	if dir == "" {
+		dir = os.Getenv(persistentCacheDirKey)
+	}
+
+	if dir == "" {
+		return nil
+	}
+
+	size := persistentCacheSize
+	if size <= 0 {
+		mb, err := strconv.Atoi(os.Getenv(persistentCacheSizeKey))
+		if err != nil || mb <= 0 {
+			mb = defaultPersistentCacheSize
+		}
+
+		size = int64(mb) * 1024 * 1024
+	}
+
+	c, err := openPersistentCache(dir, size)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"dir":   dir,
+			"error": err,
+		}).Error("unable to open persistent cache, it will not be used")
+		return nil
+	}
+
+	persistentCacheStorage = c
+	return c
+}
+
+// persistentCacheKey is the key of an entry of the persistent cache. Keys
+// are only unique in their namespace, which is the name of the function
+// whose results are cached.
+type persistentCacheKey struct {
+	namespace string
+	key       uint64
+}
+
+type persistentCacheEntry struct {
+	key  persistentCacheKey
+	size int64
+}
+
+// persistentCache is a cache that keeps each entry in a file of a directory.
+// When the files take more than the maximum size, the least recently used
+// ones are removed. The modification time of the files is updated when they
+// are read, so the order is kept after restarting.
+type persistentCache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[persistentCacheKey]*list.Element
+}
+
+// openPersistentCache opens the cache in the given directory, creating it
+// if it does not exist, with the entries written by previous runs.
+func openPersistentCache(dir string, maxSize int64) (*persistentCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &persistentCache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[persistentCacheKey]*list.Element),
+	}
+
+	type file struct {
+		key     persistentCacheKey
+		size    int64
+		modTime time.Time
+	}
+
+	var files []file
+	namespaces, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ns := range namespaces {
+		if !ns.IsDir() {
+			continue
+		}
+
+		err := filepath.Walk(
+			filepath.Join(dir, ns.Name()),
+			func(path string, fi os.FileInfo, err error) error {
+				if err != nil || fi.IsDir() {
+					return err
+				}
+
+				key, err := strconv.ParseUint(fi.Name(), 16, 64)
+				if err != nil {
+					// Temporary files of interrupted writes.
+					return os.Remove(path)
+				}
+
+				files = append(files, file{
+					key:     persistentCacheKey{ns.Name(), key},
+					size:    fi.Size(),
+					modTime: fi.ModTime(),
+				})
+				return nil
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	for _, f := range files {
+		c.entries[f.key] = c.lru.PushBack(&persistentCacheEntry{f.key, f.size})
+		c.size += f.size
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
+	return c, nil
+}
+
+func (c *persistentCache) path(key persistentCacheKey) string {
+	return filepath.Join(
+		c.dir,
+		key.namespace,
+		fmt.Sprintf("%02x", key.key>>56),
+		fmt.Sprintf("%016x", key.key),
+	)
+}
+
+// Get returns the content of the entry with the given key.
+func (c *persistentCache) Get(key persistentCacheKey) ([]byte, error) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, sql.ErrKeyNotFound.New(key.key)
+	}
+
+	path := c.path(key)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		c.remove(key)
+		return nil, sql.ErrKeyNotFound.New(key.key)
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return data, nil
+}
+
+// Put writes the entry with the given key and content, removing the least
+// recently used entries if the cache is full.
+func (c *persistentCache) Put(key persistentCacheKey, data []byte) error {
+	size := int64(len(data))
+	if size > c.maxSize {
+		return nil
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// The content is written to a temporary file first, so entries are
+	// never read half written.
+	f, err := ioutil.TempFile(filepath.Dir(path), "tmp-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*persistentCacheEntry)
+		c.size -= entry.size
+		entry.size = size
+		c.lru.MoveToFront(e)
+	} else {
+		c.entries[key] = c.lru.PushFront(&persistentCacheEntry{key, size})
+	}
+
+	c.size += size
+	c.evict()
+	return nil
+}
+
+func (c *persistentCache) remove(key persistentCacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+// evict removes the least recently used entries until the cache is not
+// bigger than its maximum size. It must be called with the lock held.
+func (c *persistentCache) evict() {
+	for c.size > c.maxSize {
+		e := c.lru.Back()
+		if e == nil {
+			return
+		}
+
+		c.removeElement(e)
+	}
+}
+
+// removeElement removes an entry and its file. It must be called with the
+// lock held.
+func (c *persistentCache) removeElement(e *list.Element) {
+	entry := e.Value.(*persistentCacheEntry)
+	c.lru.Remove(e)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+
+	if err := os.Remove(c.path(entry.key)); err != nil && !os.IsNotExist(err) {
+		logrus.WithField("error", err).Warn("unable to remove persistent cache entry")
+	}
+}
+
+// tieredCache is a cache with an in-memory cache as first tier and the
+// persistent cache as second tier. Values found in the persistent cache are
+// added to the in-memory one.
+type tieredCache struct {
+	sql.KeyValueCache
+	persistent *persistentCache
+	namespace  string
+	encode     func(interface{}) ([]byte, error)
+	decode     func([]byte) (interface{}, error)
+}
+
+// newTieredCache returns the given in-memory cache backed by the persistent
+// cache, if it's enabled, using the given namespace for its keys.
+func newTieredCache(
+	memory sql.KeyValueCache,
+	namespace string,
+	encode func(interface{}) ([]byte, error),
+	decode func([]byte) (interface{}, error),
+) sql.KeyValueCache {
+	persistent := getPersistentCache()
+	if persistent == nil {
+		return memory
+	}
+
+	return &tieredCache{memory, persistent, namespace, encode, decode}
+}
+
+// Get implements the sql.KeyValueCache interface.
+func (c *tieredCache) Get(key uint64) (interface{}, error) {
+	value, err := c.KeyValueCache.Get(key)
+	if err == nil {
+		return value, nil
+	}
+
+	data, perr := c.persistent.Get(persistentCacheKey{c.namespace, key})
+	if perr != nil {
+		return nil, err
+	}
+
+	value, perr = c.decode(data)
+	if perr != nil {
+		logrus.WithField("error", perr).Warn("unable to decode persistent cache entry")
+		c.persistent.remove(persistentCacheKey{c.namespace, key})
+		return nil, err
+	}
+
+	if err := c.KeyValueCache.Put(key, value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// Put implements the sql.KeyValueCache interface. Errors writing to the
+// persistent cache are logged but not returned, as the value is kept in
+// the in-memory cache anyway.
+func (c *tieredCache) Put(key uint64, value interface{}) error {
+	if err := c.KeyValueCache.Put(key, value); err != nil {
+		return err
+	}
+
+	// Values encoded as nil are not persisted.
+	data, err := c.encode(value)
+	if err == nil && data != nil {
+		err = c.persistent.Put(persistentCacheKey{c.namespace, key}, data)
+	}
+
+	if err != nil {
+		logrus.WithField("error", err).Warn("unable to write persistent cache entry")
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package function
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bblfsh/sdk/v3/uast/nodes"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistentCache(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitbase-cache")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	c, err := openPersistentCache(dir, 10)
+	require.NoError(err)
+
+	k1 := persistentCacheKey{"uast", 1}
+	k2 := persistentCacheKey{"language", 1}
+	k3 := persistentCacheKey{"uast", 0xff00000000000003}
+
+	_, err = c.Get(k1)
+	require.True(sql.ErrKeyNotFound.Is(err))
+
+	require.NoError(c.Put(k1, []byte("aaaa")))
+	require.NoError(c.Put(k2, []byte("bbbb")))
+
+	data, err := c.Get(k1)
+	require.NoError(err)
+	require.Equal([]byte("aaaa"), data)
+
+	// k2 is the least recently used entry, so it's evicted.
+	require.NoError(c.Put(k3, []byte("cccc")))
+	_, err = c.Get(k2)
+	require.True(sql.ErrKeyNotFound.Is(err))
+	require.Equal(int64(8), c.size)
+
+	// Entries bigger than the cache are not kept.
+	require.NoError(c.Put(k2, []byte("too big for it")))
+	_, err = c.Get(k2)
+	require.True(sql.ErrKeyNotFound.Is(err))
+
+	// Leftovers of interrupted writes are removed when it's opened again.
+	tmp := filepath.Join(dir, "uast", "00", "tmp-123")
+	require.NoError(ioutil.WriteFile(tmp, []byte("x"), 0644))
+
+	c, err = openPersistentCache(dir, 10)
+	require.NoError(err)
+	require.Equal(int64(8), c.size)
+	_, err = os.Stat(tmp)
+	require.True(os.IsNotExist(err))
+
+	data, err = c.Get(k3)
+	require.NoError(err)
+	require.Equal([]byte("cccc"), data)
+
+	// A smaller size evicts the least recently used entries.
+	c, err = openPersistentCache(dir, 4)
+	require.NoError(err)
+	_, err = c.Get(k1)
+	require.True(sql.ErrKeyNotFound.Is(err))
+	data, err = c.Get(k3)
+	require.NoError(err)
+	require.Equal([]byte("cccc"), data)
+}
+
+func TestTieredCache(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "gitbase-cache")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	persistent, err := openPersistentCache(dir, 1024)
+	require.NoError(err)
+
+	newCache := func() sql.KeyValueCache {
+		memory, _ := sql.NewMemoryManager(nil).NewLRUCache(10)
+		return &tieredCache{
+			KeyValueCache: memory,
+			persistent:    persistent,
+			namespace:     "uast",
+			encode:        encodeUASTNode,
+			decode:        decodeUASTNode,
+		}
+	}
+
+	node := nodes.Object{
+		"@type": nodes.String("File"),
+		"Name":  nodes.String("main.go"),
+	}
+
+	cache := newCache()
+	require.NoError(cache.Put(1, node))
+	require.NoError(cache.Put(2, nil))
+
+	// A new in-memory cache reads the values from the persistent one.
+	cache = newCache()
+	value, err := cache.Get(1)
+	require.NoError(err)
+	require.True(nodes.Equal(node, value.(nodes.Node)))
+
+	_, err = cache.Get(2)
+	require.Error(err)
+
+	// Invalid entries are removed.
+	require.NoError(persistent.Put(persistentCacheKey{"uast", 3}, []byte("invalid")))
+	_, err = cache.Get(3)
+	require.Error(err)
+	_, err = persistent.Get(persistentCacheKey{"uast", 3})
+	require.True(sql.ErrKeyNotFound.Is(err))
+}
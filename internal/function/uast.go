@@ -27,6 +27,9 @@ const (
 
 	uastMaxBlobSizeKey     = "GITBASE_MAX_UAST_BLOB_SIZE"
 	defaultUASTMaxBlobSize = 5 * 1024 * 1024 // 5MB
+
+	uastConcurrencyKey     = "GITBASE_UAST_CONCURRENCY"
+	defaultUASTConcurrency = 1
 )
 
 var (
@@ -56,6 +59,7 @@ var (
 	uastCache       sql.KeyValueCache
 	uastCacheSize   int
 	uastMaxBlobSize int
+	uastConcurrency int
 )
 
 func getUASTCache(ctx *sql.Context) sql.KeyValueCache {
@@ -64,7 +68,8 @@ func getUASTCache(ctx *sql.Context) sql.KeyValueCache {
 	if uastCache == nil {
 		// Dispose function is ignored because the cache will never be disposed
 		// until the program dies.
-		uastCache, _ = ctx.Memory.NewLRUCache(uint(uastCacheSize))
+		memory, _ := ctx.Memory.NewLRUCache(uint(uastCacheSize))
+		uastCache = newTieredCache(memory, "uast", encodeUASTNode, decodeUASTNode)
 	}
 
 	return uastCache
@@ -83,6 +88,44 @@ func init() {
 	if err != nil {
 		uastMaxBlobSize = defaultUASTMaxBlobSize
 	}
+
+	uastConcurrency, err = strconv.Atoi(os.Getenv(uastConcurrencyKey))
+	if err != nil || uastConcurrency <= 0 {
+		uastConcurrency = defaultUASTConcurrency
+	}
+}
+
+// SetUASTCacheSize sets the maximum number of elements kept in the cache of
+// the uast and uast_mode functions. It must be called before running any
+// query.
+func SetUASTCacheSize(size int) {
+	uastmut.Lock()
+	uastCacheSize = size
+	uastmut.Unlock()
+}
+
+// SetUASTMaxBlobSize sets the maximum size in bytes of the blobs sent to
+// bblfsh to be parsed.
+func SetUASTMaxBlobSize(size int) {
+	uastMaxBlobSize = size
+}
+
+// SetUASTConcurrency sets the maximum number of rows whose uast, uast_mode
+// and uast_xpath functions are evaluated at the same time in a query. A
+// value of 1 evaluates them one row after another. It must be called before
+// running any query.
+func SetUASTConcurrency(n int) {
+	uastmut.Lock()
+	uastConcurrency = n
+	uastmut.Unlock()
+}
+
+// UASTConcurrency returns the maximum number of rows whose uast, uast_mode
+// and uast_xpath functions are evaluated at the same time in a query.
+func UASTConcurrency() int {
+	uastmut.Lock()
+	defer uastmut.Unlock()
+	return uastConcurrency
 }
 
 // uastFunc shouldn't be used as an sql.Expression itself.
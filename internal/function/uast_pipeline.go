@@ -0,0 +1,275 @@
+package function
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/plan"
+)
+
+// UASTPipeline is a node that evaluates UAST functions for the rows of its
+// child concurrently, so several blobs are parsed with bblfsh at the same
+// time. The values of the functions are appended to the rows, which are
+// returned in the same order they are read from the child.
+type UASTPipeline struct {
+	plan.UnaryNode
+	// UASTs are the expressions evaluated for each row.
+	UASTs []sql.Expression
+	// Concurrency is the maximum number of rows evaluated at the same time.
+	Concurrency int
+}
+
+var _ sql.Expressioner = (*UASTPipeline)(nil)
+
+// NewUASTPipeline creates a new UASTPipeline node.
+func NewUASTPipeline(
+	concurrency int,
+	uasts []sql.Expression,
+	child sql.Node,
+) *UASTPipeline {
+	return &UASTPipeline{
+		UnaryNode:   plan.UnaryNode{Child: child},
+		UASTs:       uasts,
+		Concurrency: concurrency,
+	}
+}
+
+// Schema implements the sql.Node interface.
+func (p *UASTPipeline) Schema() sql.Schema {
+	schema := append(sql.Schema(nil), p.Child.Schema()...)
+	for _, e := range p.UASTs {
+		schema = append(schema, &sql.Column{
+			Name:     e.String(),
+			Type:     e.Type(),
+			Nullable: e.IsNullable(),
+		})
+	}
+
+	return schema
+}
+
+// Resolved implements the sql.Node interface.
+func (p *UASTPipeline) Resolved() bool {
+	if !p.Child.Resolved() {
+		return false
+	}
+
+	for _, e := range p.UASTs {
+		if !e.Resolved() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RowIter implements the sql.Node interface.
+func (p *UASTPipeline) RowIter(ctx *sql.Context) (sql.RowIter, error) {
+	span, ctx := ctx.Span("gitbase.UASTPipeline")
+
+	iter, err := p.Child.RowIter(ctx)
+	if err != nil {
+		span.Finish()
+		return nil, err
+	}
+
+	return sql.NewSpanIter(
+		span,
+		newUASTPipelineIter(ctx, p.Concurrency, p.UASTs, iter),
+	), nil
+}
+
+func (p *UASTPipeline) String() string {
+	pr := sql.NewTreePrinter()
+	var exprs = make([]string, len(p.UASTs))
+	for i, e := range p.UASTs {
+		exprs[i] = e.String()
+	}
+
+	_ = pr.WriteNode(
+		"UASTPipeline(concurrency=%d, %s)",
+		p.Concurrency,
+		strings.Join(exprs, ", "),
+	)
+	_ = pr.WriteChildren(p.Child.String())
+	return pr.String()
+}
+
+// Expressions implements the sql.Expressioner interface.
+func (p *UASTPipeline) Expressions() []sql.Expression { return p.UASTs }
+
+// WithExpressions implements the sql.Expressioner interface.
+func (p *UASTPipeline) WithExpressions(exprs ...sql.Expression) (sql.Node, error) {
+	if len(exprs) != len(p.UASTs) {
+		return nil, sql.ErrInvalidChildrenNumber.New(p, len(exprs), len(p.UASTs))
+	}
+
+	return NewUASTPipeline(p.Concurrency, exprs, p.Child), nil
+}
+
+// WithChildren implements the sql.Node interface.
+func (p *UASTPipeline) WithChildren(children ...sql.Node) (sql.Node, error) {
+	if len(children) != 1 {
+		return nil, sql.ErrInvalidChildrenNumber.New(p, len(children), 1)
+	}
+
+	return NewUASTPipeline(p.Concurrency, p.UASTs, children[0]), nil
+}
+
+// pipelineRow is a row whose UAST functions are being evaluated. The values
+// are appended to the row once ready is closed.
+type pipelineRow struct {
+	row   sql.Row
+	err   error
+	ready chan struct{}
+}
+
+// uastPipelineIter reads the rows of its child in a goroutine, evaluating
+// each one in a new goroutine up to the concurrency. The rows being
+// evaluated are queued in order, so the number of rows read in advance is
+// also bounded by the concurrency.
+type uastPipelineIter struct {
+	ctx   *sql.Context
+	exprs []sql.Expression
+	child sql.RowIter
+
+	queue   chan *pipelineRow
+	slots   chan struct{}
+	done    chan struct{}
+	reading sync.WaitGroup
+	workers sync.WaitGroup
+	closed  bool
+}
+
+func newUASTPipelineIter(
+	ctx *sql.Context,
+	concurrency int,
+	exprs []sql.Expression,
+	child sql.RowIter,
+) *uastPipelineIter {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	i := &uastPipelineIter{
+		ctx:   ctx,
+		exprs: exprs,
+		child: child,
+		queue: make(chan *pipelineRow, concurrency),
+		slots: make(chan struct{}, concurrency),
+		done:  make(chan struct{}),
+	}
+
+	i.reading.Add(1)
+	go i.read()
+	return i
+}
+
+func (i *uastPipelineIter) read() {
+	defer i.reading.Done()
+	defer close(i.queue)
+
+	for {
+		select {
+		case i.slots <- struct{}{}:
+		case <-i.done:
+			return
+		case <-i.ctx.Done():
+			i.push(&pipelineRow{err: i.ctx.Err(), ready: closedChan})
+			return
+		}
+
+		row, err := i.child.Next()
+		if err != nil {
+			<-i.slots
+			i.push(&pipelineRow{err: err, ready: closedChan})
+			return
+		}
+
+		r := &pipelineRow{row: row, ready: make(chan struct{})}
+		if !i.push(r) {
+			<-i.slots
+			return
+		}
+
+		i.workers.Add(1)
+		go i.eval(r)
+	}
+}
+
+// push queues a row, returning false if the iterator was closed.
+func (i *uastPipelineIter) push(r *pipelineRow) bool {
+	select {
+	case i.queue <- r:
+		return true
+	case <-i.done:
+		return false
+	}
+}
+
+func (i *uastPipelineIter) eval(r *pipelineRow) {
+	defer i.workers.Done()
+	defer func() { <-i.slots }()
+	defer close(r.ready)
+
+	row := make(sql.Row, len(r.row), len(r.row)+len(i.exprs))
+	copy(row, r.row)
+	for _, e := range i.exprs {
+		v, err := evalRecover(i.ctx, e, r.row)
+		if err != nil {
+			r.err = err
+			return
+		}
+
+		row = append(row, v)
+	}
+
+	r.row = row
+}
+
+// evalRecover evaluates the expression, returning the panics in other
+// goroutines as errors so they don't crash the server.
+func evalRecover(ctx *sql.Context, e sql.Expression, row sql.Row) (v interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: unknown error: %v", e, r)
+		}
+	}()
+
+	return e.Eval(ctx, row)
+}
+
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+func (i *uastPipelineIter) Next() (sql.Row, error) {
+	r, ok := <-i.queue
+	if !ok {
+		return nil, io.EOF
+	}
+
+	<-r.ready
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return r.row, nil
+}
+
+func (i *uastPipelineIter) Close() error {
+	if i.closed {
+		return nil
+	}
+	i.closed = true
+
+	close(i.done)
+	i.reading.Wait()
+	i.workers.Wait()
+	return i.child.Close()
+}
@@ -0,0 +1,121 @@
+package function
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/src-d/go-mysql-server/memory"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/plan"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUASTPipeline(t *testing.T) {
+	require := require.New(t)
+
+	table := memory.NewPartitionedTable("t", sql.Schema{
+		{Name: "i", Type: sql.Int64, Source: "t"},
+	}, 1)
+	for i := 0; i < 20; i++ {
+		require.NoError(table.Insert(sql.NewEmptyContext(), sql.NewRow(int64(i))))
+	}
+
+	slow := &slowExpr{delay: 20 * time.Millisecond}
+	node := NewUASTPipeline(
+		5,
+		[]sql.Expression{slow},
+		plan.NewResolvedTable(table),
+	)
+	require.True(node.Resolved())
+	require.Equal(sql.Schema{
+		{Name: "i", Type: sql.Int64, Source: "t"},
+		{Name: "slow(i)", Type: sql.Int64},
+	}, node.Schema())
+
+	start := time.Now()
+	iter, err := node.RowIter(sql.NewEmptyContext())
+	require.NoError(err)
+
+	rows, err := sql.RowIterToRows(iter)
+	require.NoError(err)
+
+	var expected []sql.Row
+	for i := 0; i < 20; i++ {
+		expected = append(expected, sql.NewRow(int64(i), int64(i*2)))
+	}
+
+	require.Equal(expected, rows)
+	require.Equal(int32(5), atomic.LoadInt32(&slow.max))
+	require.True(time.Since(start) < 20*20*time.Millisecond)
+}
+
+func TestUASTPipelineError(t *testing.T) {
+	require := require.New(t)
+
+	table := memory.NewPartitionedTable("t", sql.Schema{
+		{Name: "i", Type: sql.Int64, Source: "t"},
+	}, 1)
+	for i := 0; i < 10; i++ {
+		require.NoError(table.Insert(sql.NewEmptyContext(), sql.NewRow(int64(i))))
+	}
+
+	node := NewUASTPipeline(
+		3,
+		[]sql.Expression{&slowExpr{fail: 4}},
+		plan.NewResolvedTable(table),
+	)
+
+	iter, err := node.RowIter(sql.NewEmptyContext())
+	require.NoError(err)
+
+	for i := 0; i < 4; i++ {
+		row, err := iter.Next()
+		require.NoError(err)
+		require.Equal(sql.NewRow(int64(i), int64(i*2)), row)
+	}
+
+	_, err = iter.Next()
+	require.Error(err)
+	require.NoError(iter.Close())
+}
+
+// slowExpr doubles the first column of the row after a delay, keeping the
+// maximum number of evaluations run at the same time.
+type slowExpr struct {
+	delay   time.Duration
+	fail    int64
+	running int32
+	max     int32
+}
+
+var _ sql.Expression = (*slowExpr)(nil)
+
+func (e *slowExpr) Resolved() bool                                         { return true }
+func (e *slowExpr) IsNullable() bool                                       { return false }
+func (e *slowExpr) Type() sql.Type                                         { return sql.Int64 }
+func (e *slowExpr) Children() []sql.Expression                             { return nil }
+func (e *slowExpr) String() string                                         { return "slow(i)" }
+func (e *slowExpr) WithChildren(...sql.Expression) (sql.Expression, error) { return e, nil }
+
+func (e *slowExpr) Eval(ctx *sql.Context, row sql.Row) (interface{}, error) {
+	n := atomic.AddInt32(&e.running, 1)
+	defer atomic.AddInt32(&e.running, -1)
+
+	for {
+		max := atomic.LoadInt32(&e.max)
+		if n <= max || atomic.CompareAndSwapInt32(&e.max, max, n) {
+			break
+		}
+	}
+
+	time.Sleep(e.delay)
+
+	i := row[0].(int64)
+	if e.fail > 0 && i == e.fail {
+		return nil, fmt.Errorf("row %d failed", i)
+	}
+
+	return i * 2, nil
+}
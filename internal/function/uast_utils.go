@@ -156,6 +156,39 @@ func marshalNodes(arr nodes.Array) (interface{}, error) {
 	return buf.Bytes(), nil
 }
 
+// encodeUASTNode encodes a node to be kept in the persistent cache.
+func encodeUASTNode(v interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	n, ok := v.(nodes.Node)
+	if !ok {
+		return nil, fmt.Errorf("invalid UAST node of type %T", v)
+	}
+
+	if n == nil {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	if err := nodesproto.WriteTo(&buf, n); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodeUASTNode decodes a node encoded with encodeUASTNode.
+func decodeUASTNode(data []byte) (interface{}, error) {
+	n, err := nodesproto.ReadTree(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnmarshalUAST.New(err)
+	}
+
+	return n, nil
+}
+
 func getNodes(data interface{}) (nodes.Array, error) {
 	if data == nil {
 		return nil, nil
@@ -0,0 +1,289 @@
+package rule
+
+import (
+	"strings"
+
+	"github.com/src-d/gitbase"
+	"github.com/src-d/gitbase/internal/function"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/analyzer"
+	"github.com/src-d/go-mysql-server/sql/expression"
+	"github.com/src-d/go-mysql-server/sql/parse"
+	"github.com/src-d/go-mysql-server/sql/plan"
+)
+
+// CacheResultsRule name.
+const CacheResultsRule = "cache_results"
+
+// CacheResults wraps the queries in a CachedResults node, which keeps their
+// rows in the result cache of the session, if it has one. Only queries that
+// read tables with a checksum, and whose results only depend on the contents
+// of those tables, are cached. It must be the last post-analyze rule.
+//
+// Subqueries are analyzed with the text of the whole query, so the
+// CachedResults nodes added to them are removed.
+func CacheResults(
+	ctx *sql.Context,
+	a *analyzer.Analyzer,
+	n sql.Node,
+) (sql.Node, error) {
+	if !n.Resolved() {
+		return n, nil
+	}
+
+	if _, ok := n.(*gitbase.CachedResults); ok {
+		return n, nil
+	}
+
+	session, ok := ctx.Session.(*gitbase.Session)
+	if !ok || session.ResultCache() == nil || ctx.Query() == "" {
+		return n, nil
+	}
+
+	span, _ := ctx.Span("gitbase.CacheResults")
+	defer span.Finish()
+
+	n, err := removeCachedResults(n)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, ok := cacheableTables(a, n)
+	if !ok || !deterministicQuery(ctx) {
+		return n, nil
+	}
+
+	a.Log("caching results of query")
+	return gitbase.NewCachedResults(ctx.Query(), tables, n), nil
+}
+
+// removeCachedResults removes the CachedResults nodes of the node and its
+// subqueries.
+func removeCachedResults(n sql.Node) (sql.Node, error) {
+	n, err := plan.TransformUp(n, func(n sql.Node) (sql.Node, error) {
+		switch n := n.(type) {
+		case *gitbase.CachedResults:
+			return n.Child, nil
+		case *plan.SubqueryAlias:
+			// Subquery aliases are opaque, so they are not transformed.
+			child, err := removeCachedResults(n.Child)
+			if err != nil {
+				return nil, err
+			}
+
+			return plan.NewSubqueryAlias(n.Name(), child), nil
+		default:
+			return n, nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return plan.TransformExpressionsUp(n, func(e sql.Expression) (sql.Expression, error) {
+		s, ok := e.(*expression.Subquery)
+		if !ok {
+			return e, nil
+		}
+
+		q, err := removeCachedResults(s.Query)
+		if err != nil {
+			return nil, err
+		}
+
+		return s.WithQuery(q), nil
+	})
+}
+
+// cacheableTables returns the tables read by the node and whether its
+// results can be cached, which requires all its nodes to only read rows and
+// at least a table. Squashed tables are replaced by the tables they read.
+func cacheableTables(a *analyzer.Analyzer, n sql.Node) ([]sql.Table, bool) {
+	var tables []sql.Table
+	var ok = true
+	plan.Inspect(n, func(n sql.Node) bool {
+		if !ok || n == nil {
+			return false
+		}
+
+		switch n := n.(type) {
+		case *plan.ResolvedTable:
+			t, found := resolveTables(a, n.Table)
+			if !found || !cacheableFilters(a, n.Table, &tables) {
+				ok = false
+				return false
+			}
+
+			tables = append(tables, t...)
+			return true
+		case *plan.Project, *plan.Filter, *plan.GroupBy, *plan.Having,
+			*plan.Sort, *plan.Limit, *plan.Offset, *plan.Distinct,
+			*plan.OrderedDistinct, *plan.InnerJoin, *plan.LeftJoin,
+			*plan.RightJoin, *plan.CrossJoin, *plan.NaturalJoin,
+			*plan.SubqueryAlias, *plan.TableAlias, *plan.Generate,
+			*plan.Exchange, *function.UASTPipeline, *gitbase.TrackedQuery:
+		default:
+			ok = false
+			return false
+		}
+
+		if e, isExpressioner := n.(sql.Expressioner); isExpressioner {
+			for _, e := range e.Expressions() {
+				if !cacheableExpression(a, e, &tables) {
+					ok = false
+					return false
+				}
+			}
+		}
+
+		return true
+	})
+
+	return tables, ok && len(tables) > 0
+}
+
+// cacheableExpression returns whether the subqueries of the expression can
+// be cached, adding the tables they read.
+func cacheableExpression(a *analyzer.Analyzer, e sql.Expression, tables *[]sql.Table) bool {
+	var ok = true
+	expression.Inspect(e, func(e sql.Expression) bool {
+		if !ok || e == nil {
+			return false
+		}
+
+		if s, isSubquery := e.(*expression.Subquery); isSubquery {
+			t, cacheable := cacheableTables(a, s.Query)
+			if !cacheable {
+				ok = false
+			}
+			*tables = append(*tables, t...)
+		}
+
+		return ok
+	})
+
+	return ok
+}
+
+// nonDeterministicFunctions are the functions whose value depends on the
+// session or changes every time they are called, including the ones not
+// implemented yet.
+var nonDeterministicFunctions = map[string]struct{}{
+	"now":               {},
+	"current_timestamp": {},
+	"current_date":      {},
+	"current_time":      {},
+	"sysdate":           {},
+	"utc_timestamp":     {},
+	"utc_date":          {},
+	"utc_time":          {},
+	"unix_timestamp":    {},
+	"connection_id":     {},
+	"sleep":             {},
+	"database":          {},
+	"schema":            {},
+	"user":              {},
+	"current_user":      {},
+	"session_user":      {},
+	"system_user":       {},
+	"rand":              {},
+	"uuid":              {},
+	"uuid_short":        {},
+	"last_insert_id":    {},
+	"found_rows":        {},
+	"row_count":         {},
+}
+
+// deterministicQuery returns whether the query doesn't use variables or
+// non-deterministic functions. The query is parsed again because the
+// analyzer replaces them with their values when they are constant, so they
+// can't be found in the analyzed node.
+func deterministicQuery(ctx *sql.Context) bool {
+	node, err := parse.Parse(ctx, ctx.Query())
+	if err != nil {
+		return false
+	}
+
+	return deterministicNode(node)
+}
+
+func deterministicNode(n sql.Node) bool {
+	var ok = true
+	plan.InspectExpressions(n, func(e sql.Expression) bool {
+		if !ok {
+			return false
+		}
+
+		switch e := e.(type) {
+		case *expression.UnresolvedFunction:
+			_, found := nonDeterministicFunctions[strings.ToLower(e.Name())]
+			ok = !found
+		case *expression.UnresolvedColumn:
+			// User and session variables start with @.
+			ok = !strings.HasPrefix(e.Name(), "@") &&
+				!strings.HasPrefix(e.Table(), "@")
+		case *expression.Subquery:
+			ok = deterministicNode(e.Query)
+		}
+
+		return ok
+	})
+
+	return ok
+}
+
+// cacheableFilters returns whether the subqueries of the filters pushed
+// down to the table can be cached, adding the tables they read.
+func cacheableFilters(a *analyzer.Analyzer, t sql.Table, tables *[]sql.Table) bool {
+	for {
+		if ft, ok := t.(interface{ Filters() []sql.Expression }); ok {
+			for _, f := range ft.Filters() {
+				if !cacheableExpression(a, f, tables) {
+					return false
+				}
+			}
+		}
+
+		w, ok := t.(interface{ Unwrap() sql.Table })
+		if !ok {
+			return true
+		}
+		t = w.Unwrap()
+	}
+}
+
+// resolveTables returns the tables read by the given table, which must have
+// a checksum.
+func resolveTables(a *analyzer.Analyzer, t sql.Table) ([]sql.Table, bool) {
+	for {
+		w, ok := t.(interface{ Unwrap() sql.Table })
+		if !ok {
+			break
+		}
+		t = w.Unwrap()
+	}
+
+	if st, ok := t.(*gitbase.SquashedTable); ok {
+		db, err := a.Catalog.Database(a.Catalog.CurrentDatabase())
+		if err != nil {
+			return nil, false
+		}
+
+		var tables []sql.Table
+		for _, name := range st.Tables() {
+			table, ok := db.Tables()[name]
+			if !ok {
+				return nil, false
+			}
+			tables = append(tables, table)
+		}
+
+		return tables, true
+	}
+
+	if _, ok := t.(sql.Checksumable); !ok {
+		return nil, false
+	}
+
+	return []sql.Table{t}, true
+}
@@ -0,0 +1,132 @@
+package rule
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-borges/libraries"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/analyzer"
+	sqlfunction "github.com/src-d/go-mysql-server/sql/expression/function"
+	"github.com/src-d/go-mysql-server/sql/parse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheResults(t *testing.T) {
+	pool := gitbase.NewRepositoryPool(nil, libraries.New(nil))
+	catalog := sql.NewCatalog()
+	catalog.AddDatabase(gitbase.NewDatabase("foo", pool))
+	catalog.SetCurrentDatabase("foo")
+	catalog.MustRegister(sqlfunction.Defaults...)
+	catalog.MustRegister(sql.Function0{
+		Name: "database",
+		Fn:   sqlfunction.NewDatabase(catalog),
+	})
+	a := analyzer.NewBuilder(catalog).
+		AddPostAnalyzeRule(SquashJoinsRule, SquashJoins).
+		AddPostAnalyzeRule(CacheResultsRule, CacheResults).
+		Build()
+	a.Batches[len(a.Batches)-1].Rules = a.Batches[len(a.Batches)-1].Rules[1:]
+
+	session := gitbase.NewSession(pool, gitbase.WithResultCache(
+		gitbase.NewResultCache(1024*1024, 0),
+	))
+
+	testCases := []struct {
+		query  string
+		tables []string
+	}{
+		{
+			`SELECT commit_hash FROM commits WHERE commit_author_name = 'foo'`,
+			[]string{"commits"},
+		},
+		{
+			`SELECT r.ref_name, c.commit_hash
+			FROM refs r
+			INNER JOIN commits c ON r.commit_hash = c.commit_hash`,
+			[]string{"commits", "refs"},
+		},
+		{
+			`SELECT t.commit_hash
+			FROM (SELECT commit_hash FROM commits) t
+			WHERE t.commit_hash IN (SELECT commit_hash FROM ref_commits)`,
+			[]string{"commits", "ref_commits"},
+		},
+		{
+			`SELECT COUNT(*) FROM commits GROUP BY committer_email ORDER BY 1 LIMIT 5`,
+			[]string{"commits"},
+		},
+		{`SELECT commit_hash, NOW() FROM commits`, nil},
+		{`SELECT commit_hash, DATABASE() FROM commits`, nil},
+		{`SELECT commit_hash FROM commits WHERE @@gitbase_result_cache = 1`, nil},
+		{`SELECT commit_hash FROM commits WHERE committer_when < NOW()`, nil},
+		{
+			`SELECT r.ref_name, c.commit_hash
+			FROM refs r
+			INNER JOIN commits c ON r.commit_hash = c.commit_hash
+			WHERE c.committer_when < NOW()`,
+			nil,
+		},
+		{`SELECT 1`, nil},
+		{`SHOW TABLES`, nil},
+		{`DESCRIBE TABLE commits`, nil},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.query, func(t *testing.T) {
+			require := require.New(t)
+			ctx := sql.NewContext(
+				context.Background(),
+				sql.WithSession(session),
+				sql.WithQuery(tt.query),
+			)
+
+			node, err := parse.Parse(ctx, tt.query)
+			require.NoError(err)
+
+			result, err := a.Analyze(ctx, node)
+			require.NoError(err)
+
+			cached, ok := result.(*gitbase.CachedResults)
+			if tt.tables == nil {
+				require.False(ok, result.String())
+				require.NotContains(result.String(), "CachedResults")
+				return
+			}
+
+			require.True(ok, result.String())
+			require.Equal(tt.query, cached.Query)
+			require.Equal(1, strings.Count(result.String(), "CachedResults"))
+
+			var tables []string
+			seen := make(map[string]struct{})
+			for _, t := range cached.Tables {
+				if _, ok := seen[t.Name()]; !ok {
+					seen[t.Name()] = struct{}{}
+					tables = append(tables, t.Name())
+				}
+			}
+			sort.Strings(tables)
+			require.Equal(tt.tables, tables)
+		})
+	}
+
+	// queries are not cached when the cache is disabled in the session
+	session.Set(gitbase.ResultCacheVar, sql.Boolean, false)
+	query := `SELECT commit_hash FROM commits`
+	ctx := sql.NewContext(
+		context.Background(),
+		sql.WithSession(session),
+		sql.WithQuery(query),
+	)
+
+	node, err := parse.Parse(ctx, query)
+	require.NoError(t, err)
+
+	result, err := a.Analyze(ctx, node)
+	require.NoError(t, err)
+	require.NotContains(t, result.String(), "CachedResults")
+}
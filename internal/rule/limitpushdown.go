@@ -0,0 +1,164 @@
+package rule
+
+import (
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/analyzer"
+	"github.com/src-d/go-mysql-server/sql/expression"
+	"github.com/src-d/go-mysql-server/sql/plan"
+)
+
+// LimitPushdownRule name.
+const LimitPushdownRule = "limit_pushdown"
+
+// LimitPushdown pushes the LIMIT of the queries down to the gitbase tables
+// and squashed tables, so they stop reading rows and opening partitions once
+// they have returned enough rows. It only applies when every row of the
+// table reaches the LIMIT, with projections and aliases in between.
+//
+// LIMIT with ORDER BY history_index of the ref_commits table is pushed down
+// as a filter of the history indexes lower than the LIMIT, as every row with
+// a history index of n has at least n rows with a lower one in the same
+// reference. The table stops walking the history of each reference once no
+// commit left can be at that depth. It must run after SquashJoins.
+func LimitPushdown(
+	ctx *sql.Context,
+	a *analyzer.Analyzer,
+	n sql.Node,
+) (sql.Node, error) {
+	if !n.Resolved() {
+		return n, nil
+	}
+
+	span, _ := ctx.Span("gitbase.LimitPushdown")
+	defer span.Finish()
+
+	return plan.TransformUp(n, func(n sql.Node) (sql.Node, error) {
+		limit, ok := n.(*plan.Limit)
+		if !ok {
+			return n, nil
+		}
+
+		return pushdownLimit(a, limit)
+	})
+}
+
+func pushdownLimit(a *analyzer.Analyzer, limit *plan.Limit) (sql.Node, error) {
+	rows := limit.Limit
+	var sort *plan.Sort
+	var alias string
+
+	node := limit.Child
+	if offset, ok := node.(*plan.Offset); ok {
+		rows += offset.Offset
+		node = offset.Child
+	}
+
+	for {
+		switch n := node.(type) {
+		case *plan.Project:
+			node = n.Child
+		case *plan.TableAlias:
+			alias = n.Name()
+			node = n.Child
+		case *plan.Sort:
+			if sort != nil {
+				return limit, nil
+			}
+
+			sort = n
+			node = n.Child
+		case *plan.ResolvedTable:
+			table, ok := limitTable(a, n.Table, rows, sort, alias)
+			if !ok {
+				return limit, nil
+			}
+
+			return replaceTable(limit, n, table)
+		default:
+			return limit, nil
+		}
+	}
+}
+
+// limitTable returns the given table limited to the given number of rows,
+// or false if the limit can't be pushed down.
+func limitTable(
+	a *analyzer.Analyzer,
+	table sql.Table,
+	rows int64,
+	sort *plan.Sort,
+	alias string,
+) (sql.Table, bool) {
+	if sort != nil {
+		return historyIndexLimitTable(a, table, rows, sort, alias)
+	}
+
+	switch table.(type) {
+	case gitbase.Table, *gitbase.SquashedTable:
+		a.Log("pushing down limit %d to table %s", rows, table.Name())
+		return gitbase.NewLimitedTable(table, rows), true
+	default:
+		return nil, false
+	}
+}
+
+// historyIndexLimitTable returns the ref_commits table with a filter of the
+// history indexes lower than the given number of rows if the rows are
+// sorted by ascending history index.
+func historyIndexLimitTable(
+	a *analyzer.Analyzer,
+	table sql.Table,
+	rows int64,
+	sort *plan.Sort,
+	alias string,
+) (sql.Table, bool) {
+	ft, ok := table.(gitbase.Table)
+	if !ok || table.Name() != gitbase.RefCommitsTableName {
+		return nil, false
+	}
+
+	if len(sort.SortFields) != 1 || sort.SortFields[0].Order != plan.Ascending {
+		return nil, false
+	}
+
+	field, ok := sort.SortFields[0].Column.(*expression.GetField)
+	if !ok || field.Name() != "history_index" ||
+		(field.Table() != table.Name() && field.Table() != alias) {
+		return nil, false
+	}
+
+	idx := gitbase.RefCommitsSchema.IndexOf("history_index", gitbase.RefCommitsTableName)
+	filter := expression.NewLessThan(
+		expression.NewGetFieldWithTable(
+			idx,
+			sql.Int64,
+			gitbase.RefCommitsTableName,
+			"history_index",
+			false,
+		),
+		expression.NewLiteral(rows, sql.Int64),
+	)
+
+	filters := ft.Filters()
+	for _, f := range filters {
+		if f.String() == filter.String() {
+			return nil, false
+		}
+	}
+
+	a.Log("pushing down limit %d as a history_index filter of table %s", rows, table.Name())
+	return ft.WithFilters(append(filters[:len(filters):len(filters)], filter)), true
+}
+
+// replaceTable returns the node with the given resolved table replaced by
+// a new one with the given table.
+func replaceTable(n sql.Node, old *plan.ResolvedTable, table sql.Table) (sql.Node, error) {
+	return plan.TransformUp(n, func(n sql.Node) (sql.Node, error) {
+		if n == old {
+			return plan.NewResolvedTable(table), nil
+		}
+
+		return n, nil
+	})
+}
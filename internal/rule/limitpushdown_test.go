@@ -0,0 +1,143 @@
+package rule
+
+import (
+	"testing"
+
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-borges/libraries"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/analyzer"
+	"github.com/src-d/go-mysql-server/sql/parse"
+	"github.com/src-d/go-mysql-server/sql/plan"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeLimitPushdown(t *testing.T) {
+	catalog := sql.NewCatalog()
+	catalog.AddDatabase(
+		gitbase.NewDatabase("foo", gitbase.NewRepositoryPool(nil, libraries.New(nil))),
+	)
+	a := analyzer.NewBuilder(catalog).
+		WithParallelism(2).
+		AddPostAnalyzeRule(SquashJoinsRule, SquashJoins).
+		AddPostAnalyzeRule(LimitPushdownRule, LimitPushdown).
+		Build()
+	a.Batches[len(a.Batches)-1].Rules = a.Batches[len(a.Batches)-1].Rules[1:]
+
+	testCases := []struct {
+		query string
+		limit int64
+	}{
+		{`SELECT * FROM commits LIMIT 10`, 10},
+		{`SELECT commit_hash FROM commits c LIMIT 5 OFFSET 3`, 8},
+		{`SELECT * FROM refs NATURAL JOIN commits LIMIT 2`, 2},
+		{`SELECT * FROM commits WHERE commit_hash = 'foo' LIMIT 3`, 3},
+		{`SELECT * FROM commits LIMIT 0`, 0},
+		{`SELECT * FROM commits WHERE commit_author_name LIKE '%foo%' LIMIT 3`, 3},
+		{`SELECT * FROM commits ORDER BY commit_hash LIMIT 3`, -1},
+		{`SELECT commit_author_name FROM commits GROUP BY commit_author_name LIMIT 3`, -1},
+		{`SELECT * FROM commits c1 INNER JOIN commits c2 ON c1.commit_hash = c2.commit_hash LIMIT 3`, -1},
+		{`SELECT DISTINCT commit_author_name FROM commits LIMIT 3`, -1},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.query, func(t *testing.T) {
+			require := require.New(t)
+			ctx := sql.NewEmptyContext()
+
+			node, err := parse.Parse(ctx, tt.query)
+			require.NoError(err)
+
+			result, err := a.Analyze(ctx, node)
+			require.NoError(err)
+
+			var limited []*gitbase.LimitedTable
+			plan.Inspect(result, func(n sql.Node) bool {
+				if rt, ok := n.(*plan.ResolvedTable); ok {
+					if lt, ok := rt.Table.(*gitbase.LimitedTable); ok {
+						limited = append(limited, lt)
+					}
+				}
+				return true
+			})
+
+			if tt.limit < 0 {
+				require.Empty(limited)
+				return
+			}
+
+			require.Len(limited, 1)
+			require.Equal(tt.limit, limited[0].Limit())
+		})
+	}
+}
+
+func TestAnalyzeLimitPushdownHistoryIndex(t *testing.T) {
+	catalog := sql.NewCatalog()
+	catalog.AddDatabase(
+		gitbase.NewDatabase("foo", gitbase.NewRepositoryPool(nil, libraries.New(nil))),
+	)
+	a := analyzer.NewBuilder(catalog).
+		AddPostAnalyzeRule(LimitPushdownRule, LimitPushdown).
+		Build()
+	a.Batches[len(a.Batches)-1].Rules = a.Batches[len(a.Batches)-1].Rules[1:]
+
+	testCases := []struct {
+		query  string
+		filter string
+	}{
+		{
+			`SELECT * FROM ref_commits ORDER BY history_index LIMIT 10`,
+			"ref_commits.history_index < 10",
+		},
+		{
+			`SELECT commit_hash FROM ref_commits r ORDER BY r.history_index ASC LIMIT 5 OFFSET 5`,
+			"ref_commits.history_index < 10",
+		},
+		{
+			`SELECT * FROM ref_commits ORDER BY history_index DESC LIMIT 10`,
+			"",
+		},
+		{
+			`SELECT * FROM ref_commits ORDER BY history_index, commit_hash LIMIT 10`,
+			"",
+		},
+		{
+			`SELECT * FROM ref_commits ORDER BY commit_hash LIMIT 10`,
+			"",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.query, func(t *testing.T) {
+			require := require.New(t)
+			ctx := sql.NewEmptyContext()
+
+			node, err := parse.Parse(ctx, tt.query)
+			require.NoError(err)
+
+			result, err := a.Analyze(ctx, node)
+			require.NoError(err)
+
+			var filters []string
+			plan.Inspect(result, func(n sql.Node) bool {
+				if rt, ok := n.(*plan.ResolvedTable); ok {
+					_, ok := rt.Table.(*gitbase.LimitedTable)
+					require.False(ok)
+
+					for _, f := range rt.Table.(gitbase.Table).Filters() {
+						filters = append(filters, f.String())
+					}
+				}
+				return true
+			})
+
+			if tt.filter == "" {
+				require.Empty(filters)
+				return
+			}
+
+			require.Equal([]string{tt.filter}, filters)
+		})
+	}
+}
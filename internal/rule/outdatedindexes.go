@@ -0,0 +1,121 @@
+package rule
+
+import (
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/analyzer"
+	"github.com/src-d/go-mysql-server/sql/plan"
+)
+
+// OutdatedIndexesRule name.
+const OutdatedIndexesRule = "outdated_indexes"
+
+// OutdatedIndexes removes the index lookups of the tables using indexes that
+// were created before the library of the repository pool was replaced, that
+// is, indexes whose checksum doesn't match the one of the pool anymore.
+// Those indexes don't contain the repositories added after they were created,
+// so the tables are read without them. It must run before SquashJoins.
+func OutdatedIndexes(
+	ctx *sql.Context,
+	a *analyzer.Analyzer,
+	n sql.Node,
+) (sql.Node, error) {
+	if !n.Resolved() {
+		return n, nil
+	}
+
+	session, ok := ctx.Session.(*gitbase.Session)
+	if !ok || session.Pool == nil || session.Pool.Generation() == 0 {
+		return n, nil
+	}
+
+	span, _ := ctx.Span("gitbase.OutdatedIndexes")
+	defer span.Finish()
+
+	var checksum string
+	var refreshed bool
+	return plan.TransformUp(n, func(n sql.Node) (sql.Node, error) {
+		rt, ok := n.(*plan.ResolvedTable)
+		if !ok {
+			return n, nil
+		}
+
+		table, ok := rt.Table.(sql.IndexableTable)
+		if !ok || table.IndexLookup() == nil {
+			return n, nil
+		}
+
+		var err error
+		if checksum == "" {
+			checksum, err = session.Pool.Checksum()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		outdated, err := hasOutdatedIndexes(a, table.IndexLookup(), checksum)
+		if err != nil {
+			return nil, err
+		}
+
+		// The cached checksum may be older than the index if the
+		// repositories were modified, so it's computed again before
+		// discarding the index.
+		if outdated && !refreshed {
+			session.Pool.InvalidateChecksum()
+			refreshed = true
+
+			checksum, err = session.Pool.Checksum()
+			if err != nil {
+				return nil, err
+			}
+
+			outdated, err = hasOutdatedIndexes(a, table.IndexLookup(), checksum)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !outdated {
+			return n, nil
+		}
+
+		a.Log("removing outdated indexes %v of table %s", table.IndexLookup().Indexes(), rt.Name())
+		return plan.NewResolvedTable(table.WithIndexLookup(nil)), nil
+	})
+}
+
+// hasOutdatedIndexes returns whether any of the indexes of the lookup has a
+// checksum different from the given one. Indexes are always assigned from
+// the current database.
+func hasOutdatedIndexes(
+	a *analyzer.Analyzer,
+	lookup sql.IndexLookup,
+	checksum string,
+) (bool, error) {
+	db := a.Catalog.CurrentDatabase()
+	for _, id := range lookup.Indexes() {
+		idx := a.Catalog.Index(db, id)
+		if idx == nil {
+			continue
+		}
+
+		c, ok := idx.(sql.Checksumable)
+		if !ok {
+			a.Catalog.ReleaseIndex(idx)
+			continue
+		}
+
+		idxChecksum, err := c.Checksum()
+		a.Catalog.ReleaseIndex(idx)
+		if err != nil {
+			return false, err
+		}
+
+		if idxChecksum != checksum {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
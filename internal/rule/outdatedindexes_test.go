@@ -0,0 +1,91 @@
+package rule
+
+import (
+	"context"
+	"testing"
+
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-borges/libraries"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/analyzer"
+	"github.com/src-d/go-mysql-server/sql/plan"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutdatedIndexes(t *testing.T) {
+	require := require.New(t)
+
+	pool := gitbase.NewRepositoryPool(nil, libraries.New(nil))
+	db := gitbase.NewDatabase("foo", pool)
+
+	catalog := sql.NewCatalog()
+	catalog.AddDatabase(db)
+	catalog.SetCurrentDatabase("foo")
+	a := analyzer.NewBuilder(catalog).Build()
+
+	checksum, err := pool.Checksum()
+	require.NoError(err)
+
+	addIndex := func(id, checksum string) {
+		created, ready, err := catalog.AddIndex(&dummyIndex{id: id, checksum: checksum})
+		require.NoError(err)
+		close(created)
+		<-ready
+	}
+	addIndex("current", checksum)
+	addIndex("outdated", "outdated")
+
+	table := db.Tables()[gitbase.CommitsTableName].(sql.IndexableTable)
+	node := func(indexes ...string) sql.Node {
+		return plan.NewProject(nil, plan.NewResolvedTable(
+			table.WithIndexLookup(&idsLookup{indexes}),
+		))
+	}
+
+	lookup := func(n sql.Node) sql.IndexLookup {
+		rt := n.(*plan.Project).Child.(*plan.ResolvedTable)
+		return rt.Table.(sql.IndexableTable).IndexLookup()
+	}
+
+	ctx := sql.NewContext(context.Background(), sql.WithSession(gitbase.NewSession(pool)))
+
+	// the library of the pool was never replaced
+	result, err := OutdatedIndexes(ctx, a, node("outdated"))
+	require.NoError(err)
+	require.NotNil(lookup(result))
+
+	pool.SetLibrary(libraries.New(nil))
+
+	result, err = OutdatedIndexes(ctx, a, node("current"))
+	require.NoError(err)
+	require.NotNil(lookup(result))
+
+	result, err = OutdatedIndexes(ctx, a, node("current", "outdated"))
+	require.NoError(err)
+	require.Nil(lookup(result))
+}
+
+type dummyIndex struct {
+	id       string
+	checksum string
+}
+
+var _ sql.Checksumable = (*dummyIndex)(nil)
+
+func (i *dummyIndex) Get(...interface{}) (sql.IndexLookup, error) { return nil, nil }
+func (i *dummyIndex) Has(sql.Partition, ...interface{}) (bool, error) {
+	return false, nil
+}
+func (i *dummyIndex) ID() string                { return i.id }
+func (i *dummyIndex) Database() string          { return "foo" }
+func (i *dummyIndex) Table() string             { return gitbase.CommitsTableName }
+func (i *dummyIndex) Expressions() []string     { return []string{i.id} }
+func (i *dummyIndex) Driver() string            { return "dummy" }
+func (i *dummyIndex) Checksum() (string, error) { return i.checksum, nil }
+
+type idsLookup struct {
+	indexes []string
+}
+
+func (l *idsLookup) Values(sql.Partition) (sql.IndexValueIter, error) { return nil, nil }
+func (l *idsLookup) Indexes() []string                                { return l.indexes }
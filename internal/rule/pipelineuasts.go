@@ -0,0 +1,134 @@
+package rule
+
+import (
+	"github.com/src-d/gitbase/internal/function"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/analyzer"
+	"github.com/src-d/go-mysql-server/sql/expression"
+	sqlfunction "github.com/src-d/go-mysql-server/sql/expression/function"
+	"github.com/src-d/go-mysql-server/sql/plan"
+)
+
+// PipelineUASTsRule name.
+const PipelineUASTsRule = "pipeline_uasts"
+
+// PipelineUASTs moves the uast, uast_mode and uast_xpath functions of the
+// projections to a UASTPipeline node below them, which evaluates them for
+// several rows at the same time, as many as the UAST concurrency. The
+// functions are replaced by fields with their values in the projections.
+//
+// Functions inside conditional expressions are not moved, as they may not
+// need to be evaluated at all. It does nothing if the UAST concurrency is 1.
+func PipelineUASTs(
+	ctx *sql.Context,
+	a *analyzer.Analyzer,
+	n sql.Node,
+) (sql.Node, error) {
+	if !n.Resolved() {
+		return n, nil
+	}
+
+	concurrency := function.UASTConcurrency()
+	if concurrency <= 1 {
+		return n, nil
+	}
+
+	span, _ := ctx.Span("gitbase.PipelineUASTs")
+	defer span.Finish()
+
+	return plan.TransformUp(n, func(n sql.Node) (sql.Node, error) {
+		project, ok := n.(*plan.Project)
+		if !ok {
+			return n, nil
+		}
+
+		if _, ok := project.Child.(*function.UASTPipeline); ok {
+			return n, nil
+		}
+
+		p := &uastPipelineBuilder{
+			offset: len(project.Child.Schema()),
+			fields: make(map[string]sql.Expression),
+		}
+
+		var changed bool
+		projections := make([]sql.Expression, len(project.Projections))
+		for i, e := range project.Projections {
+			ne, err := p.replace(e)
+			if err != nil {
+				return nil, err
+			}
+
+			changed = changed || ne != e
+			projections[i] = ne
+		}
+
+		if !changed {
+			return n, nil
+		}
+
+		a.Log("pipelining %d uast functions with concurrency %d", len(p.uasts), concurrency)
+
+		return plan.NewProject(
+			projections,
+			function.NewUASTPipeline(concurrency, p.uasts, project.Child),
+		), nil
+	})
+}
+
+// uastPipelineBuilder collects the uast functions of the projections, which
+// are replaced by fields of the columns the pipeline appends to the rows.
+type uastPipelineBuilder struct {
+	offset int
+	uasts  []sql.Expression
+	fields map[string]sql.Expression
+}
+
+// replace returns the expression with the outermost uast functions replaced
+// by fields, or the same expression if there are none.
+func (p *uastPipelineBuilder) replace(e sql.Expression) (sql.Expression, error) {
+	switch e.(type) {
+	case *function.UAST, *function.UASTMode, *function.UASTXPath:
+		return p.field(e), nil
+	case *expression.Case, *expression.And, *expression.Or,
+		*sqlfunction.IfNull, *sqlfunction.NullIf, *sqlfunction.Coalesce:
+		return e, nil
+	}
+
+	children := e.Children()
+	if len(children) == 0 {
+		return e, nil
+	}
+
+	var changed bool
+	newChildren := make([]sql.Expression, len(children))
+	for i, c := range children {
+		nc, err := p.replace(c)
+		if err != nil {
+			return nil, err
+		}
+
+		changed = changed || nc != c
+		newChildren[i] = nc
+	}
+
+	if !changed {
+		return e, nil
+	}
+
+	return e.WithChildren(newChildren...)
+}
+
+// field returns the field with the value of the given uast function, adding
+// it to the pipeline the first time it's seen.
+func (p *uastPipelineBuilder) field(e sql.Expression) sql.Expression {
+	name := e.String()
+	if f, ok := p.fields[name]; ok {
+		return f
+	}
+
+	f := expression.NewGetField(p.offset+len(p.uasts), e.Type(), name, e.IsNullable())
+	p.uasts = append(p.uasts, e)
+	p.fields[name] = f
+	return f
+}
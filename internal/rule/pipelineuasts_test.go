@@ -0,0 +1,139 @@
+package rule
+
+import (
+	"testing"
+
+	"github.com/src-d/gitbase"
+	"github.com/src-d/gitbase/internal/function"
+	"github.com/src-d/go-borges/libraries"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/analyzer"
+	"github.com/src-d/go-mysql-server/sql/expression"
+	sqlfunction "github.com/src-d/go-mysql-server/sql/expression/function"
+	"github.com/src-d/go-mysql-server/sql/parse"
+	"github.com/src-d/go-mysql-server/sql/plan"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzePipelineUASTs(t *testing.T) {
+	catalog := sql.NewCatalog()
+	catalog.AddDatabase(
+		gitbase.NewDatabase("foo", gitbase.NewRepositoryPool(nil, libraries.New(nil))),
+	)
+	catalog.MustRegister(sqlfunction.Defaults...)
+	catalog.MustRegister(function.Functions...)
+	a := analyzer.NewBuilder(catalog).
+		AddPostAnalyzeRule(PipelineUASTsRule, PipelineUASTs).
+		Build()
+	a.Batches[len(a.Batches)-1].Rules = a.Batches[len(a.Batches)-1].Rules[1:]
+
+	function.SetUASTConcurrency(4)
+	defer function.SetUASTConcurrency(1)
+
+	testCases := []struct {
+		query       string
+		projections []string
+		uasts       []string
+	}{
+		{
+			`SELECT file_path, uast(blob_content, 'Go') FROM files`,
+			[]string{"files.file_path", `uast(files.blob_content, "Go")`},
+			[]string{`uast(files.blob_content, "Go")`},
+		},
+		{
+			`SELECT uast_xpath(uast(blob_content, 'Go'), '//Identifier') AS ids,
+				uast_mode('semantic', blob_content, 'Go') AS sem,
+				uast(blob_content, 'Go') u1,
+				uast(blob_content, 'Go') u2
+			FROM files`,
+			[]string{
+				`uast_xpath(uast(files.blob_content, "Go"), "//Identifier") as ids`,
+				`uast_mode(files.blob_content, "semantic", "Go") as sem`,
+				`uast(files.blob_content, "Go") as u1`,
+				`uast(files.blob_content, "Go") as u2`,
+			},
+			[]string{
+				`uast_xpath(uast(files.blob_content, "Go"), "//Identifier")`,
+				`uast_mode(files.blob_content, "semantic", "Go")`,
+				`uast(files.blob_content, "Go")`,
+			},
+		},
+		{
+			`SELECT uast_children(uast(blob_content, 'Go')) FROM files`,
+			[]string{`uast_children(uast(files.blob_content, "Go"))`},
+			[]string{`uast(files.blob_content, "Go")`},
+		},
+		{
+			`SELECT IFNULL(uast(blob_content, 'Go'), '') FROM files`,
+			[]string{`ifnull(uast(files.blob_content, "Go"), "")`},
+			nil,
+		},
+		{
+			`SELECT file_path FROM files`,
+			[]string{"files.file_path"},
+			nil,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.query, func(t *testing.T) {
+			require := require.New(t)
+			ctx := sql.NewEmptyContext()
+
+			node, err := parse.Parse(ctx, tt.query)
+			require.NoError(err)
+
+			result, err := a.Analyze(ctx, node)
+			require.NoError(err)
+
+			var pipelines []*function.UASTPipeline
+			var project *plan.Project
+			plan.Inspect(result, func(n sql.Node) bool {
+				switch n := n.(type) {
+				case *function.UASTPipeline:
+					pipelines = append(pipelines, n)
+				case *plan.Project:
+					if project == nil {
+						project = n
+					}
+				}
+				return true
+			})
+
+			require.NotNil(project)
+			var projections []string
+			for _, e := range project.Projections {
+				projections = append(projections, e.String())
+			}
+			require.Equal(tt.projections, projections)
+
+			var calls int
+			for _, e := range project.Projections {
+				expression.Inspect(e, func(e sql.Expression) bool {
+					switch e.(type) {
+					case *function.UAST, *function.UASTMode, *function.UASTXPath:
+						calls++
+					}
+					return true
+				})
+			}
+
+			if tt.uasts == nil {
+				require.Empty(pipelines)
+				return
+			}
+
+			// All the functions are evaluated in the pipeline.
+			require.Zero(calls)
+
+			require.Len(pipelines, 1)
+			require.Equal(4, pipelines[0].Concurrency)
+
+			var uasts []string
+			for _, e := range pipelines[0].UASTs {
+				uasts = append(uasts, e.String())
+			}
+			require.Equal(tt.uasts, uasts)
+		})
+	}
+}
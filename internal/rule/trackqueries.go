@@ -0,0 +1,86 @@
+package rule
+
+import (
+	"github.com/src-d/gitbase"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/analyzer"
+	"github.com/src-d/go-mysql-server/sql/expression"
+	"github.com/src-d/go-mysql-server/sql/plan"
+)
+
+// TrackQueriesRule name.
+const TrackQueriesRule = "track_queries"
+
+// TrackQueries wraps the read queries of gitbase sessions in a TrackedQuery
+// node, which enforces the limits of the query on the rows it returns and
+// its execution time. It must be a post-validation rule, so it only runs
+// once per query.
+//
+// Subqueries are analyzed as queries too, so the TrackedQuery nodes added to
+// them are removed.
+func TrackQueries(
+	ctx *sql.Context,
+	a *analyzer.Analyzer,
+	n sql.Node,
+) (sql.Node, error) {
+	if !n.Resolved() {
+		return n, nil
+	}
+
+	if _, ok := ctx.Session.(*gitbase.Session); !ok {
+		return n, nil
+	}
+
+	switch n.(type) {
+	case *gitbase.TrackedQuery, *plan.CreateIndex, *plan.DropIndex,
+		*plan.InsertInto, *plan.DeleteFrom, *plan.Update, *plan.LockTables,
+		*plan.UnlockTables, *plan.CreateTable, *plan.DropTable, *plan.Set,
+		*plan.Use, *plan.Describe, *plan.DescribeQuery:
+		return n, nil
+	}
+
+	n, err := removeTrackedQueries(n)
+	if err != nil {
+		return nil, err
+	}
+
+	return gitbase.NewTrackedQuery(n), nil
+}
+
+// removeTrackedQueries removes the TrackedQuery nodes of the node and its
+// subqueries.
+func removeTrackedQueries(n sql.Node) (sql.Node, error) {
+	n, err := plan.TransformUp(n, func(n sql.Node) (sql.Node, error) {
+		switch n := n.(type) {
+		case *gitbase.TrackedQuery:
+			return n.Child, nil
+		case *plan.SubqueryAlias:
+			// Subquery aliases are opaque, so they are not transformed.
+			child, err := removeTrackedQueries(n.Child)
+			if err != nil {
+				return nil, err
+			}
+
+			return plan.NewSubqueryAlias(n.Name(), child), nil
+		default:
+			return n, nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return plan.TransformExpressionsUp(n, func(e sql.Expression) (sql.Expression, error) {
+		s, ok := e.(*expression.Subquery)
+		if !ok {
+			return e, nil
+		}
+
+		q, err := removeTrackedQueries(s.Query)
+		if err != nil {
+			return nil, err
+		}
+
+		return s.WithQuery(q), nil
+	})
+}
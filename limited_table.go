@@ -0,0 +1,128 @@
+package gitbase
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/src-d/go-mysql-server/sql"
+)
+
+// LimitedTable is a table that stops reading rows once its partitions have
+// returned a maximum number of them between all. Partitions are not opened
+// after that, so queries with a LIMIT only read the repositories they need.
+// It must only be used when all the rows of the table reach the LIMIT, that
+// is, when there are no filters, joins or sorts between them.
+type LimitedTable struct {
+	sql.Table
+	limit int64
+	// rows is the number of rows read from the partitions, shared between
+	// the partitions read in parallel.
+	rows *int64
+}
+
+var _ sql.PartitionCounter = (*LimitedTable)(nil)
+
+// NewLimitedTable creates a new table returning at most limit rows of the
+// given table.
+func NewLimitedTable(table sql.Table, limit int64) *LimitedTable {
+	return &LimitedTable{Table: table, limit: limit, rows: new(int64)}
+}
+
+// Limit returns the maximum number of rows returned by the table.
+func (t *LimitedTable) Limit() int64 { return t.limit }
+
+// Unwrap returns the table limited by this table.
+func (t *LimitedTable) Unwrap() sql.Table { return t.Table }
+
+func (t *LimitedTable) String() string {
+	p := sql.NewTreePrinter()
+	_ = p.WriteNode("Limit(%d)", t.limit)
+	_ = p.WriteChildren(fmt.Sprint(t.Table))
+	return p.String()
+}
+
+// Partitions implements the sql.Table interface. The count of rows read is
+// reset, as the partitions are listed every time the table is read.
+func (t *LimitedTable) Partitions(ctx *sql.Context) (sql.PartitionIter, error) {
+	atomic.StoreInt64(t.rows, 0)
+
+	iter, err := t.Table.Partitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedPartitionIter{iter, t}, nil
+}
+
+// PartitionCount implements the sql.PartitionCounter interface.
+func (t *LimitedTable) PartitionCount(ctx *sql.Context) (int64, error) {
+	if c, ok := t.Table.(sql.PartitionCounter); ok {
+		return c.PartitionCount(ctx)
+	}
+
+	iter, err := t.Table.Partitions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	return countPartitions(iter)
+}
+
+// PartitionRows implements the sql.Table interface.
+func (t *LimitedTable) PartitionRows(
+	ctx *sql.Context,
+	p sql.Partition,
+) (sql.RowIter, error) {
+	if t.done() {
+		return noRows, nil
+	}
+
+	iter, err := t.Table.PartitionRows(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedRowIter{iter, t}, nil
+}
+
+func (t *LimitedTable) done() bool {
+	return atomic.LoadInt64(t.rows) >= t.limit
+}
+
+type limitedPartitionIter struct {
+	sql.PartitionIter
+	table *LimitedTable
+}
+
+func (i *limitedPartitionIter) Next() (sql.Partition, error) {
+	if i.table.done() {
+		return nil, io.EOF
+	}
+
+	return i.PartitionIter.Next()
+}
+
+type limitedRowIter struct {
+	sql.RowIter
+	table *LimitedTable
+}
+
+func (i *limitedRowIter) Next() (sql.Row, error) {
+	if i.table.done() {
+		return nil, io.EOF
+	}
+
+	row, err := i.RowIter.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	// Other partitions may have reached the limit while this row was read.
+	if atomic.AddInt64(i.table.rows, 1) > i.table.limit {
+		return nil, io.EOF
+	}
+
+	return row, nil
+}
@@ -0,0 +1,69 @@
+package gitbase
+
+import (
+	"io"
+	"testing"
+
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitedTable(t *testing.T) {
+	require := require.New(t)
+	ctx, _, cleanup := setup(t)
+	defer cleanup()
+
+	table := NewLimitedTable(newCommitsTable(poolFromCtx(t, ctx)), 3)
+	require.Equal(int64(3), table.Limit())
+
+	rows, err := tableToRows(ctx, table)
+	require.NoError(err)
+	require.Len(rows, 3)
+
+	// The count of rows is reset every time the table is read.
+	rows, err = tableToRows(ctx, table)
+	require.NoError(err)
+	require.Len(rows, 3)
+
+	rows, err = tableToRows(ctx, NewLimitedTable(table.Unwrap(), 0))
+	require.NoError(err)
+	require.Len(rows, 0)
+
+	rows, err = tableToRows(ctx, NewLimitedTable(table.Unwrap(), 100))
+	require.NoError(err)
+	require.Len(rows, 9)
+}
+
+func TestLimitedTablePartitions(t *testing.T) {
+	require := require.New(t)
+	ctx, closed := setupSivaCloseRepos(t, "_testdata")
+
+	count, err := partitioned{}.PartitionCount(ctx)
+	require.NoError(err)
+	require.True(count > 1)
+
+	table := NewLimitedTable(newReferencesTable(poolFromCtx(t, ctx)), 1)
+
+	n, err := table.PartitionCount(ctx)
+	require.NoError(err)
+	require.Equal(count, n)
+
+	opened := len(closed.repos)
+	iter, err := table.Partitions(ctx)
+	require.NoError(err)
+
+	p, err := iter.Next()
+	require.NoError(err)
+
+	rows, err := sql.RowIterToRows(mustPartitionRows(t, ctx, table, p))
+	require.NoError(err)
+	require.Len(rows, 1)
+
+	// The limit was reached, so the rest of partitions are not read.
+	_, err = iter.Next()
+	require.Equal(io.EOF, err)
+	require.NoError(iter.Close())
+
+	require.Equal(1, len(closed.repos)-opened)
+	require.True(closed.Check())
+}
@@ -0,0 +1,355 @@
+package gitbase
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/plan"
+	errors "gopkg.in/src-d/go-errors.v1"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+const (
+	// MaxExecutionTimeVar is the session variable with the maximum time in
+	// milliseconds a query can run. 0 means no limit.
+	MaxExecutionTimeVar = "max_execution_time"
+	// MaxRowsVar is the session variable with the maximum number of rows a
+	// query can return. 0 means no limit.
+	MaxRowsVar = "gitbase_max_rows"
+	// MaxBlobBytesVar is the session variable with the maximum number of
+	// bytes of blob contents a query can read from the tables. 0 means no
+	// limit.
+	MaxBlobBytesVar = "gitbase_max_blob_bytes"
+)
+
+var (
+	// ErrMaxExecutionTime is returned when a query runs for longer than the
+	// maximum execution time.
+	ErrMaxExecutionTime = errors.NewKind("query exceeded the maximum execution time of %s, set by " + MaxExecutionTimeVar)
+	// ErrMaxRows is returned when a query returns more rows than allowed.
+	ErrMaxRows = errors.NewKind("query exceeded the maximum of %d rows returned, set by " + MaxRowsVar)
+	// ErrMaxBlobBytes is returned when a query reads more bytes of blob
+	// contents than allowed.
+	ErrMaxBlobBytes = errors.NewKind("query exceeded the maximum of %d bytes of blobs read, set by " + MaxBlobBytesVar)
+)
+
+// QueryLimits are the default limits of the queries of a session. Zero
+// values mean no limit.
+type QueryLimits struct {
+	// MaxExecutionTime is the maximum time a query can run.
+	MaxExecutionTime time.Duration
+	// MaxRows is the maximum number of rows a query can return.
+	MaxRows int64
+	// MaxBlobBytes is the maximum number of bytes of blob contents a query
+	// can read from the tables.
+	MaxBlobBytes int64
+}
+
+// setDefaults sets the limits as the values of the session variables.
+func (l QueryLimits) setDefaults(s sql.Session) {
+	s.Set(MaxExecutionTimeVar, sql.Int64, int64(l.MaxExecutionTime/time.Millisecond))
+	s.Set(MaxRowsVar, sql.Int64, l.MaxRows)
+	s.Set(MaxBlobBytesVar, sql.Int64, l.MaxBlobBytes)
+}
+
+// sessionQueryLimits returns the limits set in the session variables.
+func sessionQueryLimits(s sql.Session) QueryLimits {
+	return QueryLimits{
+		MaxExecutionTime: time.Duration(intSessionVar(s, MaxExecutionTimeVar)) * time.Millisecond,
+		MaxRows:          intSessionVar(s, MaxRowsVar),
+		MaxBlobBytes:     intSessionVar(s, MaxBlobBytesVar),
+	}
+}
+
+func intSessionVar(s sql.Session, key string) int64 {
+	_, val := s.Get(key)
+	if val == nil {
+		return 0
+	}
+
+	v, err := sql.Int64.Convert(val)
+	if err != nil || v.(int64) < 0 {
+		return 0
+	}
+
+	return v.(int64)
+}
+
+// check returns an error if the query was canceled or exceeded its maximum
+// execution time. The context of the query has a deadline when it has a
+// maximum execution time, but the time is also checked for the iterators
+// read without it.
+func (t *queryTracker) check(ctx context.Context) error {
+	max := t.limits.MaxExecutionTime
+	if err := ctx.Err(); err != nil {
+		if err == context.DeadlineExceeded && max > 0 {
+			return t.fail(ErrMaxExecutionTime.New(max))
+		}
+
+		return err
+	}
+
+	if max > 0 && time.Since(t.start) > max {
+		return t.fail(ErrMaxExecutionTime.New(max))
+	}
+
+	return nil
+}
+
+// readBlobBytes accounts the given number of bytes of blob contents read
+// by the query and returns an error if it exceeded its maximum.
+func (t *queryTracker) readBlobBytes(n int64) error {
+	bytes := atomic.AddInt64(&t.bytes, n)
+	if max := t.limits.MaxBlobBytes; max > 0 && bytes > max {
+		return t.fail(ErrMaxBlobBytes.New(max))
+	}
+
+	return nil
+}
+
+// readRow accounts a row read from a table by the query.
+func (t *queryTracker) readRow() {
+	atomic.AddInt64(&t.rows, 1)
+}
+
+// returnRow accounts a row returned by the query and returns an error if
+// the query exceeded its maximum number of rows.
+func (t *queryTracker) returnRow() error {
+	rows := atomic.AddInt64(&t.returned, 1)
+	if max := t.limits.MaxRows; max > 0 && rows > max {
+		return t.fail(ErrMaxRows.New(max))
+	}
+
+	return nil
+}
+
+// fail keeps the first limit exceeded by the query, so it's returned by the
+// query even if the iterator that found it ignores the error.
+func (t *queryTracker) fail(err error) error {
+	t.mu.Lock()
+	if t.err == nil {
+		t.err = err
+	}
+	t.mu.Unlock()
+	return err
+}
+
+// failure returns the first limit exceeded by the query, if any.
+func (t *queryTracker) failure() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// TrackedQuery is the root node of the queries of the sessions with limits
+// or statistics. The rows it returns count towards the maximum number of
+// rows of the query, and the context of its child has a deadline when the
+// query has a maximum execution time.
+type TrackedQuery struct {
+	plan.UnaryNode
+}
+
+// NewTrackedQuery creates a new TrackedQuery node.
+func NewTrackedQuery(child sql.Node) *TrackedQuery {
+	return &TrackedQuery{plan.UnaryNode{Child: child}}
+}
+
+// RowIter implements the sql.Node interface.
+func (n *TrackedQuery) RowIter(ctx *sql.Context) (sql.RowIter, error) {
+	// The execution time of the query starts to count here, as it's
+	// when the tracker of the query is created.
+	t := queryTrackerFromContext(ctx)
+	if t == nil {
+		return n.Child.RowIter(ctx)
+	}
+
+	cancel := func() {}
+	if max := t.limits.MaxExecutionTime; max > 0 {
+		var c context.Context
+		c, cancel = context.WithDeadline(ctx, t.start.Add(max))
+		ctx = ctx.WithContext(c)
+	}
+
+	iter, err := n.Child.RowIter(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &trackedQueryIter{ctx: ctx, iter: iter, tracker: t, cancel: cancel}, nil
+}
+
+// WithChildren implements the sql.Node interface.
+func (n *TrackedQuery) WithChildren(children ...sql.Node) (sql.Node, error) {
+	if len(children) != 1 {
+		return nil, sql.ErrInvalidChildrenNumber.New(n, len(children), 1)
+	}
+
+	return NewTrackedQuery(children[0]), nil
+}
+
+func (n *TrackedQuery) String() string {
+	pr := sql.NewTreePrinter()
+	_ = pr.WriteNode("TrackedQuery")
+	_ = pr.WriteChildren(n.Child.String())
+	return pr.String()
+}
+
+type trackedQueryIter struct {
+	ctx     *sql.Context
+	iter    sql.RowIter
+	tracker *queryTracker
+	cancel  context.CancelFunc
+}
+
+func (i *trackedQueryIter) Next() (sql.Row, error) {
+	row, err := i.iter.Next()
+
+	// Limits found by iterators that skip errors are returned anyway.
+	if ferr := i.tracker.failure(); ferr != nil {
+		return nil, ferr
+	}
+
+	if err != nil {
+		if err != io.EOF {
+			if cerr := i.tracker.check(i.ctx); cerr != nil {
+				return nil, cerr
+			}
+		}
+
+		return nil, err
+	}
+
+	if err := i.tracker.returnRow(); err != nil {
+		return nil, err
+	}
+
+	return row, nil
+}
+
+func (i *trackedQueryIter) Close() error {
+	defer i.cancel()
+	return i.iter.Close()
+}
+
+// limitObjects returns a copy of the repository whose objects check the
+// limits of the query when they are read, and account the bytes of the
+// contents of the blobs read.
+func limitObjects(ctx context.Context, repo *Repository, t *queryTracker) *Repository {
+	r := *repo.Repository
+	r.Storer = &limitedStorer{Storer: r.Storer, ctx: ctx, tracker: t}
+
+	nr := *repo
+	nr.Repository = &r
+	return &nr
+}
+
+// limitedStorer is a storer that checks the limits of the query every time
+// an object is read.
+type limitedStorer struct {
+	storage.Storer
+	ctx     context.Context
+	tracker *queryTracker
+}
+
+// EncodedObject implements the storer.EncodedObjectStorer interface.
+func (s *limitedStorer) EncodedObject(
+	t plumbing.ObjectType,
+	h plumbing.Hash,
+) (plumbing.EncodedObject, error) {
+	if err := s.tracker.check(s.ctx); err != nil {
+		return nil, err
+	}
+
+	o, err := s.Storer.EncodedObject(t, h)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.limitObject(o), nil
+}
+
+// IterEncodedObjects implements the storer.EncodedObjectStorer interface.
+func (s *limitedStorer) IterEncodedObjects(
+	t plumbing.ObjectType,
+) (storer.EncodedObjectIter, error) {
+	iter, err := s.Storer.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedObjectIter{EncodedObjectIter: iter, storer: s}, nil
+}
+
+func (s *limitedStorer) limitObject(o plumbing.EncodedObject) plumbing.EncodedObject {
+	if o.Type() != plumbing.BlobObject {
+		return o
+	}
+
+	return &limitedObject{EncodedObject: o, tracker: s.tracker}
+}
+
+type limitedObjectIter struct {
+	storer.EncodedObjectIter
+	storer *limitedStorer
+}
+
+func (i *limitedObjectIter) Next() (plumbing.EncodedObject, error) {
+	if err := i.storer.tracker.check(i.storer.ctx); err != nil {
+		return nil, err
+	}
+
+	o, err := i.EncodedObjectIter.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return i.storer.limitObject(o), nil
+}
+
+func (i *limitedObjectIter) ForEach(cb func(plumbing.EncodedObject) error) error {
+	return i.EncodedObjectIter.ForEach(func(o plumbing.EncodedObject) error {
+		if err := i.storer.tracker.check(i.storer.ctx); err != nil {
+			return err
+		}
+
+		return cb(i.storer.limitObject(o))
+	})
+}
+
+// limitedObject is a blob whose contents count towards the bytes of blobs
+// read by the query as they are read.
+type limitedObject struct {
+	plumbing.EncodedObject
+	tracker *queryTracker
+}
+
+func (o *limitedObject) Reader() (io.ReadCloser, error) {
+	r, err := o.EncodedObject.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedReader{ReadCloser: r, tracker: o.tracker}, nil
+}
+
+type limitedReader struct {
+	io.ReadCloser
+	tracker *queryTracker
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if lerr := r.tracker.readBlobBytes(int64(n)); lerr != nil {
+			return n, lerr
+		}
+	}
+
+	return n, err
+}
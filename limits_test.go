@@ -0,0 +1,124 @@
+package gitbase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/expression"
+	"github.com/src-d/go-mysql-server/sql/plan"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryLimits(t *testing.T) {
+	require := require.New(t)
+
+	ctx, _, cleanup := setup(t)
+	defer cleanup()
+
+	session := NewSession(poolFromCtx(t, ctx), WithQueryLimits(QueryLimits{
+		MaxRows: 5,
+	}))
+
+	var pid uint64
+	newCtx := func() *sql.Context {
+		pid++
+		return sql.NewContext(context.TODO(),
+			sql.WithSession(session),
+			sql.WithPid(pid),
+		)
+	}
+
+	typ, val := session.Get(MaxRowsVar)
+	require.Equal(sql.Int64, typ)
+	require.Equal(int64(5), val)
+
+	_, val = session.Get(MaxExecutionTimeVar)
+	require.Equal(int64(0), val)
+
+	commits := plan.NewResolvedTable(newCommitsTable(session.Pool))
+	_, err := sql.NodeToRows(newCtx(), NewTrackedQuery(commits))
+	require.True(ErrMaxRows.Is(err), err)
+
+	// only the rows returned by the query count
+	_, err = tableToRows(newCtx(), newCommitsTable(session.Pool))
+	require.NoError(err)
+
+	join := plan.NewCrossJoin(commits, plan.NewResolvedTable(newCommitsTable(session.Pool)))
+	rows, err := sql.NodeToRows(newCtx(), NewTrackedQuery(plan.NewLimit(5, join)))
+	require.NoError(err)
+	require.Len(rows, 5)
+
+	// limits can be changed with the session variables
+	session.Set(MaxRowsVar, sql.Int8, int8(0))
+	rows, err = sql.NodeToRows(newCtx(), NewTrackedQuery(commits))
+	require.NoError(err)
+	require.Len(rows, 9)
+
+	session.Set(MaxBlobBytesVar, sql.Int64, int64(100))
+	table := newBlobsTable(session.Pool).WithProjection([]string{"blob_content"})
+	_, err = tableToRows(newCtx(), table)
+	require.True(ErrMaxBlobBytes.Is(err), err)
+
+	// blobs dropped by filters count too
+	filtered := plan.NewFilter(
+		expression.NewLike(
+			expression.NewGetFieldWithTable(3, sql.Blob, BlobsTableName, "blob_content", false),
+			expression.NewLiteral("%nomatch%", sql.Text),
+		),
+		plan.NewResolvedTable(table),
+	)
+	_, err = sql.NodeToRows(newCtx(), NewTrackedQuery(filtered))
+	require.True(ErrMaxBlobBytes.Is(err), err)
+
+	// blob contents that are not read don't count
+	table = newBlobsTable(session.Pool).WithProjection([]string{"blob_hash"})
+	_, err = tableToRows(newCtx(), table)
+	require.NoError(err)
+
+	session.Set(MaxBlobBytesVar, sql.Int64, int64(0))
+	session.Set(MaxExecutionTimeVar, sql.Int64, int64(1))
+	ctx = newCtx()
+	require.NotNil(session.queryTracker(ctx))
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = tableToRows(ctx, newCommitsTable(session.Pool))
+	require.True(ErrMaxExecutionTime.Is(err), err)
+
+	// the context of the query is canceled when it exceeds its maximum
+	// execution time
+	session.Set(MaxExecutionTimeVar, sql.Int64, int64(10))
+	_, err = sql.NodeToRows(newCtx(), NewTrackedQuery(blockingNode{}))
+	require.True(ErrMaxExecutionTime.Is(err), err)
+
+	// sessions without limits don't keep track of queries
+	session.Set(MaxExecutionTimeVar, sql.Int64, int64(0))
+	require.Nil(session.queryTracker(newCtx()))
+}
+
+// blockingNode is a node whose rows are never returned until its context
+// is canceled.
+type blockingNode struct{}
+
+func (blockingNode) Resolved() bool       { return true }
+func (blockingNode) String() string       { return "blockingNode" }
+func (blockingNode) Schema() sql.Schema   { return nil }
+func (blockingNode) Children() []sql.Node { return nil }
+
+func (n blockingNode) WithChildren(...sql.Node) (sql.Node, error) {
+	return n, nil
+}
+
+func (blockingNode) RowIter(ctx *sql.Context) (sql.RowIter, error) {
+	return blockingIter{ctx}, nil
+}
+
+type blockingIter struct{ ctx *sql.Context }
+
+func (i blockingIter) Next() (sql.Row, error) {
+	<-i.ctx.Done()
+	return nil, i.ctx.Err()
+}
+
+func (blockingIter) Close() error { return nil }
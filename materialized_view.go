@@ -0,0 +1,554 @@
+package gitbase
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/src-d/go-mysql-server/sql"
+	errors "gopkg.in/src-d/go-errors.v1"
+	"vitess.io/vitess/go/vt/proto/query"
+)
+
+const (
+	// materializedViewFile is the file of the directory of a materialized
+	// view with its definition.
+	materializedViewFile = "view.json"
+	// materializedViewRowsExt is the extension of the files with the rows
+	// of each repository of a materialized view.
+	materializedViewRowsExt = ".rows"
+)
+
+var (
+	// ErrInvalidViewName is returned when the name of a materialized view
+	// is not a valid identifier.
+	ErrInvalidViewName = errors.NewKind("invalid materialized view name %q")
+	// ErrViewExists is returned when a materialized view is created in a
+	// directory that already has one.
+	ErrViewExists = errors.NewKind("materialized view %s already exists")
+	// ErrInvalidViewType is returned when the definition of a materialized
+	// view has a column type that is not supported.
+	ErrInvalidViewType = errors.NewKind("invalid type %s of column %s of materialized view %s")
+
+	viewNameRegexp = regexp.MustCompile(`^\w+$`)
+)
+
+func init() {
+	// Values of the rows of materialized views are encoded as interfaces,
+	// so the types that are not registered by default must be.
+	gob.Register(time.Time{})
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+}
+
+// MaterializedView is a table with the rows returned by a query, which are
+// kept in a directory. The query is run once for each repository, so the
+// rows of each repository are kept apart and are updated only when the
+// repository changes. Each repository is a partition of the table, and only
+// the repositories allowed in the pool of the session are read.
+type MaterializedView struct {
+	dir string
+
+	mu  sync.RWMutex
+	def materializedViewDefinition
+}
+
+var _ sql.Table = (*MaterializedView)(nil)
+var _ sql.Checksumable = (*MaterializedView)(nil)
+
+// materializedViewDefinition is the definition of a materialized view, which
+// is kept in its directory, with the checksums of the repositories whose rows
+// are in the view.
+type materializedViewDefinition struct {
+	Name         string                   `json:"name"`
+	Query        string                   `json:"query"`
+	Columns      []materializedViewColumn `json:"columns"`
+	Repositories map[string]string        `json:"repositories"`
+}
+
+type materializedViewColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// NewMaterializedView creates an empty materialized view with the given
+// name, query and schema in the given directory.
+func NewMaterializedView(
+	dir, name, query string,
+	schema sql.Schema,
+) (*MaterializedView, error) {
+	if !viewNameRegexp.MatchString(name) {
+		return nil, ErrInvalidViewName.New(name)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, materializedViewFile)); err == nil {
+		return nil, ErrViewExists.New(name)
+	}
+
+	columns := make([]materializedViewColumn, len(schema))
+	for i, col := range schema {
+		columns[i] = materializedViewColumn{
+			Name:     col.Name,
+			Type:     typeName(col.Type),
+			Nullable: col.Nullable,
+		}
+	}
+
+	v := &MaterializedView{
+		dir: dir,
+		def: materializedViewDefinition{
+			Name:         name,
+			Query:        query,
+			Columns:      columns,
+			Repositories: make(map[string]string),
+		},
+	}
+
+	if _, err := v.schema(); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := v.writeDefinition(v.def); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// OpenMaterializedView opens the materialized view in the given directory.
+func OpenMaterializedView(dir string) (*MaterializedView, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, materializedViewFile))
+	if err != nil {
+		return nil, err
+	}
+
+	v := &MaterializedView{dir: dir}
+	if err := json.Unmarshal(data, &v.def); err != nil {
+		return nil, fmt.Errorf("invalid materialized view in %s: %s", dir, err)
+	}
+
+	if v.def.Repositories == nil {
+		v.def.Repositories = make(map[string]string)
+	}
+
+	if _, err := v.schema(); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Name implements the sql.Table interface.
+func (v *MaterializedView) Name() string {
+	return v.def.Name
+}
+
+func (v *MaterializedView) String() string {
+	return fmt.Sprintf("MaterializedView(%s)", v.def.Name)
+}
+
+// Query returns the query of the view.
+func (v *MaterializedView) Query() string {
+	return v.def.Query
+}
+
+// Schema implements the sql.Table interface.
+func (v *MaterializedView) Schema() sql.Schema {
+	// The types were validated when the view was created or opened.
+	schema, _ := v.schema()
+	return schema
+}
+
+func (v *MaterializedView) schema() (sql.Schema, error) {
+	schema := make(sql.Schema, len(v.def.Columns))
+	for i, col := range v.def.Columns {
+		typ, err := parseTypeName(col.Type)
+		if err != nil {
+			return nil, ErrInvalidViewType.New(col.Type, col.Name, v.def.Name)
+		}
+
+		schema[i] = &sql.Column{
+			Name:     col.Name,
+			Type:     typ,
+			Nullable: col.Nullable,
+			Source:   v.def.Name,
+		}
+	}
+
+	return schema, nil
+}
+
+// Repositories returns the checksum of each repository whose rows are in
+// the view by repository ID.
+func (v *MaterializedView) Repositories() map[string]string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	repos := make(map[string]string, len(v.def.Repositories))
+	for id, checksum := range v.def.Repositories {
+		repos[id] = checksum
+	}
+
+	return repos
+}
+
+// Checksum implements the sql.Checksumable interface. It changes when the
+// rows of any repository are updated.
+func (v *MaterializedView) Checksum() (string, error) {
+	repos := v.Repositories()
+	ids := make([]string, 0, len(repos))
+	for id := range repos {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	hash := sha1.New()
+	hash.Write([]byte(v.def.Query))
+	for _, id := range ids {
+		hash.Write([]byte{0})
+		hash.Write([]byte(id))
+		hash.Write([]byte{0})
+		hash.Write([]byte(repos[id]))
+	}
+
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+// SetRepository replaces the rows of the repository with the given ID with
+// the rows of the given iterator, which were computed for the repository
+// with the given checksum. The iterator is not closed.
+func (v *MaterializedView) SetRepository(id, checksum string, iter sql.RowIter) error {
+	// The rows are written before taking the lock, as it may take a while
+	// to read them, and they replace the previous ones at once.
+	if err := v.writeRows(id, v.Schema(), iter); err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	def := v.def
+	def.Repositories = make(map[string]string, len(v.def.Repositories)+1)
+	for id, checksum := range v.def.Repositories {
+		def.Repositories[id] = checksum
+	}
+	def.Repositories[id] = checksum
+
+	if err := v.writeDefinition(def); err != nil {
+		return err
+	}
+
+	v.def = def
+	return nil
+}
+
+// RemoveRepository removes the rows of the repository with the given ID.
+func (v *MaterializedView) RemoveRepository(id string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.def.Repositories[id]; !ok {
+		return nil
+	}
+
+	def := v.def
+	def.Repositories = make(map[string]string, len(v.def.Repositories))
+	for repo, checksum := range v.def.Repositories {
+		if repo != id {
+			def.Repositories[repo] = checksum
+		}
+	}
+
+	if err := v.writeDefinition(def); err != nil {
+		return err
+	}
+
+	v.def = def
+	err := os.Remove(v.rowsPath(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes the directory of the view.
+func (v *MaterializedView) Delete() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return os.RemoveAll(v.dir)
+}
+
+// Partitions implements the sql.Table interface.
+func (v *MaterializedView) Partitions(ctx *sql.Context) (sql.PartitionIter, error) {
+	ids, err := v.allowedRepositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := make([]sql.Partition, len(ids))
+	for i, id := range ids {
+		partitions[i] = RepositoryPartition{ID: id}
+	}
+
+	return &materializedViewPartitionIter{partitions: partitions}, nil
+}
+
+// PartitionCount implements the sql.PartitionCounter interface.
+func (v *MaterializedView) PartitionCount(ctx *sql.Context) (int64, error) {
+	ids, err := v.allowedRepositories(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(ids)), nil
+}
+
+// PartitionRows implements the sql.Table interface.
+func (v *MaterializedView) PartitionRows(
+	ctx *sql.Context,
+	p sql.Partition,
+) (sql.RowIter, error) {
+	span, ctx := ctx.Span("gitbase.MaterializedView")
+
+	v.mu.RLock()
+	f, err := os.Open(v.rowsPath(string(p.Key())))
+	v.mu.RUnlock()
+	if os.IsNotExist(err) {
+		return sql.NewSpanIter(span, sql.RowsToRowIter()), nil
+	}
+
+	if err != nil {
+		span.Finish()
+		return nil, err
+	}
+
+	return sql.NewSpanIter(span, &materializedViewRowIter{
+		f:   f,
+		dec: gob.NewDecoder(f),
+	}), nil
+}
+
+// allowedRepositories returns the sorted IDs of the repositories of the view
+// allowed in the pool of the session.
+func (v *MaterializedView) allowedRepositories(ctx *sql.Context) ([]string, error) {
+	s, err := getSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for id := range v.Repositories() {
+		if s.Pool.Allowed(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (v *MaterializedView) rowsPath(id string) string {
+	return filepath.Join(v.dir, hex.EncodeToString([]byte(id))+materializedViewRowsExt)
+}
+
+// writeDefinition writes the definition of the view, replacing the previous
+// one only once it's completely written.
+func (v *MaterializedView) writeDefinition(def materializedViewDefinition) error {
+	data, err := json.MarshalIndent(def, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(v.dir, materializedViewFile), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeRows writes the rows of the repository with the given ID, replacing
+// the previous ones only once they are completely written.
+func (v *MaterializedView) writeRows(id string, schema sql.Schema, iter sql.RowIter) error {
+	return writeFileAtomic(v.rowsPath(id), func(w io.Writer) error {
+		enc := gob.NewEncoder(w)
+		for {
+			row, err := iter.Next()
+			if err == io.EOF {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			values := make([]materializedValue, len(row))
+			for i, val := range row {
+				if i < len(schema) && schema[i].Type == sql.JSON {
+					var err error
+					if val, err = normalizeJSON(val); err != nil {
+						return err
+					}
+				}
+
+				values[i] = materializedValue{val}
+			}
+
+			if err := enc.Encode(values); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// materializedValue is a value of a row of a materialized view. Values are
+// wrapped so their type is encoded along with them.
+type materializedValue struct {
+	V interface{}
+}
+
+type materializedViewRowIter struct {
+	f   *os.File
+	dec *gob.Decoder
+}
+
+func (i *materializedViewRowIter) Next() (sql.Row, error) {
+	var values []materializedValue
+	if err := i.dec.Decode(&values); err != nil {
+		return nil, err
+	}
+
+	row := make(sql.Row, len(values))
+	for j, v := range values {
+		// Empty slices are decoded as nil slices, which are not NULL.
+		switch val := v.V.(type) {
+		case []interface{}:
+			if val == nil {
+				v.V = []interface{}{}
+			}
+		case []byte:
+			if val == nil {
+				v.V = []byte{}
+			}
+		}
+
+		row[j] = v.V
+	}
+
+	return row, nil
+}
+
+func (i *materializedViewRowIter) Close() error {
+	return i.f.Close()
+}
+
+type materializedViewPartitionIter struct {
+	partitions []sql.Partition
+}
+
+func (i *materializedViewPartitionIter) Next() (sql.Partition, error) {
+	if len(i.partitions) == 0 {
+		return nil, io.EOF
+	}
+
+	p := i.partitions[0]
+	i.partitions = i.partitions[1:]
+	return p, nil
+}
+
+func (i *materializedViewPartitionIter) Close() error {
+	i.partitions = nil
+	return nil
+}
+
+// writeFileAtomic writes a file with the given function in a temporary file
+// that replaces the given one once it's written.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// normalizeJSON returns the value of a JSON column as the generic value
+// decoded from its JSON encoding, so it can be encoded as an interface.
+func normalizeJSON(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// typeName returns the name of the type, which is the name of its MySQL
+// type, wrapped in ARRAY() for arrays.
+func typeName(t sql.Type) string {
+	if sql.IsArray(t) {
+		return "ARRAY(" + typeName(sql.UnderlyingType(t)) + ")"
+	}
+
+	return t.Type().String()
+}
+
+// parseTypeName returns the type with the name returned by typeName.
+func parseTypeName(name string) (sql.Type, error) {
+	if strings.HasPrefix(name, "ARRAY(") && strings.HasSuffix(name, ")") {
+		t, err := parseTypeName(name[len("ARRAY(") : len(name)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		return sql.Array(t), nil
+	}
+
+	t, ok := query.Type_value[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %s", name)
+	}
+
+	return sql.MysqlTypeToType(query.Type(t))
+}
@@ -0,0 +1,150 @@
+package gitbase
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/src-d/go-borges/libraries"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaterializedView(t *testing.T) {
+	require := require.New(t)
+
+	tmp, err := ioutil.TempDir("", "gitbase-views")
+	require.NoError(err)
+	defer os.RemoveAll(tmp)
+
+	schema := sql.Schema{
+		{Name: "repository_id", Type: sql.Text},
+		{Name: "count", Type: sql.Int64},
+		{Name: "date", Type: sql.Timestamp},
+		{Name: "stats", Type: sql.JSON, Nullable: true},
+		{Name: "names", Type: sql.Array(sql.Text)},
+		{Name: "data", Type: sql.Blob, Nullable: true},
+	}
+
+	dir := filepath.Join(tmp, "stats")
+	v, err := NewMaterializedView(dir, "stats", "SELECT 1", schema)
+	require.NoError(err)
+	require.Equal("stats", v.Name())
+	require.Empty(v.Repositories())
+
+	_, err = NewMaterializedView(dir, "stats", "SELECT 1", schema)
+	require.True(ErrViewExists.Is(err))
+
+	_, err = NewMaterializedView(filepath.Join(tmp, "foo"), "foo-bar", "SELECT 1", schema)
+	require.True(ErrInvalidViewName.Is(err))
+
+	date := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	rowsA := []sql.Row{
+		sql.NewRow(
+			"a", int64(1), date,
+			struct {
+				Files int `json:"files"`
+			}{2},
+			[]interface{}{"foo", "bar"},
+			[]byte("data"),
+		),
+		sql.NewRow("a", int64(2), date, nil, []interface{}{}, nil),
+	}
+	rowsB := []sql.Row{
+		sql.NewRow("b", int64(3), date, nil, []interface{}{"baz"}, nil),
+	}
+
+	emptyChecksum, err := v.Checksum()
+	require.NoError(err)
+
+	require.NoError(v.SetRepository("a", "1", sql.RowsToRowIter(rowsA...)))
+	require.NoError(v.SetRepository("b", "2", sql.RowsToRowIter(rowsB...)))
+	require.Equal(map[string]string{"a": "1", "b": "2"}, v.Repositories())
+
+	checksum, err := v.Checksum()
+	require.NoError(err)
+	require.NotEqual(emptyChecksum, checksum)
+
+	// JSON values are kept as their generic JSON value
+	expected := []sql.Row{
+		sql.NewRow(
+			"a", int64(1), date,
+			map[string]interface{}{"files": float64(2)},
+			[]interface{}{"foo", "bar"},
+			[]byte("data"),
+		),
+		rowsA[1],
+		rowsB[0],
+	}
+
+	pool := NewRepositoryPool(nil, libraries.New(nil))
+	ctx := sql.NewContext(context.TODO(), sql.WithSession(NewSession(pool)))
+
+	v, err = OpenMaterializedView(dir)
+	require.NoError(err)
+	require.Equal("SELECT 1", v.Query())
+	for i, col := range v.Schema() {
+		require.Equal(schema[i].Name, col.Name)
+		require.Equal(schema[i].Type, col.Type)
+		require.Equal(schema[i].Nullable, col.Nullable)
+	}
+
+	rows, err := tableToRows(ctx, v)
+	require.NoError(err)
+	require.Equal(expected, rows)
+
+	count, err := v.PartitionCount(ctx)
+	require.NoError(err)
+	require.Equal(int64(2), count)
+
+	// only the repositories allowed in the session are read
+	ctx = sql.NewContext(context.TODO(), sql.WithSession(
+		NewSession(pool.WithRepositories("b")),
+	))
+	rows, err = tableToRows(ctx, v)
+	require.NoError(err)
+	require.Equal(expected[2:], rows)
+
+	require.NoError(v.RemoveRepository("b"))
+	require.Equal(map[string]string{"a": "1"}, v.Repositories())
+	rows, err = tableToRows(ctx, v)
+	require.NoError(err)
+	require.Empty(rows)
+
+	_, err = os.Stat(v.rowsPath("b"))
+	require.True(os.IsNotExist(err))
+
+	require.NoError(v.Delete())
+	_, err = os.Stat(dir)
+	require.True(os.IsNotExist(err))
+}
+
+func TestDatabaseMaterializedViews(t *testing.T) {
+	require := require.New(t)
+
+	tmp, err := ioutil.TempDir("", "gitbase-views")
+	require.NoError(err)
+	defer os.RemoveAll(tmp)
+
+	schema := sql.Schema{{Name: "repository_id", Type: sql.Text}}
+	db := NewDatabase(testDBName, NewRepositoryPool(nil, libraries.New(nil))).(*Database)
+
+	v, err := NewMaterializedView(filepath.Join(tmp, "foo"), "foo", "SELECT 1", schema)
+	require.NoError(err)
+	require.NoError(db.AddMaterializedView(v))
+	require.True(ErrTableExists.Is(db.AddMaterializedView(v)))
+	require.Equal(v, db.Tables()["foo"])
+	require.Equal([]*MaterializedView{v}, db.MaterializedViews())
+
+	v, err = NewMaterializedView(filepath.Join(tmp, "commits"), CommitsTableName, "SELECT 1", schema)
+	require.NoError(err)
+	require.True(ErrTableExists.Is(db.AddMaterializedView(v)))
+
+	db.RemoveMaterializedView("foo")
+	_, ok := db.MaterializedView("foo")
+	require.False(ok)
+	require.NotContains(db.Tables(), "foo")
+}
@@ -22,57 +22,108 @@ func (partitioned) PartitionCount(ctx *sql.Context) (int64, error) {
 		return 0, err
 	}
 
-	it, err := s.Pool.RepoIter()
-	if err != nil {
-		return 0, err
-	}
+	return s.Pool.partitionCount(RepositorySplit{}, func() (int64, error) {
+		it, err := s.Pool.RepoIter()
+		if err != nil {
+			return 0, err
+		}
 
+		var count int64
+		for {
+			r, err := it.Next()
+			if err == io.EOF {
+				return count, nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			_ = r.Close()
+			count++
+		}
+	})
+}
+
+// countPartitions returns the number of partitions left in the iterator.
+func countPartitions(iter sql.PartitionIter) (int64, error) {
 	var count int64
 	for {
-		_, err = it.Next()
+		_, err := iter.Next()
 		if err == io.EOF {
 			return count, nil
 		}
+
 		if err != nil {
 			return 0, err
 		}
+
 		count++
 	}
 }
 
-// RepositoryPartition represents a partition which is a repository id.
-type RepositoryPartition string
+// RepositoryPartition represents a partition which is a repository. It
+// holds the handle of the repository shared by the tables of the query, so
+// the repository opened to list the partitions is not opened again.
+type RepositoryPartition struct {
+	// ID is the repository id.
+	ID string
+
+	handle *repositoryHandle
+}
 
 // Key implements the sql.Partition interface.
 func (p RepositoryPartition) Key() []byte {
-	return []byte(p)
+	return []byte(p.ID)
 }
 
 type repositoryPartitionIter struct {
+	ctx        *sql.Context
+	session    *Session
 	repoIter   borges.RepositoryIterator
 	lib        borges.Library
 	skipErrors bool
+	split      RepositorySplit
+	pending    []sql.Partition
+	held       *repositoryHandle
 }
 
 func newRepositoryPartitionIter(ctx *sql.Context) (sql.PartitionIter, error) {
+	return newSplitPartitionIter(ctx, RepositorySplit{})
+}
+
+// newSplitPartitionIter returns an iterator of the partitions of the
+// repositories, splitting them as configured.
+func newSplitPartitionIter(
+	ctx *sql.Context,
+	split RepositorySplit,
+) (sql.PartitionIter, error) {
 	s, err := getSession(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	it, err := s.Pool.library.Repositories(borges.ReadOnlyMode)
+	lib := s.Pool.Library()
+	it, err := lib.Repositories(borges.ReadOnlyMode)
 	if err != nil {
 		return nil, err
 	}
 
 	return &repositoryPartitionIter{
+		ctx:        ctx,
+		session:    s,
 		repoIter:   it,
-		lib:        s.Pool.library,
+		lib:        lib,
 		skipErrors: s.SkipGitErrors,
+		split:      split,
 	}, nil
 }
 
 func (i *repositoryPartitionIter) Next() (sql.Partition, error) {
+	if len(i.pending) > 0 {
+		p := i.pending[0]
+		i.pending = i.pending[1:]
+		return p, nil
+	}
+
 	var r borges.Repository
 	var err error
 	for {
@@ -85,13 +136,29 @@ func (i *repositoryPartitionIter) Next() (sql.Partition, error) {
 		}
 	}
 
-	// TODO: RepositoryPartition should hold the repository so we don't
-	// get it twice.
-	defer r.Close()
-	return RepositoryPartition(r.ID().String()), nil
+	// The handle is held until the next repository is read, so the opened
+	// repository is still there when the partition is read right away.
+	i.release()
+	h := i.session.repositoryHandle(i.ctx, r.ID().String())
+	h.hold()
+	i.held = h
+
+	partitions := repositoryPartitions(r, h, i.split)
+	h.adopt(i.lib, r)
+
+	i.pending = partitions[1:]
+	return partitions[0], nil
+}
+
+func (i *repositoryPartitionIter) release() {
+	if i.held != nil {
+		i.held.unhold()
+		i.held = nil
+	}
 }
 
 func (i *repositoryPartitionIter) Close() error {
+	i.release()
 	if i.repoIter != nil {
 		i.repoIter.Close()
 	}
@@ -104,8 +171,14 @@ func (i *repositoryPartitionIter) Close() error {
 var ErrNoRepositoryPartition = errors.NewKind("%T not a valid repository partition")
 
 func getPartitionRepo(ctx *sql.Context, p sql.Partition) (*Repository, error) {
-	rp, ok := p.(RepositoryPartition)
-	if !ok {
+	var id string
+	var handle *repositoryHandle
+	switch rp := p.(type) {
+	case RepositoryPartition:
+		id, handle = rp.ID, rp.handle
+	case SplitPartition:
+		id, handle = rp.ID, rp.handle
+	default:
 		return nil, ErrNoRepositoryPartition.New(p)
 	}
 
@@ -114,7 +187,25 @@ func getPartitionRepo(ctx *sql.Context, p sql.Partition) (*Repository, error) {
 		return nil, err
 	}
 
-	return s.Pool.GetRepo(string(rp))
+	var repo *Repository
+	if handle != nil {
+		repo, err = handle.acquire()
+	} else {
+		repo, err = s.Pool.GetRepo(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t := s.queryTracker(ctx); t != nil {
+		repo = limitObjects(ctx, t.addRepository(repo), t)
+	}
+
+	if ps := profileStateFromContext(ctx); ps != nil {
+		repo = countObjects(repo, ps.objects)
+	}
+
+	return repo, nil
 }
 
 var errColumnNotFound = errors.NewKind("column %s not found in table %s")
@@ -134,7 +225,9 @@ func newTablePartitionIndexKeyValueIter(
 	colNames []string,
 	mapper rowKeyMapper,
 ) (*tablePartitionIndexKeyValueIter, error) {
-	partitions, err := t.Partitions(ctx)
+	// Index values are always stored per repository partition, even for
+	// tables that can split repositories.
+	partitions, err := newRepositoryPartitionIter(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -227,7 +320,9 @@ func newPartitionedIndexKeyValueIter(
 	columns []string,
 	builder indexKeyValueIterBuilder,
 ) (sql.PartitionIndexKeyValueIter, error) {
-	partitions, err := table.Partitions(ctx)
+	// Index values are always stored per repository partition, even for
+	// tables that can split repositories.
+	partitions, err := newRepositoryPartitionIter(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -279,12 +374,13 @@ func errorWithRepo(repo *Repository, err error) error {
 }
 
 type repoRowIter struct {
-	iter sql.RowIter
-	repo *Repository
+	iter    sql.RowIter
+	repo    *Repository
+	tracker *queryTracker
 }
 
-func newRepoRowIter(repo *Repository, iter sql.RowIter) sql.RowIter {
-	return &repoRowIter{iter, repo}
+func newRepoRowIter(ctx *sql.Context, repo *Repository, iter sql.RowIter) sql.RowIter {
+	return &repoRowIter{iter, repo, queryTrackerFromContext(ctx)}
 }
 
 func (i *repoRowIter) Next() (sql.Row, error) {
@@ -295,6 +391,11 @@ func (i *repoRowIter) Next() (sql.Row, error) {
 		}
 		return nil, errorWithRepo(i.repo, err)
 	}
+
+	if i.tracker != nil {
+		i.tracker.readRow()
+	}
+
 	return row, nil
 }
 
@@ -0,0 +1,466 @@
+package gitbase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/plan"
+)
+
+// Profile collects the rows produced, the time spent and the git objects read
+// by the tables of a query and, for squashed tables, by each one of the
+// iterators of their chain.
+type Profile struct {
+	mu    sync.Mutex
+	roots []*ProfileNode
+	nodes map[interface{}]*ProfileNode
+}
+
+// NewProfile creates a new empty profile.
+func NewProfile() *Profile {
+	return &Profile{nodes: make(map[interface{}]*ProfileNode)}
+}
+
+// Instrument returns the given node with its tables replaced by tables that
+// collect their statistics in the profile.
+func (p *Profile) Instrument(n sql.Node) (sql.Node, error) {
+	return plan.TransformUp(n, func(n sql.Node) (sql.Node, error) {
+		t, ok := n.(*plan.ResolvedTable)
+		if !ok {
+			return n, nil
+		}
+
+		if _, ok := t.Table.(*profiledTable); ok {
+			return n, nil
+		}
+
+		node := p.node(t.Table, nil, tableName(t.Table))
+		return plan.NewResolvedTable(&profiledTable{t.Table, p, node}), nil
+	})
+}
+
+// Roots returns the nodes of the tables of the profile.
+func (p *Profile) Roots() []*ProfileNode {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*ProfileNode(nil), p.roots...)
+}
+
+// node returns the node for the given key, creating it as a child of parent
+// if it does not exist yet.
+func (p *Profile) node(key interface{}, parent *ProfileNode, name string) *ProfileNode {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if n, ok := p.nodes[key]; ok {
+		return n
+	}
+
+	n := &ProfileNode{Name: name}
+	p.nodes[key] = n
+	if parent == nil {
+		p.roots = append(p.roots, n)
+	} else {
+		parent.Children = append(parent.Children, n)
+	}
+
+	return n
+}
+
+// ProfileNode contains the statistics of a table or an iterator of a
+// squashed table. Times are inclusive of the ones of its children.
+type ProfileNode struct {
+	// rows, nanos, objects and partitions go first so they are aligned for
+	// atomic operations.
+	rows       int64
+	nanos      int64
+	objects    int64
+	partitions int64
+
+	Name     string
+	Children []*ProfileNode
+}
+
+// Rows returns the number of rows produced.
+func (n *ProfileNode) Rows() int64 { return atomic.LoadInt64(&n.rows) }
+
+// Time returns the time spent producing the rows. Time spent by partitions
+// processed in parallel is added up.
+func (n *ProfileNode) Time() time.Duration {
+	return time.Duration(atomic.LoadInt64(&n.nanos))
+}
+
+// Objects returns the number of git objects read.
+func (n *ProfileNode) Objects() int64 { return atomic.LoadInt64(&n.objects) }
+
+// Partitions returns the number of partitions read. It's only set for
+// tables.
+func (n *ProfileNode) Partitions() int64 { return atomic.LoadInt64(&n.partitions) }
+
+func (n *ProfileNode) String() string {
+	p := sql.NewTreePrinter()
+	var stats = fmt.Sprintf("rows=%d, time=%s, objects=%d",
+		n.Rows(), n.Time().Round(time.Microsecond), n.Objects())
+	if partitions := n.Partitions(); partitions > 0 {
+		stats = fmt.Sprintf("partitions=%d, %s", partitions, stats)
+	}
+
+	_ = p.WriteNode("%s [%s]", n.Name, stats)
+	var children = make([]string, len(n.Children))
+	for i, c := range n.Children {
+		children[i] = c.String()
+	}
+	_ = p.WriteChildren(children...)
+	return p.String()
+}
+
+func (n *ProfileNode) add(d time.Duration, objects int64, row bool) {
+	atomic.AddInt64(&n.nanos, int64(d))
+	if objects > 0 {
+		atomic.AddInt64(&n.objects, objects)
+	}
+
+	if row {
+		atomic.AddInt64(&n.rows, 1)
+	}
+}
+
+// tableName returns the first line of the description of the table, which
+// is its name without the columns and filters.
+func tableName(t sql.Table) string {
+	name := fmt.Sprint(t)
+	if idx := strings.IndexByte(name, '\n'); idx >= 0 {
+		name = name[:idx]
+	}
+
+	return strings.TrimSpace(name)
+}
+
+type profileKey struct{}
+
+// profileState is the state of the profile in the context of the partition
+// of a table being read.
+type profileState struct {
+	profile *Profile
+	parent  *ProfileNode
+	// objects is the number of objects read by the partition.
+	objects *int64
+}
+
+func withProfileState(ctx *sql.Context, s *profileState) *sql.Context {
+	return ctx.WithContext(context.WithValue(ctx.Context, profileKey{}, s))
+}
+
+func profileStateFromContext(ctx *sql.Context) *profileState {
+	if ctx == nil {
+		return nil
+	}
+
+	s, _ := ctx.Value(profileKey{}).(*profileState)
+	return s
+}
+
+// profiledTable is a table that collects the statistics of its partitions in
+// a profile.
+type profiledTable struct {
+	sql.Table
+	profile *Profile
+	node    *ProfileNode
+}
+
+func (t *profiledTable) String() string { return fmt.Sprint(t.Table) }
+
+// PartitionRows implements the sql.Table interface.
+func (t *profiledTable) PartitionRows(
+	ctx *sql.Context,
+	p sql.Partition,
+) (sql.RowIter, error) {
+	atomic.AddInt64(&t.node.partitions, 1)
+
+	objects := new(int64)
+	ctx = withProfileState(ctx, &profileState{t.profile, t.node, objects})
+
+	start := time.Now()
+	iter, err := t.Table.PartitionRows(ctx, p)
+	t.node.add(time.Since(start), atomic.LoadInt64(objects), false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &profiledRowIter{iter, t.node, objects}, nil
+}
+
+type profiledRowIter struct {
+	sql.RowIter
+	node    *ProfileNode
+	objects *int64
+}
+
+func (i *profiledRowIter) Next() (sql.Row, error) {
+	start := time.Now()
+	objects := atomic.LoadInt64(i.objects)
+	row, err := i.RowIter.Next()
+	i.node.add(time.Since(start), atomic.LoadInt64(i.objects)-objects, err == nil)
+	return row, err
+}
+
+// iterProfile is the profile of an instance of a chainable iterator.
+type iterProfile struct {
+	node    *ProfileNode
+	objects *int64
+}
+
+// newProfiledIter creates a new iterator from the given one. If the context
+// is being profiled, the returned profile is the one of the iterator at the
+// position of template in the chain of iterators.
+func newProfiledIter(
+	ctx *sql.Context,
+	repo *Repository,
+	template ChainableIter,
+	inner ChainableIter,
+) (ChainableIter, *iterProfile, error) {
+	s := profileStateFromContext(ctx)
+	if s == nil {
+		iter, err := inner.New(ctx, repo)
+		return iter, nil, err
+	}
+
+	name := strings.TrimPrefix(fmt.Sprintf("%T", inner), "*gitbase.")
+	p := &iterProfile{
+		node:    s.profile.node(template, s.parent, name),
+		objects: s.objects,
+	}
+
+	var iter ChainableIter
+	err := p.track(false, func() error {
+		var err error
+		iter, err = inner.New(
+			withProfileState(ctx, &profileState{s.profile, p.node, s.objects}),
+			repo,
+		)
+		return err
+	})
+
+	return iter, p, err
+}
+
+func (p *iterProfile) track(row bool, fn func() error) error {
+	start := time.Now()
+	objects := atomic.LoadInt64(p.objects)
+	err := fn()
+	p.node.add(time.Since(start), atomic.LoadInt64(p.objects)-objects, row && err == nil)
+	return err
+}
+
+func (p *iterProfile) advance(iter ChainableIter) error {
+	if p == nil {
+		return iter.Advance()
+	}
+
+	return p.track(true, iter.Advance)
+}
+
+type profiledReposIter struct {
+	ReposIter
+	profile *iterProfile
+}
+
+func profileReposIter(iter ReposIter) ReposIter {
+	return &profiledReposIter{ReposIter: iter}
+}
+
+func (i *profiledReposIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
+	iter, p, err := newProfiledIter(ctx, repo, i, i.ReposIter)
+	if p == nil || err != nil {
+		return iter, err
+	}
+
+	return &profiledReposIter{iter.(ReposIter), p}, nil
+}
+
+func (i *profiledReposIter) Advance() error { return i.profile.advance(i.ReposIter) }
+
+type profiledRemotesIter struct {
+	RemotesIter
+	profile *iterProfile
+}
+
+func profileRemotesIter(iter RemotesIter) RemotesIter {
+	return &profiledRemotesIter{RemotesIter: iter}
+}
+
+func (i *profiledRemotesIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
+	iter, p, err := newProfiledIter(ctx, repo, i, i.RemotesIter)
+	if p == nil || err != nil {
+		return iter, err
+	}
+
+	return &profiledRemotesIter{iter.(RemotesIter), p}, nil
+}
+
+func (i *profiledRemotesIter) Advance() error { return i.profile.advance(i.RemotesIter) }
+
+type profiledRefsIter struct {
+	RefsIter
+	profile *iterProfile
+}
+
+func profileRefsIter(iter RefsIter) RefsIter {
+	return &profiledRefsIter{RefsIter: iter}
+}
+
+func (i *profiledRefsIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
+	iter, p, err := newProfiledIter(ctx, repo, i, i.RefsIter)
+	if p == nil || err != nil {
+		return iter, err
+	}
+
+	return &profiledRefsIter{iter.(RefsIter), p}, nil
+}
+
+func (i *profiledRefsIter) Advance() error { return i.profile.advance(i.RefsIter) }
+
+type profiledCommitsIter struct {
+	CommitsIter
+	profile *iterProfile
+}
+
+func profileCommitsIter(iter CommitsIter) CommitsIter {
+	return &profiledCommitsIter{CommitsIter: iter}
+}
+
+func (i *profiledCommitsIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
+	iter, p, err := newProfiledIter(ctx, repo, i, i.CommitsIter)
+	if p == nil || err != nil {
+		return iter, err
+	}
+
+	return &profiledCommitsIter{iter.(CommitsIter), p}, nil
+}
+
+func (i *profiledCommitsIter) Advance() error { return i.profile.advance(i.CommitsIter) }
+
+type profiledRefCommitsIter struct {
+	RefCommitsIter
+	profile *iterProfile
+}
+
+func profileRefCommitsIter(iter RefCommitsIter) RefCommitsIter {
+	return &profiledRefCommitsIter{RefCommitsIter: iter}
+}
+
+func (i *profiledRefCommitsIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
+	iter, p, err := newProfiledIter(ctx, repo, i, i.RefCommitsIter)
+	if p == nil || err != nil {
+		return iter, err
+	}
+
+	return &profiledRefCommitsIter{iter.(RefCommitsIter), p}, nil
+}
+
+func (i *profiledRefCommitsIter) Advance() error { return i.profile.advance(i.RefCommitsIter) }
+
+type profiledTreesIter struct {
+	TreesIter
+	profile *iterProfile
+}
+
+func profileTreesIter(iter TreesIter) TreesIter {
+	return &profiledTreesIter{TreesIter: iter}
+}
+
+func (i *profiledTreesIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
+	iter, p, err := newProfiledIter(ctx, repo, i, i.TreesIter)
+	if p == nil || err != nil {
+		return iter, err
+	}
+
+	return &profiledTreesIter{iter.(TreesIter), p}, nil
+}
+
+func (i *profiledTreesIter) Advance() error { return i.profile.advance(i.TreesIter) }
+
+type profiledTreeEntriesIter struct {
+	TreeEntriesIter
+	profile *iterProfile
+}
+
+func profileTreeEntriesIter(iter TreeEntriesIter) TreeEntriesIter {
+	return &profiledTreeEntriesIter{TreeEntriesIter: iter}
+}
+
+func (i *profiledTreeEntriesIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
+	iter, p, err := newProfiledIter(ctx, repo, i, i.TreeEntriesIter)
+	if p == nil || err != nil {
+		return iter, err
+	}
+
+	return &profiledTreeEntriesIter{iter.(TreeEntriesIter), p}, nil
+}
+
+func (i *profiledTreeEntriesIter) Advance() error { return i.profile.advance(i.TreeEntriesIter) }
+
+type profiledBlobsIter struct {
+	BlobsIter
+	profile *iterProfile
+}
+
+func profileBlobsIter(iter BlobsIter) BlobsIter {
+	return &profiledBlobsIter{BlobsIter: iter}
+}
+
+func (i *profiledBlobsIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
+	iter, p, err := newProfiledIter(ctx, repo, i, i.BlobsIter)
+	if p == nil || err != nil {
+		return iter, err
+	}
+
+	return &profiledBlobsIter{iter.(BlobsIter), p}, nil
+}
+
+func (i *profiledBlobsIter) Advance() error { return i.profile.advance(i.BlobsIter) }
+
+type profiledFilesIter struct {
+	FilesIter
+	profile *iterProfile
+}
+
+func profileFilesIter(iter FilesIter) FilesIter {
+	return &profiledFilesIter{FilesIter: iter}
+}
+
+func (i *profiledFilesIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
+	iter, p, err := newProfiledIter(ctx, repo, i, i.FilesIter)
+	if p == nil || err != nil {
+		return iter, err
+	}
+
+	return &profiledFilesIter{iter.(FilesIter), p}, nil
+}
+
+func (i *profiledFilesIter) Advance() error { return i.profile.advance(i.FilesIter) }
+
+type profiledChainableIter struct {
+	ChainableIter
+	profile *iterProfile
+}
+
+func profileChainableIter(iter ChainableIter) ChainableIter {
+	return &profiledChainableIter{ChainableIter: iter}
+}
+
+func (i *profiledChainableIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
+	iter, p, err := newProfiledIter(ctx, repo, i, i.ChainableIter)
+	if p == nil || err != nil {
+		return iter, err
+	}
+
+	return &profiledChainableIter{iter, p}, nil
+}
+
+func (i *profiledChainableIter) Advance() error { return i.profile.advance(i.ChainableIter) }
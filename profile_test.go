@@ -0,0 +1,70 @@
+package gitbase
+
+import (
+	"testing"
+
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/plan"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfile(t *testing.T) {
+	require := require.New(t)
+
+	ctx, _, cleanup := setup(t)
+	defer cleanup()
+
+	pool := poolFromCtx(t, ctx)
+	node := plan.NewCrossJoin(
+		plan.NewResolvedTable(NewSquashedTable(
+			NewRefRefCommitsIter(NewAllRefsIter(nil, false), nil),
+			nil, nil, nil,
+			ReferencesTableName, RefCommitsTableName,
+		)),
+		plan.NewResolvedTable(newRepositoriesTable(pool)),
+	)
+
+	profile := NewProfile()
+	instrumented, err := profile.Instrument(node)
+	require.NoError(err)
+
+	rows, err := sql.NodeToRows(ctx, instrumented)
+	require.NoError(err)
+
+	roots := profile.Roots()
+	require.Len(roots, 2)
+
+	squashed := roots[0]
+	require.Equal("SquashedTable(refs, ref_commits)", squashed.Name)
+	require.Equal(int64(len(rows)), squashed.Rows())
+	require.Equal(int64(1), squashed.Partitions())
+	require.True(squashed.Objects() > 0, squashed.Objects())
+	require.True(squashed.Time() > 0)
+
+	require.Len(squashed.Children, 1)
+	refCommits := squashed.Children[0]
+	require.Equal("squashRefRefCommitsIter", refCommits.Name)
+	require.Equal(squashed.Rows(), refCommits.Rows())
+	require.Equal(int64(0), refCommits.Partitions())
+
+	require.Len(refCommits.Children, 1)
+	refs := refCommits.Children[0]
+	require.Equal("squashRefIter", refs.Name)
+	require.True(refs.Rows() > 0 && refs.Rows() < refCommits.Rows(), refs.Rows())
+	require.True(refs.Time() <= refCommits.Time())
+	require.Empty(refs.Children)
+
+	// the right side of a cross join is read again for every row of the left
+	repos := roots[1]
+	require.Equal("Table(repositories)", repos.Name)
+	require.Equal(int64(len(rows)), repos.Rows())
+
+	// contexts without a profile are not instrumented
+	count := refCommits.Rows()
+	rows2, err := tableToRows(ctx, newSquashTable(
+		NewRefRefCommitsIter(NewAllRefsIter(nil, false), nil),
+	))
+	require.NoError(err)
+	require.Len(rows2, len(rows))
+	require.Equal(count, refCommits.Rows())
+}
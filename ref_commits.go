@@ -8,13 +8,12 @@ import (
 	"gopkg.in/src-d/go-git.v4/plumbing"
 
 	"github.com/src-d/go-mysql-server/sql"
-	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	gitgraph "gopkg.in/src-d/go-git.v4/plumbing/object/commitgraph"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
 )
 
 type refCommitsTable struct {
 	checksumable
-	partitioned
 	filters []sql.Expression
 	index   sql.IndexLookup
 }
@@ -67,6 +66,16 @@ func (t *refCommitsTable) WithIndexLookup(idx sql.IndexLookup) sql.Table {
 func (t *refCommitsTable) IndexLookup() sql.IndexLookup { return t.index }
 func (t *refCommitsTable) Filters() []sql.Expression    { return t.filters }
 
+// Partitions implements the sql.Table interface.
+func (t *refCommitsTable) Partitions(ctx *sql.Context) (sql.PartitionIter, error) {
+	return splitPartitions(ctx, t.index)
+}
+
+// PartitionCount implements the sql.PartitionCounter interface.
+func (t *refCommitsTable) PartitionCount(ctx *sql.Context) (int64, error) {
+	return splitPartitionCount(ctx, t.index)
+}
+
 func (t *refCommitsTable) PartitionRows(
 	ctx *sql.Context,
 	p sql.Partition,
@@ -102,6 +111,24 @@ func (t *refCommitsTable) PartitionRows(
 				names[i] = strings.ToLower(names[i])
 			}
 
+			var patterns []textPattern
+			patterns, err = selectors.textPatterns("ref_name")
+			if err != nil {
+				return nil, err
+			}
+
+			// The history_index filters are not kept, so no row can
+			// match if they contradict each other.
+			if !selectors.isValid("history_index") {
+				return noRows, nil
+			}
+
+			var historyIndexes []int64
+			historyIndexes, err = selectors.int64Values("history_index")
+			if err != nil {
+				return nil, err
+			}
+
 			var indexValues sql.IndexValueIter
 			if t.index != nil {
 				if indexValues, err = t.index.Values(p); err != nil {
@@ -110,11 +137,15 @@ func (t *refCommitsTable) PartitionRows(
 			}
 
 			return &refCommitsRowIter{
-				ctx:           ctx,
-				refNames:      names,
-				repo:          repo,
-				index:         indexValues,
-				skipGitErrors: shouldSkipErrors(ctx),
+				ctx:             ctx,
+				refNames:        names,
+				refPatterns:     patterns,
+				historyIndexes:  historyIndexes,
+				maxHistoryIndex: maxHistoryIndex(selectors, historyIndexes),
+				repo:            repo,
+				index:           indexValues,
+				part:            partitionPart(p),
+				skipGitErrors:   shouldSkipErrors(ctx),
 			}, nil
 		},
 	)
@@ -124,14 +155,44 @@ func (t *refCommitsTable) PartitionRows(
 		return nil, errorWithRepo(repo, err)
 	}
 
-	return sql.NewSpanIter(span, newRepoRowIter(repo, iter)), nil
+	return sql.NewSpanIter(span, newRepoRowIter(ctx, repo, iter)), nil
 }
 
 func (refCommitsTable) HandledFilters(filters []sql.Expression) []sql.Expression {
 	return handledFilters(RefCommitsTableName, RefCommitsSchema, filters)
 }
 
-func (refCommitsTable) handledColumns() []string { return []string{"ref_name", "repository_id"} }
+func (refCommitsTable) handledColumns() []string {
+	return []string{"ref_name", "repository_id", "history_index"}
+}
+
+// maxHistoryIndex returns the greatest history index the rows can have
+// according to the selectors, or -1 if there is no limit.
+func maxHistoryIndex(selectors selectors, historyIndexes []int64) int64 {
+	max := int64(-1)
+	for _, idx := range historyIndexes {
+		if idx > max {
+			max = idx
+		}
+	}
+
+	bound, ok := selectors.int64UpperBound("history_index")
+	if !ok {
+		return max
+	}
+
+	if bound < 0 {
+		// no rows can match, but the history is walked up to the start
+		// commit so the filters still see them
+		bound = 0
+	}
+
+	if max < 0 || bound < max {
+		return bound
+	}
+
+	return max
+}
 
 // IndexKeyValues implements the sql.IndexableTable interface.
 func (t *refCommitsTable) IndexKeyValues(
@@ -219,21 +280,48 @@ type refCommitsRowIter struct {
 	commits       *indexedCommitIter
 	ref           *plumbing.Reference
 	index         sql.IndexValueIter
+	part          repositoryPart
 	skipGitErrors bool
 	mapper        refCommitsRowKeyMapper
 
 	// selectors for faster filtering
-	refNames []string
+	refNames       []string
+	refPatterns    []textPattern
+	historyIndexes []int64
+	// maxHistoryIndex is the depth the history of each reference is walked
+	// to, or -1 to walk all of it.
+	maxHistoryIndex int64
 }
 
-var refNameIdx = RefCommitsSchema.IndexOf("ref_name", RefCommitsTableName)
+var (
+	refNameIdx      = RefCommitsSchema.IndexOf("ref_name", RefCommitsTableName)
+	historyIndexIdx = RefCommitsSchema.IndexOf("history_index", RefCommitsTableName)
+)
 
 func (i *refCommitsRowIter) shouldVisitRef(ref *plumbing.Reference) bool {
 	if len(i.refNames) > 0 && !stringContains(i.refNames, strings.ToLower(ref.Name().String())) {
 		return false
 	}
 
-	return true
+	if !matchesPatterns(i.refPatterns, ref.Name().String()) {
+		return false
+	}
+
+	return i.part.hasRef(ref.Name().String())
+}
+
+func (i *refCommitsRowIter) hasHistoryIndex(idx int64) bool {
+	if len(i.historyIndexes) == 0 {
+		return true
+	}
+
+	for _, v := range i.historyIndexes {
+		if v == idx {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (i *refCommitsRowIter) Next() (sql.Row, error) {
@@ -259,6 +347,10 @@ func (i *refCommitsRowIter) nextFromIndex() (sql.Row, error) {
 			continue
 		}
 
+		if !i.hasHistoryIndex(row[historyIndexIdx].(int64)) {
+			continue
+		}
+
 		return row, nil
 	}
 }
@@ -267,7 +359,7 @@ func (i *refCommitsRowIter) next() (sql.Row, error) {
 	for {
 		var err error
 		if i.refs == nil {
-			i.refs, err = i.repo.References()
+			i.refs, err = referencesWithPatterns(i.repo, i.refPatterns)
 			if err != nil {
 				i.repo.Close()
 
@@ -327,7 +419,8 @@ func (i *refCommitsRowIter) next() (sql.Row, error) {
 				return nil, err
 			}
 
-			i.commits = newIndexedCommitIter(i.skipGitErrors, i.repo, commit)
+			i.commits = newIndexedCommitIter(i.skipGitErrors, i.repo, commit.Hash)
+			i.commits.maxIdx = i.maxHistoryIndex
 		}
 
 		commit, idx, err := i.commits.Next()
@@ -345,9 +438,13 @@ func (i *refCommitsRowIter) next() (sql.Row, error) {
 			return nil, err
 		}
 
+		if !i.hasHistoryIndex(int64(idx)) {
+			continue
+		}
+
 		return sql.NewRow(
 			i.repo.ID(),
-			commit.Hash.String(),
+			commit.ID().String(),
 			i.ref.Name().String(),
 			int64(idx),
 		), nil
@@ -373,22 +470,33 @@ func (i *refCommitsRowIter) Close() error {
 type indexedCommitIter struct {
 	skipGitErrors bool
 	repo          *Repository
+	nodes         gitgraph.CommitNodeIndex
 	stack         []*stackFrame
 	seen          map[plumbing.Hash]struct{}
+	// maxIdx is the greatest distance to the start commit of the commits
+	// that must be returned, or -1 to return the whole history. Commits
+	// farther than it may be returned too.
+	maxIdx int64
 }
 
+// newIndexedCommitIter returns an iterator over the history of the given
+// commit with the distance of each commit to it. The history is read from
+// the commit-graph of the repository when available, so the commit objects
+// are not decoded.
 func newIndexedCommitIter(
 	skipGitErrors bool,
 	repo *Repository,
-	start *object.Commit,
+	start plumbing.Hash,
 ) *indexedCommitIter {
 	return &indexedCommitIter{
 		skipGitErrors: skipGitErrors,
 		repo:          repo,
+		nodes:         repo.CommitNodes(),
 		stack: []*stackFrame{
-			{0, 0, []plumbing.Hash{start.Hash}},
+			{0, 0, []plumbing.Hash{start}},
 		},
-		seen: make(map[plumbing.Hash]struct{}),
+		seen:   make(map[plumbing.Hash]struct{}),
+		maxIdx: -1,
 	}
 }
 
@@ -398,9 +506,13 @@ type stackFrame struct {
 	hashes []plumbing.Hash
 }
 
-func (i *indexedCommitIter) Next() (*object.Commit, int, error) {
+func (i *indexedCommitIter) Next() (gitgraph.CommitNode, int, error) {
 	for {
-		if len(i.stack) == 0 {
+		// The frames of the stack are sorted by distance, so once the
+		// first one is farther than maxIdx no commit can be closer. The
+		// walk can't stop earlier, because the commits seen in the farther
+		// frames are skipped when they are reached again by shorter paths.
+		if len(i.stack) == 0 || (i.maxIdx >= 0 && int64(i.stack[0].idx) > i.maxIdx) {
 			i.repo.Close()
 			return nil, -1, io.EOF
 		}
@@ -417,7 +529,7 @@ func (i *indexedCommitIter) Next() (*object.Commit, int, error) {
 			i.stack = i.stack[:len(i.stack)-1]
 		}
 
-		c, err := i.repo.CommitObject(h)
+		c, err := i.nodes.Get(h)
 		if err != nil {
 			if i.skipGitErrors {
 				continue
@@ -429,7 +541,7 @@ func (i *indexedCommitIter) Next() (*object.Commit, int, error) {
 
 		if c.NumParents() > 0 {
 			parents := make([]plumbing.Hash, 0, c.NumParents())
-			for _, h = range c.ParentHashes {
+			for _, h = range c.ParentHashes() {
 				if _, ok := i.seen[h]; !ok {
 					parents = append(parents, h)
 				}
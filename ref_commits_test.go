@@ -1,7 +1,9 @@
 package gitbase
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/src-d/go-mysql-server/sql"
 	"github.com/src-d/go-mysql-server/sql/expression"
@@ -107,6 +109,23 @@ func TestRefCommitsPushdown(t *testing.T) {
 				{"918c48b83bd081e863dbe1b80f8998f058cd8294", "refs/remotes/origin/master", int64(1)},
 			},
 		},
+		{
+			"ref pattern filter",
+			[]sql.Expression{
+				expression.NewLike(
+					expression.NewGetFieldWithTable(2, sql.Text, RefCommitsTableName, "ref_name", false),
+					expression.NewLiteral("refs/remotes/%", sql.Text),
+				),
+				expression.NewEquals(
+					expression.NewGetFieldWithTable(1, sql.Text, RefCommitsTableName, "commit_hash", false),
+					expression.NewLiteral("918c48b83bd081e863dbe1b80f8998f058cd8294", sql.Text),
+				),
+			},
+			[]sql.Row{
+				{"918c48b83bd081e863dbe1b80f8998f058cd8294", "refs/remotes/origin/branch", int64(1)},
+				{"918c48b83bd081e863dbe1b80f8998f058cd8294", "refs/remotes/origin/master", int64(1)},
+			},
+		},
 	}
 
 	for _, tt := range testCases {
@@ -128,6 +147,128 @@ func TestRefCommitsPushdown(t *testing.T) {
 	}
 }
 
+func TestRefCommitsHistoryIndexPushdown(t *testing.T) {
+	require := require.New(t)
+	ctx, _, cleanup := setup(t)
+	defer cleanup()
+
+	session := NewSession(poolFromCtx(t, ctx), WithQueryStats(true))
+
+	var pid uint64
+	read := func(filters ...sql.Expression) ([]sql.Row, int64) {
+		pid++
+		ctx := sql.NewContext(context.TODO(),
+			sql.WithSession(session),
+			sql.WithPid(pid),
+		)
+
+		table := newRefCommitsTable(session.Pool).WithFilters(filters)
+		rows, err := tableToRows(ctx, table)
+		require.NoError(err)
+		return rows, session.QueryStats().Objects
+	}
+
+	historyIndex := expression.NewGetFieldWithTable(3, sql.Int64, RefCommitsTableName, "history_index", false)
+
+	all, allObjects := read()
+
+	rows, objects := read(expression.NewLessThan(
+		historyIndex,
+		expression.NewLiteral(int64(1), sql.Int64),
+	))
+
+	// only the commits the references point to are read
+	var expected []sql.Row
+	for _, row := range all {
+		if row[3].(int64) < 1 {
+			expected = append(expected, row)
+		}
+	}
+	require.Equal(expected, rows)
+	require.True(objects <= 3*int64(len(rows)), "%d objects read", objects)
+	require.True(objects < allObjects, "%d objects read, %d without filter", objects, allObjects)
+
+	rows, objects = read(expression.NewIn(
+		historyIndex,
+		expression.NewTuple(
+			expression.NewLiteral(int64(0), sql.Int64),
+			expression.NewLiteral(int64(2), sql.Int64),
+		),
+	))
+
+	expected = nil
+	for _, row := range all {
+		if idx := row[3].(int64); idx == 0 || idx == 2 {
+			expected = append(expected, row)
+		}
+	}
+	require.Equal(expected, rows)
+	require.True(objects < allObjects, "%d objects read, %d without filter", objects, allObjects)
+}
+
+func TestRefCommitsHistoryIndexMerges(t *testing.T) {
+	require := require.New(t)
+
+	// The first parent of the merge e is a long side branch, so a is first
+	// reached through it, farther than through the diamond b, c, d.
+	when := time.Date(2019, time.October, 1, 0, 0, 0, 0, time.UTC)
+	ctx, _, cleanup := setupHistory(t, []testCommit{
+		{"a", nil, when},
+		{"b", []string{"a"}, when},
+		{"c", []string{"a"}, when},
+		{"d", []string{"b", "c"}, when},
+		{"s1", []string{"a"}, when},
+		{"s2", []string{"s1"}, when},
+		{"s3", []string{"s2"}, when},
+		{"s4", []string{"s3"}, when},
+		{"s5", []string{"s4"}, when},
+		{"e", []string{"s5", "d"}, when},
+		{"f", []string{"e"}, when},
+	}, "f", "d")
+	defer cleanup()
+
+	read := func(filters ...sql.Expression) []sql.Row {
+		table := newRefCommitsTable(poolFromCtx(t, ctx)).WithFilters(filters)
+		rows, err := tableToRows(ctx, table)
+		require.NoError(err)
+		return rows
+	}
+
+	historyIndex := expression.NewGetFieldWithTable(3, sql.Int64, RefCommitsTableName, "history_index", false)
+	literal := func(n int64) sql.Expression {
+		return expression.NewLiteral(n, sql.Int64)
+	}
+
+	all := read()
+	require.Len(all, 11+4)
+
+	for n := int64(0); n <= 8; n++ {
+		var expected []sql.Row
+		for _, row := range all {
+			if row[3].(int64) <= n {
+				expected = append(expected, row)
+			}
+		}
+
+		require.ElementsMatch(expected, read(expression.NewLessThanOrEqual(historyIndex, literal(n))), "history_index <= %d", n)
+
+		expected = nil
+		for _, row := range all {
+			if row[3].(int64) == n {
+				expected = append(expected, row)
+			}
+		}
+
+		require.ElementsMatch(expected, read(expression.NewEquals(historyIndex, literal(n))), "history_index = %d", n)
+	}
+
+	// contradicting filters match no rows
+	require.Len(read(
+		expression.NewEquals(historyIndex, literal(1)),
+		expression.NewEquals(historyIndex, literal(3)),
+	), 0)
+}
+
 func TestRefCommitsIndexKeyValueIter(t *testing.T) {
 	require := require.New(t)
 	ctx, _, cleanup := setup(t)
@@ -14,7 +14,6 @@ import (
 
 type referencesTable struct {
 	checksumable
-	partitioned
 	filters []sql.Expression
 	index   sql.IndexLookup
 }
@@ -69,6 +68,16 @@ func (r *referencesTable) WithIndexLookup(idx sql.IndexLookup) sql.Table {
 func (r *referencesTable) IndexLookup() sql.IndexLookup { return r.index }
 func (r *referencesTable) Filters() []sql.Expression    { return r.filters }
 
+// Partitions implements the sql.Table interface.
+func (r *referencesTable) Partitions(ctx *sql.Context) (sql.PartitionIter, error) {
+	return splitPartitions(ctx, r.index)
+}
+
+// PartitionCount implements the sql.PartitionCounter interface.
+func (r *referencesTable) PartitionCount(ctx *sql.Context) (int64, error) {
+	return splitPartitionCount(ctx, r.index)
+}
+
 func (r *referencesTable) PartitionRows(
 	ctx *sql.Context,
 	p sql.Partition,
@@ -100,6 +109,12 @@ func (r *referencesTable) PartitionRows(
 				names[i] = strings.ToLower(names[i])
 			}
 
+			var patterns []textPattern
+			patterns, err = selectors.textPatterns("ref_name")
+			if err != nil {
+				return nil, err
+			}
+
 			var indexValues sql.IndexValueIter
 			if r.index != nil {
 				if indexValues, err = r.index.Values(p); err != nil {
@@ -111,7 +126,9 @@ func (r *referencesTable) PartitionRows(
 				hashes:        stringsToHashes(hashes),
 				repo:          repo,
 				names:         names,
+				patterns:      patterns,
 				index:         indexValues,
+				part:          partitionPart(p),
 				skipGitErrors: shouldSkipErrors(ctx),
 			}, nil
 		},
@@ -122,7 +139,7 @@ func (r *referencesTable) PartitionRows(
 		return nil, errorWithRepo(repo, err)
 	}
 
-	return sql.NewSpanIter(span, newRepoRowIter(repo, iter)), nil
+	return sql.NewSpanIter(span, newRepoRowIter(ctx, repo, iter)), nil
 }
 
 func (referencesTable) HandledFilters(filters []sql.Expression) []sql.Expression {
@@ -203,7 +220,9 @@ type refRowIter struct {
 	repo          *Repository
 	hashes        []plumbing.Hash
 	names         []string
+	patterns      []textPattern
 	index         sql.IndexValueIter
+	part          repositoryPart
 	skipGitErrors bool
 
 	head   *plumbing.Reference
@@ -221,7 +240,7 @@ func (i *refRowIter) Next() (sql.Row, error) {
 
 func (i *refRowIter) init() error {
 	var err error
-	i.iter, err = i.repo.References()
+	i.iter, err = referencesWithPatterns(i.repo, i.patterns)
 	if err != nil {
 		return err
 	}
@@ -289,6 +308,10 @@ func (i *refRowIter) next() (sql.Row, error) {
 				continue
 			}
 
+			if !i.part.hasRef("HEAD") || !matchesPatterns(i.patterns, "HEAD") {
+				continue
+			}
+
 			return sql.NewRow(
 				i.repo.ID(),
 				"HEAD",
@@ -313,6 +336,10 @@ func (i *refRowIter) next() (sql.Row, error) {
 			continue
 		}
 
+		if !i.part.hasRef(o.Name().String()) || !matchesPatterns(i.patterns, o.Name().String()) {
+			continue
+		}
+
 		return referenceToRow(i.repo.ID(), o), nil
 	}
 }
@@ -343,6 +370,38 @@ func referenceToRow(repositoryID string, c *plumbing.Reference) sql.Row {
 	)
 }
 
+// referencesWithPatterns returns the references of the repository that can
+// match the given ref_name patterns. If one of them has no wildcards, only
+// that reference is read. Otherwise, the references without the literal
+// prefix of the patterns are skipped before matching the patterns.
+func referencesWithPatterns(
+	repo *Repository,
+	patterns []textPattern,
+) (storer.ReferenceIter, error) {
+	prefix, exact := patternsPrefix(patterns)
+	if exact {
+		ref, err := repo.Reference(plumbing.ReferenceName(prefix), false)
+		if err == plumbing.ErrReferenceNotFound {
+			return storer.NewReferenceSliceIter(nil), nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return storer.NewReferenceSliceIter([]*plumbing.Reference{ref}), nil
+	}
+
+	refs, err := repo.References()
+	if err != nil || prefix == "" {
+		return refs, err
+	}
+
+	return storer.NewReferenceFilteredIter(func(ref *plumbing.Reference) bool {
+		return strings.HasPrefix(ref.Name().String(), prefix)
+	}, refs), nil
+}
+
 func isIgnoredReference(r *plumbing.Reference) bool {
 	return r.Type() != plumbing.HashReference
 }
@@ -76,6 +76,78 @@ func TestReferencesPushdown(t *testing.T) {
 	rows, err = tableToRows(ctx, t3)
 	require.NoError(err)
 	require.Len(rows, 0)
+
+	t4 := table.WithFilters([]sql.Expression{
+		expression.NewLike(
+			expression.NewGetFieldWithTable(1, sql.Text, ReferencesTableName, "name", false),
+			expression.NewLiteral("refs/remotes/%", sql.Text),
+		),
+	})
+
+	rows, err = tableToRows(ctx, t4)
+	require.NoError(err)
+	require.Len(rows, 2)
+
+	t5 := table.WithFilters([]sql.Expression{
+		expression.NewLike(
+			expression.NewGetFieldWithTable(1, sql.Text, ReferencesTableName, "name", false),
+			expression.NewLiteral("H_AD", sql.Text),
+		),
+	})
+
+	rows, err = tableToRows(ctx, t5)
+	require.NoError(err)
+	require.Len(rows, 1)
+	require.Equal("HEAD", rows[0][1])
+}
+
+func TestReferencesWithPatterns(t *testing.T) {
+	require := require.New(t)
+	ctx, _, cleanup := setup(t)
+	defer cleanup()
+
+	session, err := getSession(ctx)
+	require.NoError(err)
+	iter, err := session.Pool.RepoIter()
+	require.NoError(err)
+	repo, err := iter.Next()
+	require.NoError(err)
+	require.NoError(iter.Close())
+	defer repo.Close()
+
+	names := func(patterns ...string) []string {
+		var tps []textPattern
+		for _, p := range patterns {
+			tp, err := newTextPattern(p)
+			require.NoError(err)
+			tps = append(tps, tp)
+		}
+
+		refs, err := referencesWithPatterns(repo, tps)
+		require.NoError(err)
+
+		var result []string
+		require.NoError(refs.ForEach(func(ref *plumbing.Reference) error {
+			if !isIgnoredReference(ref) {
+				result = append(result, ref.Name().String())
+			}
+			return nil
+		}))
+		return result
+	}
+
+	require.ElementsMatch([]string{
+		"refs/remotes/origin/branch",
+		"refs/remotes/origin/master",
+	}, names("refs/remotes/%"))
+	// only the longest prefix is checked, the patterns are matched later
+	require.ElementsMatch([]string{
+		"refs/remotes/origin/branch",
+		"refs/remotes/origin/master",
+	}, names("refs/%/master", "refs/remotes/origin/%"))
+	require.Equal([]string{"refs/heads/master"}, names("refs/heads/master"))
+	require.Empty(names("refs/heads/foo"))
+	require.Len(names(), 3)
 }
 
 func TestReferencesIndexKeyValueIter(t *testing.T) {
@@ -114,7 +114,7 @@ func (r *remotesTable) PartitionRows(
 		return nil, errorWithRepo(repo, err)
 	}
 
-	return sql.NewSpanIter(span, newRepoRowIter(repo, iter)), nil
+	return sql.NewSpanIter(span, newRepoRowIter(ctx, repo, iter)), nil
 }
 
 func (remotesTable) HandledFilters(filters []sql.Expression) []sql.Expression {
@@ -0,0 +1,203 @@
+package gitbase
+
+import (
+	"sync"
+
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-mysql-server/sql"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// repositoryHandle is a reference-counted repository shared by the
+// partitions and tables of a query. The repository is opened lazily when
+// it's acquired and it's kept open while the handle is referenced, so tables
+// reading the same repository one after another don't open it again.
+// Repositories can't be read concurrently, so acquiring a handle while its
+// repository is in use opens another one.
+type repositoryHandle struct {
+	id   string
+	pool *RepositoryPool
+
+	mu   sync.Mutex
+	refs int
+	idle *Repository
+
+	// buckets are the hashes of the objects of the repository split in
+	// the parts read by the split partitions, enumerated only once for all
+	// of them.
+	bucketsMu sync.Mutex
+	buckets   [][]plumbing.Hash
+}
+
+func newRepositoryHandle(pool *RepositoryPool, id string) *repositoryHandle {
+	return &repositoryHandle{id: id, pool: pool}
+}
+
+// hold adds a reference to the handle, keeping its idle repository open.
+func (h *repositoryHandle) hold() {
+	h.mu.Lock()
+	h.refs++
+	h.mu.Unlock()
+}
+
+// unhold removes a reference added with hold. The idle repository is closed
+// when there are no references left.
+func (h *repositoryHandle) unhold() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.refs--
+	h.closeIdle()
+}
+
+// adopt makes an already opened repository the idle repository of the
+// handle, or closes it if the handle has one already.
+func (h *repositoryHandle) adopt(lib borges.Library, repo borges.Repository) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.idle != nil || h.refs == 0 {
+		_ = repo.Close()
+		return
+	}
+
+	h.idle = NewRepository(lib, repo, h.pool.cache)
+}
+
+// acquire returns the repository of the handle, opening it if there is no
+// idle one, and adds a reference until the returned repository is closed.
+func (h *repositoryHandle) acquire() (*Repository, error) {
+	h.mu.Lock()
+	repo := h.idle
+	h.idle = nil
+	h.refs++
+	h.mu.Unlock()
+
+	if repo == nil {
+		var err error
+		repo, err = h.pool.GetRepo(h.id)
+		if err != nil {
+			h.unhold()
+			return nil, err
+		}
+	}
+
+	acquired := *repo
+	acquired.handle = h
+	var once sync.Once
+	acquired.release = func() {
+		once.Do(func() { h.release(repo) })
+	}
+
+	return &acquired, nil
+}
+
+// release returns a repository obtained with acquire to the handle, which
+// keeps it as its idle repository if the handle is still referenced.
+func (h *repositoryHandle) release(repo *Repository) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.refs--
+	if h.refs > 0 && h.idle == nil {
+		h.idle = repo
+		return
+	}
+
+	_ = repo.Close()
+	h.closeIdle()
+}
+
+// closeIdle closes the idle repository if the handle is not referenced. It
+// must be called with the lock held.
+func (h *repositoryHandle) closeIdle() {
+	if h.refs > 0 || h.idle == nil {
+		return
+	}
+
+	_ = h.idle.Close()
+	h.idle = nil
+}
+
+// objectBuckets returns the hashes of the objects of the repository split in
+// the given number of parts. They are enumerated with the given function the
+// first time and shared by all the parts afterwards.
+func (h *repositoryHandle) objectBuckets(
+	parts int,
+	enumerate func() ([][]plumbing.Hash, error),
+) ([][]plumbing.Hash, error) {
+	h.bucketsMu.Lock()
+	defer h.bucketsMu.Unlock()
+
+	if len(h.buckets) == parts {
+		return h.buckets, nil
+	}
+
+	buckets, err := enumerate()
+	if err != nil {
+		return nil, err
+	}
+
+	h.buckets = buckets
+	return buckets, nil
+}
+
+// dropBuckets discards the object buckets of the handle. Partitions still
+// reading them keep the ones they already got.
+func (h *repositoryHandle) dropBuckets() {
+	h.bucketsMu.Lock()
+	h.buckets = nil
+	h.bucketsMu.Unlock()
+}
+
+// queryHandles are the repository handles of a query.
+type queryHandles struct {
+	pid     uint64
+	handles map[string]*repositoryHandle
+}
+
+// repositoryHandle returns the handle of the repository with the given id,
+// which is shared by all the partitions of the query of the context. The
+// handles of the query are released when its context is done, which happens
+// when the query finishes.
+func (s *Session) repositoryHandle(ctx *sql.Context, id string) *repositoryHandle {
+	s.handlesMu.Lock()
+	defer s.handlesMu.Unlock()
+
+	if s.handles == nil || s.handles.pid != ctx.Pid() {
+		s.handles = &queryHandles{
+			pid:     ctx.Pid(),
+			handles: make(map[string]*repositoryHandle),
+		}
+
+		if done := ctx.Done(); done != nil {
+			go func(handles *queryHandles) {
+				<-done
+				s.releaseHandles(handles)
+			}(s.handles)
+		}
+	}
+
+	h, ok := s.handles.handles[id]
+	if !ok {
+		h = newRepositoryHandle(s.Pool, id)
+		s.handles.handles[id] = h
+	}
+
+	return h
+}
+
+// releaseHandles discards the given handles of a query and their object
+// buckets. The repositories still in use are closed once they are released
+// by their partitions.
+func (s *Session) releaseHandles(handles *queryHandles) {
+	s.handlesMu.Lock()
+	defer s.handlesMu.Unlock()
+
+	if s.handles == handles {
+		s.handles = nil
+	}
+
+	for _, h := range handles.handles {
+		h.dropBuckets()
+	}
+}
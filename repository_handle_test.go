@@ -0,0 +1,158 @@
+package gitbase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryHandle(t *testing.T) {
+	require := require.New(t)
+	ctx, closed := setupSivaCloseRepos(t, "_testdata")
+
+	session, err := getSession(ctx)
+	require.NoError(err)
+
+	iter, err := session.Pool.RepoIter()
+	require.NoError(err)
+	repo, err := iter.Next()
+	require.NoError(err)
+	id := repo.ID()
+	require.NoError(repo.Close())
+
+	h := session.repositoryHandle(ctx, id)
+	require.True(h == session.repositoryHandle(ctx, id))
+
+	h.hold()
+
+	r1, err := h.acquire()
+	require.NoError(err)
+
+	// The repository is in use, so another one is opened.
+	r2, err := h.acquire()
+	require.NoError(err)
+	require.False(r1.Repository == r2.Repository)
+
+	require.NoError(r1.Close())
+	require.NoError(r1.Close())
+	require.NoError(r2.Close())
+
+	// The idle repository is reused while the handle is held.
+	r3, err := h.acquire()
+	require.NoError(err)
+	require.True(r3.Repository == r1.Repository)
+	require.NoError(r3.Close())
+	require.False(closed.Check())
+
+	h.unhold()
+	require.True(closed.Check())
+}
+
+func TestRepositoryPartitionsReuseRepository(t *testing.T) {
+	require := require.New(t)
+	ctx, closed := setupSivaCloseRepos(t, "_testdata")
+
+	count, err := partitioned{}.PartitionCount(ctx)
+	require.NoError(err)
+	opened := len(closed.repos)
+
+	table := newReferencesTable(poolFromCtx(t, ctx))
+	_, err = tableToRows(ctx, table)
+	require.NoError(err)
+
+	require.True(closed.Check())
+	require.Equal(int(count), len(closed.repos)-opened)
+}
+
+func TestRepositoryHandlesReleased(t *testing.T) {
+	require := require.New(t)
+	ctx, _, cleanup := setup(t)
+	defer cleanup()
+
+	session, err := getSession(ctx)
+	require.NoError(err)
+	session.split = RepositorySplit{Parts: 4}
+
+	c, cancel := context.WithCancel(ctx)
+	ctx = ctx.WithContext(c)
+
+	rows, err := tableToRows(ctx, newBlobsTable(poolFromCtx(t, ctx)))
+	require.NoError(err)
+	require.NotEmpty(rows)
+
+	session.handlesMu.Lock()
+	handles := session.handles
+	session.handlesMu.Unlock()
+	require.NotNil(handles)
+	require.NotEmpty(handles.handles)
+
+	// The query is done when its context is.
+	cancel()
+
+	released := func() bool {
+		session.handlesMu.Lock()
+		defer session.handlesMu.Unlock()
+		return session.handles == nil
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !released() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.True(released())
+
+	for _, h := range handles.handles {
+		h.bucketsMu.Lock()
+		require.Nil(h.buckets)
+		h.bucketsMu.Unlock()
+	}
+}
+
+func TestPartitionCountCache(t *testing.T) {
+	require := require.New(t)
+	ctx, _, cleanup := setup(t)
+	defer cleanup()
+
+	pool := poolFromCtx(t, ctx)
+
+	var calls int
+	count := func() (int64, error) {
+		calls++
+		return 5, nil
+	}
+
+	n, err := pool.partitionCount(RepositorySplit{}, count)
+	require.NoError(err)
+	require.Equal(int64(5), n)
+
+	n, err = pool.partitionCount(RepositorySplit{}, count)
+	require.NoError(err)
+	require.Equal(int64(5), n)
+	require.Equal(1, calls)
+
+	_, err = pool.partitionCount(RepositorySplit{Parts: 2}, count)
+	require.NoError(err)
+	require.Equal(2, calls)
+
+	pool.SetLibrary(pool.Library())
+	_, err = pool.partitionCount(RepositorySplit{}, count)
+	require.NoError(err)
+	require.Equal(3, calls)
+
+	pool.InvalidatePartitionCounts()
+	_, err = pool.partitionCount(RepositorySplit{}, count)
+	require.NoError(err)
+	require.Equal(4, calls)
+
+	// counts computed while they are invalidated are not cached
+	_, err = pool.partitionCount(RepositorySplit{Parts: 3}, func() (int64, error) {
+		pool.InvalidatePartitionCounts()
+		return count()
+	})
+	require.NoError(err)
+	_, err = pool.partitionCount(RepositorySplit{Parts: 3}, count)
+	require.NoError(err)
+	require.Equal(6, calls)
+}
@@ -3,6 +3,7 @@ package gitbase
 import (
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/src-d/go-borges"
 	billy "gopkg.in/src-d/go-billy.v4"
@@ -27,6 +28,13 @@ type Repository struct {
 	cache cache.Object
 	repo  borges.Repository
 	lib   borges.Library
+	graph *repositoryGraph
+
+	// release, if set, returns the repository to the handle it was
+	// acquired from instead of closing it.
+	release func()
+	// handle is the handle the repository was acquired from, if any.
+	handle *repositoryHandle
 }
 
 func NewRepository(
@@ -39,6 +47,7 @@ func NewRepository(
 		lib:        lib,
 		repo:       repo,
 		cache:      cache,
+		graph:      &repositoryGraph{},
 	}
 }
 
@@ -60,6 +69,15 @@ func (r *Repository) Cache() cache.Object {
 }
 
 func (r *Repository) Close() error {
+	if r != nil && r.release != nil {
+		r.release()
+		return nil
+	}
+
+	if r != nil && r.graph != nil {
+		_ = r.graph.close()
+	}
+
 	if r != nil && r.repo != nil {
 		if closer, ok := r.repo.(io.Closer); ok {
 			return closer.Close()
@@ -71,8 +89,22 @@ func (r *Repository) Close() error {
 // RepositoryPool holds a pool git repository paths and
 // functionality to open and iterate them.
 type RepositoryPool struct {
-	cache   cache.Object
-	library borges.Library
+	cache cache.Object
+
+	mut        sync.RWMutex
+	library    borges.Library
+	generation uint64
+	checksum   string
+
+	countMu          sync.Mutex
+	counts           map[RepositorySplit]int64
+	countsGeneration uint64
+	countsEpoch      uint64
+
+	// base is the pool this one restricts, if any, to the repositories
+	// allowed.
+	base    *RepositoryPool
+	allowed func(id string) bool
 }
 
 // NewRepositoryPool holds a repository library and a shared object cache.
@@ -86,6 +118,148 @@ func NewRepositoryPool(
 	}
 }
 
+// WithAccess returns a view of the pool with only the repositories allowed
+// by the given access. The view shares the library of the pool, so replacing
+// the library of any of them replaces it in both.
+func (p *RepositoryPool) WithAccess(access *RepositoryAccess) *RepositoryPool {
+	if access == nil {
+		return p
+	}
+
+	return p.view(access.Allowed)
+}
+
+// WithRepositories returns a view of the pool with only the repositories with
+// the given IDs, like WithAccess.
+func (p *RepositoryPool) WithRepositories(ids ...string) *RepositoryPool {
+	set := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+
+	return p.view(func(id string) bool {
+		_, ok := set[id]
+		return ok
+	})
+}
+
+// view returns a view of the pool with only the allowed repositories of
+// this pool.
+func (p *RepositoryPool) view(allowed func(id string) bool) *RepositoryPool {
+	if p.base != nil {
+		parent := p.allowed
+		return &RepositoryPool{
+			cache: p.cache,
+			base:  p.base,
+			allowed: func(id string) bool {
+				return parent(id) && allowed(id)
+			},
+		}
+	}
+
+	return &RepositoryPool{
+		cache:   p.cache,
+		base:    p,
+		allowed: allowed,
+	}
+}
+
+// Allowed returns whether the repository with the given ID is allowed in
+// the pool, which is always true unless the pool is a view.
+func (p *RepositoryPool) Allowed(id string) bool {
+	return p.base == nil || p.allowed(id)
+}
+
+// root returns the pool that holds the library.
+func (p *RepositoryPool) root() *RepositoryPool {
+	if p.base != nil {
+		return p.base
+	}
+
+	return p
+}
+
+// Library returns the library of the pool.
+func (p *RepositoryPool) Library() borges.Library {
+	if p.base != nil {
+		return FilterLibrary(p.base.Library(), p.allowed)
+	}
+
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	return p.library
+}
+
+// SetLibrary replaces the library of the pool. Queries already running keep
+// using the previous library, while new ones will use the given one. The
+// cached checksum of the pool is discarded.
+func (p *RepositoryPool) SetLibrary(lib borges.Library) {
+	p = p.root()
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.library = lib
+	p.generation++
+	p.checksum = ""
+}
+
+// Generation returns the number of times the library of the pool has been
+// replaced with SetLibrary.
+func (p *RepositoryPool) Generation() uint64 {
+	p = p.root()
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	return p.generation
+}
+
+// partitionCount returns the number of partitions of the repositories in
+// the pool split with the given options, calling count only if it's not
+// cached. Counts are cached until the library of the pool is replaced or
+// InvalidatePartitionCounts is called, as repositories can be split once
+// their packfiles grow.
+func (p *RepositoryPool) partitionCount(
+	split RepositorySplit,
+	count func() (int64, error),
+) (int64, error) {
+	generation := p.Generation()
+
+	p.countMu.Lock()
+	n, ok := p.counts[split]
+	ok = ok && p.countsGeneration == generation
+	epoch := p.countsEpoch
+	p.countMu.Unlock()
+
+	if ok {
+		return n, nil
+	}
+
+	n, err := count()
+	if err != nil {
+		return 0, err
+	}
+
+	p.countMu.Lock()
+	if p.countsEpoch == epoch {
+		if p.counts == nil || p.countsGeneration != generation {
+			p.counts = make(map[RepositorySplit]int64)
+			p.countsGeneration = generation
+		}
+		p.counts[split] = n
+	}
+	p.countMu.Unlock()
+
+	return n, nil
+}
+
+// InvalidatePartitionCounts discards the cached partition counts of the
+// pool, so they are computed again with the current size of the
+// repositories.
+func (p *RepositoryPool) InvalidatePartitionCounts() {
+	p.countMu.Lock()
+	p.counts = nil
+	p.countsEpoch++
+	p.countMu.Unlock()
+}
+
 // ErrPoolRepoNotFound is returned when a repository id is not present in the pool.
 var ErrPoolRepoNotFound = errors.NewKind("repository id %s not found in the pool")
 
@@ -93,7 +267,8 @@ var ErrPoolRepoNotFound = errors.NewKind("repository id %s not found in the pool
 func (p *RepositoryPool) GetRepo(id string) (*Repository, error) {
 	i := borges.RepositoryID(id)
 
-	repo, err := p.library.Get(i, borges.ReadOnlyMode)
+	lib := p.Library()
+	repo, err := lib.Get(i, borges.ReadOnlyMode)
 	if err != nil {
 		if borges.ErrRepositoryNotExists.Is(err) {
 			return nil, ErrPoolRepoNotFound.New(id)
@@ -102,19 +277,21 @@ func (p *RepositoryPool) GetRepo(id string) (*Repository, error) {
 		return nil, err
 	}
 
-	r := NewRepository(p.library, repo, p.cache)
+	r := NewRepository(lib, repo, p.cache)
 	return r, nil
 }
 
 // RepoIter creates a new Repository iterator
 func (p *RepositoryPool) RepoIter() (*RepositoryIter, error) {
-	it, err := p.library.Repositories(borges.ReadOnlyMode)
+	lib := p.Library()
+	it, err := lib.Repositories(borges.ReadOnlyMode)
 	if err != nil {
 		return nil, err
 	}
 
 	iter := &RepositoryIter{
 		pool: p,
+		lib:  lib,
 		iter: it,
 	}
 
@@ -124,6 +301,7 @@ func (p *RepositoryPool) RepoIter() (*RepositoryIter, error) {
 // RepositoryIter iterates over all repositories in the pool
 type RepositoryIter struct {
 	pool *RepositoryPool
+	lib  borges.Library
 	iter borges.RepositoryIterator
 }
 
@@ -135,7 +313,7 @@ func (i *RepositoryIter) Next() (*Repository, error) {
 		return nil, err
 	}
 
-	r := NewRepository(i.pool.library, repo, i.pool.cache)
+	r := NewRepository(i.lib, repo, i.pool.cache)
 	return r, nil
 }
 
@@ -196,3 +196,85 @@ func TestRepositoryPoolSiva(t *testing.T) {
 	require.Equal(expectedRepos, i)
 	require.Equal(expected, result)
 }
+
+func TestRepositoryPoolSetLibrary(t *testing.T) {
+	require := require.New(t)
+
+	defer func() {
+		require.NoError(fixtures.Clean())
+	}()
+
+	lib, pool, err := newMultiPool()
+	require.NoError(err)
+	require.Equal(uint64(0), pool.Generation())
+
+	path := fixtures.ByTag("worktree").One().Worktree().Root()
+	require.NoError(lib.AddPlain("worktree", path, nil))
+
+	checksum, err := pool.Checksum()
+	require.NoError(err)
+	require.Equal(checksumSingle, checksum)
+
+	iter, err := pool.RepoIter()
+	require.NoError(err)
+
+	empty, err := newMultiLibrary()
+	require.NoError(err)
+	pool.SetLibrary(empty)
+	require.Equal(uint64(1), pool.Generation())
+	require.Equal(empty, pool.Library())
+
+	// iterators already created keep using the previous library
+	repo, err := iter.Next()
+	require.NoError(err)
+	require.Equal("worktree", repo.ID())
+
+	_, err = pool.GetRepo("worktree")
+	require.True(ErrPoolRepoNotFound.Is(err))
+
+	checksum, err = pool.Checksum()
+	require.NoError(err)
+	require.NotEqual(checksumSingle, checksum)
+}
+
+func TestRepositoryPoolWithRepositories(t *testing.T) {
+	require := require.New(t)
+
+	defer func() {
+		require.NoError(fixtures.Clean())
+	}()
+
+	lib, pool, err := newMultiPool()
+	require.NoError(err)
+
+	path := fixtures.ByTag("worktree").One().Worktree().Root()
+	require.NoError(lib.AddPlain("public/a", path, nil))
+	require.NoError(lib.AddPlain("public/b", path, nil))
+	require.NoError(lib.AddPlain("secret/c", path, nil))
+
+	ids := func(p *RepositoryPool) []string {
+		iter, err := p.RepoIter()
+		require.NoError(err)
+		defer iter.Close()
+
+		var ids []string
+		for {
+			repo, err := iter.Next()
+			if err == io.EOF {
+				return ids
+			}
+			require.NoError(err)
+			ids = append(ids, repo.ID())
+		}
+	}
+
+	view := pool.WithRepositories("public/a", "secret/c", "missing")
+	require.ElementsMatch([]string{"public/a", "secret/c"}, ids(view))
+
+	// views of views only have the repositories allowed by both
+	view = pool.WithAccess(&RepositoryAccess{Deny: []string{"secret/*"}}).
+		WithRepositories("public/a", "secret/c")
+	require.Equal([]string{"public/a"}, ids(view))
+
+	require.Empty(ids(pool.WithRepositories()))
+}
@@ -0,0 +1,403 @@
+package gitbase
+
+import (
+	"container/list"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/plan"
+	errors "gopkg.in/src-d/go-errors.v1"
+)
+
+// ResultCacheVar is the session variable that enables the result cache for
+// the queries of the session. It's enabled by default when the session has
+// a result cache.
+const ResultCacheVar = "gitbase_result_cache"
+
+var errNoChecksum = errors.NewKind("table %s has no checksum")
+
+// ResultCache keeps the rows returned by queries, so the same query over the
+// same repositories is answered without reading them again. Entries are
+// removed once they are older than the TTL, and the least recently used ones
+// when the rows of all the entries take more than the maximum size.
+type ResultCache struct {
+	maxSize int64
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type resultCacheEntry struct {
+	key     string
+	rows    []sql.Row
+	size    int64
+	expires time.Time
+}
+
+// NewResultCache creates a cache keeping up to maxSize bytes of rows, whose
+// entries expire after the given TTL. A TTL of zero means entries don't
+// expire.
+func NewResultCache(maxSize int64, ttl time.Duration) *ResultCache {
+	return &ResultCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the rows cached with the given key.
+func (c *ResultCache) Get(key string) ([]sql.Row, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := e.Value.(*resultCacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.removeElement(e)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(e)
+	return entry.rows, true
+}
+
+// Put caches the rows with the given key. Rows taking more than the maximum
+// size of the cache are not cached.
+func (c *ResultCache) Put(key string, rows []sql.Row) {
+	size := int64(len(key)) + rowsSize(rows)
+	if size > c.maxSize {
+		return
+	}
+
+	entry := &resultCacheEntry{key: key, rows: rows, size: size}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.removeElement(e)
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += size
+
+	for c.size > c.maxSize {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// Len returns the number of entries in the cache.
+func (c *ResultCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Size returns the size in bytes of the entries in the cache.
+func (c *ResultCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *ResultCache) removeElement(e *list.Element) {
+	entry := c.lru.Remove(e).(*resultCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// rowsSize returns an estimation of the memory used by the rows.
+func rowsSize(rows []sql.Row) int64 {
+	var size int64
+	for _, row := range rows {
+		size += 24
+		for _, v := range row {
+			size += valueSize(v)
+		}
+	}
+
+	return size
+}
+
+func valueSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case string:
+		return 16 + int64(len(v))
+	case []byte:
+		return 24 + int64(len(v))
+	case []interface{}:
+		size := int64(24)
+		for _, v := range v {
+			size += valueSize(v)
+		}
+		return size
+	case time.Time:
+		return 24
+	default:
+		return 16
+	}
+}
+
+// CachedResults is a node that returns the rows of its child from the result
+// cache of the session, if they were cached for the same query and the same
+// contents of its tables. Otherwise, the rows of the child are cached once
+// all of them are read.
+type CachedResults struct {
+	plan.UnaryNode
+	// Query is the text of the query whose results are cached.
+	Query string
+	// Tables are the tables read by the query, whose checksums are part of
+	// the key of the results.
+	Tables []sql.Table
+}
+
+// NewCachedResults creates a new CachedResults node.
+func NewCachedResults(query string, tables []sql.Table, child sql.Node) *CachedResults {
+	return &CachedResults{
+		UnaryNode: plan.UnaryNode{Child: child},
+		Query:     query,
+		Tables:    tables,
+	}
+}
+
+// RowIter implements the sql.Node interface.
+func (n *CachedResults) RowIter(ctx *sql.Context) (sql.RowIter, error) {
+	span, ctx := ctx.Span("gitbase.CachedResults")
+
+	var cache *ResultCache
+	var key string
+	session, err := getSession(ctx)
+	if err == nil {
+		cache = session.ResultCache()
+	}
+
+	// The query is run without the cache if the checksums of its tables
+	// can't be computed.
+	if cache != nil {
+		if key, err = n.key(ctx, session); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"query": n.Query,
+				"error": err,
+			}).Warn("unable to compute the key of the query results, skipping the result cache")
+			cache = nil
+		}
+	}
+
+	if cache == nil {
+		iter, err := n.Child.RowIter(ctx)
+		if err != nil {
+			span.Finish()
+			return nil, err
+		}
+
+		return sql.NewSpanIter(span, iter), nil
+	}
+
+	if rows, ok := cache.Get(key); ok {
+		span.SetTag("hit", true)
+		if t := session.queryTracker(ctx); t != nil {
+			t.setCached()
+		}
+
+		return sql.NewSpanIter(span, sql.RowsToRowIter(rows...)), nil
+	}
+	span.SetTag("hit", false)
+
+	iter, err := n.Child.RowIter(ctx)
+	if err != nil {
+		span.Finish()
+		return nil, err
+	}
+
+	return sql.NewSpanIter(span, &cachingIter{
+		iter:    iter,
+		cache:   cache,
+		key:     key,
+		maxSize: cache.maxSize,
+	}), nil
+}
+
+// key returns the key of the results of the query, which is made of the
+// user, the checksums of the tables and the normalized query. The user is
+// part of it because users may be allowed to access different repositories.
+func (n *CachedResults) key(ctx *sql.Context, session *Session) (string, error) {
+	var gitbaseChecksum string
+	checksums := make(map[string]string, len(n.Tables))
+	for _, t := range n.Tables {
+		if _, ok := checksums[t.Name()]; ok {
+			continue
+		}
+
+		// All the gitbase tables have the checksum of the repositories,
+		// so it's computed only once. It's computed again for every query
+		// instead of using the one cached by the pool, which doesn't change
+		// when commits or references are added to the repositories.
+		if _, ok := t.(Table); ok {
+			if gitbaseChecksum == "" {
+				_, checksum, err := session.Pool.RepositoryChecksums()
+				if err != nil {
+					return "", err
+				}
+				gitbaseChecksum = checksum
+			}
+
+			checksums[t.Name()] = gitbaseChecksum
+			continue
+		}
+
+		c, ok := t.(sql.Checksumable)
+		if !ok {
+			return "", errNoChecksum.New(t.Name())
+		}
+
+		checksum, err := c.Checksum()
+		if err != nil {
+			return "", err
+		}
+
+		checksums[t.Name()] = checksum
+	}
+
+	names := make([]string, 0, len(checksums))
+	for name := range checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(ctx.Client().User)
+	b.WriteByte(0)
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(checksums[name])
+		b.WriteByte(0)
+	}
+	b.WriteString(NormalizeQuery(n.Query))
+
+	return b.String(), nil
+}
+
+func (n *CachedResults) String() string {
+	pr := sql.NewTreePrinter()
+	_ = pr.WriteNode("CachedResults")
+	_ = pr.WriteChildren(n.Child.String())
+	return pr.String()
+}
+
+// WithChildren implements the sql.Node interface.
+func (n *CachedResults) WithChildren(children ...sql.Node) (sql.Node, error) {
+	if len(children) != 1 {
+		return nil, sql.ErrInvalidChildrenNumber.New(n, len(children), 1)
+	}
+
+	return NewCachedResults(n.Query, n.Tables, children[0]), nil
+}
+
+// cachingIter returns the rows of its iterator, keeping them to put them in
+// the cache once all of them are read. Rows are not kept anymore once they
+// take more than the maximum size.
+type cachingIter struct {
+	iter    sql.RowIter
+	cache   *ResultCache
+	key     string
+	maxSize int64
+
+	rows      []sql.Row
+	size      int64
+	discarded bool
+}
+
+func (i *cachingIter) Next() (sql.Row, error) {
+	row, err := i.iter.Next()
+	if err == io.EOF {
+		if !i.discarded {
+			i.cache.Put(i.key, i.rows)
+			i.discarded = true
+			i.rows = nil
+		}
+		return nil, err
+	}
+
+	if err != nil {
+		i.discarded = true
+		i.rows = nil
+		return nil, err
+	}
+
+	if !i.discarded {
+		i.size += rowsSize([]sql.Row{row})
+		if i.size > i.maxSize {
+			i.discarded = true
+			i.rows = nil
+		} else {
+			i.rows = append(i.rows, row)
+		}
+	}
+
+	return row, nil
+}
+
+func (i *cachingIter) Close() error {
+	i.rows = nil
+	return i.iter.Close()
+}
+
+// NormalizeQuery returns the query with its keywords and identifiers in
+// lower case and without redundant whitespace or trailing semicolons, so
+// queries written differently share the same results. Quoted strings and
+// identifiers are left untouched.
+func NormalizeQuery(q string) string {
+	var b strings.Builder
+	var quote byte
+	var space bool
+	for i := 0; i < len(q); i++ {
+		c := q[i]
+		if quote != 0 {
+			b.WriteByte(c)
+			if c == '\\' && quote != '`' && i+1 < len(q) {
+				i++
+				b.WriteByte(q[i])
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			space = true
+			continue
+		case '\'', '"', '`':
+			quote = c
+		}
+
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		b.WriteByte(c)
+	}
+
+	return strings.TrimRight(b.String(), "; ")
+}
@@ -0,0 +1,197 @@
+package gitbase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/src-d/go-mysql-server/memory"
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/src-d/go-mysql-server/sql/plan"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultCache(t *testing.T) {
+	require := require.New(t)
+
+	rows := []sql.Row{sql.NewRow("foo", int64(1))}
+	size := int64(len("a")) + rowsSize(rows)
+
+	cache := NewResultCache(2*size, 0)
+	cache.Put("a", rows)
+	cache.Put("b", rows)
+	require.Equal(2, cache.Len())
+	require.Equal(2*size, cache.Size())
+
+	result, ok := cache.Get("a")
+	require.True(ok)
+	require.Equal(rows, result)
+
+	// b is the least recently used
+	cache.Put("c", rows)
+	require.Equal(2, cache.Len())
+	_, ok = cache.Get("b")
+	require.False(ok)
+	_, ok = cache.Get("a")
+	require.True(ok)
+
+	// rows bigger than the cache are not cached
+	cache.Put("d", append(rows, rows[0], rows[0]))
+	_, ok = cache.Get("d")
+	require.False(ok)
+	require.Equal(2, cache.Len())
+
+	cache = NewResultCache(2*size, 10*time.Millisecond)
+	cache.Put("a", rows)
+	_, ok = cache.Get("a")
+	require.True(ok)
+
+	time.Sleep(20 * time.Millisecond)
+	_, ok = cache.Get("a")
+	require.False(ok)
+	require.Zero(cache.Len())
+	require.Zero(cache.Size())
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT * FROM commits", "select * from commits"},
+		{"  select *\n\tFROM   commits ;; ", "select * from commits"},
+		{
+			"SELECT 'Foo  Bar', \"It''s\" FROM `Refs`",
+			"select 'Foo  Bar', \"It''s\" from `Refs`",
+		},
+		{`SELECT 'a\'  B' ;`, `select 'a\'  B'`},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.query, func(t *testing.T) {
+			require.Equal(t, tt.expected, NormalizeQuery(tt.query))
+		})
+	}
+}
+
+func TestCachedResults(t *testing.T) {
+	require := require.New(t)
+
+	ctx, _, cleanup := setup(t)
+	defer cleanup()
+
+	cache := NewResultCache(1024*1024, 0)
+	session := NewSession(poolFromCtx(t, ctx), WithResultCache(cache))
+	ctx = sql.NewContext(context.TODO(), sql.WithSession(session))
+
+	_, val := session.Get(ResultCacheVar)
+	require.Equal(true, val)
+	require.Equal(cache, session.ResultCache())
+
+	commits := newCommitsTable(session.Pool)
+	query := "SELECT * FROM commits"
+	node := NewCachedResults(query, []sql.Table{commits}, plan.NewResolvedTable(commits))
+
+	iter, err := node.RowIter(ctx)
+	require.NoError(err)
+	expected, err := sql.RowIterToRows(iter)
+	require.NoError(err)
+	require.Len(expected, 9)
+	require.Equal(1, cache.Len())
+
+	// the rows are returned from the cache, not from the new child
+	node = NewCachedResults(
+		"select *  from COMMITS;",
+		[]sql.Table{commits},
+		plan.NewResolvedTable(newBlobsTable(session.Pool)),
+	)
+	iter, err = node.RowIter(ctx)
+	require.NoError(err)
+	rows, err := sql.RowIterToRows(iter)
+	require.NoError(err)
+	require.Equal(expected, rows)
+
+	session.Set(ResultCacheVar, sql.Int8, int8(0))
+	require.Nil(session.ResultCache())
+
+	iter, err = node.RowIter(ctx)
+	require.NoError(err)
+	rows, err = sql.RowIterToRows(iter)
+	require.NoError(err)
+	require.Len(rows, 10)
+
+	session.Set(ResultCacheVar, sql.Text, "ON")
+	require.Equal(cache, session.ResultCache())
+	require.Nil(NewSession(session.Pool).ResultCache())
+}
+
+func TestCachedResultsTracking(t *testing.T) {
+	require := require.New(t)
+
+	ctx, _, cleanup := setup(t)
+	defer cleanup()
+
+	cache := NewResultCache(1024*1024, 0)
+	session := NewSession(
+		poolFromCtx(t, ctx),
+		WithResultCache(cache),
+		WithQueryStats(true),
+		WithQueryLimits(QueryLimits{MaxRows: 5}),
+	)
+
+	var pid uint64
+	run := func(node sql.Node) ([]sql.Row, error) {
+		pid++
+		ctx := sql.NewContext(context.TODO(),
+			sql.WithSession(session),
+			sql.WithPid(pid),
+		)
+		return sql.NodeToRows(ctx, NewTrackedQuery(node))
+	}
+
+	commits := newCommitsTable(session.Pool)
+	node := NewCachedResults(
+		"SELECT * FROM commits",
+		[]sql.Table{commits},
+		plan.NewResolvedTable(commits),
+	)
+
+	// the rows of queries exceeding the limits are not cached
+	_, err := run(node)
+	require.True(ErrMaxRows.Is(err), err)
+	require.Zero(cache.Len())
+
+	session.Set(MaxRowsVar, sql.Int64, int64(0))
+	rows, err := run(node)
+	require.NoError(err)
+	require.Len(rows, 9)
+	require.Equal(1, cache.Len())
+	require.False(session.QueryStats().Cached)
+	require.Equal(int64(9), session.QueryStats().Rows)
+
+	rows, err = run(node)
+	require.NoError(err)
+	require.Len(rows, 9)
+	stats := session.QueryStats()
+	require.True(stats.Cached)
+	require.Zero(stats.Rows)
+
+	// the rows returned from the cache count towards the limits
+	session.Set(MaxRowsVar, sql.Int64, int64(5))
+	_, err = run(node)
+	require.True(ErrMaxRows.Is(err), err)
+
+	// queries reading tables without checksum are run without the cache
+	table := memory.NewTable("foo", sql.Schema{{Name: "a", Type: sql.Int64}})
+	require.NoError(table.Insert(sql.NewEmptyContext(), sql.NewRow(int64(1))))
+	node = NewCachedResults(
+		"SELECT * FROM foo",
+		[]sql.Table{table},
+		plan.NewResolvedTable(table),
+	)
+
+	rows, err = run(node)
+	require.NoError(err)
+	require.Equal([]sql.Row{{int64(1)}}, rows)
+	require.Equal(1, cache.Len())
+}
@@ -20,6 +20,17 @@ type Session struct {
 	sql.Session
 	Pool *RepositoryPool
 
+	acl RepositoryACL
+
+	limits       *QueryLimits
+	collectStats bool
+	split        RepositorySplit
+	resultCache  *ResultCache
+	trackerMu    sync.Mutex
+	tracker      *queryTracker
+	handlesMu    sync.Mutex
+	handles      *queryHandles
+
 	bblfshMu       sync.Mutex
 	bblfshEndpoint string
 	bblfshClient   *BblfshClient
@@ -64,6 +75,46 @@ func WithSkipGitErrors(enabled bool) SessionOption {
 	}
 }
 
+// WithRepositoryACL restricts the repositories of the session to the ones
+// the user of the session can access.
+func WithRepositoryACL(acl RepositoryACL) SessionOption {
+	return func(s *Session) {
+		s.acl = acl
+	}
+}
+
+// WithQueryLimits sets the default limits of the queries of the session,
+// which can be changed with the session variables.
+func WithQueryLimits(limits QueryLimits) SessionOption {
+	return func(s *Session) {
+		s.limits = &limits
+	}
+}
+
+// WithRepositorySplit configures how the repositories are split in several
+// partitions by the tables that support it.
+func WithRepositorySplit(split RepositorySplit) SessionOption {
+	return func(s *Session) {
+		s.split = split
+	}
+}
+
+// WithQueryStats makes the session collect the statistics of its queries,
+// which are returned by QueryStats.
+func WithQueryStats(enabled bool) SessionOption {
+	return func(s *Session) {
+		s.collectStats = enabled
+	}
+}
+
+// WithResultCache makes the session keep the results of its queries in the
+// given cache, unless it's disabled with the gitbase_result_cache variable.
+func WithResultCache(cache *ResultCache) SessionOption {
+	return func(s *Session) {
+		s.resultCache = cache
+	}
+}
+
 // WithBaseSession sets the given session as the base session.
 func WithBaseSession(sess sql.Session) SessionOption {
 	return func(s *Session) {
@@ -84,6 +135,18 @@ func NewSession(pool *RepositoryPool, opts ...SessionOption) *Session {
 		opt(sess)
 	}
 
+	if sess.limits != nil {
+		sess.limits.setDefaults(sess.Session)
+	}
+
+	if sess.resultCache != nil {
+		sess.Session.Set(ResultCacheVar, sql.Boolean, true)
+	}
+
+	if sess.acl != nil && pool != nil {
+		sess.Pool = pool.WithAccess(sess.acl.Access(sess.Client().User))
+	}
+
 	return sess
 }
 
@@ -93,6 +156,8 @@ const bblfshMaxAttempts = 10
 // functionality.
 type BblfshClient struct {
 	*bblfsh.Client
+
+	mu                 sync.Mutex
 	supportedLanguages []string
 }
 
@@ -116,6 +181,9 @@ func (c *BblfshClient) IsLanguageSupported(ctx context.Context, lang string) (bo
 // SupportedLanguages returns the list of supported languages for the bblfsh
 // server this client is connected to.
 func (c *BblfshClient) SupportedLanguages(ctx context.Context) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if len(c.supportedLanguages) == 0 {
 		driverManifests, err := c.Client.
 			NewSupportedLanguagesRequest().
@@ -209,6 +277,37 @@ func (s *Session) BblfshClient() (*BblfshClient, error) {
 	}
 }
 
+// ResultCache returns the result cache of the session, or nil if it has no
+// result cache or it's disabled with the gitbase_result_cache variable.
+func (s *Session) ResultCache() *ResultCache {
+	if s.resultCache == nil {
+		return nil
+	}
+
+	_, val := s.Get(ResultCacheVar)
+	switch val := val.(type) {
+	case nil:
+		return nil
+	case bool:
+		if !val {
+			return nil
+		}
+	case string:
+		switch strings.ToLower(val) {
+		case "on", "true", "1":
+		default:
+			return nil
+		}
+	default:
+		v, err := sql.Int64.Convert(val)
+		if err != nil || v.(int64) == 0 {
+			return nil
+		}
+	}
+
+	return s.resultCache
+}
+
 // Close implements the io.Closer interface.
 func (s *Session) Close() error {
 	s.bblfshMu.Lock()
@@ -236,11 +335,23 @@ func connectToBblfsh(endpoint string) (*bblfsh.Client, error) {
 // NewSessionBuilder creates a SessionBuilder with the given Repository Pool.
 func NewSessionBuilder(pool *RepositoryPool, opts ...SessionOption) server.SessionBuilder {
 	return func(c *mysql.Conn, host string) sql.Session {
-		opts = append(opts, WithBaseSession(sql.NewSession(host, c.RemoteAddr().String(), c.User, c.ConnectionID)))
-		return NewSession(pool, opts...)
+		return NewClientSession(pool, host, c.RemoteAddr().String(), c.User, c.ConnectionID, opts...)
 	}
 }
 
+// NewClientSession creates the session of a client with the given options,
+// in the same way the sessions of the MySQL connections are created by
+// NewSessionBuilder. It can be used by clients of other protocols.
+func NewClientSession(
+	pool *RepositoryPool,
+	server, client, user string,
+	id uint32,
+	opts ...SessionOption,
+) *Session {
+	opts = append(opts[:len(opts):len(opts)], WithBaseSession(sql.NewSession(server, client, user, id)))
+	return NewSession(pool, opts...)
+}
+
 // ErrSessionCanceled is returned when session context is canceled
 var ErrSessionCanceled = errors.NewKind("session canceled")
 
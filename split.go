@@ -0,0 +1,316 @@
+package gitbase
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+
+	"github.com/sirupsen/logrus"
+	"github.com/src-d/go-borges"
+	"github.com/src-d/go-mysql-server/sql"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem/dotgit"
+)
+
+// MaxRepositorySplits is the maximum number of partitions a repository can
+// be split in.
+const MaxRepositorySplits = 256
+
+// RepositorySplit configures how the repositories are split in several
+// partitions by the tables that support it, so the rows of a big repository
+// can be read in parallel.
+type RepositorySplit struct {
+	// Parts is the number of partitions of each split repository. Values
+	// lower than 2 disable splitting.
+	Parts int
+	// MinSize is the minimum size in bytes of the packfiles of a repository
+	// to split it.
+	MinSize int64
+}
+
+func (s RepositorySplit) enabled() bool {
+	return s.Parts > 1
+}
+
+// SplitPartition represents a part of the rows of a repository. The refs
+// and ref_commits tables split the references of the repository by name,
+// and the blobs and tree_entries tables split its objects by hash ranges,
+// like the fanout table of the packfile indexes, so each row belongs to
+// only one of the parts.
+type SplitPartition struct {
+	// ID is the repository id.
+	ID string
+	// Part is the index of the part, from 0 to Parts-1.
+	Part int
+	// Parts is the number of parts the repository is split in.
+	Parts int
+
+	handle *repositoryHandle
+}
+
+// Key implements the sql.Partition interface.
+func (p SplitPartition) Key() []byte {
+	return []byte(fmt.Sprintf("%s#%d/%d", p.ID, p.Part, p.Parts))
+}
+
+// repositoryPart is the part of the rows of a repository read by a
+// partition.
+type repositoryPart struct {
+	part  int
+	parts int
+}
+
+// partitionPart returns the part of the repository read by the partition.
+// Repository partitions read the whole repository.
+func partitionPart(p sql.Partition) repositoryPart {
+	if sp, ok := p.(SplitPartition); ok {
+		return repositoryPart{sp.Part, sp.Parts}
+	}
+
+	return repositoryPart{0, 1}
+}
+
+func (p repositoryPart) split() bool {
+	return p.parts > 1
+}
+
+// hasRef returns whether the reference with the given name belongs to the
+// part.
+func (p repositoryPart) hasRef(name string) bool {
+	if !p.split() {
+		return true
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	return int(h.Sum32()%uint32(p.parts)) == p.part
+}
+
+// hasObject returns whether the object with the given hash belongs to the
+// part.
+func (p repositoryPart) hasObject(hash plumbing.Hash) bool {
+	if !p.split() {
+		return true
+	}
+
+	return objectPart(hash, p.parts) == p.part
+}
+
+// objectPart returns the part the object with the given hash belongs to
+// when the repository is split in the given number of parts.
+func objectPart(hash plumbing.Hash, parts int) int {
+	return int(hash[0]) * parts / 256
+}
+
+// partObjectHashes returns the hashes of the objects of the repository that
+// belong to the part, packed and loose. If hashes is not empty, only those
+// are returned instead of reading all the objects of the repository. The
+// objects of a repository acquired from a handle are enumerated only once
+// for all its parts.
+func partObjectHashes(
+	repo *Repository,
+	part repositoryPart,
+	hashes []plumbing.Hash,
+) ([]plumbing.Hash, error) {
+	if len(hashes) > 0 {
+		var result []plumbing.Hash
+		for _, h := range hashes {
+			if part.hasObject(h) {
+				result = append(result, h)
+			}
+		}
+
+		return result, nil
+	}
+
+	enumerate := func() ([][]plumbing.Hash, error) {
+		return objectBuckets(repo, part.parts)
+	}
+
+	var buckets [][]plumbing.Hash
+	var err error
+	if repo.handle != nil {
+		buckets, err = repo.handle.objectBuckets(part.parts, enumerate)
+	} else {
+		buckets, err = enumerate()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buckets[part.part], nil
+}
+
+// objectBuckets returns the hashes of the objects of the repository, packed
+// and loose, split in the given number of parts by their first byte.
+func objectBuckets(repo *Repository, parts int) ([][]plumbing.Hash, error) {
+	idx, err := newRepositoryIndex(repo)
+	if err != nil {
+		return nil, err
+	}
+	defer idx.Close()
+
+	buckets := make([][]plumbing.Hash, parts)
+	seen := make(map[plumbing.Hash]struct{})
+	add := func(h plumbing.Hash) {
+		if _, ok := seen[h]; ok {
+			return
+		}
+
+		seen[h] = struct{}{}
+		part := objectPart(h, parts)
+		buckets[part] = append(buckets[part], h)
+	}
+
+	for _, pi := range idx.indexes {
+		// Entries are read by offset so the objects are decoded in the
+		// order they are in the packfile.
+		entries, err := pi.idx.EntriesByOffset()
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			e, err := entries.Next()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				entries.Close()
+				return nil, err
+			}
+
+			add(e.Hash)
+		}
+
+		entries.Close()
+	}
+
+	err = idx.dir.ForEachObjectHash(func(h plumbing.Hash) error {
+		add(h)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return buckets, nil
+}
+
+// repositorySize returns the size in bytes of the packfiles of a
+// repository.
+func repositorySize(repo borges.Repository) (int64, error) {
+	fs := repo.FS()
+	if fs == nil {
+		return 0, fmt.Errorf("filesystem inaccesible")
+	}
+
+	fs, err := findDotGit(fs)
+	if err != nil {
+		return 0, err
+	}
+
+	packfiles, err := dotgit.New(fs).ObjectPacks()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, p := range packfiles {
+		path := fs.Join("objects", "pack", fmt.Sprintf("pack-%s.pack", p))
+		fi, err := fs.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+
+		size += fi.Size()
+	}
+
+	return size, nil
+}
+
+// repositoryPartitions returns the partitions of a repository, which are
+// several split partitions if the repository is big enough or just one
+// repository partition otherwise. All of them share the given handle.
+func repositoryPartitions(
+	repo borges.Repository,
+	handle *repositoryHandle,
+	split RepositorySplit,
+) []sql.Partition {
+	id := repo.ID().String()
+	whole := []sql.Partition{RepositoryPartition{ID: id, handle: handle}}
+	if !split.enabled() {
+		return whole
+	}
+
+	size, err := repositorySize(repo)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"repository": id,
+			"error":      err,
+		}).Debug("unable to get repository size, it will not be split")
+		return whole
+	}
+
+	if size < split.MinSize {
+		return whole
+	}
+
+	parts := split.Parts
+	if parts > MaxRepositorySplits {
+		parts = MaxRepositorySplits
+	}
+
+	partitions := make([]sql.Partition, parts)
+	for i := range partitions {
+		partitions[i] = SplitPartition{
+			ID:     id,
+			Part:   i,
+			Parts:  parts,
+			handle: handle,
+		}
+	}
+
+	return partitions
+}
+
+// splitPartitions returns the partitions of a table whose rows can be split
+// in several partitions per repository. Indexes store their values per
+// repository partition, so tables using an index lookup are never split.
+func splitPartitions(ctx *sql.Context, index sql.IndexLookup) (sql.PartitionIter, error) {
+	s, err := getSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if index != nil {
+		return newRepositoryPartitionIter(ctx)
+	}
+
+	return newSplitPartitionIter(ctx, s.split)
+}
+
+// splitPartitionCount returns the number of partitions returned by
+// splitPartitions.
+func splitPartitionCount(ctx *sql.Context, index sql.IndexLookup) (int64, error) {
+	s, err := getSession(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if index != nil || !s.split.enabled() {
+		return partitioned{}.PartitionCount(ctx)
+	}
+
+	return s.Pool.partitionCount(s.split, func() (int64, error) {
+		iter, err := splitPartitions(ctx, index)
+		if err != nil {
+			return 0, err
+		}
+		defer iter.Close()
+
+		return countPartitions(iter)
+	})
+}
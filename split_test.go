@@ -0,0 +1,169 @@
+package gitbase
+
+import (
+	"io"
+	"testing"
+
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestSplitPartitions(t *testing.T) {
+	ctx, _, cleanup := setup(t)
+	defer cleanup()
+
+	pool := poolFromCtx(t, ctx)
+	tables := []sql.Table{
+		newReferencesTable(pool),
+		newRefCommitsTable(pool),
+		newBlobsTable(pool),
+		newTreeEntriesTable(pool),
+	}
+
+	session, err := getSession(ctx)
+	require.NoError(t, err)
+
+	for _, table := range tables {
+		t.Run(table.Name(), func(t *testing.T) {
+			require := require.New(t)
+
+			session.split = RepositorySplit{}
+			expected, err := tableToRows(ctx, table)
+			require.NoError(err)
+			require.NotEmpty(expected)
+
+			session.split = RepositorySplit{Parts: 4}
+			defer func() { session.split = RepositorySplit{} }()
+
+			count, err := table.(sql.PartitionCounter).PartitionCount(ctx)
+			require.NoError(err)
+			require.Equal(int64(4), count)
+
+			iter, err := table.Partitions(ctx)
+			require.NoError(err)
+
+			var rows []sql.Row
+			for {
+				p, err := iter.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(err)
+				require.IsType(SplitPartition{}, p)
+
+				partRows, err := sql.RowIterToRows(mustPartitionRows(t, ctx, table, p))
+				require.NoError(err)
+				rows = append(rows, partRows...)
+			}
+			require.NoError(iter.Close())
+
+			require.ElementsMatch(expected, rows)
+		})
+	}
+}
+
+func TestSplitPartitionsMinSize(t *testing.T) {
+	require := require.New(t)
+	ctx, _, cleanup := setup(t)
+	defer cleanup()
+
+	session, err := getSession(ctx)
+	require.NoError(err)
+	session.split = RepositorySplit{Parts: 4, MinSize: 1 << 40}
+
+	iter, err := newReferencesTable(poolFromCtx(t, ctx)).Partitions(ctx)
+	require.NoError(err)
+	defer iter.Close()
+
+	p, err := iter.Next()
+	require.NoError(err)
+	require.IsType(RepositoryPartition{}, p)
+
+	_, err = iter.Next()
+	require.Equal(io.EOF, err)
+}
+
+func TestRepositoryPartHasObject(t *testing.T) {
+	require := require.New(t)
+
+	hashes := []plumbing.Hash{
+		plumbing.NewHash("0000000000000000000000000000000000000000"),
+		plumbing.NewHash("3fffffffffffffffffffffffffffffffffffffff"),
+		plumbing.NewHash("4000000000000000000000000000000000000000"),
+		plumbing.NewHash("8000000000000000000000000000000000000000"),
+		plumbing.NewHash("ffffffffffffffffffffffffffffffffffffffff"),
+	}
+	expected := []int{0, 0, 1, 2, 3}
+
+	for i, h := range hashes {
+		var parts []int
+		for part := 0; part < 4; part++ {
+			if (repositoryPart{part, 4}).hasObject(h) {
+				parts = append(parts, part)
+			}
+		}
+
+		require.Equal([]int{expected[i]}, parts, h.String())
+		require.True((repositoryPart{0, 1}).hasObject(h))
+	}
+}
+
+func TestPartObjectHashesShared(t *testing.T) {
+	require := require.New(t)
+	ctx, _, cleanup := setup(t)
+	defer cleanup()
+
+	session, err := getSession(ctx)
+	require.NoError(err)
+
+	iter, err := session.Pool.RepoIter()
+	require.NoError(err)
+	repo, err := iter.Next()
+	require.NoError(err)
+	require.NoError(iter.Close())
+
+	all, err := partObjectHashes(repo, repositoryPart{0, 1}, nil)
+	require.NoError(err)
+	require.NotEmpty(all)
+	require.NoError(repo.Close())
+
+	h := session.repositoryHandle(ctx, repo.ID())
+	h.hold()
+	defer h.unhold()
+
+	var hashes []plumbing.Hash
+	for part := 0; part < 4; part++ {
+		r, err := h.acquire()
+		require.NoError(err)
+
+		partHashes, err := partObjectHashes(r, repositoryPart{part, 4}, nil)
+		require.NoError(err)
+		require.NoError(r.Close())
+
+		// the objects are enumerated once and shared by all the parts
+		require.Len(h.buckets, 4)
+		if len(partHashes) > 0 {
+			require.True(&partHashes[0] == &h.buckets[part][0])
+		}
+
+		for _, hash := range partHashes {
+			require.Equal(part, objectPart(hash, 4))
+		}
+		hashes = append(hashes, partHashes...)
+	}
+
+	require.ElementsMatch(all, hashes)
+}
+
+func mustPartitionRows(
+	t *testing.T,
+	ctx *sql.Context,
+	table sql.Table,
+	p sql.Partition,
+) sql.RowIter {
+	t.Helper()
+	iter, err := table.PartitionRows(ctx, p)
+	require.NoError(t, err)
+	return iter
+}
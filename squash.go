@@ -44,6 +44,16 @@ func (t *SquashedTable) Name() string {
 	return fmt.Sprintf("SquashedTable(%s)", strings.Join(t.tables, ", "))
 }
 
+// Tables returns the names of the squashed tables.
+func (t *SquashedTable) Tables() []string {
+	return t.tables
+}
+
+// Filters returns the filters applied by the iterators of the table.
+func (t *SquashedTable) Filters() []sql.Expression {
+	return t.filters
+}
+
 // Schema implements the sql.Table interface.
 func (t *SquashedTable) Schema() sql.Schema {
 	if len(t.schemaMappings) == 0 {
@@ -70,6 +80,10 @@ func (t *SquashedTable) PartitionRows(ctx *sql.Context, p sql.Partition) (sql.Ro
 		return nil, err
 	}
 
+	if t := session.queryTracker(ctx); t != nil {
+		t.setSquashed()
+	}
+
 	repo, err := getPartitionRepo(ctx, p)
 	if err != nil {
 		span.Finish()
@@ -89,13 +103,14 @@ func (t *SquashedTable) PartitionRows(ctx *sql.Context, p sql.Partition) (sql.Ro
 	if len(t.schemaMappings) == 0 {
 		return sql.NewSpanIter(
 			span,
-			newRepoRowIter(repo, NewChainableRowIter(iter)),
+			newRepoRowIter(ctx, repo, NewChainableRowIter(iter)),
 		), nil
 	}
 
 	return sql.NewSpanIter(
 		span,
 		newRepoRowIter(
+			ctx,
 			repo,
 			NewSchemaMapperIter(NewChainableRowIter(iter), t.schemaMappings),
 		),
@@ -52,7 +52,7 @@ type squashReposIter struct {
 // NewAllReposIter returns an iterator that will return all repositories
 // that match the given filters.
 func NewAllReposIter(filters sql.Expression) ReposIter {
-	return &squashReposIter{filters: filters}
+	return profileReposIter(&squashReposIter{filters: filters})
 }
 
 func (i *squashReposIter) Repo() *Repository { return i.repo }
@@ -142,7 +142,7 @@ type squashRemoteIter struct {
 // NewAllRemotesIter returns an iterator that will return all remotes
 // that match the given filters.
 func NewAllRemotesIter(filters sql.Expression) RemotesIter {
-	return &squashRemoteIter{filters: filters}
+	return profileRemotesIter(&squashRemoteIter{filters: filters})
 }
 
 func (i *squashRemoteIter) Remote() *Remote { return i.remote }
@@ -249,7 +249,7 @@ type squashRepoRemotesIter struct {
 // NewRepoRemotesIter returns an iterator that will return all remotes for the
 // given ReposIter repositories that match the given filters.
 func NewRepoRemotesIter(squashReposIter ReposIter, filters sql.Expression) RemotesIter {
-	return &squashRepoRemotesIter{repos: squashReposIter, filters: filters}
+	return profileRemotesIter(&squashRepoRemotesIter{repos: squashReposIter, filters: filters})
 }
 
 func (i *squashRepoRemotesIter) Repository() *Repository { return i.repos.Repository() }
@@ -396,7 +396,7 @@ type squashRefIter struct {
 // passing it to other iterators that are chained with references but
 // don't need the reference data in their output rows.
 func NewAllRefsIter(filters sql.Expression, virtual bool) RefsIter {
-	return &squashRefIter{filters: filters, virtual: virtual}
+	return profileRefsIter(&squashRefIter{filters: filters, virtual: virtual})
 }
 
 func (i *squashRefIter) Repository() *Repository { return i.repo }
@@ -522,7 +522,7 @@ type squashRefIndexIter struct {
 // NewIndexRefsIter returns an iterator that will return all references
 // that match the given filters in the given index.
 func NewIndexRefsIter(filters sql.Expression, index sql.IndexLookup) RefsIter {
-	return &squashRefIndexIter{filters: filters, index: index}
+	return profileRefsIter(&squashRefIndexIter{filters: filters, index: index})
 }
 
 func (i *squashRefIndexIter) Repository() *Repository { return i.repo }
@@ -540,7 +540,7 @@ func (i *squashRefIndexIter) New(ctx *sql.Context, repo *Repository) (ChainableI
 		return nil, err
 	}
 
-	values, err := i.index.Values(RepositoryPartition(repo.ID()))
+	values, err := i.index.Values(RepositoryPartition{ID: repo.ID()})
 	if err != nil {
 		return nil, err
 	}
@@ -634,11 +634,11 @@ func NewRepoRefsIter(
 	filters sql.Expression,
 	virtual bool,
 ) RefsIter {
-	return &squashRepoRefsIter{
+	return profileRefsIter(&squashRepoRefsIter{
 		repos:   squashReposIter,
 		filters: filters,
 		virtual: virtual,
-	}
+	})
 }
 
 func (i *squashRepoRefsIter) Repository() *Repository { return i.repos.Repository() }
@@ -774,10 +774,10 @@ func NewRemoteRefsIter(
 	remotesIter RemotesIter,
 	filters sql.Expression,
 ) RefsIter {
-	return &squashRemoteRefsIter{
+	return profileRefsIter(&squashRemoteRefsIter{
 		remotes: remotesIter,
 		filters: filters,
-	}
+	})
 }
 
 func (i *squashRemoteRefsIter) Repository() *Repository { return i.remotes.Repository() }
@@ -921,7 +921,7 @@ type squashRefRefCommitsIter struct {
 // NewRefRefCommitsIter returns an iterator that will return all ref_commits
 // for all the references in the given iterator.
 func NewRefRefCommitsIter(refsIter RefsIter, filters sql.Expression) CommitsIter {
-	return &squashRefRefCommitsIter{refs: refsIter, filters: filters}
+	return profileRefCommitsIter(&squashRefRefCommitsIter{refs: refsIter, filters: filters})
 }
 
 func (squashRefRefCommitsIter) isRefCommitsIter()          {}
@@ -980,10 +980,10 @@ func (i *squashRefRefCommitsIter) Advance() error {
 				return err
 			}
 
-			i.commits = newIndexedCommitIter(i.skipGitErrors, i.Repository(), commit)
+			i.commits = newIndexedCommitIter(i.skipGitErrors, i.Repository(), commit.Hash)
 		}
 
-		commit, idx, err := i.commits.Next()
+		node, idx, err := i.commits.Next()
 		if err != nil {
 			if err == io.EOF {
 				i.commits = nil
@@ -993,6 +993,15 @@ func (i *squashRefRefCommitsIter) Advance() error {
 			return err
 		}
 
+		commit, err := node.Commit()
+		if err != nil {
+			if i.skipGitErrors {
+				continue
+			}
+
+			return err
+		}
+
 		i.commit = commit
 		i.row = append(
 			i.refs.Row(),
@@ -1032,7 +1041,7 @@ type squashRefHeadRefCommitsIter struct {
 // NewRefHeadRefCommitsIter returns an iterator that will return all ref_commit
 // rows of the HEAD commits in references of the given iterator.
 func NewRefHeadRefCommitsIter(refs RefsIter, filters sql.Expression) CommitsIter {
-	return &squashRefHeadRefCommitsIter{refs: refs, filters: filters}
+	return profileRefCommitsIter(&squashRefHeadRefCommitsIter{refs: refs, filters: filters})
 }
 
 func (squashRefHeadRefCommitsIter) isRefCommitsIter()          {}
@@ -1127,10 +1136,10 @@ type squashRefCommitsIndexIter struct {
 // NewIndexRefCommitsIter returns an iterator that will return all results in
 // the given index.
 func NewIndexRefCommitsIter(index sql.IndexLookup, filters sql.Expression) RefCommitsIter {
-	return &squashRefCommitsIndexIter{
+	return profileRefCommitsIter(&squashRefCommitsIndexIter{
 		index:   index,
 		filters: filters,
-	}
+	})
 }
 
 func (i *squashRefCommitsIndexIter) Repository() *Repository { return i.repo }
@@ -1142,7 +1151,7 @@ func (i *squashRefCommitsIndexIter) New(ctx *sql.Context, repo *Repository) (Cha
 		return nil, err
 	}
 
-	values, err := i.index.Values(RepositoryPartition(repo.ID()))
+	values, err := i.index.Values(RepositoryPartition{ID: repo.ID()})
 	if err != nil {
 		return nil, err
 	}
@@ -1223,7 +1232,7 @@ type squashRefCommitCommitsIter struct {
 // NewRefCommitCommitsIter returns an iterator that will return commits
 // based on the ref_commits returned by the previous iterator.
 func NewRefCommitCommitsIter(refCommits CommitsIter, filters sql.Expression) CommitsIter {
-	return &squashRefCommitCommitsIter{refCommits: refCommits, filters: filters}
+	return profileCommitsIter(&squashRefCommitCommitsIter{refCommits: refCommits, filters: filters})
 }
 
 func (i *squashRefCommitCommitsIter) Repository() *Repository { return i.refCommits.Repository() }
@@ -1294,7 +1303,7 @@ type squashCommitsIter struct {
 // NewAllCommitsIter returns an iterator that will return all commits
 // that match the given filters.
 func NewAllCommitsIter(filters sql.Expression, virtual bool) CommitsIter {
-	return &squashCommitsIter{filters: filters, virtual: virtual}
+	return profileCommitsIter(&squashCommitsIter{filters: filters, virtual: virtual})
 }
 
 func (i *squashCommitsIter) Repository() *Repository { return i.repo }
@@ -1404,10 +1413,10 @@ type squashCommitsIndexIter struct {
 // NewIndexCommitsIter returns an iterator that will return all results in
 // the given index.
 func NewIndexCommitsIter(index sql.IndexLookup, filters sql.Expression) CommitsIter {
-	return &squashCommitsIndexIter{
+	return profileCommitsIter(&squashCommitsIndexIter{
 		index:   index,
 		filters: filters,
-	}
+	})
 }
 
 func (i *squashCommitsIndexIter) Repository() *Repository { return i.repo }
@@ -1418,7 +1427,7 @@ func (i *squashCommitsIndexIter) New(ctx *sql.Context, repo *Repository) (Chaina
 		return nil, err
 	}
 
-	values, err := i.index.Values(RepositoryPartition(repo.ID()))
+	values, err := i.index.Values(RepositoryPartition{ID: repo.ID()})
 	if err != nil {
 		return nil, err
 	}
@@ -1500,7 +1509,7 @@ type squashRepoCommitsIter struct {
 // NewRepoCommitsIter is an iterator that returns all commits for the
 // repositories returned by the given iterator.
 func NewRepoCommitsIter(repos ReposIter, filters sql.Expression) CommitsIter {
-	return &squashRepoCommitsIter{repos: repos, filters: filters}
+	return profileCommitsIter(&squashRepoCommitsIter{repos: repos, filters: filters})
 }
 
 func (i *squashRepoCommitsIter) Repository() *Repository { return i.repos.Repository() }
@@ -1614,7 +1623,7 @@ func NewRefHEADCommitsIter(
 	filters sql.Expression,
 	virtual bool,
 ) CommitsIter {
-	return &squashRefHeadCommitsIter{refs: refsIter, filters: filters, virtual: virtual}
+	return profileCommitsIter(&squashRefHeadCommitsIter{refs: refsIter, filters: filters, virtual: virtual})
 }
 
 func (i *squashRefHeadCommitsIter) Repository() *Repository { return i.refs.Repository() }
@@ -1735,10 +1744,10 @@ type squashCommitTreesIndexIter struct {
 // NewIndexCommitTreesIter returns an iterator that will return all results in
 // the given index.
 func NewIndexCommitTreesIter(index sql.IndexLookup, filters sql.Expression) TreesIter {
-	return &squashCommitTreesIndexIter{
+	return profileTreesIter(&squashCommitTreesIndexIter{
 		index:   index,
 		filters: filters,
-	}
+	})
 }
 
 func (i *squashCommitTreesIndexIter) Repository() *Repository { return i.repo }
@@ -1749,7 +1758,7 @@ func (i *squashCommitTreesIndexIter) New(ctx *sql.Context, repo *Repository) (Ch
 		return nil, err
 	}
 
-	values, err := i.index.Values(RepositoryPartition(repo.ID()))
+	values, err := i.index.Values(RepositoryPartition{ID: repo.ID()})
 	if err != nil {
 		return nil, err
 	}
@@ -1837,11 +1846,11 @@ func NewCommitTreesIter(
 	filters sql.Expression,
 	virtual bool,
 ) TreesIter {
-	return &squashCommitTreesIter{
+	return profileTreesIter(&squashCommitTreesIter{
 		commits: commits,
 		filters: filters,
 		virtual: virtual,
-	}
+	})
 }
 
 func (i *squashCommitTreesIter) Repository() *Repository { return i.commits.Repository() }
@@ -1956,7 +1965,7 @@ type squashRepoTreeEntriesIter struct {
 // NewRepoTreeEntriesIter returns an iterator that will return all tree entries
 // for every repo returned by the given iterator.
 func NewRepoTreeEntriesIter(repos ReposIter, filters sql.Expression) TreeEntriesIter {
-	return &squashRepoTreeEntriesIter{repos: repos, filters: filters}
+	return profileTreeEntriesIter(&squashRepoTreeEntriesIter{repos: repos, filters: filters})
 }
 
 func (i *squashRepoTreeEntriesIter) Repository() *Repository { return i.repos.Repository() }
@@ -2084,11 +2093,11 @@ func NewCommitMainTreeIter(
 	filters sql.Expression,
 	virtual bool,
 ) TreesIter {
-	return &squashCommitMainTreeIter{
+	return profileTreesIter(&squashCommitMainTreeIter{
 		commits: commits,
 		filters: filters,
 		virtual: virtual,
-	}
+	})
 }
 
 func (i *squashCommitMainTreeIter) Repository() *Repository { return i.commits.Repository() }
@@ -2286,7 +2295,7 @@ type squashTreeEntriesIter struct {
 // NewAllTreeEntriesIter returns an iterator that will return all tree entries
 // that match the given filters.
 func NewAllTreeEntriesIter(filters sql.Expression) TreeEntriesIter {
-	return &squashTreeEntriesIter{filters: filters}
+	return profileTreeEntriesIter(&squashTreeEntriesIter{filters: filters})
 }
 
 func (i *squashTreeEntriesIter) Repository() *Repository { return i.repo }
@@ -2390,10 +2399,10 @@ type squashTreeEntriesIndexIter struct {
 // NewIndexTreeEntriesIter returns an iterator that will return all results in
 // the given index.
 func NewIndexTreeEntriesIter(index sql.IndexLookup, filters sql.Expression) TreeEntriesIter {
-	return &squashTreeEntriesIndexIter{
+	return profileTreeEntriesIter(&squashTreeEntriesIndexIter{
 		index:   index,
 		filters: filters,
-	}
+	})
 }
 
 func (i *squashTreeEntriesIndexIter) Repository() *Repository { return i.repo }
@@ -2404,7 +2413,7 @@ func (i *squashTreeEntriesIndexIter) New(ctx *sql.Context, repo *Repository) (Ch
 		return nil, err
 	}
 
-	values, err := i.index.Values(RepositoryPartition(repo.ID()))
+	values, err := i.index.Values(RepositoryPartition{ID: repo.ID()})
 	if err != nil {
 		return nil, err
 	}
@@ -2489,11 +2498,11 @@ func NewTreeTreeEntriesIter(
 	filters sql.Expression,
 	virtual bool,
 ) TreeEntriesIter {
-	return &squashTreeTreeEntriesIter{
+	return profileTreeEntriesIter(&squashTreeTreeEntriesIter{
 		trees:   trees,
 		virtual: virtual,
 		filters: filters,
-	}
+	})
 }
 
 func (i *squashTreeTreeEntriesIter) Repository() *Repository { return i.trees.Repository() }
@@ -2591,10 +2600,10 @@ type squashCommitBlobsIndexIter struct {
 // NewIndexCommitBlobsIter returns an iterator that will return all results in
 // the given index.
 func NewIndexCommitBlobsIter(index sql.IndexLookup, filters sql.Expression) BlobsIter {
-	return &squashCommitBlobsIndexIter{
+	return profileBlobsIter(&squashCommitBlobsIndexIter{
 		index:   index,
 		filters: filters,
-	}
+	})
 }
 
 func (i *squashCommitBlobsIndexIter) Repository() *Repository { return i.repo }
@@ -2605,7 +2614,7 @@ func (i *squashCommitBlobsIndexIter) New(ctx *sql.Context, repo *Repository) (Ch
 		return nil, err
 	}
 
-	values, err := i.index.Values(RepositoryPartition(repo.ID()))
+	values, err := i.index.Values(RepositoryPartition{ID: repo.ID()})
 	if err != nil {
 		return nil, err
 	}
@@ -2697,10 +2706,10 @@ func NewCommitBlobsIter(
 	commits CommitsIter,
 	filters sql.Expression,
 ) BlobsIter {
-	return &squashCommitBlobsIter{
+	return profileBlobsIter(&squashCommitBlobsIter{
 		commits: commits,
 		filters: filters,
-	}
+	})
 }
 
 func (i *squashCommitBlobsIter) Close() error {
@@ -2823,11 +2832,11 @@ func NewRepoBlobsIter(
 	filters sql.Expression,
 	readContent bool,
 ) BlobsIter {
-	return &squashRepoBlobsIter{
+	return profileBlobsIter(&squashRepoBlobsIter{
 		repos:       repos,
 		filters:     filters,
 		readContent: readContent,
-	}
+	})
 }
 
 func (i *squashRepoBlobsIter) Repository() *Repository { return i.repos.Repository() }
@@ -2926,11 +2935,11 @@ func NewTreeEntryBlobsIter(
 	filters sql.Expression,
 	readContent bool,
 ) BlobsIter {
-	return &squashTreeEntryBlobsIter{
+	return profileBlobsIter(&squashTreeEntryBlobsIter{
 		treeEntries: squashTreeEntriesIter,
 		filters:     filters,
 		readContent: readContent,
-	}
+	})
 }
 
 func (i *squashTreeEntryBlobsIter) Repository() *Repository { return i.treeEntries.Repository() }
@@ -3030,11 +3039,11 @@ func NewCommitBlobBlobsIter(
 	filters sql.Expression,
 	readContent bool,
 ) BlobsIter {
-	return &squashCommitBlobBlobsIter{
+	return profileBlobsIter(&squashCommitBlobBlobsIter{
 		commitBlobs: commitBlobs,
 		filters:     filters,
 		readContent: readContent,
-	}
+	})
 }
 
 func (i *squashCommitBlobBlobsIter) Repository() *Repository { return i.commitBlobs.Repository() }
@@ -3121,7 +3130,7 @@ func NewAllCommitFilesIter(filters sql.Expression) FilesIter {
 // NewCommitFilesIter returns an iterator that will return all commit files
 // for the commits in the given iterator.
 func NewCommitFilesIter(iter CommitsIter, filters sql.Expression) FilesIter {
-	return &squashCommitFilesIter{commits: iter, filters: filters}
+	return profileFilesIter(&squashCommitFilesIter{commits: iter, filters: filters})
 }
 
 func (i *squashCommitFilesIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
@@ -3244,11 +3253,11 @@ type squashIndexCommitFilesIter struct {
 // NewIndexCommitFilesIter returns an iterator that will return all commit
 // files for the commits in the given index.
 func NewIndexCommitFilesIter(index sql.IndexLookup, filters sql.Expression) FilesIter {
-	return &squashIndexCommitFilesIter{index: index, filters: filters}
+	return profileFilesIter(&squashIndexCommitFilesIter{index: index, filters: filters})
 }
 
 func (i *squashIndexCommitFilesIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
-	values, err := i.index.Values(RepositoryPartition(repo.ID()))
+	values, err := i.index.Values(RepositoryPartition{ID: repo.ID()})
 	if err != nil {
 		return nil, err
 	}
@@ -3350,11 +3359,11 @@ func NewCommitFileFilesIter(
 	filters sql.Expression,
 	readContent bool,
 ) ChainableIter {
-	return &squashCommitFileFilesIter{
+	return profileChainableIter(&squashCommitFileFilesIter{
 		files:       files,
 		filters:     filters,
 		readContent: readContent,
-	}
+	})
 }
 
 func (i *squashCommitFileFilesIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
@@ -3425,11 +3434,11 @@ func NewCommitFileBlobsIter(
 	filters sql.Expression,
 	readContent bool,
 ) ChainableIter {
-	return &squashCommitFileBlobsIter{
+	return profileChainableIter(&squashCommitFileBlobsIter{
 		files:       files,
 		filters:     filters,
 		readContent: readContent,
-	}
+	})
 }
 
 func (i *squashCommitFileBlobsIter) New(ctx *sql.Context, repo *Repository) (ChainableIter, error) {
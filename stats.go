@@ -0,0 +1,219 @@
+package gitbase
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/src-d/go-mysql-server/sql"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+// QueryStats are the statistics of the tables read by a query.
+type QueryStats struct {
+	// Repositories are the IDs of the repositories read, sorted.
+	Repositories []string
+	// Rows is the number of rows read from the tables.
+	Rows int64
+	// Objects is the number of git objects read from the repositories.
+	Objects int64
+	// BlobBytes is the number of bytes of blob contents read, including
+	// the ones of rows discarded by filters.
+	BlobBytes int64
+	// Squashed is whether any of the tables read was a squashed table.
+	Squashed bool
+	// Cached is whether the rows were returned from the result cache, in
+	// which case no table was read.
+	Cached bool
+}
+
+// queryTracker keeps track of the limits and statistics of a query. It's
+// safe to use from the iterators of several partitions at the same time.
+type queryTracker struct {
+	// rows, returned, bytes and objects go first so they are aligned for
+	// atomic operations.
+	rows     int64
+	returned int64
+	bytes    int64
+	objects  int64
+	squashed int32
+	cached   int32
+
+	pid    uint64
+	limits QueryLimits
+	start  time.Time
+
+	// repositories is nil if the statistics are not collected.
+	mu           sync.Mutex
+	repositories map[string]struct{}
+	// err is the first limit exceeded by the query.
+	err error
+}
+
+// queryTracker returns the tracker of the query of the given context, or nil
+// if the query has no limits and the session doesn't collect statistics. The
+// limits of a query are the values of the session variables when it starts
+// running, which is also when its execution time starts to count.
+func (s *Session) queryTracker(ctx *sql.Context) *queryTracker {
+	s.trackerMu.Lock()
+	defer s.trackerMu.Unlock()
+
+	if s.tracker == nil || s.tracker.pid != ctx.Pid() {
+		s.tracker = &queryTracker{
+			pid:    ctx.Pid(),
+			limits: sessionQueryLimits(s),
+			start:  time.Now(),
+		}
+
+		if s.collectStats {
+			s.tracker.repositories = make(map[string]struct{})
+		}
+	}
+
+	if !s.collectStats && s.tracker.limits == (QueryLimits{}) {
+		return nil
+	}
+
+	return s.tracker
+}
+
+func queryTrackerFromContext(ctx *sql.Context) *queryTracker {
+	s, err := getSession(ctx)
+	if err != nil {
+		return nil
+	}
+
+	return s.queryTracker(ctx)
+}
+
+// ResetQueryStats discards the statistics of the last query of the session,
+// so the ones returned by QueryStats are only the ones of queries run after
+// this call.
+func (s *Session) ResetQueryStats() {
+	s.trackerMu.Lock()
+	s.tracker = nil
+	s.trackerMu.Unlock()
+}
+
+// QueryStats returns the statistics of the last query of the session that
+// read any table or returned cached rows. Statistics are only collected by sessions created with
+// WithQueryStats, the ones of other sessions are always empty.
+func (s *Session) QueryStats() QueryStats {
+	s.trackerMu.Lock()
+	t := s.tracker
+	s.trackerMu.Unlock()
+
+	if t == nil || t.repositories == nil {
+		return QueryStats{}
+	}
+
+	t.mu.Lock()
+	repos := make([]string, 0, len(t.repositories))
+	for id := range t.repositories {
+		repos = append(repos, id)
+	}
+	t.mu.Unlock()
+	sort.Strings(repos)
+
+	return QueryStats{
+		Repositories: repos,
+		Rows:         atomic.LoadInt64(&t.rows),
+		Objects:      atomic.LoadInt64(&t.objects),
+		BlobBytes:    atomic.LoadInt64(&t.bytes),
+		Squashed:     atomic.LoadInt32(&t.squashed) == 1,
+		Cached:       atomic.LoadInt32(&t.cached) == 1,
+	}
+}
+
+func (t *queryTracker) collectsStats() bool {
+	return t.repositories != nil
+}
+
+// addRepository accounts the given repository as read by the query. If the
+// statistics are collected, the objects read from the returned repository
+// are counted.
+func (t *queryTracker) addRepository(repo *Repository) *Repository {
+	if !t.collectsStats() {
+		return repo
+	}
+
+	t.mu.Lock()
+	t.repositories[repo.ID()] = struct{}{}
+	t.mu.Unlock()
+
+	return countObjects(repo, &t.objects)
+}
+
+// countObjects returns a copy of the repository that adds the objects read
+// from it to the given counter.
+func countObjects(repo *Repository, objects *int64) *Repository {
+	r := *repo.Repository
+	r.Storer = &countingStorer{Storer: r.Storer, objects: objects}
+
+	nr := *repo
+	nr.Repository = &r
+	return &nr
+}
+
+func (t *queryTracker) setSquashed() {
+	atomic.StoreInt32(&t.squashed, 1)
+}
+
+func (t *queryTracker) setCached() {
+	atomic.StoreInt32(&t.cached, 1)
+}
+
+// countingStorer is a storer that counts the objects read from it.
+type countingStorer struct {
+	storage.Storer
+	objects *int64
+}
+
+// EncodedObject implements the storer.EncodedObjectStorer interface.
+func (s *countingStorer) EncodedObject(
+	t plumbing.ObjectType,
+	h plumbing.Hash,
+) (plumbing.EncodedObject, error) {
+	o, err := s.Storer.EncodedObject(t, h)
+	if err == nil {
+		atomic.AddInt64(s.objects, 1)
+	}
+
+	return o, err
+}
+
+// IterEncodedObjects implements the storer.EncodedObjectStorer interface.
+func (s *countingStorer) IterEncodedObjects(
+	t plumbing.ObjectType,
+) (storer.EncodedObjectIter, error) {
+	iter, err := s.Storer.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &countingObjectIter{EncodedObjectIter: iter, objects: s.objects}, nil
+}
+
+type countingObjectIter struct {
+	storer.EncodedObjectIter
+	objects *int64
+}
+
+func (i *countingObjectIter) Next() (plumbing.EncodedObject, error) {
+	o, err := i.EncodedObjectIter.Next()
+	if err == nil {
+		atomic.AddInt64(i.objects, 1)
+	}
+
+	return o, err
+}
+
+func (i *countingObjectIter) ForEach(cb func(plumbing.EncodedObject) error) error {
+	return i.EncodedObjectIter.ForEach(func(o plumbing.EncodedObject) error {
+		atomic.AddInt64(i.objects, 1)
+		return cb(o)
+	})
+}
@@ -0,0 +1,67 @@
+package gitbase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/src-d/go-mysql-server/sql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryStats(t *testing.T) {
+	require := require.New(t)
+
+	ctx, path, cleanup := setup(t)
+	defer cleanup()
+
+	session := NewSession(poolFromCtx(t, ctx), WithQueryStats(true))
+
+	var pid uint64
+	newCtx := func() *sql.Context {
+		pid++
+		return sql.NewContext(context.TODO(),
+			sql.WithSession(session),
+			sql.WithPid(pid),
+		)
+	}
+
+	require.Equal(QueryStats{}, session.QueryStats())
+
+	_, err := tableToRows(newCtx(), newCommitsTable(session.Pool))
+	require.NoError(err)
+
+	stats := session.QueryStats()
+	require.Equal([]string{path}, stats.Repositories)
+	require.Equal(int64(9), stats.Rows)
+	require.True(stats.Objects >= 9, stats.Objects)
+	require.Zero(stats.BlobBytes)
+	require.False(stats.Squashed)
+
+	table := newBlobsTable(session.Pool).WithProjection([]string{"blob_content"})
+	rows, err := tableToRows(newCtx(), table)
+	require.NoError(err)
+
+	var bytes int64
+	for _, row := range rows {
+		bytes += int64(len(row[3].([]byte)))
+	}
+
+	stats = session.QueryStats()
+	require.Equal(int64(len(rows)), stats.Rows)
+	// blobs are read to know whether they are binary, too
+	require.True(stats.BlobBytes >= bytes, stats.BlobBytes)
+	require.True(bytes > 0)
+
+	_, err = tableToRows(newCtx(), newSquashTable(NewAllRefsIter(nil, false)))
+	require.NoError(err)
+	require.True(session.QueryStats().Squashed)
+
+	session.ResetQueryStats()
+	require.Equal(QueryStats{}, session.QueryStats())
+
+	// sessions not collecting statistics don't keep them
+	session = NewSession(session.Pool)
+	_, err = tableToRows(newCtx(), newCommitsTable(session.Pool))
+	require.NoError(err)
+	require.Equal(QueryStats{}, session.QueryStats())
+}
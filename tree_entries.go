@@ -13,7 +13,6 @@ import (
 
 type treeEntriesTable struct {
 	checksumable
-	partitioned
 	filters []sql.Expression
 	index   sql.IndexLookup
 }
@@ -60,6 +59,16 @@ func (r *treeEntriesTable) WithIndexLookup(idx sql.IndexLookup) sql.Table {
 func (r *treeEntriesTable) IndexLookup() sql.IndexLookup { return r.index }
 func (r *treeEntriesTable) Filters() []sql.Expression    { return r.filters }
 
+// Partitions implements the sql.Table interface.
+func (r *treeEntriesTable) Partitions(ctx *sql.Context) (sql.PartitionIter, error) {
+	return splitPartitions(ctx, r.index)
+}
+
+// PartitionCount implements the sql.PartitionCounter interface.
+func (r *treeEntriesTable) PartitionCount(ctx *sql.Context) (int64, error) {
+	return splitPartitionCount(ctx, r.index)
+}
+
 func (r *treeEntriesTable) PartitionRows(
 	ctx *sql.Context,
 	p sql.Partition,
@@ -101,6 +110,7 @@ func (r *treeEntriesTable) PartitionRows(
 			return &treeEntriesRowIter{
 				repo:          repo,
 				hashes:        stringsToHashes(hashes),
+				part:          partitionPart(p),
 				skipGitErrors: shouldSkipErrors(ctx),
 			}, nil
 		},
@@ -111,7 +121,7 @@ func (r *treeEntriesTable) PartitionRows(
 		return nil, errorWithRepo(repo, err)
 	}
 
-	return sql.NewSpanIter(span, newRepoRowIter(repo, iter)), nil
+	return sql.NewSpanIter(span, newRepoRowIter(ctx, repo, iter)), nil
 }
 
 func (treeEntriesTable) HandledFilters(filters []sql.Expression) []sql.Expression {
@@ -152,10 +162,16 @@ type treeEntriesRowIter struct {
 	iter          *object.TreeIter
 	cursor        int
 	repo          *Repository
+	part          repositoryPart
+	partRead      bool
 	skipGitErrors bool
 }
 
 func (i *treeEntriesRowIter) Next() (sql.Row, error) {
+	if i.part.split() {
+		return i.nextInPart()
+	}
+
 	if len(i.hashes) > 0 {
 		return i.nextByHash()
 	}
@@ -163,6 +179,26 @@ func (i *treeEntriesRowIter) Next() (sql.Row, error) {
 	return i.next()
 }
 
+// nextInPart returns the next entry of the trees of the part of the
+// repository read by the iterator, looking up by hash the objects of the
+// part.
+func (i *treeEntriesRowIter) nextInPart() (sql.Row, error) {
+	if !i.partRead {
+		hashes, err := partObjectHashes(i.repo, i.part, i.hashes)
+		if err != nil {
+			if i.skipGitErrors {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+
+		i.hashes = hashes
+		i.partRead = true
+	}
+
+	return i.nextByHash()
+}
+
 func (i *treeEntriesRowIter) next() (sql.Row, error) {
 	for {
 		if i.iter == nil {